@@ -0,0 +1,245 @@
+package main
+
+import (
+	"dhcptest/connection"
+	"dhcptest/layers"
+	"dhcptest/utility"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	exitRogueFound = 1
+	exitBadAudit   = 2
+)
+
+// starveResult is the outcome of a transaction of the starvation test
+type starveResult struct {
+	index   int
+	mac     net.HardwareAddr
+	state   string
+	address net.IP
+	at      time.Duration
+}
+
+// runAudit runs "audit starve", which leases as many addresses as it can, or
+// "audit rogue", which watches the offers for servers off the allowlist. Both
+// watch the servers of all the offers, the exit code is 1 when a rogue is seen
+func runAudit(dc client, args []string) int {
+	v4, ok := dc.(v4Client)
+	if !ok {
+		log.Println("the audit command speaks dhcpv4 only")
+		return exitBadAudit
+	}
+	watch, err := connection.NewServerWatch(strings.Split(utility.AllowServers, ","))
+	if err != nil {
+		log.Println(err)
+		return exitBadAudit
+	}
+	watch.OnNew = func(s connection.SeenServer) {
+		log.Printf("new %s", s)
+	}
+	v4.Servers = watch
+
+	mode := ""
+	if len(args) > 0 {
+		mode = args[0]
+	}
+	code := exitOK
+	switch mode {
+	case "starve":
+		code = starve(v4)
+	case "rogue":
+		code = watchServers(v4)
+	default:
+		log.Println("usage: audit starve|rogue")
+		return exitBadAudit
+	}
+
+	servers := watch.Servers()
+	log.Printf("%d servers seen, %d of them rogue", len(servers), watch.Rogues())
+	for _, s := range servers {
+		log.Printf("  %s", s)
+	}
+	if code == exitOK && watch.Rogues() > 0 {
+		code = exitRogueFound
+	}
+	return code
+}
+
+// starve starts a DORA from a new mac every 1/--starve-rate second until
+// --starve-streak transactions in a row get a nak or no offer, or --starve-max
+// addresses are leased, then reports when the pool ran out
+func starve(v4 v4Client) int {
+	if utility.StarveRate <= 0 || utility.StarveStreak <= 0 {
+		log.Println("--starve-rate and --starve-streak should be positive")
+		return exitBadAudit
+	}
+	v4.Start(utility.StarveRate*int(2*utility.Timeout/time.Second+1)*3+16, true, false)
+	loggerStop := make(chan int)
+	go report(v4, loggerStop, false)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	var (
+		results     = make(chan starveResult, utility.StarveRate)
+		start       = time.Now()
+		sent        int
+		inflight    int
+		acked       []net.HardwareAddr
+		addresses   = make(map[string]bool)
+		lastAck     time.Duration
+		firstNak    *starveResult
+		firstMiss   *starveResult
+		streak      int
+		streakStart starveResult
+		exhausted   *starveResult
+		exhaustedAt time.Duration
+	)
+	tally := func(r starveResult) {
+		inflight--
+		switch r.state {
+		case connection.StateAcked:
+			acked = append(acked, r.mac)
+			addresses[r.address.String()] = true
+			lastAck = r.at
+			streak = 0
+			return
+		case connection.StateNaked:
+			if firstNak == nil {
+				firstNak = &r
+			}
+		case connection.StateOfferTimeout:
+			if firstMiss == nil {
+				firstMiss = &r
+			}
+		}
+		if streak == 0 {
+			streakStart = r
+		}
+		streak++
+	}
+
+	log.Printf("starving with %d DORA per second", utility.StarveRate)
+	ticker := time.NewTicker(time.Second / time.Duration(utility.StarveRate))
+loop:
+	for {
+		select {
+		case <-ticker.C:
+			mac, err := macGenerator.Next()
+			if err != nil {
+				log.Println(err)
+				break loop
+			}
+			index := sent
+			pr := v4.Send(v4.newRequest(index, mac).(*layers.DHCPv4), connection.WithTransactionID(rand.Uint32()))
+			sent++
+			inflight++
+			go func() {
+				<-pr.Done()
+				r := starveResult{index: index, mac: mac, state: pr.State(), at: time.Since(start)}
+				if ack := pr.Packet(layers.DHCPMsgTypeAck); ack != nil {
+					_, lease := connection.NewLease(ack)
+					r.address = lease.FixedAddress
+				}
+				results <- r
+			}()
+		case r := <-results:
+			tally(r)
+			if streak >= utility.StarveStreak {
+				exhausted, exhaustedAt = &streakStart, lastAck
+				break loop
+			}
+			if utility.StarveMax > 0 && len(acked) >= utility.StarveMax {
+				break loop
+			}
+		case <-signals:
+			break loop
+		}
+	}
+	ticker.Stop()
+	for inflight > 0 {
+		tally(<-results)
+	}
+	loggerStop <- 1
+	v4.Stop()
+
+	log.Printf("starvation: %d addresses leased by %d of %d macs in %s",
+		len(addresses), len(acked), sent, time.Since(start).Round(time.Millisecond))
+	if exhausted != nil {
+		log.Printf("pool exhausted %s after the start, at the last ack; %d naks or missing offers in a row from transaction %d at %s",
+			exhaustedAt.Round(time.Millisecond), utility.StarveStreak, exhausted.index, exhausted.at.Round(time.Millisecond))
+	} else {
+		log.Println("the pool was not exhausted")
+	}
+	if firstNak != nil {
+		log.Printf("first nak: transaction %d at %s", firstNak.index, firstNak.at.Round(time.Millisecond))
+	}
+	if firstMiss != nil {
+		log.Printf("first missing offer: transaction %d at %s", firstMiss.index, firstMiss.at.Round(time.Millisecond))
+	}
+
+	if utility.Release {
+		released := 0
+		for _, lease := range v4.Leases.List() {
+			mac, _ := lease.HardwareAddrs()
+			for _, starved := range acked {
+				if mac.String() == starved.String() {
+					if err := v4.ReleaseLease(lease); err != nil {
+						log.Println(err)
+					} else {
+						released++
+					}
+					break
+				}
+			}
+		}
+		log.Printf("released %d leases", released)
+	}
+	return exitOK
+}
+
+// watchServers broadcasts a discover every --timeout so that every server
+// offers, and watches the offers for --watch
+func watchServers(v4 v4Client) int {
+	mac, err := macGenerator.Next()
+	if err != nil {
+		log.Println(err)
+		return exitBadAudit
+	}
+	v4.Start(16, false, false)
+	loggerStop := make(chan int)
+	go report(v4, loggerStop, false)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	var deadline <-chan time.Time
+	if utility.Watch > 0 {
+		timer := time.NewTimer(utility.Watch)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	log.Printf("watching the offers to %s and to the other clients", mac)
+loop:
+	for {
+		pr := v4.Send(v4.newRequest(0, mac).(*layers.DHCPv4), connection.WithTransactionID(rand.Uint32()))
+		select {
+		case <-pr.Done():
+		case <-deadline:
+			break loop
+		case <-signals:
+			break loop
+		}
+	}
+	loggerStop <- 1
+	v4.Stop()
+	return exitOK
+}
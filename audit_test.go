@@ -0,0 +1,26 @@
+package main
+
+import (
+	"dhcptest/connection"
+	"dhcptest/utility"
+	"testing"
+)
+
+func TestRunAuditArgs(t *testing.T) {
+	defer func(allow string) { utility.AllowServers = allow }(utility.AllowServers)
+
+	utility.AllowServers = ""
+	if code := runAudit(&fakeClient{}, []string{"rogue"}); code != exitBadAudit {
+		t.Errorf("a dhcpv6 client exits with %d", code)
+	}
+	v4 := v4Client{&connection.DhcpClient{}}
+	for _, args := range [][]string{nil, {"flood"}} {
+		if code := runAudit(v4, args); code != exitBadAudit {
+			t.Errorf("%v exits with %d", args, code)
+		}
+	}
+	utility.AllowServers = "10.0.0.1,server"
+	if code := runAudit(v4, []string{"rogue"}); code != exitBadAudit {
+		t.Errorf("a bad --allow-servers exits with %d", code)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"dhcptest/connection"
+	"dhcptest/utility"
+	"fmt"
+	"log"
+	"net"
+)
+
+// newCapture creates the --pcap file for the frames of the interface, it
+// returns nil when no capture is asked for
+func newCapture(iface *net.Interface) (*connection.Capture, error) {
+	if len(utility.Pcap) == 0 {
+		return nil, nil
+	}
+	if utility.PcapRotate < 0 {
+		return nil, fmt.Errorf("invalid --pcap-rotate %d, want a number of megabytes or 0", utility.PcapRotate)
+	}
+	return connection.NewCapture(utility.Pcap, int64(utility.PcapRotate)<<20, iface)
+}
+
+// closeCapture writes the frames left and closes the capture, it logs the
+// frames dropped on the way
+func closeCapture(capture *connection.Capture) {
+	if capture == nil {
+		return
+	}
+	if err := capture.Close(); err != nil {
+		log.Printf("pcap: %s", err)
+	}
+	if dropped := capture.Dropped(); dropped > 0 {
+		log.Printf("pcap: %d frames dropped while the file was behind", dropped)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/pinterest/bender"
+	"net"
+)
+
+// transaction is what the executors return, it is done when the
+// transaction completes or times out
+type transaction interface {
+	Done() <-chan struct{}
+}
+
+// deviceRequest is the packet which starts a transaction of a device
+type deviceRequest struct {
+	index  int
+	packet interface{}
+}
+
+// concurrencyTest keeps one transaction in flight for every mac until stop is signalled,
+// a device starts its next exchange only when the previous one completes or times out.
+// A device whose request the executor fails to build is left out from then on.
+// The client must have been started for throughput testing
+func concurrencyTest(dc client, macList []net.HardwareAddr, stop chan int) {
+	idle := make(chan int, len(macList))
+	for index := range macList {
+		idle <- index
+	}
+	requests := make(chan interface{})
+	go func() {
+		defer close(requests)
+		for {
+			select {
+			case index := <-idle:
+				select {
+				case requests <- deviceRequest{index: index, packet: dc.newRequest(index, macList[index])}:
+				case <-stop:
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	execute := dc.executor()
+	wait := func(now int64, request interface{}) (interface{}, error) {
+		device := request.(deviceRequest)
+		response, err := execute(now, device.packet)
+		if err != nil {
+			//the request of the device can't be built, it would fail again at once
+			return response, err
+		}
+		if t, ok := response.(transaction); ok {
+			<-t.Done()
+		}
+		idle <- device.index
+		return response, nil
+	}
+
+	workers := bender.NewWorkerSemaphore()
+	go workers.Signal(len(macList))
+	recorder := make(chan interface{}, len(macList))
+	bender.LoadTestConcurrency(workers, requests, wait, recorder)
+	for range recorder {
+	}
+	//the permits are handed back when the test is over, take them so that no worker is left blocked
+	workers.Wait(len(macList))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"dhcptest/connection"
+	"fmt"
+	"github.com/pinterest/bender"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// doneTransaction is a transaction which is over once its channel is closed
+type doneTransaction chan struct{}
+
+func (t doneTransaction) Done() <-chan struct{} {
+	return t
+}
+
+// fakeClient hands out transactions which are over after latency, it counts
+// the transactions of each device and the most in flight at once. The request
+// of a broken device fails
+type fakeClient struct {
+	latency     time.Duration
+	broken      map[int]bool
+	stops       int
+	lock        sync.Mutex
+	started     map[int]int
+	inFlight    int
+	maxInFlight int
+}
+
+func (c *fakeClient) Close() error                          { return nil }
+func (c *fakeClient) Start(size int, ifRequest, ifLog bool) {}
+func (c *fakeClient) Stop() {
+	c.lock.Lock()
+	c.stops++
+	c.lock.Unlock()
+}
+
+func (c *fakeClient) GetRequestAndResponse() (int, int)     { return 0, 0 }
+func (c *fakeClient) Stats() *connection.Statistics         { return nil }
+
+func (c *fakeClient) newRequest(index int, mac net.HardwareAddr) interface{} {
+	return index
+}
+
+func (c *fakeClient) executor() bender.RequestExecutor {
+	return func(_ int64, request interface{}) (interface{}, error) {
+		c.lock.Lock()
+		c.started[request.(int)]++
+		if c.broken[request.(int)] {
+			c.lock.Unlock()
+			return nil, fmt.Errorf("device %d is broken", request.(int))
+		}
+		c.inFlight++
+		if c.inFlight > c.maxInFlight {
+			c.maxInFlight = c.inFlight
+		}
+		c.lock.Unlock()
+		t := make(doneTransaction)
+		time.AfterFunc(c.latency, func() {
+			c.lock.Lock()
+			c.inFlight--
+			c.lock.Unlock()
+			close(t)
+		})
+		return t, nil
+	}
+}
+
+func TestConcurrencyTest(t *testing.T) {
+	dc := &fakeClient{latency: 10 * time.Millisecond, started: make(map[int]int)}
+	macs := make([]net.HardwareAddr, 3)
+	stop := make(chan int)
+	done := make(chan struct{})
+	go func() {
+		concurrencyTest(dc, macs, stop)
+		close(done)
+	}()
+	time.Sleep(300 * time.Millisecond)
+	close(stop)
+	<-done
+
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+	if dc.maxInFlight != len(macs) {
+		t.Errorf("%d transactions in flight at most, want one per device", dc.maxInFlight)
+	}
+	//a device starts its next exchange as soon as the previous one is over
+	for index := range macs {
+		if n := dc.started[index]; n < 10 {
+			t.Errorf("device %d ran %d transactions in 300ms of 10ms ones", index, n)
+		}
+	}
+}
+
+func TestConcurrencyTestBrokenDevice(t *testing.T) {
+	dc := &fakeClient{latency: 10 * time.Millisecond, started: make(map[int]int), broken: map[int]bool{1: true}}
+	macs := make([]net.HardwareAddr, 3)
+	stop := make(chan int)
+	done := make(chan struct{})
+	go func() {
+		concurrencyTest(dc, macs, stop)
+		close(done)
+	}()
+	time.Sleep(200 * time.Millisecond)
+	close(stop)
+	<-done
+
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+	if n := dc.started[1]; n != 1 {
+		t.Errorf("the broken device was tried %d times, want once", n)
+	}
+	if dc.started[0] < 5 || dc.started[2] < 5 {
+		t.Errorf("the other devices ran %d and %d transactions", dc.started[0], dc.started[2])
+	}
+}
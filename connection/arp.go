@@ -0,0 +1,205 @@
+package connection
+
+import (
+	"bytes"
+	"dhcptest/layers"
+	"dhcptest/utility"
+	"fmt"
+	"github.com/google/gopacket"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// probeNum is the number of arp probes sent for an address, RFC 5227 PROBE_NUM
+	probeNum = 3
+)
+
+// prober matches the arp packets received to the addresses being probed. Two
+// simulated clients may be acked the same address, its probes are kept by the
+// address and by the mac of the client
+type prober struct {
+	lock   sync.Mutex
+	probes map[string]map[string]chan net.HardwareAddr
+}
+
+func newProber() *prober {
+	return &prober{probes: make(map[string]map[string]chan net.HardwareAddr)}
+}
+
+// watch returns the channel the mac of a host using the ip is sent to while
+// the client probes it
+func (p *prober) watch(ip net.IP, client net.HardwareAddr) chan net.HardwareAddr {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	owner := make(chan net.HardwareAddr, 1)
+	clients, ok := p.probes[ip.String()]
+	if !ok {
+		clients = make(map[string]chan net.HardwareAddr)
+		p.probes[ip.String()] = clients
+	}
+	clients[client.String()] = owner
+	return owner
+}
+
+// unwatch ends the probe of the channel, a later probe of the same client
+// replacing it is left alone
+func (p *prober) unwatch(ip net.IP, client net.HardwareAddr, owner chan net.HardwareAddr) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	clients := p.probes[ip.String()]
+	if clients[client.String()] != owner {
+		return
+	}
+	delete(clients, client.String())
+	if len(clients) == 0 {
+		delete(p.probes, ip.String())
+	}
+}
+
+// conflict reports the mac of a host using the ip to the probes of the other
+// clients, if any
+func (p *prober) conflict(ip net.IP, mac net.HardwareAddr) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for client, owner := range p.probes[ip.String()] {
+		if client == mac.String() {
+			continue
+		}
+		select {
+		case owner <- mac:
+		default:
+		}
+	}
+}
+
+// startProbe probes the address of the ack of a DORA before the client binds it
+func (dc *DhcpClient) startProbe(ack *layers.DHCPv4, discover *layers.DHCPv4, serverMac net.HardwareAddr) {
+	if !dc.leases.enter() {
+		return
+	}
+	go dc.probe(ack, discover, serverMac)
+}
+
+// probe sends probeNum arp probes for the address of the ack, --probe-wait apart, and waits
+// --probe-wait after the last one (RFC 5227). The address is in use when another host claims
+// it meanwhile, or when another simulated client holds it already: the client declines it and
+// starts over with a new discover. The probes are sent from the mac of the interface so that the
+// replies come back to it
+func (dc *DhcpClient) probe(ack *layers.DHCPv4, discover *layers.DHCPv4, serverMac net.HardwareAddr) {
+	defer dc.leases.inflight.Done()
+	ip := ack.YourClientIP
+	owner := dc.probes.watch(ip, ack.ClientHWAddr)
+	defer dc.probes.unwatch(ip, ack.ClientHWAddr, owner)
+
+	conflict := dc.leases.holder(ip, ack.ClientHWAddr)
+	for i := 0; conflict == nil && i < probeNum; i++ {
+		if err := dc.sendProbe(ip); err != nil {
+			dc.addMessage(err)
+		}
+		select {
+		case conflict = <-owner:
+		case <-time.After(utility.ProbeWait):
+		}
+	}
+	if conflict == nil {
+		dc.responder.add(ack)
+		if utility.Renew {
+			dc.bind(ack, serverMac)
+		}
+		return
+	}
+
+	_, lease := NewLease(ack)
+	dc.stats.conflict()
+	dc.addMessage(fmt.Sprintf("%s address %s leased by %s is in use by %s, declined",
+		ack.ClientHWAddr, ip, lease.ServerID, conflict))
+	dc.unbind(ack.ClientHWAddr)
+	dc.Leases.remove(ack.ClientHWAddr)
+	decline := NewDeclineFromAck(ack)
+	rediscover := NewDiscover(ack.ClientHWAddr)
+	if dc.Relay != nil {
+		WithRelayOf(discover)(decline)
+		WithRelayOf(discover)(rediscover)
+	}
+	dc.Send(decline, WithTransactionID(rand.Uint32()))
+	dc.Send(rediscover, WithTransactionID(rand.Uint32()))
+}
+
+// arpLoop hands the arp packets of other hosts to the probes
+func (dc *DhcpClient) arpLoop() {
+	defer dc.wg.Done()
+	for {
+		select {
+		case <-dc.stop:
+			return
+		default:
+			recvBuf := make([]byte, MAXUDPReceivedPacketSize)
+			dc.arp.SetReadDeadline(time.Now().Add(DefaultReadTimeout))
+			n, _, err := dc.arp.ReadFrom(recvBuf)
+			if err != nil {
+				if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+					dc.addMessage(err)
+				}
+				continue
+			}
+			ip, mac := ParseARP(recvBuf[:n])
+			if ip == nil || bytes.Equal(mac, dc.Iface.HardwareAddr) {
+				continue
+			}
+			dc.probes.conflict(ip, mac)
+		}
+	}
+}
+
+// ParseARP returns the address an arp frame claims and the mac claiming it.
+// A probe claims its target address, other packets their sender address
+func ParseARP(data []byte) (net.IP, net.HardwareAddr) {
+	packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+	arpLayer := packet.Layer(layers.LayerTypeARP)
+	if arpLayer == nil {
+		return nil, nil
+	}
+	arp := arpLayer.(*layers.ARP)
+	if len(arp.SourceProtAddress) != 4 || len(arp.DstProtAddress) != 4 {
+		return nil, nil
+	}
+	ip := net.IP(arp.SourceProtAddress)
+	if ip.Equal(net.IPv4zero) {
+		if arp.Operation != layers.ARPRequest {
+			return nil, nil
+		}
+		ip = net.IP(arp.DstProtAddress)
+	}
+	return ip, net.HardwareAddr(arp.SourceHwAddress)
+}
+
+// newProbe builds the arp probe frame for the ip sent from the mac
+func newProbe(mac net.HardwareAddr, ip net.IP) ([]byte, error) {
+	eth := layers.Ethernet{
+		EthernetType: layers.EthernetTypeARP,
+		SrcMAC:       mac,
+		DstMAC:       layers.EthernetBroadcast,
+	}
+	arp := layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         layers.ARPRequest,
+		SourceHwAddress:   mac,
+		SourceProtAddress: net.IPv4zero.To4(),
+		DstHwAddress:      make([]byte, 6),
+		DstProtAddress:    ip.To4(),
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{
+		FixLengths: true,
+	}
+	if err := gopacket.SerializeLayers(buf, opts, &eth, &arp); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
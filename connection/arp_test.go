@@ -0,0 +1,91 @@
+package connection
+
+import (
+	"dhcptest/layers"
+	"github.com/google/gopacket"
+	"net"
+	"testing"
+)
+
+func TestParseARP(t *testing.T) {
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+	ip := net.IPv4(10, 0, 0, 5)
+
+	probe, err := newProbe(mac, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimed, owner := ParseARP(probe)
+	if !claimed.Equal(ip) || owner.String() != mac.String() {
+		t.Errorf("probe claims %s by %s, want %s by %s", claimed, owner, ip, mac)
+	}
+
+	other, _ := net.ParseMAC("02:00:00:00:00:02")
+	eth := layers.Ethernet{EthernetType: layers.EthernetTypeARP, SrcMAC: other, DstMAC: mac}
+	reply := layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         layers.ARPReply,
+		SourceHwAddress:   other,
+		SourceProtAddress: ip.To4(),
+		DstHwAddress:      mac,
+		DstProtAddress:    net.IPv4zero.To4(),
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, &eth, &reply); err != nil {
+		t.Fatal(err)
+	}
+	claimed, owner = ParseARP(buf.Bytes())
+	if !claimed.Equal(ip) || owner.String() != other.String() {
+		t.Errorf("reply claims %s by %s, want %s by %s", claimed, owner, ip, other)
+	}
+
+	if claimed, _ := ParseARP([]byte{1, 2, 3}); claimed != nil {
+		t.Errorf("truncated frame claims %s", claimed)
+	}
+}
+
+func TestProber(t *testing.T) {
+	p := newProber()
+	ip := net.IPv4(10, 0, 0, 5)
+	first, _ := net.ParseMAC("02:00:00:00:00:01")
+	second, _ := net.ParseMAC("02:00:00:00:00:02")
+	host, _ := net.ParseMAC("02:00:00:00:00:fe")
+
+	//the server acked the same address to two clients
+	firstOwner := p.watch(ip, first)
+	secondOwner := p.watch(ip, second)
+	p.conflict(ip, host)
+	for _, owner := range []chan net.HardwareAddr{firstOwner, secondOwner} {
+		select {
+		case mac := <-owner:
+			if mac.String() != host.String() {
+				t.Errorf("conflict with %s, want %s", mac, host)
+			}
+		default:
+			t.Error("a probe misses the conflict")
+		}
+	}
+
+	//a client claiming the address is a conflict for the other one only
+	p.conflict(ip, second)
+	if len(secondOwner) != 0 || len(firstOwner) != 1 {
+		t.Errorf("the claim of the second client reaches %d and %d probes", len(firstOwner), len(secondOwner))
+	}
+	<-firstOwner
+
+	//the end of a probe leaves the other one and a newer probe of the same client alone
+	p.unwatch(ip, first, firstOwner)
+	again := p.watch(ip, second)
+	p.unwatch(ip, second, secondOwner)
+	p.conflict(ip, host)
+	if len(again) != 1 || len(firstOwner) != 0 {
+		t.Errorf("after the unwatches %d and %d conflicts, want the newer probe only", len(again), len(firstOwner))
+	}
+	p.unwatch(ip, second, again)
+	if len(p.probes) != 0 {
+		t.Errorf("probes %v left", p.probes)
+	}
+}
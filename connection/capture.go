@@ -0,0 +1,312 @@
+package connection
+
+import (
+	"bufio"
+	"dhcptest/layers"
+	"encoding/binary"
+	"fmt"
+	"github.com/google/gopacket"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// captureBuffer is the number of frames waiting to be written, the frames
+	// past it are dropped rather than slowing the loops down
+	captureBuffer = 4096
+	// linkTypeEthernet is the link type of the captured frames
+	linkTypeEthernet = 1
+
+	pcapMagic         = 0xa1b2c3d4
+	pcapngSectionType = 0x0a0d0d0a
+	pcapngByteOrder   = 0x1a2b3c4d
+	pcapngIfaceType   = 1
+	pcapngPacketType  = 6
+	// epb_flags of the enhanced packet blocks, the direction is in the low bits
+	pcapngInbound  = 1
+	pcapngOutbound = 2
+)
+
+// capturedFrame is a frame waiting to be written
+type capturedFrame struct {
+	at       time.Time
+	frame    []byte
+	outbound bool
+}
+
+// Capture writes the frames the clients send and receive to a pcap file, or
+// to a pcapng file marking the direction of each frame, which Wireshark opens.
+// The frames are written in the background, the ones arriving while the buffer
+// is full are dropped and counted. The file is rotated to name-1.ext, name-2.ext
+// and so on once it holds the rotation size
+type Capture struct {
+	path    string
+	ng      bool
+	rotate  int64
+	iface   string
+	lock    sync.Mutex
+	closed  bool
+	frames  chan capturedFrame
+	done    chan error
+	dropped uint64
+}
+
+// NewCapture creates the file at path and starts writing the frames of the
+// interface to it, in pcap when the extension is .pcap and in pcapng
+// otherwise. rotate is the size in bytes a file is rotated at, 0 never rotates
+func NewCapture(path string, rotate int64, iface *net.Interface) (*Capture, error) {
+	c := &Capture{
+		path:   path,
+		ng:     !strings.EqualFold(filepath.Ext(path), ".pcap"),
+		rotate: rotate,
+		frames: make(chan capturedFrame, captureBuffer),
+		done:   make(chan error, 1),
+	}
+	if iface != nil {
+		c.iface = iface.Name
+	}
+	w, err := c.create(0)
+	if err != nil {
+		return nil, err
+	}
+	go c.writeLoop(w)
+	return c, nil
+}
+
+// sent captures a frame sent by the client
+func (c *Capture) sent(frame []byte) {
+	c.add(frame, true)
+}
+
+// received captures a frame received by the client
+func (c *Capture) received(frame []byte) {
+	c.add(frame, false)
+}
+
+// udp captures a udp payload in a frame built around it, for the sockets
+// which give no frame. mac is the mac of the client, the frames from the
+// remote end come from the zero mac
+func (c *Capture) udp(outbound bool, mac net.HardwareAddr, local *net.UDPAddr, remote *net.UDPAddr, payload []byte) {
+	if c == nil {
+		return
+	}
+	src, dst := local, remote
+	srcMac, dstMac := mac, layers.EthernetBroadcast
+	if !outbound {
+		src, dst = remote, local
+		srcMac, dstMac = make(net.HardwareAddr, 6), mac
+	}
+	eth := layers.Ethernet{SrcMAC: srcMac, DstMAC: dstMac}
+	udp := layers.UDP{SrcPort: layers.UDPPort(src.Port), DstPort: layers.UDPPort(dst.Port)}
+	var ip gopacket.SerializableLayer
+	if src.IP.To4() != nil && dst.IP.To4() != nil {
+		ip4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: src.IP.To4(), DstIP: dst.IP.To4()}
+		eth.EthernetType = layers.EthernetTypeIPv4
+		udp.SetNetworkLayerForChecksum(ip4)
+		ip = ip4
+	} else {
+		ip6 := &layers.IPv6{Version: 6, HopLimit: 1, NextHeader: layers.IPProtocolUDP, SrcIP: src.IP, DstIP: dst.IP}
+		eth.EthernetType = layers.EthernetTypeIPv6
+		if outbound {
+			eth.DstMAC = AllDHCPMac
+		}
+		udp.SetNetworkLayerForChecksum(ip6)
+		ip = ip6
+	}
+	frame, err := serialize(&eth, ip, &udp, gopacket.Payload(payload))
+	if err != nil {
+		return
+	}
+	c.add(frame, outbound)
+}
+
+func (c *Capture) add(frame []byte, outbound bool) {
+	if c == nil {
+		return
+	}
+	f := capturedFrame{at: time.Now(), frame: append([]byte{}, frame...), outbound: outbound}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return
+	}
+	select {
+	case c.frames <- f:
+	default:
+		atomic.AddUint64(&c.dropped, 1)
+	}
+}
+
+// Dropped returns the number of frames dropped while the buffer was full
+func (c *Capture) Dropped() uint64 {
+	if c == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&c.dropped)
+}
+
+// Close writes the buffered frames and closes the file, it returns the first
+// error met while writing
+func (c *Capture) Close() error {
+	if c == nil {
+		return nil
+	}
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.frames)
+	c.lock.Unlock()
+	return <-c.done
+}
+
+// name returns the name of the nth file of the rotation
+func (c *Capture) name(n int) string {
+	if n == 0 {
+		return c.path
+	}
+	ext := filepath.Ext(c.path)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(c.path, ext), n, ext)
+}
+
+// create creates the nth file of the rotation and writes its header
+func (c *Capture) create(n int) (*captureFile, error) {
+	file, err := os.Create(c.name(n))
+	if err != nil {
+		return nil, err
+	}
+	w := &captureFile{file: file, writer: bufio.NewWriter(file), ng: c.ng, index: n}
+	if c.ng {
+		w.sectionHeader()
+		w.interfaceDescription(c.iface)
+	} else {
+		w.fileHeader()
+	}
+	return w, nil
+}
+
+func (c *Capture) writeLoop(w *captureFile) {
+	var err error
+	for f := range c.frames {
+		if err != nil {
+			continue
+		}
+		if c.rotate > 0 && w.records > 0 && w.size+w.recordLen(f.frame) > c.rotate {
+			if err = w.close(); err == nil {
+				w, err = c.create(w.index + 1)
+			}
+			if err != nil {
+				w = nil
+				continue
+			}
+		}
+		w.record(f)
+	}
+	if w != nil {
+		if cerr := w.close(); err == nil {
+			err = cerr
+		}
+	}
+	c.done <- err
+}
+
+// captureFile is one file of the rotation
+type captureFile struct {
+	file    *os.File
+	writer  *bufio.Writer
+	ng      bool
+	index   int
+	size    int64
+	records int
+}
+
+func (w *captureFile) write(data ...interface{}) {
+	for _, d := range data {
+		binary.Write(w.writer, binary.LittleEndian, d)
+		w.size += int64(binary.Size(d))
+	}
+}
+
+// pad writes the zeros aligning n bytes to 32 bits
+func (w *captureFile) pad(n int) {
+	w.write(make([]byte, (4-n%4)%4))
+}
+
+// fileHeader writes the global header of a pcap file
+func (w *captureFile) fileHeader() {
+	w.write(uint32(pcapMagic), uint16(2), uint16(4), int32(0), uint32(0), uint32(MAXUDPReceivedPacketSize), uint32(linkTypeEthernet))
+}
+
+// sectionHeader writes the section header block of a pcapng file, the length
+// of the section is left unspecified
+func (w *captureFile) sectionHeader() {
+	application := []byte("dhcptest")
+	optionsLen := 4 + len(application) + (4-len(application)%4)%4 + 4
+	total := uint32(28 + optionsLen)
+	w.write(uint32(pcapngSectionType), total, uint32(pcapngByteOrder), uint16(1), uint16(0), int64(-1))
+	//shb_userappl
+	w.write(uint16(4), uint16(len(application)), application)
+	w.pad(len(application))
+	w.write(uint32(0), total)
+}
+
+// interfaceDescription writes the interface description block of the
+// ethernet interface, with timestamps in nanoseconds
+func (w *captureFile) interfaceDescription(name string) {
+	optionsLen := 8 + 4
+	if len(name) > 0 {
+		optionsLen += 4 + len(name) + (4-len(name)%4)%4
+	}
+	total := uint32(20 + optionsLen)
+	w.write(uint32(pcapngIfaceType), total, uint16(linkTypeEthernet), uint16(0), uint32(0))
+	if len(name) > 0 {
+		//if_name
+		w.write(uint16(2), uint16(len(name)), []byte(name))
+		w.pad(len(name))
+	}
+	//if_tsresol
+	w.write(uint16(9), uint16(1), uint8(9), []byte{0, 0, 0})
+	w.write(uint32(0), total)
+}
+
+// recordLen returns the size of the record of the frame
+func (w *captureFile) recordLen(frame []byte) int64 {
+	if !w.ng {
+		return int64(16 + len(frame))
+	}
+	return int64(32 + len(frame) + (4-len(frame)%4)%4 + 12)
+}
+
+// record writes a frame, in an enhanced packet block with its direction in pcapng
+func (w *captureFile) record(f capturedFrame) {
+	w.records++
+	if !w.ng {
+		w.write(uint32(f.at.Unix()), uint32(f.at.Nanosecond()/1000), uint32(len(f.frame)), uint32(len(f.frame)), f.frame)
+		return
+	}
+	total := uint32(w.recordLen(f.frame))
+	ts := uint64(f.at.UnixNano())
+	w.write(uint32(pcapngPacketType), total, uint32(0), uint32(ts>>32), uint32(ts), uint32(len(f.frame)), uint32(len(f.frame)), f.frame)
+	w.pad(len(f.frame))
+	flags := uint32(pcapngInbound)
+	if f.outbound {
+		flags = pcapngOutbound
+	}
+	//epb_flags
+	w.write(uint16(2), uint16(4), flags, uint32(0), total)
+}
+
+func (w *captureFile) close() error {
+	err := w.writer.Flush()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,131 @@
+package connection
+
+import (
+	"bytes"
+	"dhcptest/layers"
+	"encoding/binary"
+	"github.com/google/gopacket"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// pcapngPackets walks the blocks of a pcapng file, it returns the frames of
+// the enhanced packet blocks and their epb_flags
+func pcapngPackets(t *testing.T, data []byte) (frames [][]byte, flags []uint32) {
+	var types []uint32
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatalf("%d bytes left after the blocks", len(data))
+		}
+		blockType, total := binary.LittleEndian.Uint32(data), binary.LittleEndian.Uint32(data[4:])
+		if total%4 != 0 || int(total) > len(data) || binary.LittleEndian.Uint32(data[total-4:]) != total {
+			t.Fatalf("block %#x has inconsistent lengths", blockType)
+		}
+		types = append(types, blockType)
+		if blockType == pcapngPacketType {
+			length := binary.LittleEndian.Uint32(data[20:])
+			frames = append(frames, data[28:28+length])
+			options := data[28+length+(4-length%4)%4 : total-4]
+			if binary.LittleEndian.Uint16(options) != 2 {
+				t.Fatal("the packet block has no epb_flags")
+			}
+			flags = append(flags, binary.LittleEndian.Uint32(options[4:]))
+		}
+		data = data[total:]
+	}
+	if len(types) < 2 || types[0] != pcapngSectionType || types[1] != pcapngIfaceType {
+		t.Fatalf("blocks %x, want a section header and an interface description first", types)
+	}
+	return frames, flags
+}
+
+func TestCapturePcapng(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out.pcapng")
+	c, err := NewCapture(path, 0, &net.Interface{Name: "eth0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	out := []byte{1, 2, 3, 4, 5}
+	c.sent(out)
+	c.received(out[:3])
+	discover := NewDiscover(mac)
+	buf := gopacket.NewSerializeBuffer()
+	if err := discover.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatal(err)
+	}
+	c.udp(true, mac, &net.UDPAddr{IP: net.IPv4zero, Port: 68}, &net.UDPAddr{IP: net.IPv4bcast, Port: 67}, buf.Bytes())
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	c.sent(out)
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, flags := pcapngPackets(t, data)
+	if len(frames) != 3 || !bytes.Equal(frames[0], out) || !bytes.Equal(frames[1], out[:3]) {
+		t.Fatalf("frames %x", frames)
+	}
+	if flags[0] != pcapngOutbound || flags[1] != pcapngInbound || flags[2] != pcapngOutbound {
+		t.Errorf("flags %v, want outbound, inbound and outbound", flags)
+	}
+	packet, srcMac, err := DecodeFrame(frames[2], layers.LayerTypeEthernet)
+	if err != nil || packet == nil || packet.MessageType() != layers.DHCPMsgTypeDiscover || srcMac.String() != mac.String() {
+		t.Errorf("the frame built around the payload decodes to %v from %s: %v", packet, srcMac, err)
+	}
+}
+
+func TestCapturePcapRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	frame := make([]byte, 300)
+	//a global header and three records per file
+	c, err := NewCapture(filepath.Join(dir, "out.pcap"), 24+3*(16+300), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 7; i++ {
+		frame[0] = byte(i)
+		c.sent(frame)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, name := range []string{"out.pcap", "out-1.pcap", "out-2.pcap"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if binary.LittleEndian.Uint32(data) != pcapMagic || binary.LittleEndian.Uint32(data[20:]) != linkTypeEthernet {
+			t.Fatalf("%s has no pcap header", name)
+		}
+		records := 0
+		for data = data[24:]; len(data) > 0; records++ {
+			length := binary.LittleEndian.Uint32(data[8:])
+			if data[16] != byte(3*i+records) {
+				t.Errorf("%s: record %d holds frame %d", name, records, data[16])
+			}
+			data = data[16+length:]
+		}
+		if want := []int{3, 3, 1}[i]; records != want {
+			t.Errorf("%s: %d records, want %d", name, records, want)
+		}
+	}
+}
@@ -20,15 +20,41 @@ type Callback func(*Lease)
 type DhcpClient struct {
 	//ClientMac net.HardwareAddr
 	Iface *net.Interface
+	// Relay makes the client act as a relay agent when it is set
+	Relay *Relay
+	// Identity adds the client id and the host name of every client when it is set
+	Identity *Identity
+	// Exporter writes the record of every transaction when it is set
+	Exporter *Exporter
+	// Metrics counts the packets and the transactions when it is set
+	Metrics *Metrics
+	// Leases holds the lease acked to every client, it is kept from one
+	// Start to the next. Open sets an in-memory store when it is nil
+	Leases *LeaseStore
+	// Servers records the servers of all the offers received when it is set
+	Servers *ServerWatch
+	// Capture writes the frames sent and received when it is set
+	Capture *Capture
+	// Validator checks the offers and the acks when it is set
+	Validator *Validator
 	BufferSize int
 	ifRequest bool
 	ifLog     bool
 	connection net.PacketConn
+	arp net.PacketConn
+	probes *prober
+	templated *deviceOptions
+	respond net.PacketConn
+	// responder is nil when the responder socket is not open, the leased
+	// addresses are not tracked then
+	responder *responder
 	logger *utility.Log
 	sendQueue chan *layers.DHCPv4
 	messages chan interface{}
 	packets map[uint32]*PacketResponse
 	packetsLock *sync.Mutex
+	leases *leaseTable
+	stats *Statistics
 	stop chan int
 	requestSend chan int
 	requestGet chan int
@@ -43,16 +69,60 @@ func (dc *DhcpClient) Open() error {
 	dc.wg = new(sync.WaitGroup)
 	dc.logger = &utility.Log{Logger: utility.DHCPLogger()}
 	var err error
-	dc.connection, err = UDPListener()(dc.Iface)
+	dc.connection, err = listenClients(dc.Iface)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
+	if dc.Leases == nil {
+		dc.Leases = NewLeaseStore()
+	}
+	dc.templated = newDeviceOptions()
+	if utility.ARPProbe {
+		dc.arp, err = listenARP(dc.Iface)
+		if err != nil {
+			dc.connection.Close()
+			return err
+		}
+	}
+	//the servers arp for the address of a renewing client before they answer
+	if utility.Respond || utility.Renew {
+		dc.respond, err = listenResponder(dc.Iface)
+		if err != nil {
+			if dc.arp != nil {
+				dc.arp.Close()
+			}
+			dc.connection.Close()
+			return err
+		}
+	}
 	return nil
 
 }
 
+// OpenResponder opens the socket the arp requests for the addresses of the
+// clients are answered on, when --respond or --renew hasn't opened it. The
+// servers arp for the ciaddr of an inform before they unicast the ack to it.
+// It takes effect at the next Start
+func (dc *DhcpClient) OpenResponder() error {
+	if dc.respond != nil {
+		return nil
+	}
+	conn, err := listenResponder(dc.Iface)
+	if err != nil {
+		return err
+	}
+	dc.respond = conn
+	return nil
+}
+
 func (dc *DhcpClient) Close() error {
+	if dc.arp != nil {
+		dc.arp.Close()
+	}
+	if dc.respond != nil {
+		dc.respond.Close()
+	}
 	err := dc.connection.Close()
 	if err != nil {
 		return err
@@ -67,6 +137,9 @@ func (dc *DhcpClient) Start(size int, ifRequest bool, ifLog bool) {
 	dc.sendQueue = make(chan *layers.DHCPv4, dc.BufferSize)
 	dc.messages = make(chan interface{}, dc.BufferSize)
 	dc.packets = make(map[uint32]*PacketResponse)
+	dc.leases = newLeaseTable()
+	dc.stats = NewStatistics(dc.Metrics)
+	dc.probes = newProber()
 	dc.requestSend = make(chan int, dc.BufferSize)
 	dc.requestGet = make(chan int)
 	dc.responseSend =  make(chan int, dc.BufferSize)
@@ -81,6 +154,16 @@ func (dc *DhcpClient) Start(size int, ifRequest bool, ifLog bool) {
 		dc.workers = append(dc.workers, dc.counter)
 		dc.wg.Add(1)
 	}
+	if dc.arp != nil {
+		dc.workers = append(dc.workers, dc.arpLoop)
+		dc.wg.Add(1)
+	}
+	dc.responder = nil
+	if dc.respond != nil {
+		dc.responder = newResponder()
+		dc.workers = append(dc.workers, dc.respondLoop)
+		dc.wg.Add(1)
+	}
 	dc.startWorkers()
 }
 
@@ -133,8 +216,14 @@ func (dc *DhcpClient) GetRequestAndResponse() (request int, response int) {
 	return request, response
 }
 
+// Stats returns the latency statistics of the running client
+func (dc *DhcpClient) Stats() *Statistics {
+	return dc.stats
+}
+
 func (dc *DhcpClient) Stop() {
 	log.Printf("[%s] shutting down dhcp client", dc.Iface.Name)
+	dc.closeLeases(utility.Release)
 	dc.stopWorkers()
 	dc.wg.Wait()
 	close(dc.sendQueue)
@@ -143,6 +232,12 @@ func (dc *DhcpClient) Stop() {
 	close(dc.responseSend)
 	close(dc.requestGet)
 	close(dc.responseGet)
+	total := dc.stats.Total()
+	log.Printf("[%s] total %s", dc.Iface.Name, total)
+	dc.Exporter.Summary("total", total)
+	if err := dc.Leases.Save(); err != nil {
+		log.Printf("[%s] save leases: %s", dc.Iface.Name, err)
+	}
 	log.Printf("[%s] shutting down dhcp client over", dc.Iface.Name)
 
 }
@@ -154,25 +249,32 @@ func (dc *DhcpClient) stopWorkers() {
 	}
 }
 
-func (dc *DhcpClient) send(packet *layers.DHCPv4) error {
+func (dc *DhcpClient) send(packet *layers.DHCPv4, route *Route) error {
+	if route == nil {
+		route = BroadcastRoute
+	}
 
 	eth := layers.Ethernet{
 		EthernetType: layers.EthernetTypeIPv4,
 		SrcMAC: dc.Iface.HardwareAddr,
-		DstMAC: layers.EthernetBroadcast,
+		DstMAC: route.DstMAC,
+	}
+	if eth.DstMAC == nil {
+		eth.DstMAC = layers.EthernetBroadcast
 	}
 
 	ip := layers.IPv4{
 		Version: 4,
 		TTL:    64,
-		SrcIP:  net.IPv4(0, 0, 0, 0),
-		DstIP:  net.IPv4bcast,
+		SrcIP:  route.SrcIP,
+		DstIP:  route.DstIP,
 		Protocol: layers.IPProtocolUDP,
 	}
 
+	srcPort, dstPort := route.ports()
 	udp := layers.UDP{
-		SrcPort: 68,
-		DstPort: 67,
+		SrcPort: layers.UDPPort(srcPort),
+		DstPort: layers.UDPPort(dstPort),
 	}
 
 	buf := gopacket.NewSerializeBuffer()
@@ -190,6 +292,9 @@ func (dc *DhcpClient) send(packet *layers.DHCPv4) error {
 
 	dc.connection.SetWriteDeadline(time.Now().Add(DefaultWriteTimeout))
 	_, err = dc.connection.WriteTo(buf.Bytes(), &raw.Addr{HardwareAddr:eth.DstMAC})
+	if err == nil {
+		dc.Capture.sent(buf.Bytes())
+	}
 	return err
 }
 
@@ -227,12 +332,16 @@ func (dc *DhcpClient) sendLoop() {
 			if ok {
 				if packet.MessageType() == layers.DHCPMsgTypeDiscover {
 					pr.Call(NewEvent(discoverDequeue, packet))
-				} else if packet.MessageType() == layers.DHCPMsgTypeRequest {
+				} else if msgType := packet.MessageType(); msgType == layers.DHCPMsgTypeRequest || msgType == layers.DHCPMsgTypeInform {
 					pr.Call(NewEvent(requestDequeue, packet))
 				}
-				err := dc.send(packet)
+				err := dc.send(packet, pr.route)
 				if err != nil {
+					dc.stats.sendError()
+					pr.Call(NewEvent(sendFailed, err))
 					dc.addMessage(err)
+				} else {
+					dc.Metrics.packetSent(packet.MessageType().String())
 				}
 				if dc.ifLog {
 					dc.addMessage(packet)
@@ -281,19 +390,34 @@ func (dc *DhcpClient) listenLoop() {
 		default:
 			recvBuf := make([]byte, MAXUDPReceivedPacketSize)
 			dc.connection.SetReadDeadline(time.Now().Add(DefaultReadTimeout))
-			_, _, err := dc.connection.ReadFrom(recvBuf)
+			n, _, err := dc.connection.ReadFrom(recvBuf)
 
 			if err != nil {
 				dc.addMessage(err)
 				continue
 			}
 
-			packet := ParsePacket(recvBuf, layers.LayerTypeEthernet)
+			packet, serverMac, err := DecodeFrame(recvBuf[:n], layers.LayerTypeEthernet)
+			if err != nil || packet != nil {
+				dc.Capture.received(recvBuf[:n])
+			}
+			if err != nil {
+				dc.stats.malformedReceived()
+				if dc.ifLog {
+					dc.addMessage(fmt.Errorf("malformed packet: %s", err))
+				}
+				continue
+			}
 
 			if packet == nil {
 				continue
 			}
+			dc.Servers.observe(packet, serverMac)
 			dc.packetsLock.Lock()
+			if pr, ok := dc.packets[packet.Xid]; ok && packet.Operation != layers.DHCPOpReply && isReply(packet) {
+				//an offer or an ack sent as a BOOTREQUEST answers nothing
+				pr.violated(dc.Validator.check(pr.requestOf(packet), packet))
+			}
 			if pr, ok := dc.packets[packet.Xid];ok && packet.Operation == layers.DHCPOpReply {
 				if dc.ifLog {
 					dc.addMessage(packet)
@@ -301,15 +425,44 @@ func (dc *DhcpClient) listenLoop() {
 					dc.responseSend <- 1
 
 				}
-				if packet.MessageType() == layers.DHCPMsgTypeOffer {
+				dc.Metrics.packetReceived(packet.MessageType().String())
+				if isReply(packet) {
+					pr.violated(dc.Validator.check(pr.requestOf(packet), packet))
+				}
+				if !echoes(pr.requestOf(packet), packet) {
+					//the reply to another client sharing the xid answers nothing, the validator counts it
+				} else if packet.MessageType() == layers.DHCPMsgTypeOffer {
 					pr.Call(NewEvent(receivedOffer, packet))
-					if dc.ifRequest {
-						dc.sendQueue <- NewRequestFromOffer(packet)
+					if pr.request {
+						//a replayed request carries the relay fields already
+						relayed := pr.selecting != nil
+						request := pr.requestFor(packet)
+						if discover := pr.Packet(layers.DHCPMsgTypeDiscover); dc.Relay != nil && discover != nil && !relayed {
+							WithRelayOf(discover)(request)
+						}
+						dc.templated.apply(request)
+						dc.Identity.Modifier()(request)
+						dc.sendQueue <- request
 					}
 				} else if packet.MessageType() == layers.DHCPMsgTypeAck {
 					pr.Call(NewEvent(receivedAck, packet))
+					if _, lease := NewLease(packet); pr.Packet(layers.DHCPMsgTypeInform) == nil {
+						dc.Leases.put(lease, packet.ClientHWAddr, serverMac)
+					}
+					discover := pr.Packet(layers.DHCPMsgTypeDiscover)
+					if dc.arp != nil && discover != nil && pr.Packet(layers.DHCPMsgTypeAck) == packet {
+						dc.startProbe(packet, discover, serverMac)
+					} else {
+						dc.responder.add(packet)
+						if utility.Renew {
+							dc.bind(packet, serverMac)
+						}
+					}
 				} else if packet.MessageType() == layers.DHCPMsgTypeNak {
 					pr.Call(NewEvent(receivedNak, packet))
+					dc.unbind(packet.ClientHWAddr)
+					dc.Leases.remove(packet.ClientHWAddr)
+					dc.responder.remove(packet.ClientHWAddr)
 				}
 
 			}
@@ -319,14 +472,42 @@ func (dc *DhcpClient) listenLoop() {
 }
 
 func (dc *DhcpClient) Send(packet *layers.DHCPv4, modifiers ...Modifier) *PacketResponse {
+	return dc.SendTo(nil, packet, modifiers...)
+}
+
+// SendTo works as Send but addresses the packet with the given route,
+// a nil route broadcasts the packet, or sends it through the relay
+func (dc *DhcpClient) SendTo(route *Route, packet *layers.DHCPv4, modifiers ...Modifier) *PacketResponse {
 	for _, modifier := range modifiers {
 		modifier(packet)
 	}
+	return dc.sendTo(route, packet, nil)
+}
+
+// sendTo queues the packet in a new transaction, the offers are answered with
+// the given request when it is set
+func (dc *DhcpClient) sendTo(route *Route, packet *layers.DHCPv4, request *layers.DHCPv4) *PacketResponse {
+	dc.templated.apply(packet)
+	dc.Identity.Modifier()(packet)
+	if route == nil && dc.Relay != nil {
+		route = dc.Relay.Route()
+	} else if route == nil && packet.MessageType() == layers.DHCPMsgTypeInform {
+		//an informing client has its address already and broadcasts from it
+		route = &Route{SrcIP: packet.ClientIP, DstIP: net.IPv4bcast, DstMAC: layers.EthernetBroadcast}
+	}
+
+	if packet.MessageType() == layers.DHCPMsgTypeInform {
+		//the ack is unicast to ciaddr
+		dc.responder.claim(packet.ClientHWAddr, packet.ClientIP)
+	}
 
 	pr := NewPacketResponse()
-	dc.packetsLock.Lock()
-	dc.packets[packet.Xid] = pr
-	dc.packetsLock.Unlock()
+	pr.route = route
+	pr.stats = dc.stats
+	pr.export = dc.Exporter
+	pr.request = dc.ifRequest || request != nil
+	pr.selecting = request
+	dc.track(packet.Xid, pr)
 	dc.sendQueue <- packet
 	return pr
 }
@@ -0,0 +1,351 @@
+package connection
+
+import (
+	"dhcptest/layers"
+	"dhcptest/utility"
+	"fmt"
+	"github.com/pinterest/bender"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+//DhcpV6Client simulates DHCPv6 clients, one per mac, from the link-local
+//address of the interface
+type DhcpV6Client struct {
+	Iface      *net.Interface
+	DUIDType   layers.DHCPv6DUIDType
+	// Exporter writes the record of every transaction when it is set
+	Exporter   *Exporter
+	// Metrics counts the packets and the transactions when it is set
+	Metrics    *Metrics
+	// Capture writes the frames sent and received when it is set
+	Capture    *Capture
+	BufferSize int
+	ifRequest  bool
+	ifLog      bool
+	connection net.PacketConn
+	linkLocal  net.IP
+	duidTime   time.Time
+	logger     *utility.Log
+	sendQueue  chan *layers.DHCPv6
+	messages   chan interface{}
+	packets    map[uint32]*PacketResponse6
+	packetsLock *sync.Mutex
+	leasesLock *sync.Mutex
+	leases     map[string]Lease6
+	stats      *Statistics
+	stop       chan int
+	requestSend  chan int
+	requestGet   chan int
+	responseSend chan int
+	responseGet  chan int
+	workers    []func()
+	wg         *sync.WaitGroup
+}
+
+func (dc *DhcpV6Client) Open() error {
+	dc.packetsLock = new(sync.Mutex)
+	dc.leasesLock = new(sync.Mutex)
+	dc.wg = new(sync.WaitGroup)
+	dc.logger = &utility.Log{Logger: utility.DHCPLogger()}
+	if dc.DUIDType == 0 {
+		dc.DUIDType = layers.DHCPv6DUIDTypeLL
+	}
+	dc.duidTime = time.Now()
+	var err error
+	dc.linkLocal, err = linkLocal(dc.Iface)
+	if err != nil {
+		return err
+	}
+	dc.connection, err = listen6(dc.Iface)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (dc *DhcpV6Client) Close() error {
+	return dc.connection.Close()
+}
+
+func (dc *DhcpV6Client) Start(size int, ifRequest bool, ifLog bool) {
+	dc.BufferSize = size
+	dc.ifRequest = ifRequest
+	dc.ifLog = ifLog
+	dc.sendQueue = make(chan *layers.DHCPv6, dc.BufferSize)
+	dc.messages = make(chan interface{}, dc.BufferSize)
+	dc.packets = make(map[uint32]*PacketResponse6)
+	dc.leases = make(map[string]Lease6)
+	dc.stats = NewStatistics(dc.Metrics)
+	dc.requestSend = make(chan int, dc.BufferSize)
+	dc.requestGet = make(chan int)
+	dc.responseSend = make(chan int, dc.BufferSize)
+	dc.responseGet = make(chan int)
+	dc.stop = make(chan int)
+	dc.workers = make([]func(), 0)
+	dc.workers = append(dc.workers, dc.messageLoop)
+	dc.workers = append(dc.workers, dc.listenLoop)
+	dc.workers = append(dc.workers, dc.sendLoop)
+	dc.wg.Add(3)
+	if !dc.ifLog {
+		dc.workers = append(dc.workers, dc.counter)
+		dc.wg.Add(1)
+	}
+	for _, worker := range dc.workers {
+		go worker()
+	}
+}
+
+func (dc *DhcpV6Client) counter() {
+	request, response := 0, 0
+	ticket := time.NewTicker(time.Second * time.Duration(5))
+	defer func() {
+		ticket.Stop()
+		dc.wg.Done()
+	}()
+	for {
+		select {
+		case <-dc.stop:
+			return
+		case amount := <-dc.requestSend:
+			request = request + amount
+		case amount := <-dc.responseSend:
+			response = response + amount
+		case <-ticket.C:
+			dc.requestGet <- request
+			dc.responseGet <- response
+		}
+	}
+}
+
+func (dc *DhcpV6Client) GetRequestAndResponse() (request int, response int) {
+	request = <-dc.requestGet
+	response = <-dc.responseGet
+	return request, response
+}
+
+// Stats returns the latency statistics of the running client
+func (dc *DhcpV6Client) Stats() *Statistics {
+	return dc.stats
+}
+
+// Leases returns the last lease every simulated client got, keyed by mac
+func (dc *DhcpV6Client) Leases() map[string]Lease6 {
+	dc.leasesLock.Lock()
+	defer dc.leasesLock.Unlock()
+	leases := make(map[string]Lease6, len(dc.leases))
+	for mac, lease := range dc.leases {
+		leases[mac] = lease
+	}
+	return leases
+}
+
+func (dc *DhcpV6Client) Stop() {
+	log.Printf("[%s] shutting down dhcpv6 client", dc.Iface.Name)
+	for range dc.workers {
+		dc.stop <- 1
+	}
+	dc.wg.Wait()
+	close(dc.sendQueue)
+	close(dc.messages)
+	close(dc.requestSend)
+	close(dc.responseSend)
+	close(dc.requestGet)
+	close(dc.responseGet)
+	total := dc.stats.Total()
+	log.Printf("[%s] total %s", dc.Iface.Name, total)
+	dc.Exporter.Summary("total", total)
+	log.Printf("[%s] %d clients hold a lease", dc.Iface.Name, len(dc.Leases()))
+	log.Printf("[%s] shutting down dhcpv6 client over", dc.Iface.Name)
+}
+
+func (dc *DhcpV6Client) sendLoop() {
+	defer dc.wg.Done()
+	for {
+		select {
+		case <-dc.stop:
+			return
+		case packet := <-dc.sendQueue:
+			dc.packetsLock.Lock()
+			pr, ok := dc.packets[Xid6(packet)]
+			dc.packetsLock.Unlock()
+			if !ok {
+				dc.addMessage(fmt.Errorf("xid %x not found in packets", Xid6(packet)))
+				continue
+			}
+			if packet.MsgType == layers.DHCPv6MsgTypeSolicit {
+				pr.Call(NewEvent(solicitDequeue, packet))
+			} else if msgType := packet.MsgType; msgType == layers.DHCPv6MsgTypeRequest ||
+				msgType == layers.DHCPv6MsgTypeRenew || msgType == layers.DHCPv6MsgTypeRebind {
+				pr.Call(NewEvent(request6Dequeue, packet))
+			}
+			if err := dc.send(packet); err != nil {
+				dc.stats.sendError()
+				pr.Call(NewEvent(sendFailed, err))
+				dc.addMessage(err)
+			} else {
+				dc.Metrics.packetSent(packet.MsgType.String())
+			}
+			if dc.ifLog {
+				dc.addMessage(packet)
+			} else {
+				dc.requestSend <- 1
+			}
+		}
+	}
+}
+
+func (dc *DhcpV6Client) messageLoop() {
+	defer dc.wg.Done()
+	for {
+		select {
+		case <-dc.stop:
+			return
+		case message := <-dc.messages:
+			dc.logger.PrintLog(message)
+		}
+	}
+}
+
+func (dc *DhcpV6Client) addMessage(message interface{}) {
+	dc.messages <- message
+}
+
+func (dc *DhcpV6Client) listenLoop() {
+	defer dc.wg.Done()
+	for {
+		select {
+		case <-dc.stop:
+			return
+		default:
+			recvBuf := make([]byte, MAXUDPReceivedPacketSize)
+			dc.connection.SetReadDeadline(time.Now().Add(DefaultReadTimeout))
+			n, from, err := dc.connection.ReadFrom(recvBuf)
+			if err != nil {
+				if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+					dc.addMessage(err)
+				}
+				continue
+			}
+
+			packet, err := DecodePacket6(recvBuf[:n], decoder6)
+			if err != nil || packet != nil {
+				dc.captureReceived(recvBuf[:n], from)
+			}
+			if err != nil {
+				dc.stats.malformedReceived()
+				if dc.ifLog {
+					dc.addMessage(fmt.Errorf("malformed packet: %s", err))
+				}
+				continue
+			}
+			if packet == nil {
+				continue
+			}
+			if packet.MsgType != layers.DHCPv6MsgTypeAdverstise && packet.MsgType != layers.DHCPv6MsgTypeReply {
+				continue
+			}
+			dc.packetsLock.Lock()
+			if pr, ok := dc.packets[Xid6(packet)]; ok {
+				if dc.ifLog {
+					dc.addMessage(packet)
+				} else {
+					dc.responseSend <- 1
+				}
+				dc.Metrics.packetReceived(packet.MsgType.String())
+				lease := NewLease6(packet)
+				if packet.MsgType == layers.DHCPv6MsgTypeAdverstise {
+					pr.Call(NewEvent(receivedAdvertise, packet))
+					if pr.request && lease.OK() {
+						dc.sendQueue <- pr.requestFor(packet)
+					}
+				} else {
+					pr.Call(NewEvent(receivedReply, packet))
+					dc.bind(lease)
+				}
+			}
+			dc.packetsLock.Unlock()
+		}
+	}
+}
+
+// bind records the lease of a reply
+func (dc *DhcpV6Client) bind(lease Lease6) {
+	var duid layers.DHCPv6DUID
+	if err := duid.DecodeFromBytes(lease.ClientID); err != nil {
+		return
+	}
+	mac := duid.LinkLayerAddress.String()
+	dc.leasesLock.Lock()
+	if lease.OK() {
+		dc.leases[mac] = lease
+	} else {
+		delete(dc.leases, mac)
+	}
+	dc.leasesLock.Unlock()
+	if dc.ifLog {
+		dc.addMessage(lease)
+	}
+}
+
+// NewSolicit builds the solicit of the simulated client with the given mac
+func (dc *DhcpV6Client) NewSolicit(mac net.HardwareAddr) *layers.DHCPv6 {
+	return NewSolicit(NewDUID(dc.DUIDType, mac, dc.duidTime))
+}
+
+func (dc *DhcpV6Client) Send(packet *layers.DHCPv6, modifiers ...Modifier6) *PacketResponse6 {
+	for _, modifier := range modifiers {
+		modifier(packet)
+	}
+	return dc.sendWith(packet, nil)
+}
+
+// sendWith queues the packet in a new transaction, the advertises are
+// answered with the given request when it is set
+func (dc *DhcpV6Client) sendWith(packet *layers.DHCPv6, request *layers.DHCPv6) *PacketResponse6 {
+	pr := NewPacketResponse6()
+	pr.stats = dc.stats
+	pr.export = dc.Exporter
+	pr.request = dc.ifRequest || request != nil
+	pr.selecting = request
+	dc.track(Xid6(packet), pr)
+	dc.sendQueue <- packet
+	return pr
+}
+
+// CreateExecutor6 creates a new DHCPv6 RequestExecutor.
+func CreateExecutor6(client *DhcpV6Client) bender.RequestExecutor {
+	return func(_ int64, request interface{}) (interface{}, error) {
+		packet, ok := request.(*layers.DHCPv6)
+		if !ok {
+			return nil, fmt.Errorf("invalid request type %T, want: *layers.DHCPv6", request)
+		}
+		return client.Send(packet, WithTransactionID6(rand.Uint32())), nil
+	}
+}
+
+// linkLocal returns the link-local address of the interface, the replies of
+// the servers are sent to it. An EUI-64 address is made up when the interface
+// has none
+func linkLocal(iface *net.Interface) (net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		ip, _, err := net.ParseCIDR(addr.String())
+		if err == nil && ip.To4() == nil && ip.IsLinkLocalUnicast() {
+			return ip, nil
+		}
+	}
+	if len(iface.HardwareAddr) != 6 {
+		return nil, fmt.Errorf("no link-local address on %s", iface.Name)
+	}
+	mac := iface.HardwareAddr
+	ip := net.IP{0xfe, 0x80, 0, 0, 0, 0, 0, 0, mac[0] ^ 0x02, mac[1], mac[2], 0xff, 0xfe, mac[3], mac[4], mac[5]}
+	return ip, nil
+}
@@ -8,6 +8,13 @@ var (
 	receivedNak       = "received nak"
 	offerTimeout      = "offer time out"
 	ackNakTimeout     = "ack Timeout"
+	solicitDequeue    = "solicit send"
+	request6Dequeue   = "v6 request send"
+	receivedAdvertise = "received advertise"
+	receivedReply     = "received reply"
+	advertiseTimeout  = "advertise time out"
+	replyTimeout      = "reply time out"
+	sendFailed        = "send failed"
 )
 
 type PacketEventHandler func(e PacketEvent)
@@ -0,0 +1,269 @@
+package connection
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Final states of a transaction. A DHCPv6 transaction uses the same states,
+// an advertise standing for an offer and a reply for an ack or a nak
+const (
+	StateOffered      = "offered"
+	StateAcked        = "acked"
+	StateNaked        = "naked"
+	StateOfferTimeout = "offer-timeout"
+	StateAckTimeout   = "ack-timeout"
+)
+
+// Message is one packet of a transaction, sent or received
+type Message struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+}
+
+// Record is the result of one transaction
+type Record struct {
+	MAC          string    `json:"mac"`
+	Xid          string    `json:"xid"`
+	Messages     []Message `json:"messages"`
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	OfferLatency float64   `json:"offer_latency_ms,omitempty"`
+	AckLatency   float64   `json:"ack_latency_ms,omitempty"`
+	OfferedIP    string    `json:"offered_ip,omitempty"`
+	ServerID     string    `json:"server_id,omitempty"`
+	State        string    `json:"state"`
+	Error        string    `json:"error,omitempty"`
+	Violations   []Rule    `json:"violations,omitempty"`
+}
+
+// add appends a message to the sequence of the transaction
+func (r *Record) add(msgType string) {
+	now := time.Now()
+	if len(r.Messages) == 0 {
+		r.Start = now
+	}
+	r.Messages = append(r.Messages, Message{Type: msgType, Time: now})
+}
+
+// sequence returns the messages as "type:ms" pairs, the time of a message is
+// counted from the start of the transaction
+func (r *Record) sequence() string {
+	var messages []string
+	for _, message := range r.Messages {
+		messages = append(messages, fmt.Sprintf("%s:%.3f", message.Type, milliseconds(message.Time.Sub(r.Start))))
+	}
+	return strings.Join(messages, ";")
+}
+
+// violations returns the rules the replies broke separated by semicolons
+func (r *Record) violations() string {
+	var rules []string
+	for _, rule := range r.Violations {
+		rules = append(rules, string(rule))
+	}
+	return strings.Join(rules, ";")
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// LatencyRecord holds the percentiles of a Latency in milliseconds
+type LatencyRecord struct {
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	P999 float64 `json:"p999"`
+	Max  float64 `json:"max"`
+}
+
+func newLatencyRecord(l Latency) LatencyRecord {
+	return LatencyRecord{
+		P50:  milliseconds(l.P50),
+		P90:  milliseconds(l.P90),
+		P99:  milliseconds(l.P99),
+		P999: milliseconds(l.P999),
+		Max:  milliseconds(l.Max),
+	}
+}
+
+// SummaryRecord is a Summary as it is exported, the name tells an interval
+// summary from the total one
+type SummaryRecord struct {
+	Name          string        `json:"name"`
+	Time          time.Time     `json:"time"`
+	Elapsed       float64       `json:"elapsed_s"`
+	Discovers     int           `json:"discovers"`
+	Offers        int           `json:"offers"`
+	OfferTimeouts int           `json:"offer_timeouts"`
+	Requests      int           `json:"requests"`
+	Acks          int           `json:"acks"`
+	Naks          int           `json:"naks"`
+	AckTimeouts   int           `json:"ack_timeouts"`
+	Errors        int           `json:"errors"`
+	Conflicts     int           `json:"conflicts"`
+	Malformed     int           `json:"malformed"`
+	Offer         LatencyRecord `json:"offer_latency_ms"`
+	Ack           LatencyRecord `json:"ack_latency_ms"`
+}
+
+func newSummaryRecord(name string, s Summary) SummaryRecord {
+	return SummaryRecord{
+		Name:          name,
+		Time:          time.Now(),
+		Elapsed:       s.Elapsed.Seconds(),
+		Discovers:     s.Discovers,
+		Offers:        s.Offers,
+		OfferTimeouts: s.OfferTimeouts,
+		Requests:      s.Requests,
+		Acks:          s.Acks,
+		Naks:          s.Naks,
+		AckTimeouts:   s.AckTimeouts,
+		Errors:        s.Errors,
+		Conflicts:     s.Conflicts,
+		Malformed:     s.Malformed,
+		Offer:         newLatencyRecord(s.Offer),
+		Ack:           newLatencyRecord(s.Ack),
+	}
+}
+
+// csvHeader holds the columns of both the transaction and the summary rows,
+// the record column tells which of them a row is
+var csvHeader = []string{
+	"record", "time",
+	"mac", "xid", "messages", "start", "end", "offer_latency_ms", "ack_latency_ms", "offered_ip", "server_id", "state", "error", "violations",
+	"name", "elapsed_s", "discovers", "offers", "offer_timeouts", "requests", "acks", "naks", "ack_timeouts", "errors", "conflicts", "malformed",
+	"offer_p50_ms", "offer_p90_ms", "offer_p99_ms", "offer_p999_ms", "offer_max_ms",
+	"ack_p50_ms", "ack_p90_ms", "ack_p99_ms", "ack_p999_ms", "ack_max_ms",
+}
+
+// Exporter writes a record per transaction and the periodic summaries as
+// JSON lines or as CSV rows
+type Exporter struct {
+	lock   sync.Mutex
+	writer *bufio.Writer
+	csv    *csv.Writer
+	closer io.Closer
+}
+
+// NewExporter returns an exporter writing in the format, "json" or "csv", to w.
+// w is closed with the exporter when it is an io.Closer
+func NewExporter(format string, w io.Writer) (*Exporter, error) {
+	e := &Exporter{writer: bufio.NewWriter(w)}
+	if closer, ok := w.(io.Closer); ok {
+		e.closer = closer
+	}
+	switch format {
+	case "json":
+	case "csv":
+		e.csv = csv.NewWriter(e.writer)
+		if err := e.csv.Write(csvHeader); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown output format %q, want json or csv", format)
+	}
+	return e, nil
+}
+
+// transaction writes the record of a finished transaction
+func (e *Exporter) transaction(r Record) {
+	if e == nil {
+		return
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.csv == nil {
+		e.writeJSON(struct {
+			Kind string `json:"record"`
+			Record
+		}{"transaction", r})
+		return
+	}
+	row := make([]string, len(csvHeader))
+	copy(row, []string{
+		"transaction", r.End.Format(time.RFC3339Nano),
+		r.MAC, r.Xid, r.sequence(), r.Start.Format(time.RFC3339Nano), r.End.Format(time.RFC3339Nano),
+		formatMs(r.OfferLatency), formatMs(r.AckLatency), r.OfferedIP, r.ServerID, r.State, r.Error, r.violations(),
+	})
+	e.csv.Write(row)
+}
+
+// Summary writes a summary record and flushes the output, a nil exporter,
+// the one of a run without --output, writes nothing
+func (e *Exporter) Summary(name string, s Summary) {
+	if e == nil {
+		return
+	}
+	record := newSummaryRecord(name, s)
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.csv == nil {
+		e.writeJSON(struct {
+			Kind string `json:"record"`
+			SummaryRecord
+		}{"summary", record})
+	} else {
+		row := []string{"summary", record.Time.Format(time.RFC3339Nano)}
+		row = append(row, make([]string, 12)...)
+		row = append(row, record.Name, strconv.FormatFloat(record.Elapsed, 'f', 3, 64))
+		for _, count := range []int{record.Discovers, record.Offers, record.OfferTimeouts, record.Requests,
+			record.Acks, record.Naks, record.AckTimeouts, record.Errors, record.Conflicts, record.Malformed} {
+			row = append(row, strconv.Itoa(count))
+		}
+		for _, ms := range []float64{record.Offer.P50, record.Offer.P90, record.Offer.P99, record.Offer.P999, record.Offer.Max,
+			record.Ack.P50, record.Ack.P90, record.Ack.P99, record.Ack.P999, record.Ack.Max} {
+			row = append(row, strconv.FormatFloat(ms, 'f', 3, 64))
+		}
+		e.csv.Write(row)
+	}
+	e.flush()
+}
+
+func (e *Exporter) writeJSON(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	e.writer.Write(data)
+	e.writer.WriteByte('\n')
+}
+
+func (e *Exporter) flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+	}
+	return e.writer.Flush()
+}
+
+// Close flushes the pending records and closes the output, it does nothing
+// on a nil exporter
+func (e *Exporter) Close() error {
+	if e == nil {
+		return nil
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	err := e.flush()
+	if e.closer != nil {
+		if cerr := e.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func formatMs(ms float64) string {
+	if ms == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(ms, 'f', 3, 64)
+}
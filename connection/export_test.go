@@ -0,0 +1,138 @@
+package connection
+
+import (
+	"bytes"
+	"dhcptest/layers"
+	"dhcptest/utility"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dora runs a discover, offer, request and ack transaction through a PacketResponse
+func dora(export *Exporter) {
+	utility.Timeout = time.Hour
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	serverID := net.IPv4(192, 168, 0, 1).To4()
+
+	discover := NewPacket()
+	WithHwAddr(mac)(discover)
+	WithTransactionID(0x1234)(discover)
+	WithMessageType(layers.DHCPMsgTypeDiscover)(discover)
+	offer := NewPacket(layers.NewDHCPOption(layers.DHCPOptServerID, serverID))
+	WithReply(discover)(offer)
+	offer.YourClientIP = net.IPv4(192, 168, 0, 100)
+	WithMessageType(layers.DHCPMsgTypeOffer)(offer)
+	request := NewRequestFromOffer(offer)
+	ack := NewPacket(layers.NewDHCPOption(layers.DHCPOptServerID, serverID))
+	WithReply(request)(ack)
+	ack.YourClientIP = offer.YourClientIP
+	WithMessageType(layers.DHCPMsgTypeAck)(ack)
+
+	pr := NewPacketResponse()
+	pr.export = export
+	pr.request = true
+	pr.Call(NewEvent(discoverDequeue, discover))
+	pr.Call(NewEvent(receivedOffer, offer))
+	pr.Call(NewEvent(requestDequeue, request))
+	pr.Call(NewEvent(receivedAck, ack))
+	pr.Call(NewEvent(receivedAck, ack))
+	pr.dLastTimer.Stop()
+	pr.rLastTimer.Stop()
+}
+
+func TestExportJSON(t *testing.T) {
+	var buf bytes.Buffer
+	export, err := NewExporter("json", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dora(export)
+	export.Summary("total", Summary{Discovers: 1, Acks: 1})
+	export.Close()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("%d records, want 2:\n%s", len(lines), buf.String())
+	}
+	var record struct {
+		Kind string `json:"record"`
+		Record
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Kind != "transaction" || record.State != StateAcked || record.Xid != "00001234" || record.MAC != "02:00:00:00:00:01" {
+		t.Errorf("record %+v", record)
+	}
+	if record.OfferedIP != "192.168.0.100" || record.ServerID != "192.168.0.1" {
+		t.Errorf("offered ip %s server id %s", record.OfferedIP, record.ServerID)
+	}
+	var types []string
+	for _, message := range record.Messages {
+		types = append(types, message.Type)
+	}
+	if strings.Join(types, " ") != "Discover Offer Request Ack" {
+		t.Errorf("messages %v, want Discover Offer Request Ack", types)
+	}
+	var summary struct {
+		Kind string `json:"record"`
+		SummaryRecord
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Kind != "summary" || summary.Name != "total" || summary.Discovers != 1 || summary.Acks != 1 {
+		t.Errorf("summary %+v", summary)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	var buf bytes.Buffer
+	export, err := NewExporter("csv", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dora(export)
+	interval := Summary{}
+	interval.Offer = Latency{P50: time.Millisecond, P90: 2 * time.Millisecond, P99: 3 * time.Millisecond, P999: 4 * time.Millisecond, Max: 5 * time.Millisecond}
+	interval.Ack = Latency{P50: 6 * time.Millisecond, P90: 7 * time.Millisecond, P99: 8 * time.Millisecond, P999: 9 * time.Millisecond, Max: 10 * time.Millisecond}
+	export.Summary("interval", interval)
+	export.Close()
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("%d rows, want header, transaction and summary", len(rows))
+	}
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		columns[name] = i
+	}
+	if rows[1][columns["record"]] != "transaction" || rows[1][columns["state"]] != StateAcked || rows[1][columns["offered_ip"]] != "192.168.0.100" {
+		t.Errorf("transaction row %v", rows[1])
+	}
+	if rows[2][columns["record"]] != "summary" || rows[2][columns["name"]] != "interval" {
+		t.Errorf("summary row %v", rows[2])
+	}
+	latencies := []string{"offer_p50_ms", "offer_p90_ms", "offer_p99_ms", "offer_p999_ms", "offer_max_ms",
+		"ack_p50_ms", "ack_p90_ms", "ack_p99_ms", "ack_p999_ms", "ack_max_ms"}
+	if header := strings.Join(rows[0][len(rows[0])-len(latencies):], ","); header != strings.Join(latencies, ",") {
+		t.Errorf("latency columns %s", header)
+	}
+	for i, name := range latencies {
+		if want := fmt.Sprintf("%d.000", i+1); rows[2][columns[name]] != want {
+			t.Errorf("%s %s, want %s", name, rows[2][columns[name]], want)
+		}
+	}
+
+	if _, err := NewExporter("xml", &buf); err == nil {
+		t.Error("xml format accepted")
+	}
+}
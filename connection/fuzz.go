@@ -0,0 +1,259 @@
+package connection
+
+import (
+	"dhcptest/layers"
+	"encoding/binary"
+	"fmt"
+	"github.com/google/gopacket"
+	"math/rand"
+	"net"
+	"strings"
+)
+
+// Mutation is a way a discover or a request is broken before it is sent
+type Mutation string
+
+const (
+	// MutationOptionLength sets the length of an option past its data or short of it
+	MutationOptionLength Mutation = "option-length"
+	// MutationNoEnd drops the End option, garbage may follow the last option
+	MutationNoEnd Mutation = "no-end"
+	// MutationHlen sets the hardware address length over the 16 bytes of chaddr
+	MutationHlen Mutation = "hlen"
+	// MutationMagic breaks the magic cookie
+	MutationMagic Mutation = "magic"
+	// MutationDuplicateType adds message type options of other types
+	MutationDuplicateType Mutation = "duplicate-type"
+	// MutationOverlongFields fills sname and file without a terminating zero,
+	// they are overloaded by option 52 half of the time
+	MutationOverlongFields Mutation = "overlong-fields"
+	// MutationRandomOptions adds options of random codes and data
+	MutationRandomOptions Mutation = "random-options"
+	// MutationTruncateUDP cuts the frame in the udp header or breaks its length
+	MutationTruncateUDP Mutation = "truncate-udp"
+	// MutationTruncateIP cuts the frame in the ip header or breaks its lengths
+	MutationTruncateIP Mutation = "truncate-ip"
+)
+
+// Mutations holds all the mutations
+var Mutations = []Mutation{
+	MutationOptionLength, MutationNoEnd, MutationHlen, MutationMagic, MutationDuplicateType,
+	MutationOverlongFields, MutationRandomOptions, MutationTruncateUDP, MutationTruncateIP,
+}
+
+// header tells whether the mutation breaks the ip or the udp header, which
+// needs the frames to be sent raw
+func (m Mutation) header() bool {
+	return m == MutationTruncateUDP || m == MutationTruncateIP
+}
+
+// ParseMutations parses a comma separated list of mutations, "all" stands for
+// every mutation the platform can send
+func ParseMutations(value string) ([]Mutation, error) {
+	var mutations []Mutation
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "all" {
+			for _, mutation := range Mutations {
+				if rawFrames || !mutation.header() {
+					mutations = append(mutations, mutation)
+				}
+			}
+			continue
+		}
+		mutation, ok := Mutation(name), false
+		for _, known := range Mutations {
+			ok = ok || known == mutation
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown mutation %q, want all or some of %s", name, Mutations)
+		}
+		if mutation.header() && !rawFrames {
+			return nil, fmt.Errorf("mutation %s needs raw frames, which this platform has not", name)
+		}
+		mutations = append(mutations, mutation)
+	}
+	return mutations, nil
+}
+
+// Mutant is a broken discover or request, NewMutant builds the same mutant
+// again from the same seed and mutations
+type Mutant struct {
+	Seed     int64
+	Mutation Mutation
+	// MsgType is the message type of the packet before it was broken
+	MsgType layers.DHCPMsgType
+	// Payload is the broken dhcp packet
+	Payload []byte
+	// Frame is the ethernet frame carrying the payload from the source mac
+	Frame []byte
+}
+
+func (m *Mutant) String() string {
+	return fmt.Sprintf("seed %d: %s of a %s", m.Seed, m.Mutation, m.MsgType)
+}
+
+// NewMutant builds a discover or a request of a random client with
+// connection.NewPacket and breaks it with one of the mutations, all the
+// choices are drawn from the seed
+func NewMutant(seed int64, mutations []Mutation, srcMac net.HardwareAddr) (*Mutant, error) {
+	if len(mutations) == 0 {
+		return nil, fmt.Errorf("no mutation")
+	}
+	r := rand.New(rand.NewSource(seed))
+	m := &Mutant{Seed: seed, Mutation: mutations[r.Intn(len(mutations))], MsgType: layers.DHCPMsgTypeDiscover}
+
+	mac := make(net.HardwareAddr, 6)
+	r.Read(mac)
+	mac[0] = mac[0]&0xfc | 0x02
+	var packet *layers.DHCPv4
+	if r.Intn(2) == 0 {
+		packet = NewDiscover(mac)
+	} else {
+		m.MsgType = layers.DHCPMsgTypeRequest
+		packet = NewRebootRequest(mac, net.IPv4(byte(10+r.Intn(182)), byte(r.Intn(256)), byte(r.Intn(256)), byte(1+r.Intn(254))))
+	}
+	WithTransactionID(r.Uint32())(packet)
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, packet); err != nil {
+		return nil, err
+	}
+	m.Payload = mutatePayload(r, m.Mutation, append([]byte{}, buf.Bytes()...))
+
+	eth := layers.Ethernet{SrcMAC: srcMac, DstMAC: layers.EthernetBroadcast, EthernetType: layers.EthernetTypeIPv4}
+	ip := layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IPv4zero, DstIP: net.IPv4bcast}
+	udp := layers.UDP{SrcPort: 68, DstPort: 67}
+	udp.SetNetworkLayerForChecksum(&ip)
+	frame, err := serialize(&eth, &ip, &udp, gopacket.Payload(m.Payload))
+	if err != nil {
+		return nil, err
+	}
+	m.Frame = mutateFrame(r, m.Mutation, frame)
+	return m, nil
+}
+
+// optionOffsets returns the offsets of the options of an encoded packet but
+// the pad and the End options
+func optionOffsets(payload []byte) []int {
+	var offsets []int
+	for i := 240; i < len(payload); {
+		switch layers.DHCPOpt(payload[i]) {
+		case layers.DHCPOptPad:
+			i++
+			continue
+		case layers.DHCPOptEnd:
+			return offsets
+		}
+		if i+1 >= len(payload) {
+			break
+		}
+		offsets = append(offsets, i)
+		i += 2 + int(payload[i+1])
+	}
+	return offsets
+}
+
+// insertOptionData inserts encoded options before the End option, the
+// payload ends with it as serialized
+func insertOptionData(payload []byte, options []byte) []byte {
+	end := len(payload) - 1
+	return append(append(append([]byte{}, payload[:end]...), options...), payload[end:]...)
+}
+
+// mutatePayload breaks the encoded packet, it is left as it is for the
+// mutations of the headers
+func mutatePayload(r *rand.Rand, mutation Mutation, payload []byte) []byte {
+	switch mutation {
+	case MutationOptionLength:
+		offsets := optionOffsets(payload)
+		at := offsets[r.Intn(len(offsets))] + 1
+		length := payload[at]
+		for payload[at] == length {
+			payload[at] = byte(r.Intn(256))
+		}
+	case MutationNoEnd:
+		payload = payload[:len(payload)-1]
+		if r.Intn(2) == 0 {
+			garbage := make([]byte, 1+r.Intn(16))
+			r.Read(garbage)
+			payload = append(payload, garbage...)
+		}
+	case MutationHlen:
+		payload[2] = byte(17 + r.Intn(239))
+	case MutationMagic:
+		payload[236+r.Intn(4)] ^= byte(1 + r.Intn(255))
+	case MutationDuplicateType:
+		var options []byte
+		for i := 1 + r.Intn(3); i > 0; i-- {
+			options = append(options, byte(layers.DHCPOptMessageType), 1, byte(1+r.Intn(18)))
+		}
+		payload = insertOptionData(payload, options)
+	case MutationOverlongFields:
+		for i := 44; i < 236; i++ {
+			payload[i] = byte('!' + r.Intn('~'-'!'+1))
+		}
+		if r.Intn(2) == 0 {
+			payload = insertOptionData(payload, []byte{byte(layers.DHCPOptExtOptions), 1, layers.DHCPOverloadFile | layers.DHCPOverloadServerName})
+		}
+	case MutationRandomOptions:
+		var options []byte
+		for i := 1 + r.Intn(8); i > 0; i-- {
+			data := make([]byte, r.Intn(65))
+			r.Read(data)
+			options = append(append(options, byte(1+r.Intn(254)), byte(len(data))), data...)
+		}
+		payload = insertOptionData(payload, options)
+	}
+	return payload
+}
+
+// mutateFrame breaks the ip or the udp header of the ethernet frame, the frame
+// is left as it is for the mutations of the payload
+func mutateFrame(r *rand.Rand, mutation Mutation, frame []byte) []byte {
+	const ipStart, udpStart = 14, 34
+	switch mutation {
+	case MutationTruncateIP:
+		switch r.Intn(3) {
+		case 0:
+			return frame[:ipStart+1+r.Intn(19)]
+		case 1:
+			ihl := byte(r.Intn(16))
+			for ihl == 5 {
+				ihl = byte(r.Intn(16))
+			}
+			frame[ipStart] = 0x40 | ihl
+		default:
+			binary.BigEndian.PutUint16(frame[ipStart+2:], uint16(r.Intn(udpStart-ipStart+8)))
+		}
+		setIPChecksum(frame[ipStart:udpStart])
+	case MutationTruncateUDP:
+		if r.Intn(2) == 0 {
+			frame = frame[:udpStart+1+r.Intn(7)]
+			binary.BigEndian.PutUint16(frame[ipStart+2:], uint16(len(frame)-ipStart))
+			setIPChecksum(frame[ipStart:udpStart])
+			return frame
+		}
+		length := len(frame) - udpStart
+		value := r.Intn(8)
+		if r.Intn(2) == 0 {
+			value = length + 1 + r.Intn(512)
+		}
+		binary.BigEndian.PutUint16(frame[udpStart+4:], uint16(value))
+		//no checksum, so that the broken length is not dropped as a bad checksum
+		binary.BigEndian.PutUint16(frame[udpStart+6:], 0)
+	}
+	return frame
+}
+
+// setIPChecksum computes the checksum of the ipv4 header again
+func setIPChecksum(header []byte) {
+	header[10], header[11] = 0, 0
+	var sum uint32
+	for i := 0; i+1 < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+	binary.BigEndian.PutUint16(header[10:], ^uint16(sum))
+}
@@ -0,0 +1,56 @@
+package connection
+
+import (
+	"bytes"
+	"dhcptest/layers"
+	"github.com/google/gopacket"
+	"net"
+	"testing"
+)
+
+func TestNewMutant(t *testing.T) {
+	src := net.HardwareAddr{2, 0, 0, 0, 0, 1}
+	for _, mutation := range Mutations {
+		for seed := int64(1); seed <= 50; seed++ {
+			m, err := NewMutant(seed, []Mutation{mutation}, src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			again, _ := NewMutant(seed, []Mutation{mutation}, src)
+			if m.Mutation != mutation || !bytes.Equal(m.Frame, again.Frame) {
+				t.Fatalf("%s: seed %d gives another mutant", mutation, seed)
+			}
+
+			d := &layers.DHCPv4{}
+			err = d.DecodeFromBytes(m.Payload, gopacket.NilDecodeFeedback)
+			switch mutation {
+			case MutationHlen:
+				if err != layers.DecHardwareLenInvalid {
+					t.Errorf("%s: decoded with %v", m, err)
+				}
+			case MutationMagic:
+				if err != layers.InvalidMagicCookie {
+					t.Errorf("%s: decoded with %v", m, err)
+				}
+			case MutationDuplicateType:
+				if err != nil || len(d.Options) == 0 {
+					t.Errorf("%s: decoded with %v", m, err)
+				}
+			case MutationTruncateIP, MutationTruncateUDP:
+				if err != nil {
+					t.Errorf("%s: the payload is broken, %v", m, err)
+				}
+				if packet, _, _ := DecodeFrame(m.Frame, layers.LayerTypeEthernet); packet != nil && len(m.Frame) < 34+8 {
+					t.Errorf("%s: a truncated frame decodes", m)
+				}
+			}
+		}
+	}
+
+	if _, err := ParseMutations("all,bogus"); err == nil {
+		t.Error("an unknown mutation is accepted")
+	}
+	if mutations, _ := ParseMutations("hlen, magic"); len(mutations) != 2 || mutations[1] != MutationMagic {
+		t.Errorf("parsed %v", mutations)
+	}
+}
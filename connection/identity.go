@@ -0,0 +1,88 @@
+package connection
+
+import (
+	"dhcptest/layers"
+	"dhcptest/utility"
+	"net"
+)
+
+// ClientIDMac is the client id template sending the hardware type and the
+// mac, the form RFC 2132 9.14 suggests
+const ClientIDMac = "mac"
+
+// Identity derives the client identifier (option 61) and the host name
+// (option 12) of every simulated client from its mac, so that a client keeps
+// them from one transaction and one run to the next
+type Identity struct {
+	// ClientID is ClientIDMac or a template expanded by
+	// utility.ExpandMacTemplate and sent with type 0, an empty template
+	// leaves the option out
+	ClientID string
+	// Hostname is expanded by utility.ExpandMacTemplate, an empty template
+	// leaves the option out
+	Hostname string
+}
+
+// ClientIDOf returns the client identifier of the mac, nil when none is sent
+func (id *Identity) ClientIDOf(mac net.HardwareAddr) []byte {
+	if id == nil || len(id.ClientID) == 0 {
+		return nil
+	}
+	if id.ClientID == ClientIDMac {
+		return append([]byte{byte(layers.LinkTypeEthernet)}, mac...)
+	}
+	return append([]byte{0}, utility.ExpandMacTemplate(id.ClientID, mac)...)
+}
+
+// HostnameOf returns the host name of the mac, empty when none is sent
+func (id *Identity) HostnameOf(mac net.HardwareAddr) string {
+	if id == nil {
+		return ""
+	}
+	return utility.ExpandMacTemplate(id.Hostname, mac)
+}
+
+// Modifier adds the options of the identity of the client of the packet, the
+// options set by --option are kept. They go before option 82, which must stay last
+func (id *Identity) Modifier() Modifier {
+	return func(packet *layers.DHCPv4) {
+		if id == nil {
+			return
+		}
+		mac := packet.ClientHWAddr
+		var options layers.DHCPOptions
+		if data := id.ClientIDOf(mac); data != nil && !hasOption(packet, layers.DHCPOptClientID) {
+			options = append(options, layers.NewDHCPOption(layers.DHCPOptClientID, data))
+		}
+		if hostname := id.HostnameOf(mac); len(hostname) > 0 && !hasOption(packet, layers.DHCPOptHostname) {
+			options = append(options, layers.NewDHCPOption(layers.DHCPOptHostname, []byte(hostname)))
+		}
+		insertOptions(packet, options)
+	}
+}
+
+// insertOptions adds the options to the packet before option 82, which must stay last
+func insertOptions(packet *layers.DHCPv4, options layers.DHCPOptions) {
+	if len(options) == 0 {
+		return
+	}
+	at := len(packet.Options)
+	for i, option := range packet.Options {
+		if option.Type == layers.DHCPOptRelayAgent {
+			at = i
+			break
+		}
+	}
+	merged := make(layers.DHCPOptions, 0, len(packet.Options)+len(options))
+	merged = append(append(append(merged, packet.Options[:at]...), options...), packet.Options[at:]...)
+	packet.Options = merged
+}
+
+func hasOption(packet *layers.DHCPv4, opt layers.DHCPOpt) bool {
+	for _, option := range packet.Options {
+		if option.Type == opt {
+			return true
+		}
+	}
+	return false
+}
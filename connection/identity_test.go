@@ -0,0 +1,47 @@
+package connection
+
+import (
+	"bytes"
+	"dhcptest/layers"
+	"net"
+	"testing"
+)
+
+func TestIdentityModifier(t *testing.T) {
+	mac, _ := net.ParseMAC("02:00:00:0a:0b:0c")
+	packet := NewDiscover(mac)
+	packet.AddOption(layers.DHCPOptRelayAgent, []byte{1, 1, 'x'})
+	id := &Identity{ClientID: ClientIDMac, Hostname: "host-{mac_hex}"}
+	id.Modifier()(packet)
+
+	var types []layers.DHCPOpt
+	for _, option := range packet.Options {
+		types = append(types, option.Type)
+		switch option.Type {
+		case layers.DHCPOptClientID:
+			if want := append([]byte{1}, mac...); !bytes.Equal(option.Data, want) {
+				t.Errorf("client id %x, want %x", option.Data, want)
+			}
+		case layers.DHCPOptHostname:
+			if string(option.Data) != "host-0200000a0b0c" {
+				t.Errorf("host name %q", option.Data)
+			}
+		}
+	}
+	if types[len(types)-1] != layers.DHCPOptRelayAgent {
+		t.Errorf("option 82 is not last: %v", types)
+	}
+
+	//applied again, as on a retransmission, nothing is added
+	count := len(packet.Options)
+	id.Modifier()(packet)
+	if len(packet.Options) != count {
+		t.Errorf("%d options, want %d", len(packet.Options), count)
+	}
+
+	var none *Identity
+	none.Modifier()(packet)
+	if data := (&Identity{ClientID: "dev-{mac}"}).ClientIDOf(mac); string(data) != "\x00dev-02:00:00:0a:0b:0c" {
+		t.Errorf("client id %q", data)
+	}
+}
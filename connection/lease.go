@@ -0,0 +1,268 @@
+package connection
+
+import (
+	"dhcptest/layers"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// minRetransmit is the least time a client waits before it retransmits a
+	// renew or rebind request, RFC 2131 4.4.5
+	minRetransmit = 60 * time.Second
+)
+
+type bindState int
+
+const (
+	bound bindState = iota
+	renewing
+	rebinding
+)
+
+func (s bindState) String() string {
+	switch s {
+	case bound:
+		return "bound"
+	case renewing:
+		return "renewing"
+	case rebinding:
+		return "rebinding"
+	default:
+		return "unknown"
+	}
+}
+
+// binding is the lease held by one simulated client
+type binding struct {
+	mac       net.HardwareAddr
+	lease     Lease
+	serverMac net.HardwareAddr
+	state     bindState
+	timer     *time.Timer
+}
+
+// leaseTable holds the bindings of all the simulated clients keyed by mac.
+// The timers of the bindings drive the RENEW at T1, the REBIND at T2 and the
+// expiry of every lease
+type leaseTable struct {
+	lock     sync.Mutex
+	bindings map[string]*binding
+	closed   bool
+	inflight sync.WaitGroup
+}
+
+func newLeaseTable() *leaseTable {
+	return &leaseTable{bindings: make(map[string]*binding)}
+}
+
+// enter counts a goroutine which may still bind or send, closeLeases waits for
+// it. It returns false when the table is closed already
+func (t *leaseTable) enter() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.closed {
+		return false
+	}
+	t.inflight.Add(1)
+	return true
+}
+
+// holder returns the mac of another client which holds the ip, if any
+func (t *leaseTable) holder(ip net.IP, mac net.HardwareAddr) net.HardwareAddr {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for key, b := range t.bindings {
+		if key != mac.String() && b.lease.FixedAddress.Equal(ip) {
+			return b.mac
+		}
+	}
+	return nil
+}
+
+// bind records the lease carried by an ack and schedules its renewal
+func (dc *DhcpClient) bind(packet *layers.DHCPv4, serverMac net.HardwareAddr) {
+	_, lease := NewLease(packet)
+	if lease.FixedAddress == nil || lease.FixedAddress.Equal(net.IPv4zero) {
+		return
+	}
+	fillTimers(&lease)
+
+	table := dc.leases
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	if table.closed {
+		return
+	}
+	mac := packet.ClientHWAddr.String()
+	b, ok := table.bindings[mac]
+	if !ok {
+		b = &binding{mac: packet.ClientHWAddr}
+		table.bindings[mac] = b
+	} else if b.timer != nil {
+		b.timer.Stop()
+	}
+	b.lease = lease
+	b.serverMac = serverMac
+	b.state = bound
+	if lease.Expire.IsZero() {
+		//infinite lease, nothing to renew
+		b.timer = nil
+		return
+	}
+	b.timer = time.AfterFunc(time.Until(lease.Renew), func() {
+		dc.onLeaseTimer(b)
+	})
+}
+
+// unbind drops the lease of the given client, it is called on a nak
+func (dc *DhcpClient) unbind(mac net.HardwareAddr) {
+	table := dc.leases
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	b, ok := table.bindings[mac.String()]
+	if !ok {
+		return
+	}
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	delete(table.bindings, mac.String())
+	if dc.ifLog {
+		dc.addMessage(fmt.Sprintf("%s lease of %s dropped while %s", mac, b.lease.FixedAddress, b.state))
+	}
+}
+
+// onLeaseTimer moves a binding along BOUND -> RENEWING -> REBINDING -> expired
+func (dc *DhcpClient) onLeaseTimer(b *binding) {
+	table := dc.leases
+	table.lock.Lock()
+	if table.closed || table.bindings[b.mac.String()] != b {
+		table.lock.Unlock()
+		return
+	}
+	now := time.Now()
+	var packet *layers.DHCPv4
+	var route *Route
+	switch {
+	case !now.Before(b.lease.Expire):
+		delete(table.bindings, b.mac.String())
+		table.lock.Unlock()
+		dc.responder.remove(b.mac)
+		if dc.ifLog {
+			dc.addMessage(fmt.Sprintf("%s lease of %s expired", b.mac, b.lease.FixedAddress))
+		}
+		return
+	case !now.Before(b.lease.Rebind):
+		b.state = rebinding
+		packet = NewRenewFromLease(b.mac, b.lease)
+		//a rebinding client still holds its address and broadcasts from it
+		route = &Route{SrcIP: b.lease.FixedAddress, DstIP: net.IPv4bcast, DstMAC: layers.EthernetBroadcast}
+		b.timer = time.AfterFunc(nextRetransmit(now, b.lease.Expire), func() {
+			dc.onLeaseTimer(b)
+		})
+	default:
+		b.state = renewing
+		packet = NewRenewFromLease(b.mac, b.lease)
+		route = &Route{SrcIP: b.lease.FixedAddress, DstIP: b.lease.ServerID, DstMAC: b.serverMac}
+		b.timer = time.AfterFunc(nextRetransmit(now, b.lease.Rebind), func() {
+			dc.onLeaseTimer(b)
+		})
+	}
+	table.inflight.Add(1)
+	table.lock.Unlock()
+
+	//send out of the lock, listenLoop binds with packetsLock held
+	dc.SendTo(route, packet, WithTransactionID(rand.Uint32()))
+	table.inflight.Done()
+}
+
+// closeLeases stops all the lease timers and, if asked, releases the leases.
+// It must be called before the workers stop as it still uses the send queue
+func (dc *DhcpClient) closeLeases(release bool) {
+	table := dc.leases
+	table.lock.Lock()
+	table.closed = true
+	bindings := make([]*binding, 0, len(table.bindings))
+	for _, b := range table.bindings {
+		if b.timer != nil {
+			b.timer.Stop()
+		}
+		bindings = append(bindings, b)
+	}
+	table.bindings = make(map[string]*binding)
+	table.lock.Unlock()
+	table.inflight.Wait()
+
+	if !release {
+		return
+	}
+	for _, b := range bindings {
+		if err := dc.release(b.mac, b.lease, b.serverMac); err != nil {
+			dc.addMessage(err)
+		}
+	}
+	log.Printf("[%s] released %d leases", dc.Iface.Name, len(bindings))
+}
+
+// release unicasts the release of the lease to its server and drops the
+// lease from the store, it doesn't need the client to be started
+func (dc *DhcpClient) release(mac net.HardwareAddr, lease Lease, serverMac net.HardwareAddr) error {
+	packet := NewReleaseFromLease(mac, lease)
+	WithTransactionID(rand.Uint32())(packet)
+	dc.Identity.Modifier()(packet)
+	route := &Route{SrcIP: lease.FixedAddress, DstIP: lease.ServerID, DstMAC: serverMac}
+	if err := dc.send(packet, route); err != nil {
+		return err
+	}
+	dc.Leases.remove(mac)
+	return nil
+}
+
+// ReleaseLease releases a lease of the store
+func (dc *DhcpClient) ReleaseLease(lease StoredLease) error {
+	mac, serverMac := lease.HardwareAddrs()
+	return dc.release(mac, lease.Lease(), serverMac)
+}
+
+// RenewLease unicasts the renew of a lease of the store to its server, the
+// client must have been started. The ack updates the store
+func (dc *DhcpClient) RenewLease(lease StoredLease, modifiers ...Modifier) *PacketResponse {
+	mac, serverMac := lease.HardwareAddrs()
+	route := &Route{SrcIP: lease.Address, DstIP: lease.ServerID, DstMAC: serverMac}
+	modifiers = append([]Modifier{WithTransactionID(rand.Uint32())}, modifiers...)
+	return dc.SendTo(route, NewRenewFromLease(mac, lease.Lease()), modifiers...)
+}
+
+// fillTimers sets T1 and T2 to their RFC 2131 defaults when the server
+// didn't send them
+func fillTimers(lease *Lease) {
+	if lease.Expire.IsZero() {
+		return
+	}
+	duration := lease.Expire.Sub(lease.Bound)
+	if lease.Renew.IsZero() {
+		lease.Renew = lease.Bound.Add(duration / 2)
+	}
+	if lease.Rebind.IsZero() {
+		lease.Rebind = lease.Bound.Add(duration * 7 / 8)
+	}
+}
+
+// nextRetransmit waits half of the time left till the deadline, but at least
+// minRetransmit, and never past the deadline
+func nextRetransmit(now time.Time, deadline time.Time) time.Duration {
+	left := deadline.Sub(now)
+	wait := left / 2
+	if wait < minRetransmit {
+		wait = minRetransmit
+	}
+	if wait > left {
+		wait = left
+	}
+	return wait
+}
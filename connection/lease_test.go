@@ -0,0 +1,129 @@
+package connection
+
+import (
+	"dhcptest/layers"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFillTimers(t *testing.T) {
+	bound := time.Unix(1000, 0)
+	lease := Lease{Bound: bound, Expire: bound.Add(800 * time.Second)}
+	fillTimers(&lease)
+	if lease.Renew != bound.Add(400*time.Second) || lease.Rebind != bound.Add(700*time.Second) {
+		t.Errorf("defaults T1 %s T2 %s, want 400s and 700s", lease.Renew.Sub(bound), lease.Rebind.Sub(bound))
+	}
+
+	lease = Lease{Bound: bound, Expire: bound.Add(800 * time.Second), Renew: bound.Add(100 * time.Second), Rebind: bound.Add(200 * time.Second)}
+	fillTimers(&lease)
+	if lease.Renew != bound.Add(100*time.Second) || lease.Rebind != bound.Add(200*time.Second) {
+		t.Error("the timers sent by the server are overwritten")
+	}
+
+	lease = Lease{Bound: bound}
+	fillTimers(&lease)
+	if !lease.Renew.IsZero() || !lease.Rebind.IsZero() {
+		t.Error("an infinite lease gets timers")
+	}
+}
+
+func TestNextRetransmit(t *testing.T) {
+	now := time.Unix(1000, 0)
+	for _, c := range []struct {
+		left time.Duration
+		want time.Duration
+	}{
+		{time.Hour, 30 * time.Minute},
+		{100 * time.Second, minRetransmit},
+		{30 * time.Second, 30 * time.Second},
+		{0, 0},
+	} {
+		if got := nextRetransmit(now, now.Add(c.left)); got != c.want {
+			t.Errorf("%s left: waits %s, want %s", c.left, got, c.want)
+		}
+	}
+}
+
+// leaseClient returns a client which queues the packets without sending them
+func leaseClient() *DhcpClient {
+	return &DhcpClient{
+		packetsLock: new(sync.Mutex),
+		packets:     make(map[uint32]*PacketResponse),
+		sendQueue:   make(chan *layers.DHCPv4, 8),
+		templated:   newDeviceOptions(),
+		leases:      newLeaseTable(),
+	}
+}
+
+func TestLeaseTransitions(t *testing.T) {
+	dc := leaseClient()
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	serverMac := net.HardwareAddr{0x02, 0, 0, 0, 0, 0xfe}
+	address := net.IPv4(10, 0, 0, 5).To4()
+	ack := replyFrom(layers.DHCPMsgTypeAck, net.IPv4(10, 0, 0, 1), address)
+	ack.ClientHWAddr = mac
+	ack.AddOption(layers.DHCPOptLeaseTime, []byte{0, 0, 0x0e, 0x10})
+
+	dc.bind(ack, serverMac)
+	b := dc.leases.bindings[mac.String()]
+	if b == nil || b.state != bound || b.timer == nil {
+		t.Fatalf("binding %+v after the ack", b)
+	}
+	if renew := b.lease.Renew.Sub(b.lease.Bound); renew != 30*time.Minute {
+		t.Errorf("T1 %s, want half of the lease", renew)
+	}
+	b.timer.Stop()
+
+	next := func(state bindState) (*layers.DHCPv4, *Route) {
+		t.Helper()
+		dc.onLeaseTimer(b)
+		if b.timer != nil {
+			b.timer.Stop()
+		}
+		if b.state != state {
+			t.Fatalf("state %s, want %s", b.state, state)
+		}
+		packet := <-dc.sendQueue
+		return packet, dc.packets[packet.Xid].route
+	}
+
+	now := time.Now()
+	b.lease.Renew, b.lease.Rebind, b.lease.Expire = now.Add(-time.Second), now.Add(time.Hour), now.Add(2*time.Hour)
+	packet, route := next(renewing)
+	if !packet.ClientIP.Equal(address) || packet.MessageType() != layers.DHCPMsgTypeRequest {
+		t.Errorf("renew %v", packet)
+	}
+	if !route.SrcIP.Equal(address) || !route.DstIP.Equal(net.IPv4(10, 0, 0, 1)) || route.DstMAC.String() != serverMac.String() {
+		t.Errorf("the renew is sent %+v, want unicast to the server", route)
+	}
+
+	b.lease.Rebind = now.Add(-time.Second)
+	packet, route = next(rebinding)
+	if !packet.ClientIP.Equal(address) {
+		t.Errorf("rebind from ciaddr %s", packet.ClientIP)
+	}
+	if !route.SrcIP.Equal(address) || !route.DstIP.Equal(net.IPv4bcast) || route.DstMAC.String() != layers.EthernetBroadcast.String() {
+		t.Errorf("the rebind is sent %+v, want broadcast from the leased address", route)
+	}
+
+	b.lease.Expire = now.Add(-time.Second)
+	dc.onLeaseTimer(b)
+	if _, ok := dc.leases.bindings[mac.String()]; ok {
+		t.Error("the expired lease is kept")
+	}
+	select {
+	case packet := <-dc.sendQueue:
+		t.Errorf("%s sent after the expiry", packet.MessageType())
+	default:
+	}
+
+	//the timer of a binding replaced by a later ack does nothing
+	dc.bind(ack, serverMac)
+	dc.leases.bindings[mac.String()].timer.Stop()
+	dc.onLeaseTimer(b)
+	if len(dc.sendQueue) != 0 {
+		t.Error("a stale timer sends")
+	}
+}
@@ -0,0 +1,99 @@
+// +build !windows
+
+package connection
+
+import (
+	"dhcptest/layers"
+	"dhcptest/utility"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// frameConn hands the queued frames to the listen loop, it times out as the
+// socket does when there is none
+type frameConn struct {
+	net.PacketConn
+	frames chan []byte
+}
+
+func (c *frameConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case frame := <-c.frames:
+		return copy(b, frame), nil, nil
+	case <-time.After(5 * time.Millisecond):
+		return 0, nil, errors.New("timeout")
+	}
+}
+
+func (c *frameConn) SetReadDeadline(time.Time) error { return nil }
+
+func TestListenDropsForeignReplies(t *testing.T) {
+	utility.Timeout = time.Hour
+	conn := &frameConn{frames: make(chan []byte, 4)}
+	dc := leaseClient()
+	dc.connection = conn
+	dc.stop = make(chan int)
+	dc.messages = make(chan interface{}, 16)
+	dc.ifLog = true
+	dc.stats = NewStatistics(nil)
+	dc.wg = new(sync.WaitGroup)
+	dc.Validator = NewValidator(Expectations{})
+	go func() {
+		for range dc.messages {
+		}
+	}()
+	dc.wg.Add(1)
+	go dc.listenLoop()
+	defer func() {
+		dc.stop <- 1
+		dc.wg.Wait()
+		close(dc.messages)
+	}()
+
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	discover := NewDiscover(mac)
+	pr := dc.Send(discover, WithTransactionID(7))
+	pr.Call(NewEvent(discoverDequeue, <-dc.sendQueue))
+
+	offer := replyFrom(layers.DHCPMsgTypeOffer, net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 5))
+	WithReply(discover)(offer)
+	foreign := clone(offer)
+	foreign.ClientHWAddr = net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+	conn.frames <- udpFrame(t, serializeDHCP(t, foreign))
+	conn.frames <- udpFrame(t, serializeDHCP(t, foreign))
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-pr.Done():
+		t.Fatal("the offer to another chaddr finished the transaction")
+	default:
+	}
+	if offers := dc.stats.Total().Offers; offers != 0 {
+		t.Errorf("%d offers counted, want none", offers)
+	}
+
+	conn.frames <- udpFrame(t, serializeDHCP(t, offer))
+	select {
+	case <-pr.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the offer is not taken")
+	}
+	if offers := dc.stats.Total().Offers; offers != 1 {
+		t.Errorf("%d offers counted, want 1", offers)
+	}
+	for _, result := range dc.Validator.Report().Results {
+		if result.Rule == RuleEcho && (result.Checked != 3 || result.Violated != 2) {
+			t.Errorf("echo %+v, want 2 of 3 replies broke it", result)
+		}
+	}
+}
+
+func serializeDHCP(t *testing.T, packet *layers.DHCPv4) []byte {
+	data, err := serialize(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
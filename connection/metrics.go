@@ -0,0 +1,195 @@
+package connection
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the latency histograms
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// histogram is a cumulative histogram in the prometheus sense
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+	seconds := d.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// Metrics counts the packets and the transactions of the clients for the
+// whole life of the program and serves them in the prometheus text format
+type Metrics struct {
+	lock          sync.Mutex
+	sent          map[string]uint64
+	received      map[string]uint64
+	violations    map[string]uint64
+	naks          uint64
+	offerTimeouts uint64
+	ackTimeouts   uint64
+	sendErrors    uint64
+	conflicts     uint64
+	malformed     uint64
+	inFlight      int64
+	offer         histogram
+	ack           histogram
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		sent:       make(map[string]uint64),
+		received:   make(map[string]uint64),
+		violations: make(map[string]uint64),
+	}
+}
+
+func (m *Metrics) record(f func()) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	f()
+	m.lock.Unlock()
+}
+
+func (m *Metrics) packetSent(msgType string) {
+	m.record(func() { m.sent[msgType]++ })
+}
+
+func (m *Metrics) packetReceived(msgType string) {
+	m.record(func() { m.received[msgType]++ })
+}
+
+func (m *Metrics) sendError() {
+	m.record(func() { m.sendErrors++ })
+}
+
+func (m *Metrics) offerReceived(latency time.Duration) {
+	m.record(func() { m.offer.observe(latency) })
+}
+
+func (m *Metrics) ackReceived(latency time.Duration) {
+	m.record(func() { m.ack.observe(latency) })
+}
+
+func (m *Metrics) nakReceived() {
+	m.record(func() { m.naks++ })
+}
+
+func (m *Metrics) offerTimeout() {
+	m.record(func() { m.offerTimeouts++ })
+}
+
+func (m *Metrics) ackTimeout() {
+	m.record(func() { m.ackTimeouts++ })
+}
+
+func (m *Metrics) conflict() {
+	m.record(func() { m.conflicts++ })
+}
+
+func (m *Metrics) malformedReceived() {
+	m.record(func() { m.malformed++ })
+}
+
+func (m *Metrics) ruleViolated(rule Rule) {
+	m.record(func() { m.violations[string(rule)]++ })
+}
+
+func (m *Metrics) transactionStarted() {
+	m.record(func() { m.inFlight++ })
+}
+
+func (m *Metrics) transactionFinished() {
+	m.record(func() { m.inFlight-- })
+}
+
+// ServeHTTP writes the metrics in the prometheus text exposition format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	fmt.Fprintln(out, "# HELP dhcptest_packets_sent_total Packets sent by the clients, by message type.")
+	fmt.Fprintln(out, "# TYPE dhcptest_packets_sent_total counter")
+	for _, msgType := range sortedKeys(m.sent) {
+		fmt.Fprintf(out, "dhcptest_packets_sent_total{type=%q} %d\n", msgType, m.sent[msgType])
+	}
+	fmt.Fprintln(out, "# HELP dhcptest_packets_received_total Replies received by the clients, by message type.")
+	fmt.Fprintln(out, "# TYPE dhcptest_packets_received_total counter")
+	for _, msgType := range sortedKeys(m.received) {
+		fmt.Fprintf(out, "dhcptest_packets_received_total{type=%q} %d\n", msgType, m.received[msgType])
+	}
+	fmt.Fprintln(out, "# HELP dhcptest_naks_total Requests answered with a nak.")
+	fmt.Fprintln(out, "# TYPE dhcptest_naks_total counter")
+	fmt.Fprintf(out, "dhcptest_naks_total %d\n", m.naks)
+	fmt.Fprintln(out, "# HELP dhcptest_timeouts_total Transactions which got no reply in time, by phase.")
+	fmt.Fprintln(out, "# TYPE dhcptest_timeouts_total counter")
+	fmt.Fprintf(out, "dhcptest_timeouts_total{phase=\"offer\"} %d\n", m.offerTimeouts)
+	fmt.Fprintf(out, "dhcptest_timeouts_total{phase=\"ack\"} %d\n", m.ackTimeouts)
+	fmt.Fprintln(out, "# HELP dhcptest_send_errors_total Packets which could not be sent.")
+	fmt.Fprintln(out, "# TYPE dhcptest_send_errors_total counter")
+	fmt.Fprintf(out, "dhcptest_send_errors_total %d\n", m.sendErrors)
+	fmt.Fprintln(out, "# HELP dhcptest_conflicts_total Acked addresses found in use by the arp probes.")
+	fmt.Fprintln(out, "# TYPE dhcptest_conflicts_total counter")
+	fmt.Fprintf(out, "dhcptest_conflicts_total %d\n", m.conflicts)
+	fmt.Fprintln(out, "# HELP dhcptest_malformed_packets_total Received packets which failed to decode.")
+	fmt.Fprintln(out, "# TYPE dhcptest_malformed_packets_total counter")
+	fmt.Fprintf(out, "dhcptest_malformed_packets_total %d\n", m.malformed)
+	fmt.Fprintln(out, "# HELP dhcptest_rule_violations_total Replies which broke a validation rule, by rule.")
+	fmt.Fprintln(out, "# TYPE dhcptest_rule_violations_total counter")
+	for _, rule := range sortedKeys(m.violations) {
+		fmt.Fprintf(out, "dhcptest_rule_violations_total{rule=%q} %d\n", rule, m.violations[rule])
+	}
+	fmt.Fprintln(out, "# HELP dhcptest_transactions_in_flight Transactions waiting for a reply.")
+	fmt.Fprintln(out, "# TYPE dhcptest_transactions_in_flight gauge")
+	fmt.Fprintf(out, "dhcptest_transactions_in_flight %d\n", m.inFlight)
+	writeHistogram(out, "dhcptest_offer_latency_seconds", "Latency from the discover to the first offer.", &m.offer)
+	writeHistogram(out, "dhcptest_ack_latency_seconds", "Latency from the request to the ack.", &m.ack)
+}
+
+func writeHistogram(out *bufio.Writer, name string, help string, h *histogram) {
+	fmt.Fprintf(out, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(out, "# TYPE %s histogram\n", name)
+	for i, bound := range latencyBuckets {
+		var count uint64
+		if h.counts != nil {
+			count = h.counts[i]
+		}
+		fmt.Fprintf(out, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(bound, 'g', -1, 64), count)
+	}
+	fmt.Fprintf(out, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(out, "%s_sum %s\n", name, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(out, "%s_count %d\n", name, h.count)
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,91 @@
+package connection
+
+import (
+	"dhcptest/layers"
+	"dhcptest/utility"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsScrape(t *testing.T) {
+	metrics := NewMetrics()
+	stats := NewStatistics(metrics)
+	metrics.packetSent("Discover")
+	metrics.packetSent("Discover")
+	metrics.packetReceived("Offer")
+	metrics.transactionStarted()
+	metrics.transactionStarted()
+	metrics.transactionFinished()
+	stats.offerReceived(3 * time.Millisecond)
+	stats.nakReceived()
+	stats.ackTimeout()
+	stats.sendError()
+	stats.malformedReceived()
+
+	server := httptest.NewServer(metrics)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := make(map[string]bool)
+	for _, line := range strings.Split(string(body), "\n") {
+		lines[line] = true
+	}
+	for _, want := range []string{
+		`dhcptest_packets_sent_total{type="Discover"} 2`,
+		`dhcptest_packets_received_total{type="Offer"} 1`,
+		`dhcptest_naks_total 1`,
+		`dhcptest_timeouts_total{phase="offer"} 0`,
+		`dhcptest_timeouts_total{phase="ack"} 1`,
+		`dhcptest_send_errors_total 1`,
+		`dhcptest_malformed_packets_total 1`,
+		`dhcptest_transactions_in_flight 1`,
+		`dhcptest_offer_latency_seconds_bucket{le="0.0025"} 0`,
+		`dhcptest_offer_latency_seconds_bucket{le="0.005"} 1`,
+		`dhcptest_offer_latency_seconds_bucket{le="+Inf"} 1`,
+		`dhcptest_offer_latency_seconds_count 1`,
+		`dhcptest_ack_latency_seconds_count 0`,
+	} {
+		if !lines[want] {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+}
+
+func TestInFlightDiscoverOnly(t *testing.T) {
+	utility.Timeout = time.Hour
+	metrics := NewMetrics()
+	inFlight := func() int64 {
+		metrics.lock.Lock()
+		defer metrics.lock.Unlock()
+		return metrics.inFlight
+	}
+	discover := NewDiscover(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	offer := replyFrom(layers.DHCPMsgTypeOffer, net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 5))
+
+	pr := NewPacketResponse()
+	pr.stats = NewStatistics(metrics)
+	pr.Call(NewEvent(discoverDequeue, discover))
+	if n := inFlight(); n != 1 {
+		t.Fatalf("%d in flight after the discover, want 1", n)
+	}
+	pr.Call(NewEvent(receivedOffer, offer))
+	if n := inFlight(); n != 0 {
+		t.Errorf("%d in flight after the offer, want 0", n)
+	}
+	pr.Call(NewEvent(offerTimeout, nil))
+	if n := inFlight(); n != 0 {
+		t.Errorf("%d in flight after a late timeout, want 0", n)
+	}
+}
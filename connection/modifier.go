@@ -92,23 +92,21 @@ func WithHostName(hostname string) Modifier {
 	return WithOption(layers.DHCPOptHostname, []byte(hostname))
 }
 
-/*
-haven't resolve it yet
-
 // WithUserClass adds a user class option to the packet.
 // The rfc parameter allows you to specify if the userclass should be
-// rfc compliant or not. More details in issue #113
+// rfc compliant or not. More details in issue #113. RFC 3004 prefixes the
+// class with its length, the older clients send it as it is
 func WithUserClass(uc []byte, rfc bool) Modifier {
-	// TODO let the user specify multiple user classes
 	return func(packet *layers.DHCPv4) {
-		if rfc {
-			packet.AddOption(OptRFC3004UserClass([][]byte{uc}))
-		} else {
-			packet.UpdateOption(OptUserClass(uc))
+		if !rfc {
+			packet.AddOption(layers.DHCPOptUserClassInformation, uc)
+			return
+		}
+		if data, err := layers.DHCPUserClass([][]byte{uc}).Encode(); err == nil {
+			packet.AddOption(layers.DHCPOptUserClassInformation, data)
 		}
 	}
 }
-*/
 
 // WithNetboot adds bootfile URL and bootfile param options to a layers.DHCPv4 packet.
 func WithNetboot(d *layers.DHCPv4) {
@@ -137,6 +135,21 @@ func WithRelay(ip net.IP) Modifier {
 	}
 }
 
+// WithRelayOf relays the packet as the given one was: giaddr, hops and the
+// Relay Agent Information option are copied
+func WithRelayOf(relayed *layers.DHCPv4) Modifier {
+	return func(packet *layers.DHCPv4) {
+		packet.SetUnicast()
+		packet.RelayAgentIP = relayed.RelayAgentIP
+		packet.HardwareOpts = relayed.HardwareOpts
+		for _, option := range relayed.Options {
+			if option.Type == layers.DHCPOptRelayAgent {
+				packet.AddOption(option.Type, option.Data)
+			}
+		}
+	}
+}
+
 // WithNetmask adds or updates an OptSubnetMask
 func WithNetmask(mask net.IPMask) Modifier {
 	return WithOption(layers.DHCPOptSubnetMask, []byte(mask))
@@ -144,22 +157,36 @@ func WithNetmask(mask net.IPMask) Modifier {
 
 // WithLeaseTime adds or updates an OptIPAddressLeaseTime
 func WithLeaseTime(leaseTime uint32) Modifier {
-	var data []byte
+	data := make([]byte, 4)
 	binary.BigEndian.PutUint32(data, leaseTime)
 	return WithOption(layers.DHCPOptLeaseTime, data)
 }
 
-/*
-haven't resolve it yet
-
-// WithDomainSearchList adds or updates an OptionDomainSearch
+// WithDomainSearchList adds an OptDomainSearch, invalid names leave it out
 func WithDomainSearchList(searchList ...string) Modifier {
-	return WithOption(OptDomainSearch(&rfc1035label.Labels{
-		Labels: searchList,
-	}))
+	return func(packet *layers.DHCPv4) {
+		if data, err := layers.DHCPDomainSearch(searchList).Encode(); err == nil {
+			packet.AddOption(layers.DHCPOptDomainSearch, data)
+		}
+	}
 }
-*/
 
 func WithGeneric(code layers.DHCPOpt, value []byte) Modifier {
 	return WithOption(code, value)
 }
+
+type Modifier6 func(*layers.DHCPv6)
+
+// WithTransactionID6 sets the 24 bits Transaction ID for a layers.DHCPv6 packet.
+func WithTransactionID6(xid uint32) Modifier6 {
+	return func(packet *layers.DHCPv6) {
+		packet.TransactionID = []byte{byte(xid >> 16), byte(xid >> 8), byte(xid)}
+	}
+}
+
+// WithOption6 appends a layers.DHCPv6 option
+func WithOption6(code layers.DHCPv6Opt, data []byte) Modifier6 {
+	return func(packet *layers.DHCPv6) {
+		packet.Options = append(packet.Options, layers.NewDHCPv6Option(code, data))
+	}
+}
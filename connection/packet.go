@@ -4,8 +4,10 @@ import (
 	"dhcptest/layers"
 	"dhcptest/utility"
 	"encoding/binary"
+	"fmt"
 	"github.com/google/gopacket"
 	"net"
+	"sync"
 	"time"
 )
 
@@ -57,84 +59,373 @@ func NewRequestFromOffer(packet *layers.DHCPv4) *layers.DHCPv4 {
 	return requestPacket
 }
 
+// NewRenewFromLease builds the request a bound client sends to extend its lease,
+// ciaddr carries the leased address and neither server id nor requested ip is set
+func NewRenewFromLease(mac net.HardwareAddr, lease Lease) *layers.DHCPv4 {
+	renewPacket := NewPacket(utility.DhcpOptions...)
+	WithHwAddr(mac)(renewPacket)
+	WithClientIP(lease.FixedAddress)(renewPacket)
+	WithMessageType(layers.DHCPMsgTypeRequest)(renewPacket)
+	return renewPacket
+}
+
+// NewReleaseFromLease builds the release a client unicasts to give its lease back
+func NewReleaseFromLease(mac net.HardwareAddr, lease Lease) *layers.DHCPv4 {
+	releasePacket := NewPacket()
+	WithHwAddr(mac)(releasePacket)
+	WithClientIP(lease.FixedAddress)(releasePacket)
+	WithBroadcast(false)(releasePacket)
+	WithMessageType(layers.DHCPMsgTypeRelease)(releasePacket)
+	releasePacket.AddOption(layers.DHCPOptServerID, []byte(lease.ServerID.To4()))
+	return releasePacket
+}
+
+// NewDiscover builds the discover of the client with the given mac
+func NewDiscover(mac net.HardwareAddr) *layers.DHCPv4 {
+	discoverPacket := NewPacket(utility.DhcpOptions...)
+	WithHWType(layers.LinkTypeEthernet)(discoverPacket)
+	WithHwAddr(mac)(discoverPacket)
+	WithMessageType(layers.DHCPMsgTypeDiscover)(discoverPacket)
+	return discoverPacket
+}
+
+// NewRebootRequest builds the request of a client in INIT-REBOOT, which asks
+// for the address it had before the reboot: the requested ip is set, neither
+// ciaddr nor the server id is, RFC 2131 4.3.2
+func NewRebootRequest(mac net.HardwareAddr, ip net.IP) *layers.DHCPv4 {
+	rebootPacket := NewPacket(utility.DhcpOptions...)
+	WithHwAddr(mac)(rebootPacket)
+	WithMessageType(layers.DHCPMsgTypeRequest)(rebootPacket)
+	rebootPacket.AddOption(layers.DHCPOptRequestIP, []byte(ip.To4()))
+	return rebootPacket
+}
+
+// NewInform builds the inform of a client configured with the address ciaddr,
+// which asks for the other parameters only
+func NewInform(mac net.HardwareAddr, ciaddr net.IP) *layers.DHCPv4 {
+	informPacket := NewPacket(utility.DhcpOptions...)
+	WithHwAddr(mac)(informPacket)
+	WithClientIP(ciaddr)(informPacket)
+	WithMessageType(layers.DHCPMsgTypeInform)(informPacket)
+	return informPacket
+}
+
+// NewDeclineFromAck builds the decline a client broadcasts when the address of
+// the ack is in use by another host
+func NewDeclineFromAck(packet *layers.DHCPv4) *layers.DHCPv4 {
+	_, lease := NewLease(packet)
+	declinePacket := NewPacket()
+	WithHwAddr(packet.ClientHWAddr)(declinePacket)
+	WithMessageType(layers.DHCPMsgTypeDecline)(declinePacket)
+	declinePacket.AddOption(layers.DHCPOptRequestIP, []byte(lease.FixedAddress.To4()))
+	declinePacket.AddOption(layers.DHCPOptServerID, []byte(lease.ServerID.To4()))
+	return declinePacket
+}
+
 func ParsePacket(data []byte, decoder gopacket.Decoder) *layers.DHCPv4 {
+	packet, _, _ := DecodeFrame(data, decoder)
+	return packet
+}
+
+// ParseFrame works as ParsePacket, it also returns the source mac of the frame
+// when the data starts with an ethernet header
+func ParseFrame(data []byte, decoder gopacket.Decoder) (*layers.DHCPv4, net.HardwareAddr) {
+	packet, srcMac, _ := DecodeFrame(data, decoder)
+	return packet, srcMac
+}
+
+// DecodeFrame works as ParseFrame, it also returns the error of a malformed
+// dhcp packet. Frames which are no dhcp packet give neither packet nor error
+func DecodeFrame(data []byte, decoder gopacket.Decoder) (*layers.DHCPv4, net.HardwareAddr, error) {
 	packet := gopacket.NewPacket(data, decoder, gopacket.Default)
 
 	dhcpLayer := packet.Layer(layers.LayerTypeDHCPv4)
 
 	if dhcpLayer == nil {
+		return nil, nil, dhcpFailure(packet)
+	}
+
+	var srcMac net.HardwareAddr
+	if ethLayer := packet.Layer(layers.LayerTypeEthernet); ethLayer != nil {
+		srcMac = ethLayer.(*layers.Ethernet).SrcMAC
+	}
+
+	return dhcpLayer.(*layers.DHCPv4), srcMac, nil
+}
+
+// dhcpFailure returns the error of the dhcp layer of a packet which failed to
+// decode. The failing layer comes right after a udp layer whose ports lead to
+// dhcp, or first when the data is decoded as dhcp from the start. The other
+// udp payloads, such as dns or vxlan, failing to decode are no dhcp error
+func dhcpFailure(packet gopacket.Packet) error {
+	failure := packet.ErrorLayer()
+	if failure == nil {
 		return nil
 	}
+	decoded := packet.Layers()
+	if len(decoded) > 1 {
+		udp, ok := decoded[len(decoded)-2].(*layers.UDP)
+		if !ok {
+			return nil
+		}
+		if next := udp.NextLayerType(); next != layers.LayerTypeDHCPv4 && next != layers.LayerTypeDHCPv6 {
+			return nil
+		}
+	}
+	return failure.Error()
+}
+
+// Route tells how a packet is addressed on the wire. A nil route stands for
+// the broadcast from 0.0.0.0 to 255.255.255.255, zero ports stand for the
+// client port 68 and the server port 67
+type Route struct {
+	SrcIP   net.IP
+	DstIP   net.IP
+	DstMAC  net.HardwareAddr
+	SrcPort int
+	DstPort int
+}
+
+// ports returns the udp ports of the route
+func (r *Route) ports() (src int, dst int) {
+	src, dst = 68, 67
+	if r.SrcPort != 0 {
+		src = r.SrcPort
+	}
+	if r.DstPort != 0 {
+		dst = r.DstPort
+	}
+	return src, dst
+}
 
-	return dhcpLayer.(*layers.DHCPv4)
+// BroadcastRoute is the route used by the clients which have no address yet
+var BroadcastRoute = &Route{
+	SrcIP:  net.IPv4(0, 0, 0, 0),
+	DstIP:  net.IPv4bcast,
+	DstMAC: layers.EthernetBroadcast,
 }
 
 type PacketResponse struct {
 	dispatcher *PacketEventDispatcher
+	route      *Route
+	stats      *Statistics
+	export     *Exporter
+	// request tells whether an offer is followed by a request
+	request    bool
+	// selecting is the request answering the offer, it is built from the
+	// offer when nil
+	selecting  *layers.DHCPv4
+	record     Record
+	started    bool
+	finished   bool
+	done       chan struct{}
+	lock       sync.Mutex
 	dLastTimer *time.Timer
 	rLastTimer *time.Timer
+	dSent      time.Time
+	rSent      time.Time
 	packets    map[layers.DHCPMsgType][]*layers.DHCPv4
-
+	// forget drops the transaction from the client once it is over
+	forget     func()
 }
 
 
+// Call dispatches the event, events of one transaction are dispatched one at a time
+// as they come from the send loop, the listen loop and the timeout timers
 func (pr *PacketResponse) Call(event PacketEvent) {
-		pr.dispatcher.DispatchEvent(event)
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	pr.dispatcher.DispatchEvent(event)
+}
+
+// Packet returns the first packet of the given type of the transaction
+func (pr *PacketResponse) Packet(msgType layers.DHCPMsgType) *layers.DHCPv4 {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	if packets := pr.packets[msgType]; len(packets) > 0 {
+		return packets[0]
+	}
+	return nil
 }
 
 func (pr *PacketResponse) AddPacket(packet *layers.DHCPv4) {
+	if pr.record.Messages == nil {
+		pr.record.MAC = packet.ClientHWAddr.String()
+		pr.record.Xid = fmt.Sprintf("%08x", packet.Xid)
+	}
+	pr.record.add(packet.MessageType().String())
 	pr.packets[packet.MessageType()] = append(pr.packets[packet.MessageType()], packet)
 }
 
+// Done is closed when the transaction completes or times out
+func (pr *PacketResponse) Done() <-chan struct{} {
+	return pr.done
+}
+
+// State returns the state the transaction finished in, empty while it is in flight
+func (pr *PacketResponse) State() string {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	return pr.record.State
+}
+
+// begin counts the transaction in flight when its first packet is sent
+func (pr *PacketResponse) begin() {
+	if pr.started {
+		return
+	}
+	pr.started = true
+	pr.stats.observer().transactionStarted()
+}
+
+// finish ends the record of the transaction in the given state and exports it,
+// the packets coming after are left out of the record
+func (pr *PacketResponse) finish(state string) {
+	if pr.finished {
+		return
+	}
+	pr.finished = true
+	close(pr.done)
+	if pr.started {
+		pr.stats.observer().transactionFinished()
+	}
+	pr.record.State = state
+	pr.record.End = time.Now()
+	pr.export.transaction(pr.record)
+	if pr.forget != nil {
+		pr.forget()
+	}
+}
+
+// track adds the transaction to the client until it has been over for the
+// reply timeout, the late replies of the other servers are matched till then
+func (dc *DhcpClient) track(xid uint32, pr *PacketResponse) {
+	dc.packetsLock.Lock()
+	defer dc.packetsLock.Unlock()
+	packets := dc.packets
+	packets[xid] = pr
+	pr.forget = func() {
+		time.AfterFunc(utility.Timeout, func() {
+			dc.packetsLock.Lock()
+			if packets[xid] == pr {
+				delete(packets, xid)
+			}
+			dc.packetsLock.Unlock()
+		})
+	}
+}
+
+// offered records the address and the server of the first offer, or of the
+// ack of a transaction which starts with a request. The ack of an inform
+// carries no address
+func (pr *PacketResponse) offered(packet *layers.DHCPv4) {
+	if pr.record.OfferedIP != "" || pr.record.ServerID != "" {
+		return
+	}
+	_, lease := NewLease(packet)
+	if lease.FixedAddress != nil && !lease.FixedAddress.Equal(net.IPv4zero) {
+		pr.record.OfferedIP = lease.FixedAddress.String()
+	}
+	if lease.ServerID != nil {
+		pr.record.ServerID = lease.ServerID.String()
+	}
+}
+
+// replied tells whether a packet of one of the given types has been received
+func (pr *PacketResponse) replied(msgTypes ...layers.DHCPMsgType) bool {
+	for _, msgType := range msgTypes {
+		if len(pr.packets[msgType]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func NewPacketResponse() *PacketResponse {
 	pr := &PacketResponse{}
+	pr.done = make(chan struct{})
 	pr.packets = make(map[layers.DHCPMsgType][]*layers.DHCPv4)
 	pr.dispatcher = new(PacketEventDispatcher)
 	pr.dispatcher.AddEventListener(discoverDequeue, func(e PacketEvent) {
 		packet := e.object.(*layers.DHCPv4)
 		pr.AddPacket(packet)
-		pr.dLastTimer = time.NewTimer(utility.Timeout)
+		pr.begin()
+		pr.stats.discoverSent()
+		if pr.dLastTimer != nil {
+			return
+		}
+		pr.dSent = time.Now()
+		pr.dLastTimer = time.AfterFunc(utility.Timeout, func() {
+			pr.Call(NewEvent(offerTimeout, nil))
+		})
 	})
 	pr.dispatcher.AddEventListener(receivedOffer, func(e PacketEvent) {
-		select {
-		case <- pr.dLastTimer.C:
-			pr.Call(NewEvent(offerTimeout, nil))
-		default:
-			packet := e.object.(*layers.DHCPv4)
-			pr.AddPacket(packet)
+		packet := e.object.(*layers.DHCPv4)
+		if !pr.replied(layers.DHCPMsgTypeOffer) {
+			pr.stats.offerReceived(time.Since(pr.dSent))
+			pr.record.OfferLatency = milliseconds(time.Since(pr.dSent))
+			pr.offered(packet)
+		}
+		pr.AddPacket(packet)
+		if !pr.request {
+			//a discover which is not followed by a request is over at its first offer
+			if pr.dLastTimer != nil {
+				pr.dLastTimer.Stop()
+			}
+			pr.finish(StateOffered)
 		}
 	})
 	pr.dispatcher.AddEventListener(offerTimeout, func(e PacketEvent) {
 		pr.dispatcher.RemoveEventListener(receivedOffer)
-		pr.dLastTimer.Stop()
+		if !pr.replied(layers.DHCPMsgTypeOffer) {
+			pr.stats.offerTimeout()
+			pr.finish(StateOfferTimeout)
+		} else if !pr.request {
+			pr.finish(StateOffered)
+		}
 	})
 	pr.dispatcher.AddEventListener(requestDequeue, func(e PacketEvent) {
 		packet := e.object.(*layers.DHCPv4)
 		pr.AddPacket(packet)
-		pr.rLastTimer = time.NewTimer(utility.Timeout)
+		pr.begin()
+		pr.stats.requestSent()
+		if pr.rLastTimer != nil {
+			return
+		}
+		pr.rSent = time.Now()
+		pr.rLastTimer = time.AfterFunc(utility.Timeout, func() {
+			pr.Call(NewEvent(ackNakTimeout, nil))
+		})
 	})
 	pr.dispatcher.AddEventListener(receivedAck, func (e PacketEvent) {
-		select {
-		case <- pr.rLastTimer.C:
-			pr.Call(NewEvent(ackNakTimeout, nil))
-		default:
-			packet := e.object.(*layers.DHCPv4)
-			pr.AddPacket(packet)
+		packet := e.object.(*layers.DHCPv4)
+		if !pr.replied(layers.DHCPMsgTypeAck, layers.DHCPMsgTypeNak) {
+			pr.stats.ackReceived(time.Since(pr.rSent))
+			pr.record.AckLatency = milliseconds(time.Since(pr.rSent))
+			pr.offered(packet)
 		}
+		pr.AddPacket(packet)
+		pr.finish(StateAcked)
 	})
 	pr.dispatcher.AddEventListener(receivedNak, func (e PacketEvent) {
-		select {
-		case <- pr.rLastTimer.C:
-			pr.Call(NewEvent(ackNakTimeout, nil))
-		default:
-			packet := e.object.(*layers.DHCPv4)
-			pr.AddPacket(packet)
+		packet := e.object.(*layers.DHCPv4)
+		if !pr.replied(layers.DHCPMsgTypeAck, layers.DHCPMsgTypeNak) {
+			pr.stats.nakReceived()
+			pr.record.AckLatency = milliseconds(time.Since(pr.rSent))
 		}
+		pr.AddPacket(packet)
+		pr.finish(StateNaked)
 	})
 	pr.dispatcher.AddEventListener(ackNakTimeout, func(e PacketEvent) {
 		pr.dispatcher.RemoveEventListener(receivedAck)
 		pr.dispatcher.RemoveEventListener(receivedNak)
-		pr.rLastTimer.Stop()
+		if !pr.replied(layers.DHCPMsgTypeAck, layers.DHCPMsgTypeNak) {
+			pr.stats.ackTimeout()
+			pr.finish(StateAckTimeout)
+		}
+	})
+	pr.dispatcher.AddEventListener(sendFailed, func(e PacketEvent) {
+		pr.record.Error = e.object.(error).Error()
 	})
 	return pr
 }
@@ -0,0 +1,524 @@
+package connection
+
+import (
+	"dhcptest/layers"
+	"dhcptest/utility"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/google/gopacket"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// AllDHCPRelayAgentsAndServers is the multicast group the clients send to, RFC 8415 7.1
+	AllDHCPRelayAgentsAndServers = net.ParseIP("ff02::1:2")
+	// AllDHCPMac is the ethernet multicast address of ff02::1:2
+	AllDHCPMac = net.HardwareAddr{0x33, 0x33, 0x00, 0x01, 0x00, 0x02}
+
+	DefaultOptionRequestList = []layers.DHCPv6Opt{
+		layers.DHCPv6OptDNSServers,
+		layers.DHCPv6OptDomainList,
+	}
+
+	// duidEpoch is the base of the DUID-LLT time, RFC 8415 11.2
+	duidEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// ParseDUIDType returns the DUID type named by the --duid flag
+func ParseDUIDType(value string) (layers.DHCPv6DUIDType, error) {
+	switch strings.ToLower(value) {
+	case "ll":
+		return layers.DHCPv6DUIDTypeLL, nil
+	case "llt":
+		return layers.DHCPv6DUIDTypeLLT, nil
+	default:
+		return 0, fmt.Errorf("unsupport duid type %q, use ll or llt", value)
+	}
+}
+
+// NewDUID builds the DUID of a simulated client from its mac, the time is only
+// used by DUID-LLT
+func NewDUID(duidType layers.DHCPv6DUIDType, mac net.HardwareAddr, t time.Time) *layers.DHCPv6DUID {
+	duid := &layers.DHCPv6DUID{
+		Type:             duidType,
+		HardwareType:     []byte{0, byte(layers.LinkTypeEthernet)},
+		LinkLayerAddress: mac,
+	}
+	if duidType == layers.DHCPv6DUIDTypeLLT {
+		duid.Time = make([]byte, 4)
+		binary.BigEndian.PutUint32(duid.Time, uint32(t.Sub(duidEpoch)/time.Second))
+	}
+	return duid
+}
+
+// IAID derives the identity association id of a simulated client from its mac
+func IAID(mac net.HardwareAddr) uint32 {
+	if len(mac) < 4 {
+		return 1
+	}
+	return binary.BigEndian.Uint32(mac[len(mac)-4:])
+}
+
+func NewPacket6(msgType layers.DHCPv6MsgType, options ...layers.DHCPv6Option) *layers.DHCPv6 {
+	packet := layers.DHCPv6{
+		MsgType:       msgType,
+		TransactionID: make([]byte, 3),
+	}
+	packet.Options = append(packet.Options, options...)
+	return &packet
+}
+
+// NewSolicit builds the solicit of a simulated client, asking for an address,
+// and for a prefix as well with --ia-pd
+func NewSolicit(duid *layers.DHCPv6DUID) *layers.DHCPv6 {
+	iaid := IAID(duid.LinkLayerAddress)
+	packet := NewPacket6(layers.DHCPv6MsgTypeSolicit,
+		layers.NewDHCPv6Option(layers.DHCPv6OptClientID, duid.Encode()),
+		layers.NewDHCPv6Option(layers.DHCPv6OptElapsedTime, []byte{0, 0}),
+		newORO(DefaultOptionRequestList),
+		layers.NewDHCPv6Option(layers.DHCPv6OptIANA, newIA(iaid, nil)),
+	)
+	if utility.IAPD {
+		packet.Options = append(packet.Options, layers.NewDHCPv6Option(layers.DHCPv6OptIAPD, newIA(iaid, nil)))
+	}
+	if utility.RapidCommit {
+		packet.Options = append(packet.Options, layers.NewDHCPv6Option(layers.DHCPv6OptRapidCommit, nil))
+	}
+	return packet
+}
+
+// NewRequestFromAdvertise builds the request for the addresses and prefixes
+// of an advertise, the transaction id is the one of the solicit
+func NewRequestFromAdvertise(advertise *layers.DHCPv6) *layers.DHCPv6 {
+	packet := NewPacket6(layers.DHCPv6MsgTypeRequest)
+	copy(packet.TransactionID, advertise.TransactionID)
+	for _, option := range advertise.Options {
+		switch option.Code {
+		case layers.DHCPv6OptClientID, layers.DHCPv6OptServerID, layers.DHCPv6OptIANA, layers.DHCPv6OptIAPD:
+			packet.Options = append(packet.Options, layers.NewDHCPv6Option(option.Code, option.Data))
+		}
+	}
+	packet.Options = append(packet.Options,
+		layers.NewDHCPv6Option(layers.DHCPv6OptElapsedTime, []byte{0, 0}),
+		newORO(DefaultOptionRequestList),
+	)
+	return packet
+}
+
+func newORO(opts []layers.DHCPv6Opt) layers.DHCPv6Option {
+	data := make([]byte, 2*len(opts))
+	for i, opt := range opts {
+		binary.BigEndian.PutUint16(data[2*i:], uint16(opt))
+	}
+	return layers.NewDHCPv6Option(layers.DHCPv6OptOro, data)
+}
+
+// newIA encodes an IA_NA or IA_PD, T1 and T2 are left to the server
+func newIA(iaid uint32, options []layers.DHCPv6Option) []byte {
+	data := make([]byte, 12)
+	binary.BigEndian.PutUint32(data, iaid)
+	for _, option := range options {
+		data = append(data, encodeOption6(option)...)
+	}
+	return data
+}
+
+func encodeOption6(option layers.DHCPv6Option) []byte {
+	data := make([]byte, 4+len(option.Data))
+	binary.BigEndian.PutUint16(data, uint16(option.Code))
+	binary.BigEndian.PutUint16(data[2:], uint16(len(option.Data)))
+	copy(data[4:], option.Data)
+	return data
+}
+
+// decodeOptions6 decodes the options encapsulated in IA_NA, IA_PD, IAADDR and IAPREFIX
+func decodeOptions6(data []byte) ([]layers.DHCPv6Option, error) {
+	var options []layers.DHCPv6Option
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return options, errors.New("truncated dhcpv6 option header")
+		}
+		length := int(binary.BigEndian.Uint16(data[2:4]))
+		if len(data) < 4+length {
+			return options, errors.New("truncated dhcpv6 option data")
+		}
+		options = append(options, layers.NewDHCPv6Option(layers.DHCPv6Opt(binary.BigEndian.Uint16(data)), data[4:4+length]))
+		data = data[4+length:]
+	}
+	return options, nil
+}
+
+// Xid6 returns the 24 bits transaction id of the packet as a number
+func Xid6(packet *layers.DHCPv6) uint32 {
+	if len(packet.TransactionID) != 3 {
+		return 0
+	}
+	return uint32(packet.TransactionID[0])<<16 | uint32(packet.TransactionID[1])<<8 | uint32(packet.TransactionID[2])
+}
+
+func ParsePacket6(data []byte, decoder gopacket.Decoder) *layers.DHCPv6 {
+	packet, _ := DecodePacket6(data, decoder)
+	return packet
+}
+
+// DecodePacket6 works as ParsePacket6, it also returns the error of a
+// malformed dhcpv6 packet
+func DecodePacket6(data []byte, decoder gopacket.Decoder) (*layers.DHCPv6, error) {
+	packet := gopacket.NewPacket(data, decoder, gopacket.Default)
+
+	dhcpLayer := packet.Layer(layers.LayerTypeDHCPv6)
+
+	if dhcpLayer == nil {
+		return nil, dhcpFailure(packet)
+	}
+	return dhcpLayer.(*layers.DHCPv6), nil
+}
+
+// Address6 is an address assigned in an IA_NA
+type Address6 struct {
+	IP        net.IP
+	Preferred time.Duration
+	Valid     time.Duration
+}
+
+// Prefix6 is a prefix delegated in an IA_PD
+type Prefix6 struct {
+	Prefix    *net.IPNet
+	Preferred time.Duration
+	Valid     time.Duration
+}
+
+// Lease6 is what a DHCPv6 client got from an advertise or a reply
+type Lease6 struct {
+	ClientID  []byte
+	ServerID  []byte
+	Status    layers.DHCPv6StatusCode
+	Message   string
+	Addresses []Address6
+	Prefixes  []Prefix6
+	DNS       []net.IP
+	// RapidCommit is set on a reply to a solicit
+	RapidCommit bool
+
+	Bound  time.Time
+	Renew  time.Time
+	Rebind time.Time
+}
+
+// OK tells whether the server assigned something without error
+func (l Lease6) OK() bool {
+	return l.Status == layers.DHCPv6StatusCodeSuccess && (len(l.Addresses) > 0 || len(l.Prefixes) > 0)
+}
+
+func (l Lease6) String() string {
+	var client layers.DHCPv6DUID
+	var items []string
+	if client.DecodeFromBytes(l.ClientID) == nil {
+		items = append(items, fmt.Sprintf("client=%s", client.LinkLayerAddress))
+	}
+	if l.Status != layers.DHCPv6StatusCodeSuccess {
+		items = append(items, fmt.Sprintf("status=%s %q", l.Status, l.Message))
+	}
+	for _, address := range l.Addresses {
+		items = append(items, fmt.Sprintf("address=%s valid=%s", address.IP, address.Valid))
+	}
+	for _, prefix := range l.Prefixes {
+		items = append(items, fmt.Sprintf("prefix=%s valid=%s", prefix.Prefix, prefix.Valid))
+	}
+	if len(l.DNS) > 0 {
+		items = append(items, fmt.Sprintf("dns=%v", l.DNS))
+	}
+	if l.RapidCommit {
+		items = append(items, "rapid-commit")
+	}
+	return "lease6 " + strings.Join(items, " ")
+}
+
+func NewLease6(packet *layers.DHCPv6) (lease Lease6) {
+	lease.Bound = time.Now()
+	for _, option := range packet.Options {
+		switch option.Code {
+		case layers.DHCPv6OptClientID:
+			lease.ClientID = option.Data
+		case layers.DHCPv6OptServerID:
+			lease.ServerID = option.Data
+		case layers.DHCPv6OptStatusCode:
+			lease.Status, lease.Message = decodeStatus(option.Data)
+		case layers.DHCPv6OptRapidCommit:
+			lease.RapidCommit = true
+		case layers.DHCPv6OptDNSServers:
+			for i := 0; i+16 <= len(option.Data); i += 16 {
+				lease.DNS = append(lease.DNS, net.IP(option.Data[i:i+16]))
+			}
+		case layers.DHCPv6OptIANA, layers.DHCPv6OptIAPD:
+			lease.addIA(option)
+		}
+	}
+	return
+}
+
+// addIA adds the addresses or prefixes of an IA_NA or IA_PD, the earliest
+// T1 and T2 of all the IAs are kept
+func (l *Lease6) addIA(ia layers.DHCPv6Option) {
+	if len(ia.Data) < 12 {
+		return
+	}
+	t1 := time.Duration(binary.BigEndian.Uint32(ia.Data[4:8])) * time.Second
+	t2 := time.Duration(binary.BigEndian.Uint32(ia.Data[8:12])) * time.Second
+	if t1 > 0 && (l.Renew.IsZero() || l.Bound.Add(t1).Before(l.Renew)) {
+		l.Renew = l.Bound.Add(t1)
+	}
+	if t2 > 0 && (l.Rebind.IsZero() || l.Bound.Add(t2).Before(l.Rebind)) {
+		l.Rebind = l.Bound.Add(t2)
+	}
+
+	options, _ := decodeOptions6(ia.Data[12:])
+	for _, option := range options {
+		switch {
+		case option.Code == layers.DHCPv6OptStatusCode:
+			l.Status, l.Message = decodeStatus(option.Data)
+		case option.Code == layers.DHCPv6OptIAAddr && ia.Code == layers.DHCPv6OptIANA && len(option.Data) >= 24:
+			l.Addresses = append(l.Addresses, Address6{
+				IP:        net.IP(option.Data[0:16]),
+				Preferred: time.Duration(binary.BigEndian.Uint32(option.Data[16:20])) * time.Second,
+				Valid:     time.Duration(binary.BigEndian.Uint32(option.Data[20:24])) * time.Second,
+			})
+		case option.Code == layers.DHCPv6OptIAPrefix && ia.Code == layers.DHCPv6OptIAPD && len(option.Data) >= 25:
+			length := int(option.Data[8])
+			if length > 128 {
+				continue
+			}
+			l.Prefixes = append(l.Prefixes, Prefix6{
+				Prefix:    &net.IPNet{IP: net.IP(option.Data[9:25]), Mask: net.CIDRMask(length, 128)},
+				Preferred: time.Duration(binary.BigEndian.Uint32(option.Data[0:4])) * time.Second,
+				Valid:     time.Duration(binary.BigEndian.Uint32(option.Data[4:8])) * time.Second,
+			})
+		}
+	}
+}
+
+func decodeStatus(data []byte) (layers.DHCPv6StatusCode, string) {
+	if len(data) < 2 {
+		return layers.DHCPv6StatusCodeUnspecFail, ""
+	}
+	return layers.DHCPv6StatusCode(binary.BigEndian.Uint16(data)), string(data[2:])
+}
+
+// PacketResponse6 follows one DHCPv6 transaction. The statistics count a
+// solicit as a discover, an advertise as an offer and a reply as an ack, a
+// rapid commit reply completes both exchanges at once
+type PacketResponse6 struct {
+	dispatcher *PacketEventDispatcher
+	stats      *Statistics
+	export     *Exporter
+	// request tells whether an advertise is followed by a request
+	request    bool
+	// selecting is the request answering the advertise, it is built from the
+	// advertise when nil
+	selecting  *layers.DHCPv6
+	record     Record
+	started    bool
+	finished   bool
+	done       chan struct{}
+	lock       sync.Mutex
+	sTimer     *time.Timer
+	rTimer     *time.Timer
+	sSent      time.Time
+	rSent      time.Time
+	packets    map[layers.DHCPv6MsgType][]*layers.DHCPv6
+	// forget drops the transaction from the client once it is over
+	forget     func()
+}
+
+func (pr *PacketResponse6) Call(event PacketEvent) {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	pr.dispatcher.DispatchEvent(event)
+}
+
+func (pr *PacketResponse6) AddPacket(packet *layers.DHCPv6) {
+	if pr.record.Messages == nil {
+		var duid layers.DHCPv6DUID
+		if err := duid.DecodeFromBytes(NewLease6(packet).ClientID); err == nil && duid.LinkLayerAddress != nil {
+			pr.record.MAC = duid.LinkLayerAddress.String()
+		}
+		pr.record.Xid = fmt.Sprintf("%06x", Xid6(packet))
+	}
+	pr.record.add(packet.MsgType.String())
+	pr.packets[packet.MsgType] = append(pr.packets[packet.MsgType], packet)
+}
+
+// Done is closed when the transaction completes or times out
+func (pr *PacketResponse6) Done() <-chan struct{} {
+	return pr.done
+}
+
+// begin counts the transaction in flight when its first packet is sent
+func (pr *PacketResponse6) begin() {
+	if pr.started {
+		return
+	}
+	pr.started = true
+	pr.stats.observer().transactionStarted()
+}
+
+// finish ends the record of the transaction in the given state and exports it
+func (pr *PacketResponse6) finish(state string) {
+	if pr.finished {
+		return
+	}
+	pr.finished = true
+	close(pr.done)
+	if pr.started {
+		pr.stats.observer().transactionFinished()
+	}
+	pr.record.State = state
+	pr.record.End = time.Now()
+	pr.export.transaction(pr.record)
+	if pr.forget != nil {
+		pr.forget()
+	}
+}
+
+// track adds the transaction to the client until it has been over for the
+// reply timeout, the late replies of the other servers are matched till then
+func (dc *DhcpV6Client) track(xid uint32, pr *PacketResponse6) {
+	dc.packetsLock.Lock()
+	defer dc.packetsLock.Unlock()
+	packets := dc.packets
+	packets[xid] = pr
+	pr.forget = func() {
+		time.AfterFunc(utility.Timeout, func() {
+			dc.packetsLock.Lock()
+			if packets[xid] == pr {
+				delete(packets, xid)
+			}
+			dc.packetsLock.Unlock()
+		})
+	}
+}
+
+// offered records the first address, or prefix, and the server of the first
+// advertise or reply
+func (pr *PacketResponse6) offered(lease Lease6) {
+	if pr.record.OfferedIP != "" {
+		return
+	}
+	if len(lease.Addresses) > 0 {
+		pr.record.OfferedIP = lease.Addresses[0].IP.String()
+	} else if len(lease.Prefixes) > 0 {
+		pr.record.OfferedIP = lease.Prefixes[0].Prefix.String()
+	}
+	pr.record.ServerID = fmt.Sprintf("%x", lease.ServerID)
+}
+
+func (pr *PacketResponse6) replied(msgTypes ...layers.DHCPv6MsgType) bool {
+	for _, msgType := range msgTypes {
+		if len(pr.packets[msgType]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func NewPacketResponse6() *PacketResponse6 {
+	pr := &PacketResponse6{}
+	pr.done = make(chan struct{})
+	pr.packets = make(map[layers.DHCPv6MsgType][]*layers.DHCPv6)
+	pr.dispatcher = new(PacketEventDispatcher)
+	pr.dispatcher.AddEventListener(solicitDequeue, func(e PacketEvent) {
+		pr.AddPacket(e.object.(*layers.DHCPv6))
+		pr.begin()
+		pr.stats.discoverSent()
+		if pr.sTimer != nil {
+			return
+		}
+		pr.sSent = time.Now()
+		pr.sTimer = time.AfterFunc(utility.Timeout, func() {
+			pr.Call(NewEvent(advertiseTimeout, nil))
+		})
+	})
+	pr.dispatcher.AddEventListener(receivedAdvertise, func(e PacketEvent) {
+		packet := e.object.(*layers.DHCPv6)
+		if !pr.replied(layers.DHCPv6MsgTypeAdverstise, layers.DHCPv6MsgTypeReply) {
+			pr.stats.offerReceived(time.Since(pr.sSent))
+			pr.record.OfferLatency = milliseconds(time.Since(pr.sSent))
+			pr.offered(NewLease6(packet))
+		}
+		pr.AddPacket(packet)
+		if !pr.request {
+			//a solicit which is not followed by a request is over at its first advertise
+			if pr.sTimer != nil {
+				pr.sTimer.Stop()
+			}
+			pr.finish(StateOffered)
+		}
+	})
+	pr.dispatcher.AddEventListener(advertiseTimeout, func(e PacketEvent) {
+		pr.dispatcher.RemoveEventListener(receivedAdvertise)
+		if pr.rTimer == nil {
+			//no request sent, a late rapid commit reply is no more expected
+			pr.dispatcher.RemoveEventListener(receivedReply)
+		}
+		if !pr.replied(layers.DHCPv6MsgTypeAdverstise, layers.DHCPv6MsgTypeReply) {
+			pr.stats.offerTimeout()
+			pr.finish(StateOfferTimeout)
+		} else if !pr.request {
+			pr.finish(StateOffered)
+		}
+	})
+	pr.dispatcher.AddEventListener(request6Dequeue, func(e PacketEvent) {
+		pr.AddPacket(e.object.(*layers.DHCPv6))
+		pr.begin()
+		pr.stats.requestSent()
+		if pr.rTimer != nil {
+			return
+		}
+		pr.rSent = time.Now()
+		pr.rTimer = time.AfterFunc(utility.Timeout, func() {
+			pr.Call(NewEvent(replyTimeout, nil))
+		})
+	})
+	pr.dispatcher.AddEventListener(receivedReply, func(e PacketEvent) {
+		packet := e.object.(*layers.DHCPv6)
+		if pr.replied(layers.DHCPv6MsgTypeReply) {
+			pr.AddPacket(packet)
+			return
+		}
+		sent := pr.rSent
+		if sent.IsZero() {
+			//rapid commit, the reply answers the solicit
+			sent = pr.sSent
+			if !pr.replied(layers.DHCPv6MsgTypeAdverstise) {
+				pr.stats.offerReceived(time.Since(sent))
+				pr.record.OfferLatency = milliseconds(time.Since(sent))
+			}
+		}
+		lease := NewLease6(packet)
+		pr.record.AckLatency = milliseconds(time.Since(sent))
+		pr.offered(lease)
+		pr.AddPacket(packet)
+		if lease.OK() {
+			pr.stats.ackReceived(time.Since(sent))
+			pr.finish(StateAcked)
+		} else {
+			pr.stats.nakReceived()
+			pr.finish(StateNaked)
+		}
+	})
+	pr.dispatcher.AddEventListener(replyTimeout, func(e PacketEvent) {
+		pr.dispatcher.RemoveEventListener(receivedReply)
+		if !pr.replied(layers.DHCPv6MsgTypeReply) {
+			pr.stats.ackTimeout()
+			pr.finish(StateAckTimeout)
+		}
+	})
+	pr.dispatcher.AddEventListener(sendFailed, func(e PacketEvent) {
+		pr.record.Error = e.object.(error).Error()
+	})
+	return pr
+}
@@ -0,0 +1,103 @@
+package connection
+
+import (
+	"dhcptest/layers"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSolicitAndRequest(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0x12, 0x34, 0x56}
+	duid := NewDUID(layers.DHCPv6DUIDTypeLLT, mac, duidEpoch.Add(time.Hour))
+	if binary.BigEndian.Uint32(duid.Time) != 3600 {
+		t.Fatalf("duid time %v, want 3600 seconds", duid.Time)
+	}
+
+	solicit := NewSolicit(duid)
+	WithTransactionID6(0xabcdef)(solicit)
+	if Xid6(solicit) != 0xabcdef {
+		t.Fatalf("xid %x, want abcdef", Xid6(solicit))
+	}
+	var client layers.DHCPv6DUID
+	if err := client.DecodeFromBytes(optionData(solicit, layers.DHCPv6OptClientID)); err != nil {
+		t.Fatal(err)
+	}
+	if client.LinkLayerAddress.String() != mac.String() {
+		t.Fatalf("client id mac %s, want %s", client.LinkLayerAddress, mac)
+	}
+
+	advertise := newReply(solicit, layers.DHCPv6MsgTypeAdverstise)
+	request := NewRequestFromAdvertise(advertise)
+	if request.MsgType != layers.DHCPv6MsgTypeRequest || Xid6(request) != Xid6(solicit) {
+		t.Fatalf("request %s xid %x, want Request xid %x", request.MsgType, Xid6(request), Xid6(solicit))
+	}
+	for _, code := range []layers.DHCPv6Opt{layers.DHCPv6OptClientID, layers.DHCPv6OptServerID, layers.DHCPv6OptIANA, layers.DHCPv6OptIAPD} {
+		if optionData(request, code) == nil {
+			t.Errorf("request has no %s option", code)
+		}
+	}
+}
+
+func TestNewLease6(t *testing.T) {
+	solicit := NewSolicit(NewDUID(layers.DHCPv6DUIDTypeLL, net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, time.Now()))
+	lease := NewLease6(newReply(solicit, layers.DHCPv6MsgTypeReply))
+	if !lease.OK() {
+		t.Fatalf("lease %s is not ok", lease)
+	}
+	if len(lease.Addresses) != 1 || !lease.Addresses[0].IP.Equal(net.ParseIP("2001:db8::10")) || lease.Addresses[0].Valid != time.Hour {
+		t.Errorf("addresses %+v", lease.Addresses)
+	}
+	if len(lease.Prefixes) != 1 || lease.Prefixes[0].Prefix.String() != "2001:db8:1::/56" {
+		t.Errorf("prefixes %+v", lease.Prefixes)
+	}
+	if lease.Renew.Sub(lease.Bound) != 30*time.Minute || lease.Rebind.Sub(lease.Bound) != 48*time.Minute {
+		t.Errorf("renew %s rebind %s", lease.Renew.Sub(lease.Bound), lease.Rebind.Sub(lease.Bound))
+	}
+
+	noAddrs := NewPacket6(layers.DHCPv6MsgTypeReply,
+		layers.NewDHCPv6Option(layers.DHCPv6OptIANA, newIA(1, []layers.DHCPv6Option{
+			layers.NewDHCPv6Option(layers.DHCPv6OptStatusCode, append([]byte{0, byte(layers.DHCPv6StatusCodeNoAddrsAvail)}, "no addresses"...)),
+		})))
+	if lease := NewLease6(noAddrs); lease.OK() || lease.Status != layers.DHCPv6StatusCodeNoAddrsAvail {
+		t.Errorf("lease %s should fail with NoAddrsAvail", lease)
+	}
+}
+
+// newReply answers the IA_NA and IA_PD of a solicit as a server does
+func newReply(solicit *layers.DHCPv6, msgType layers.DHCPv6MsgType) *layers.DHCPv6 {
+	address := make([]byte, 24)
+	copy(address, net.ParseIP("2001:db8::10"))
+	binary.BigEndian.PutUint32(address[16:], 1800)
+	binary.BigEndian.PutUint32(address[20:], 3600)
+	iana := newIA(1, []layers.DHCPv6Option{layers.NewDHCPv6Option(layers.DHCPv6OptIAAddr, address)})
+	binary.BigEndian.PutUint32(iana[4:], 1800)
+	binary.BigEndian.PutUint32(iana[8:], 2880)
+
+	prefix := make([]byte, 25)
+	binary.BigEndian.PutUint32(prefix, 1800)
+	binary.BigEndian.PutUint32(prefix[4:], 3600)
+	prefix[8] = 56
+	copy(prefix[9:], net.ParseIP("2001:db8:1::"))
+	iapd := newIA(1, []layers.DHCPv6Option{layers.NewDHCPv6Option(layers.DHCPv6OptIAPrefix, prefix)})
+
+	server := NewDUID(layers.DHCPv6DUIDTypeLL, net.HardwareAddr{0x02, 0, 0, 0, 0, 0xff}, time.Now())
+	reply := NewPacket6(msgType,
+		layers.NewDHCPv6Option(layers.DHCPv6OptClientID, optionData(solicit, layers.DHCPv6OptClientID)),
+		layers.NewDHCPv6Option(layers.DHCPv6OptServerID, server.Encode()),
+		layers.NewDHCPv6Option(layers.DHCPv6OptIANA, iana),
+		layers.NewDHCPv6Option(layers.DHCPv6OptIAPD, iapd),
+	)
+	copy(reply.TransactionID, solicit.TransactionID)
+	return reply
+}
+
+func optionData(packet *layers.DHCPv6, code layers.DHCPv6Opt) []byte {
+	for _, option := range packet.Options {
+		if option.Code == code {
+			return option.Data
+		}
+	}
+	return nil
+}
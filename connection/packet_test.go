@@ -0,0 +1,147 @@
+package connection
+
+import (
+	"bytes"
+	"dhcptest/layers"
+	"dhcptest/utility"
+	"github.com/google/gopacket"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// udpFrame builds an ethernet frame carrying the payload from port 67 to port 68
+func udpFrame(t *testing.T, payload []byte) []byte {
+	return portFrame(t, 67, 68, payload)
+}
+
+// portFrame builds an ethernet frame carrying the payload between the udp ports
+func portFrame(t *testing.T, src layers.UDPPort, dst layers.UDPPort, payload []byte) []byte {
+	eth := layers.Ethernet{SrcMAC: net.HardwareAddr{2, 0, 0, 0, 0, 1}, DstMAC: layers.EthernetBroadcast, EthernetType: layers.EthernetTypeIPv4}
+	ip := layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IPv4(10, 0, 0, 1), DstIP: net.IPv4bcast}
+	udp := layers.UDP{SrcPort: src, DstPort: dst}
+	udp.SetNetworkLayerForChecksum(&ip)
+	frame, err := serialize(&eth, &ip, &udp, gopacket.Payload(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func TestDecodeFrame(t *testing.T) {
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, NewPacket()); err != nil {
+		t.Fatal(err)
+	}
+	packet, srcMac, err := DecodeFrame(udpFrame(t, buf.Bytes()), layers.LayerTypeEthernet)
+	if packet == nil || err != nil || srcMac.String() != "02:00:00:00:00:01" {
+		t.Fatalf("got packet %v from %s, error %v", packet, srcMac, err)
+	}
+
+	//a truncated reply is counted as malformed instead of crashing the listen loop
+	packet, _, err = DecodeFrame(udpFrame(t, buf.Bytes()[:100]), layers.LayerTypeEthernet)
+	if packet != nil || err != layers.DecPacketTooShort {
+		t.Errorf("truncated packet: got %v, error %v", packet, err)
+	}
+	packet, _, err = DecodeFrame(buf.Bytes()[:100], layers.LayerTypeDHCPv4)
+	if packet != nil || err != layers.DecPacketTooShort {
+		t.Errorf("truncated packet: got %v, error %v", packet, err)
+	}
+
+	//other traffic is neither a packet nor an error
+	ip := layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolICMPv4, SrcIP: net.IPv4(10, 0, 0, 1), DstIP: net.IPv4(10, 0, 0, 2)}
+	eth := layers.Ethernet{SrcMAC: net.HardwareAddr{2, 0, 0, 0, 0, 1}, DstMAC: layers.EthernetBroadcast, EthernetType: layers.EthernetTypeIPv4}
+	frame, err := serialize(&eth, &ip, &layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet, _, err := DecodeFrame(frame[:20], layers.LayerTypeEthernet); packet != nil || err != nil {
+		t.Errorf("truncated icmp: got %v, error %v", packet, err)
+	}
+
+	//short payloads of the other udp protocols are no malformed dhcp
+	for _, port := range []layers.UDPPort{53, 123, 4789} {
+		if packet, _, err := DecodeFrame(portFrame(t, 40000, port, []byte{1, 2, 3}), layers.LayerTypeEthernet); packet != nil || err != nil {
+			t.Errorf("short payload to port %d: got %v, error %v", port, packet, err)
+		}
+	}
+	if _, _, err := DecodeFrame(portFrame(t, 40000, 67, buf.Bytes()[:100]), layers.LayerTypeEthernet); err == nil {
+		t.Error("a truncated request to port 67 is not malformed")
+	}
+}
+
+func TestDiscoverOnlyFinishesOnOffer(t *testing.T) {
+	utility.Timeout = time.Hour
+	var buf bytes.Buffer
+	export, err := NewExporter("json", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	discover := NewDiscover(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	offer := replyFrom(layers.DHCPMsgTypeOffer, net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 5))
+
+	pr := NewPacketResponse()
+	pr.export = export
+	pr.Call(NewEvent(discoverDequeue, discover))
+	pr.Call(NewEvent(receivedOffer, offer))
+	select {
+	case <-pr.Done():
+	default:
+		t.Fatal("the transaction waits for the offer timeout")
+	}
+	if state := pr.State(); state != StateOffered {
+		t.Errorf("state %s, want %s", state, StateOffered)
+	}
+	//the timer is stopped, a late timeout changes nothing
+	pr.Call(NewEvent(offerTimeout, nil))
+	pr.Call(NewEvent(receivedOffer, offer))
+	export.Close()
+	if records := strings.Count(buf.String(), "\n"); records != 1 {
+		t.Errorf("%d records, want 1:\n%s", records, buf.String())
+	}
+	if !strings.Contains(buf.String(), `"state":"offered"`) {
+		t.Errorf("record %s", buf.String())
+	}
+}
+
+func TestFinishedTransactionIsForgotten(t *testing.T) {
+	utility.Timeout = 20 * time.Millisecond
+	defer func() {
+		utility.Timeout = time.Hour
+	}()
+	dc := leaseClient()
+	discover := NewDiscover(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	WithTransactionID(7)(discover)
+	offer := replyFrom(layers.DHCPMsgTypeOffer, net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 5))
+
+	pr := dc.Send(discover)
+	pr.Call(NewEvent(discoverDequeue, discover))
+	tracked := func() *PacketResponse {
+		dc.packetsLock.Lock()
+		defer dc.packetsLock.Unlock()
+		return dc.packets[7]
+	}
+	if tracked() != pr {
+		t.Fatal("the transaction in flight is not tracked")
+	}
+	pr.Call(NewEvent(receivedOffer, offer))
+	//a late reply of another server is still matched for a while
+	if tracked() != pr {
+		t.Fatal("the transaction is forgotten as soon as it is over")
+	}
+	time.Sleep(2 * utility.Timeout)
+	if tracked() != nil || len(dc.packets) != 0 {
+		t.Errorf("%d transactions kept after the timeout", len(dc.packets))
+	}
+
+	//the timer of an old transaction leaves a newer one with the same xid alone
+	old := dc.Send(discover)
+	old.Call(NewEvent(discoverDequeue, discover))
+	old.Call(NewEvent(receivedOffer, offer))
+	newer := dc.Send(discover)
+	time.Sleep(2 * utility.Timeout)
+	if tracked() != newer {
+		t.Error("the newer transaction of the xid is forgotten")
+	}
+}
@@ -0,0 +1,56 @@
+package connection
+
+import (
+	"dhcptest/layers"
+	"dhcptest/utility"
+	"net"
+)
+
+// Relay is the relay agent the client pretends to be. The packets are unicast
+// from IP:67 to Server:67, each simulated device sits in one of the subnets
+// given by Giaddrs and is told apart by the Option 82 templates
+type Relay struct {
+	IP     net.IP
+	Server net.IP
+	// ServerMAC is the next hop to the server, the frames are broadcast when it is nil
+	ServerMAC net.HardwareAddr
+	Giaddrs   []net.IP
+	// CircuitID and RemoteID are expanded by utility.ExpandTemplate, an
+	// empty template leaves the sub-option out
+	CircuitID string
+	RemoteID  string
+}
+
+// Route returns the route of the relayed packets
+func (r *Relay) Route() *Route {
+	return &Route{
+		SrcIP:   r.IP,
+		DstIP:   r.Server,
+		DstMAC:  r.ServerMAC,
+		SrcPort: 67,
+		DstPort: 67,
+	}
+}
+
+// Giaddr returns the giaddr of the subnet of the device with the given index
+func (r *Relay) Giaddr(index int) net.IP {
+	if len(r.Giaddrs) == 0 {
+		return r.IP
+	}
+	return r.Giaddrs[index%len(r.Giaddrs)]
+}
+
+// Modifier relays the packet of the device with the given index and mac
+func (r *Relay) Modifier(index int, mac net.HardwareAddr) Modifier {
+	return func(packet *layers.DHCPv4) {
+		WithRelay(r.Giaddr(index))(packet)
+		info := layers.DHCPRelayAgentInfo{
+			CircuitID: []byte(utility.ExpandTemplate(r.CircuitID, index, mac)),
+			RemoteID:  []byte(utility.ExpandTemplate(r.RemoteID, index, mac)),
+		}
+		if data := info.Encode(); len(data) > 0 {
+			//option 82 goes after all the other options, RFC 3046 2.1
+			packet.AddOption(layers.DHCPOptRelayAgent, data)
+		}
+	}
+}
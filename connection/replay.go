@@ -0,0 +1,508 @@
+package connection
+
+import (
+	"bytes"
+	"dhcptest/layers"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/google/gopacket"
+	"io/ioutil"
+	"math"
+	"net"
+	"time"
+)
+
+const (
+	pcapMagicNano        = 0xa1b23c4d
+	pcapngSimplePacket   = 3
+	pcapngObsoletePacket = 2
+	linkTypeIPv4         = 228
+	linkTypeIPv6         = 229
+)
+
+// CapturedFrame is a frame read from a pcap or a pcapng file
+type CapturedFrame struct {
+	At time.Time
+	// LinkType is the pcap link type of the interface the frame was captured on
+	LinkType uint32
+	Data     []byte
+}
+
+// decoder returns the decoder of the link type, nil for the link types
+// there is none for
+func (f CapturedFrame) decoder() gopacket.Decoder {
+	switch f.LinkType {
+	case uint32(layers.LinkTypeEthernet), uint32(layers.LinkTypeRaw), uint32(layers.LinkTypeLinuxSLL),
+		uint32(layers.LinkTypeNull), uint32(layers.LinkTypeLoop):
+		return layers.LinkType(f.LinkType)
+	case linkTypeIPv4, linkTypeIPv6:
+		return layers.LinkTypeRaw
+	}
+	return nil
+}
+
+// errTruncated stops the reading at a record cut short, as the last one of a
+// capture which was killed
+var errTruncated = errors.New("truncated record")
+
+// ReadCapture reads the frames of a pcap or a pcapng file, the byte order and
+// the timestamp resolution are taken from the file. A last record cut short
+// is left out
+func ReadCapture(path string) ([]CapturedFrame, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 24 {
+		return nil, fmt.Errorf("%s: not a pcap or a pcapng file", path)
+	}
+	var frames []CapturedFrame
+	if binary.LittleEndian.Uint32(data) == pcapngSectionType {
+		frames, err = readPcapng(data)
+	} else {
+		frames, err = readPcap(data)
+	}
+	if err == errTruncated {
+		err = nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return frames, nil
+}
+
+func readPcap(data []byte) ([]CapturedFrame, error) {
+	var order binary.ByteOrder
+	nano := false
+	for _, o := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch o.Uint32(data) {
+		case pcapMagic:
+			order = o
+		case pcapMagicNano:
+			order, nano = o, true
+		}
+	}
+	if order == nil {
+		return nil, errors.New("not a pcap or a pcapng file")
+	}
+	linkType := order.Uint32(data[20:]) & 0xffff
+	var frames []CapturedFrame
+	for data = data[24:]; len(data) > 0; {
+		if len(data) < 16 {
+			return frames, errTruncated
+		}
+		length := order.Uint32(data[8:])
+		if uint64(length) > uint64(len(data)-16) {
+			return frames, errTruncated
+		}
+		fraction := time.Duration(order.Uint32(data[4:]))
+		if !nano {
+			fraction *= time.Microsecond
+		}
+		frames = append(frames, CapturedFrame{
+			At:       time.Unix(int64(order.Uint32(data)), int64(fraction)),
+			LinkType: linkType,
+			Data:     data[16 : 16+length],
+		})
+		data = data[16+length:]
+	}
+	return frames, nil
+}
+
+// pcapngInterface is an interface of a pcapng section
+type pcapngInterface struct {
+	linkType uint32
+	// unit is the duration of a timestamp unit in nanoseconds
+	unit float64
+}
+
+// timestamp converts a timestamp in the units of the interface
+func (i pcapngInterface) timestamp(high uint32, low uint32) time.Time {
+	ts := uint64(high)<<32 | uint64(low)
+	if i.unit == 1 {
+		return time.Unix(0, int64(ts))
+	}
+	seconds := float64(ts) * i.unit / 1e9
+	whole := math.Floor(seconds)
+	return time.Unix(int64(whole), int64((seconds-whole)*1e9))
+}
+
+func readPcapng(data []byte) ([]CapturedFrame, error) {
+	var (
+		order      binary.ByteOrder = binary.LittleEndian
+		interfaces []pcapngInterface
+		frames     []CapturedFrame
+		last       time.Time
+	)
+	for len(data) > 0 {
+		if len(data) < 12 {
+			return frames, errTruncated
+		}
+		blockType := order.Uint32(data)
+		if blockType == pcapngSectionType {
+			switch uint32(pcapngByteOrder) {
+			case binary.LittleEndian.Uint32(data[8:]):
+				order = binary.LittleEndian
+			case binary.BigEndian.Uint32(data[8:]):
+				order = binary.BigEndian
+			default:
+				return frames, errors.New("invalid byte order magic")
+			}
+			interfaces = nil
+		}
+		total := order.Uint32(data[4:])
+		if total < 12 || total%4 != 0 {
+			return frames, fmt.Errorf("invalid length %d of a block", total)
+		}
+		if uint64(total) > uint64(len(data)) {
+			return frames, errTruncated
+		}
+		body := data[8 : total-4]
+		data = data[total:]
+
+		switch blockType {
+		case pcapngIfaceType:
+			if len(body) < 8 {
+				return frames, errors.New("short interface description")
+			}
+			iface := pcapngInterface{linkType: uint32(order.Uint16(body)), unit: 1000}
+			for options := body[8:]; len(options) >= 4; {
+				code, length := order.Uint16(options), int(order.Uint16(options[2:]))
+				if code == 0 || 4+length > len(options) {
+					break
+				}
+				if value := options[4 : 4+length]; code == 9 && length == 1 {
+					//if_tsresol, a power of 10 or of 2 when the high bit is set
+					if value[0]&0x80 == 0 {
+						iface.unit = 1e9 / math.Pow(10, float64(value[0]))
+					} else {
+						iface.unit = 1e9 / math.Pow(2, float64(value[0]&0x7f))
+					}
+				}
+				options = options[4+length+(4-length%4)%4:]
+			}
+			interfaces = append(interfaces, iface)
+		case pcapngPacketType, pcapngObsoletePacket:
+			if len(body) < 20 {
+				return frames, errors.New("short packet block")
+			}
+			id := order.Uint32(body)
+			if blockType == pcapngObsoletePacket {
+				id = uint32(order.Uint16(body))
+			}
+			length := order.Uint32(body[12:])
+			if int(id) >= len(interfaces) || uint64(length) > uint64(len(body)-20) {
+				return frames, errors.New("invalid packet block")
+			}
+			last = interfaces[id].timestamp(order.Uint32(body[4:]), order.Uint32(body[8:]))
+			frames = append(frames, CapturedFrame{At: last, LinkType: interfaces[id].linkType, Data: body[20 : 20+length]})
+		case pcapngSimplePacket:
+			if len(body) < 4 || len(interfaces) == 0 {
+				return frames, errors.New("invalid simple packet block")
+			}
+			length := order.Uint32(body)
+			if uint64(length) > uint64(len(body)-4) {
+				length = uint32(len(body) - 4)
+			}
+			//no timestamp, the frame is taken for as old as the one before
+			frames = append(frames, CapturedFrame{At: last, LinkType: interfaces[0].linkType, Data: body[4 : 4+length]})
+		}
+	}
+	return frames, nil
+}
+
+// ClientMessage is a message a dhcpv4 client sent in a capture
+type ClientMessage struct {
+	// At is the time from the first message of the capture
+	At     time.Duration
+	Packet *layers.DHCPv4
+	// Request is the request the client answered the offer to the discover with
+	Request *layers.DHCPv4
+}
+
+// ClientMessage6 is a message a dhcpv6 client sent in a capture
+type ClientMessage6 struct {
+	// At is the time from the first message of the capture
+	At     time.Duration
+	Packet *layers.DHCPv6
+	// Request is the request the client answered the advertise to the solicit with
+	Request *layers.DHCPv6
+}
+
+// ClientMessages picks the messages the dhcpv4 and the dhcpv6 clients sent,
+// from the client ports, out of the frames of a capture. Only the first
+// message of each type of a transaction is kept, the retransmissions are left
+// to the timeouts of the replay, and the first request of a transaction
+// started by a discover, or a solicit, goes with it to answer the offer
+func ClientMessages(frames []CapturedFrame) ([]ClientMessage, []ClientMessage6) {
+	var (
+		v4     []ClientMessage
+		v6     []ClientMessage6
+		start  time.Time
+		seen   = make(map[string]bool)
+		starts = make(map[string]int)
+	)
+	at := func(f CapturedFrame) time.Duration {
+		if start.IsZero() {
+			start = f.At
+		}
+		return f.At.Sub(start)
+	}
+	for _, f := range frames {
+		decoder := f.decoder()
+		if decoder == nil {
+			continue
+		}
+		packet := gopacket.NewPacket(f.Data, decoder, gopacket.NoCopy)
+		udp, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+		if !ok {
+			continue
+		}
+		if d, ok := packet.Layer(layers.LayerTypeDHCPv4).(*layers.DHCPv4); ok && udp.SrcPort == 68 && d.Operation == layers.DHCPOpRequest {
+			msgType := d.MessageType()
+			switch msgType {
+			case layers.DHCPMsgTypeDiscover, layers.DHCPMsgTypeRequest, layers.DHCPMsgTypeInform,
+				layers.DHCPMsgTypeRelease, layers.DHCPMsgTypeDecline:
+			default:
+				continue
+			}
+			transaction := fmt.Sprintf("%s/%08x", d.ClientHWAddr, d.Xid)
+			key := fmt.Sprintf("%s/%d", transaction, msgType)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if i, ok := starts[transaction]; ok && msgType == layers.DHCPMsgTypeRequest {
+				v4[i].Request = d
+				continue
+			}
+			if msgType == layers.DHCPMsgTypeDiscover {
+				starts[transaction] = len(v4)
+			}
+			v4 = append(v4, ClientMessage{At: at(f), Packet: d})
+		} else if d, ok := packet.Layer(layers.LayerTypeDHCPv6).(*layers.DHCPv6); ok && udp.SrcPort == 546 {
+			switch d.MsgType {
+			case layers.DHCPv6MsgTypeSolicit, layers.DHCPv6MsgTypeRequest, layers.DHCPv6MsgTypeConfirm,
+				layers.DHCPv6MsgTypeRenew, layers.DHCPv6MsgTypeRebind, layers.DHCPv6MsgTypeRelease,
+				layers.DHCPv6MsgTypeDecline, layers.DHCPv6MsgTypeInformationRequest:
+			default:
+				continue
+			}
+			var clientID []byte
+			for _, option := range d.Options {
+				if option.Code == layers.DHCPv6OptClientID {
+					clientID = option.Data
+				}
+			}
+			transaction := fmt.Sprintf("%x/%x", clientID, d.TransactionID)
+			key := fmt.Sprintf("%s/%d", transaction, d.MsgType)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if i, ok := starts[transaction]; ok && d.MsgType == layers.DHCPv6MsgTypeRequest {
+				v6[i].Request = d
+				continue
+			}
+			if d.MsgType == layers.DHCPv6MsgTypeSolicit {
+				starts[transaction] = len(v6)
+			}
+			v6 = append(v6, ClientMessage6{At: at(f), Packet: d})
+		}
+	}
+	return v4, v6
+}
+
+// clone returns a copy of the packet which shares nothing with it
+func clone(packet *layers.DHCPv4) *layers.DHCPv4 {
+	if packet == nil {
+		return nil
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := packet.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		return nil
+	}
+	copied := &layers.DHCPv4{}
+	if err := copied.DecodeFromBytes(buf.Bytes(), gopacket.NilDecodeFeedback); err != nil {
+		return nil
+	}
+	return copied
+}
+
+// clone6 returns a copy of the packet which shares nothing with it
+func clone6(packet *layers.DHCPv6) *layers.DHCPv6 {
+	if packet == nil {
+		return nil
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := packet.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		return nil
+	}
+	copied := &layers.DHCPv6{}
+	if err := copied.DecodeFromBytes(buf.Bytes(), gopacket.NilDecodeFeedback); err != nil {
+		return nil
+	}
+	return copied
+}
+
+// setOption sets the data of the first option of the type, the option is
+// added when the packet has none
+func setOption(packet *layers.DHCPv4, optType layers.DHCPOpt, data []byte) {
+	for i, option := range packet.Options {
+		if option.Type == optType {
+			packet.Options[i] = layers.NewDHCPOption(optType, data)
+			return
+		}
+	}
+	packet.AddOption(optType, data)
+}
+
+// WithClientMAC moves the packet to the client with the given mac, a client
+// id made of the hardware type and the former mac moves along
+func WithClientMAC(mac net.HardwareAddr) Modifier {
+	return func(packet *layers.DHCPv4) {
+		former := append([]byte{byte(packet.HardwareType)}, packet.ClientHWAddr...)
+		for i, option := range packet.Options {
+			if option.Type == layers.DHCPOptClientID && bytes.Equal(option.Data, former) {
+				packet.Options[i] = layers.NewDHCPOption(option.Type, append([]byte{byte(packet.HardwareType)}, mac...))
+			}
+		}
+		WithHwAddr(mac)(packet)
+	}
+}
+
+// WithClientMAC6 moves the packet to the client with the given mac, the
+// link-layer address of a DUID-LL or a DUID-LLT client id is replaced, the
+// other DUIDs are kept
+func WithClientMAC6(mac net.HardwareAddr) Modifier6 {
+	return func(packet *layers.DHCPv6) {
+		for i, option := range packet.Options {
+			if option.Code != layers.DHCPv6OptClientID {
+				continue
+			}
+			var duid layers.DHCPv6DUID
+			if err := duid.DecodeFromBytes(option.Data); err != nil || len(duid.LinkLayerAddress) == 0 {
+				continue
+			}
+			duid.LinkLayerAddress = mac
+			packet.Options[i] = layers.NewDHCPv6Option(option.Code, duid.Encode())
+		}
+	}
+}
+
+// requestFor returns the request answering the offer, the request given to
+// Replay with the offered address and the server id of the offer, or a new
+// request built from the offer
+func (pr *PacketResponse) requestFor(offer *layers.DHCPv4) *layers.DHCPv4 {
+	if pr.selecting == nil {
+		return NewRequestFromOffer(offer)
+	}
+	_, lease := NewLease(offer)
+	request := pr.selecting
+	request.Xid = offer.Xid
+	setOption(request, layers.DHCPOptRequestIP, lease.FixedAddress.To4())
+	setOption(request, layers.DHCPOptServerID, lease.ServerID.To4())
+	return request
+}
+
+// Replay sends a copy of a message of a capture with the modifiers, such
+// as WithTransactionID and WithClientMAC, applied to it and to its request.
+// The broadcast flag is set so that the replies reach the interface. The
+// offer to a discover is answered with the request of the message, set with
+// the offered address and the server id of the offer, a discover without a
+// request is not followed by one. The releases and the declines get no
+// answer, they are sent without a transaction and nil is returned.
+// The ack of a renew would be unicast to its ciaddr, which the client doesn't
+// hold, so a request with a ciaddr asks for it in the broadcast form of an
+// INIT-REBOOT request instead. An inform keeps its ciaddr, the responder
+// answers the server arping for it when it is open
+func (dc *DhcpClient) Replay(message ClientMessage, modifiers ...Modifier) (*PacketResponse, error) {
+	packet, request := clone(message.Packet), clone(message.Request)
+	if packet == nil || message.Request != nil && request == nil {
+		return nil, fmt.Errorf("the %s of %s cannot be encoded again", message.Packet.MessageType(), message.Packet.ClientHWAddr)
+	}
+	modifiers = append(append([]Modifier{}, modifiers...), (*layers.DHCPv4).SetBroadcast)
+	for _, modifier := range modifiers {
+		modifier(packet)
+		if request != nil {
+			modifier(request)
+		}
+	}
+	if packet.MessageType() == layers.DHCPMsgTypeRequest && packet.ClientIP != nil && !packet.ClientIP.Equal(net.IPv4zero) {
+		setOption(packet, layers.DHCPOptRequestIP, packet.ClientIP.To4())
+		packet.ClientIP = net.IPv4zero
+	}
+	switch packet.MessageType() {
+	case layers.DHCPMsgTypeRelease, layers.DHCPMsgTypeDecline:
+		var route *Route
+		if dc.Relay != nil {
+			route = dc.Relay.Route()
+		}
+		dc.Identity.Modifier()(packet)
+		if err := dc.send(packet, route); err != nil {
+			dc.stats.sendError()
+			return nil, err
+		}
+		dc.Metrics.packetSent(packet.MessageType().String())
+		return nil, nil
+	}
+	return dc.sendTo(nil, packet, request), nil
+}
+
+// Replay sends a copy of a message of a capture with the modifiers, such as
+// WithTransactionID6 and WithClientMAC6, applied to it and to its request.
+// The advertise to a solicit is answered with the request of the message,
+// with the server id and the addresses of the advertise, a solicit without a
+// request is not followed by one. The confirms, the information requests,
+// the releases and the declines get no lease in answer, they are sent without
+// a transaction and nil is returned
+func (dc *DhcpV6Client) Replay(message ClientMessage6, modifiers ...Modifier6) (*PacketResponse6, error) {
+	packet, request := clone6(message.Packet), clone6(message.Request)
+	if packet == nil || message.Request != nil && request == nil {
+		return nil, fmt.Errorf("the %s cannot be encoded again", message.Packet.MsgType)
+	}
+	for _, modifier := range modifiers {
+		modifier(packet)
+		if request != nil {
+			modifier(request)
+		}
+	}
+	switch packet.MsgType {
+	case layers.DHCPv6MsgTypeSolicit, layers.DHCPv6MsgTypeRequest, layers.DHCPv6MsgTypeRenew, layers.DHCPv6MsgTypeRebind:
+		return dc.sendWith(packet, request), nil
+	}
+	if err := dc.send(packet); err != nil {
+		dc.stats.sendError()
+		return nil, err
+	}
+	dc.Metrics.packetSent(packet.MsgType.String())
+	return nil, nil
+}
+
+// requestFor returns the request answering the advertise, the request given
+// to Replay with the server id and the addresses of the advertise, or a new
+// request built from the advertise
+func (pr *PacketResponse6) requestFor(advertise *layers.DHCPv6) *layers.DHCPv6 {
+	if pr.selecting == nil {
+		return NewRequestFromAdvertise(advertise)
+	}
+	request := pr.selecting
+	request.TransactionID = append([]byte{}, advertise.TransactionID...)
+	var options []layers.DHCPv6Option
+	for _, option := range request.Options {
+		switch option.Code {
+		case layers.DHCPv6OptServerID, layers.DHCPv6OptIANA, layers.DHCPv6OptIAPD:
+			continue
+		}
+		options = append(options, option)
+	}
+	for _, option := range advertise.Options {
+		switch option.Code {
+		case layers.DHCPv6OptServerID, layers.DHCPv6OptIANA, layers.DHCPv6OptIAPD:
+			options = append(options, layers.NewDHCPv6Option(option.Code, option.Data))
+		}
+	}
+	request.Options = options
+	return request
+}
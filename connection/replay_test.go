@@ -0,0 +1,255 @@
+package connection
+
+import (
+	"bytes"
+	"dhcptest/layers"
+	"encoding/binary"
+	"github.com/google/gopacket"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// clientFrame returns the frame of a packet sent by a dhcp client, or by the
+// server when fromServer is set
+func clientFrame(t *testing.T, packet gopacket.SerializableLayer, v6 bool, fromServer bool) []byte {
+	eth := &layers.Ethernet{SrcMAC: net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, DstMAC: layers.EthernetBroadcast}
+	udp := &layers.UDP{SrcPort: 68, DstPort: 67}
+	var ip gopacket.SerializableLayer
+	if v6 {
+		udp.SrcPort, udp.DstPort = 546, 547
+		ip6 := &layers.IPv6{Version: 6, HopLimit: 1, NextHeader: layers.IPProtocolUDP, SrcIP: net.ParseIP("fe80::1"), DstIP: AllDHCPRelayAgentsAndServers}
+		udp.SetNetworkLayerForChecksum(ip6)
+		eth.EthernetType, ip = layers.EthernetTypeIPv6, ip6
+	} else {
+		ip4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IPv4zero, DstIP: net.IPv4bcast}
+		udp.SetNetworkLayerForChecksum(ip4)
+		eth.EthernetType, ip = layers.EthernetTypeIPv4, ip4
+	}
+	if fromServer {
+		udp.SrcPort, udp.DstPort = udp.DstPort, udp.SrcPort
+	}
+	frame, err := serialize(eth, ip, udp, packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func TestReadCapture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	frames := [][]byte{{1, 2, 3}, {4, 5, 6, 7, 8}, make([]byte, 342)}
+	for _, name := range []string{"out.pcap", "out.pcapng"} {
+		path := filepath.Join(dir, name)
+		c, err := NewCapture(path, 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := time.Now()
+		for i, frame := range frames {
+			if i%2 == 0 {
+				c.sent(frame)
+			} else {
+				c.received(frame)
+			}
+		}
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+		//a capture which was killed ends with a record cut short
+		f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+		f.Write([]byte{6, 0, 0, 0, 64})
+		f.Close()
+
+		read, err := ReadCapture(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(read) != len(frames) {
+			t.Fatalf("%s: %d frames read, want %d", name, len(read), len(frames))
+		}
+		for i, f := range read {
+			if !bytes.Equal(f.Data, frames[i]) || f.LinkType != linkTypeEthernet {
+				t.Errorf("%s: frame %d is %x of link type %d", name, i, f.Data, f.LinkType)
+			}
+			if f.At.Before(before.Truncate(time.Microsecond)) || f.At.After(time.Now()) {
+				t.Errorf("%s: frame %d at %s", name, i, f.At)
+			}
+		}
+	}
+
+	//a big-endian pcap with nanoseconds of raw ip frames
+	data := make([]byte, 24+16+4)
+	binary.BigEndian.PutUint32(data, pcapMagicNano)
+	binary.BigEndian.PutUint32(data[20:], uint32(layers.LinkTypeRaw))
+	binary.BigEndian.PutUint32(data[24:], 1500000000)
+	binary.BigEndian.PutUint32(data[28:], 123)
+	binary.BigEndian.PutUint32(data[32:], 4)
+	binary.BigEndian.PutUint32(data[36:], 4)
+	path := filepath.Join(dir, "big.pcap")
+	ioutil.WriteFile(path, data, 0644)
+	read, err := ReadCapture(path)
+	if err != nil || len(read) != 1 || read[0].At.UnixNano() != 1500000000*1e9+123 {
+		t.Errorf("the big-endian capture reads %v: %v", read, err)
+	}
+
+	ioutil.WriteFile(path, make([]byte, 64), 0644)
+	if _, err := ReadCapture(path); err == nil {
+		t.Error("a file of zeros is read")
+	}
+}
+
+func TestClientMessages(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	discover := NewDiscover(mac)
+	WithTransactionID(1)(discover)
+	WithOption(layers.DHCPOptClassID, []byte("vendor"))(discover)
+	offer := NewPacket(layers.NewDHCPOption(layers.DHCPOptServerID, []byte{10, 0, 0, 1}))
+	WithReply(discover)(offer)
+	WithMessageType(layers.DHCPMsgTypeOffer)(offer)
+	request := NewRequestFromOffer(offer)
+	renew := NewRenewFromLease(net.HardwareAddr{0x02, 0, 0, 0, 0, 2}, Lease{FixedAddress: net.IPv4(10, 0, 0, 5)})
+	WithTransactionID(2)(renew)
+	release := NewReleaseFromLease(mac, Lease{FixedAddress: net.IPv4(10, 0, 0, 6), ServerID: net.IPv4(10, 0, 0, 1)})
+	WithTransactionID(3)(release)
+	solicit := NewSolicit(NewDUID(layers.DHCPv6DUIDTypeLL, mac, time.Time{}))
+	WithTransactionID6(4)(solicit)
+	advertise := NewPacket6(layers.DHCPv6MsgTypeAdverstise, solicit.Options...)
+	copy(advertise.TransactionID, solicit.TransactionID)
+	request6 := NewRequestFromAdvertise(advertise)
+
+	start := time.Unix(1000, 0)
+	var frames []CapturedFrame
+	for i, f := range []struct {
+		packet     gopacket.SerializableLayer
+		v6         bool
+		fromServer bool
+	}{
+		{discover, false, false},
+		{discover, false, false},
+		{offer, false, true},
+		{request, false, false},
+		{renew, false, false},
+		{release, false, false},
+		{solicit, true, false},
+		{advertise, true, true},
+		{request6, true, false},
+	} {
+		frames = append(frames, CapturedFrame{At: start.Add(time.Duration(i) * time.Second), LinkType: linkTypeEthernet, Data: clientFrame(t, f.packet, f.v6, f.fromServer)})
+	}
+	frames = append(frames, CapturedFrame{At: start, LinkType: 9999, Data: []byte{1, 2, 3}})
+
+	v4, v6 := ClientMessages(frames)
+	if len(v4) != 3 || len(v6) != 1 {
+		t.Fatalf("%d dhcpv4 and %d dhcpv6 messages, want 3 and 1", len(v4), len(v6))
+	}
+	if v4[0].Packet.MessageType() != layers.DHCPMsgTypeDiscover || v4[0].Request == nil || v4[0].Request.MessageType() != layers.DHCPMsgTypeRequest {
+		t.Errorf("the discover goes with %v", v4[0].Request)
+	}
+	if v4[1].Packet.Xid != 2 || v4[1].Request != nil || v4[1].At != 4*time.Second {
+		t.Errorf("the renew is %v at %s", v4[1].Packet, v4[1].At)
+	}
+	if v4[2].Packet.MessageType() != layers.DHCPMsgTypeRelease {
+		t.Errorf("the last message is a %s", v4[2].Packet.MessageType())
+	}
+	if v6[0].Packet.MsgType != layers.DHCPv6MsgTypeSolicit || v6[0].Request == nil || v6[0].At != 6*time.Second {
+		t.Errorf("the solicit goes with %v at %s", v6[0].Request, v6[0].At)
+	}
+}
+
+func TestReplayRequest(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	request := NewRebootRequest(mac, net.IPv4(192, 168, 1, 50))
+	WithOption(layers.DHCPOptServerID, []byte{192, 168, 1, 1})(request)
+	WithOption(layers.DHCPOptClassID, []byte("vendor"))(request)
+	WithOption(layers.DHCPOptClientID, append([]byte{1}, mac...))(request)
+
+	moved := net.HardwareAddr{0x02, 0, 0, 0, 0, 9}
+	copied := clone(request)
+	WithClientMAC(moved)(copied)
+	if copied.ClientHWAddr.String() != moved.String() || request.ClientHWAddr.String() != mac.String() {
+		t.Fatalf("the mac of the copy is %s, of the request %s", copied.ClientHWAddr, request.ClientHWAddr)
+	}
+
+	offer := replyFrom(layers.DHCPMsgTypeOffer, net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 100))
+	offer.Xid = 0x42
+	pr := NewPacketResponse()
+	pr.selecting = copied
+	answer := pr.requestFor(offer)
+	if answer.Xid != 0x42 {
+		t.Errorf("xid %x, want the one of the offer", answer.Xid)
+	}
+	found := make(map[layers.DHCPOpt]int)
+	for _, option := range answer.Options {
+		found[option.Type]++
+		switch option.Type {
+		case layers.DHCPOptRequestIP:
+			if !net.IP(option.Data).Equal(net.IPv4(10, 0, 0, 100)) {
+				t.Errorf("requested ip %v", net.IP(option.Data))
+			}
+		case layers.DHCPOptServerID:
+			if !net.IP(option.Data).Equal(net.IPv4(10, 0, 0, 1)) {
+				t.Errorf("server id %v", net.IP(option.Data))
+			}
+		case layers.DHCPOptClientID:
+			if !bytes.Equal(option.Data, append([]byte{1}, moved...)) {
+				t.Errorf("client id %x does not follow the mac", option.Data)
+			}
+		}
+	}
+	if found[layers.DHCPOptRequestIP] != 1 || found[layers.DHCPOptServerID] != 1 || found[layers.DHCPOptClassID] != 1 {
+		t.Errorf("options %v", answer.Options)
+	}
+
+	solicit := NewSolicit(NewDUID(layers.DHCPv6DUIDTypeLLT, mac, time.Now()))
+	WithClientMAC6(moved)(solicit)
+	lease := NewLease6(solicit)
+	var duid layers.DHCPv6DUID
+	if err := duid.DecodeFromBytes(lease.ClientID); err != nil || duid.LinkLayerAddress.String() != moved.String() || duid.Type != layers.DHCPv6DUIDTypeLLT {
+		t.Errorf("the client id is %s: %v", &duid, err)
+	}
+}
+
+func TestReplayRenew(t *testing.T) {
+	dc := leaseClient()
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	address := net.IPv4(10, 0, 0, 5)
+	renew := NewRenewFromLease(mac, Lease{FixedAddress: address})
+	if _, err := dc.Replay(ClientMessage{Packet: renew}, WithTransactionID(1)); err != nil {
+		t.Fatal(err)
+	}
+	sent := <-dc.sendQueue
+	if !sent.ClientIP.Equal(net.IPv4zero) || !requestedIP(sent).Equal(address) {
+		t.Errorf("the renew is sent from ciaddr %s for %v", sent.ClientIP, requestedIP(sent))
+	}
+	if sent.Flags&uint16(layers.BroadcastFlag) == 0 {
+		t.Error("the renew is sent without the broadcast flag")
+	}
+	if !renew.ClientIP.Equal(address) {
+		t.Error("the message of the capture is changed")
+	}
+
+	inform := NewInform(mac, address)
+	if _, err := dc.Replay(ClientMessage{Packet: inform}, WithTransactionID(2)); err != nil {
+		t.Fatal(err)
+	}
+	if sent := <-dc.sendQueue; !sent.ClientIP.Equal(address) {
+		t.Errorf("the inform is sent from ciaddr %s", sent.ClientIP)
+	}
+}
+
+func requestedIP(packet *layers.DHCPv4) net.IP {
+	for _, option := range packet.Options {
+		if option.Type == layers.DHCPOptRequestIP {
+			return net.IP(option.Data)
+		}
+	}
+	return nil
+}
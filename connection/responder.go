@@ -0,0 +1,202 @@
+package connection
+
+import (
+	"bytes"
+	"dhcptest/layers"
+	"fmt"
+	"github.com/google/gopacket"
+	"net"
+	"sync"
+	"time"
+)
+
+// responder holds the addresses leased to the simulated clients, keyed by mac,
+// so that the arp requests and the icmp echo requests for them get answered
+// the way a real client would answer them
+type responder struct {
+	lock   sync.Mutex
+	addrs  map[string]net.IP
+	owners map[string]net.HardwareAddr
+}
+
+func newResponder() *responder {
+	return &responder{
+		addrs:  make(map[string]net.IP),
+		owners: make(map[string]net.HardwareAddr),
+	}
+}
+
+// add answers for the address of the ack from now on, in place of the
+// address the client held before if any
+func (r *responder) add(ack *layers.DHCPv4) {
+	r.claim(ack.ClientHWAddr, ack.YourClientIP)
+}
+
+// claim answers for the address on behalf of the client, such as the ciaddr
+// of an inform, in place of the address the client held before if any
+func (r *responder) claim(mac net.HardwareAddr, ip net.IP) {
+	ip = ip.To4()
+	if r == nil || ip == nil || ip.Equal(net.IPv4zero) {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.drop(mac)
+	r.addrs[mac.String()] = ip
+	r.owners[ip.String()] = mac
+}
+
+// remove stops answering for the address of the client
+func (r *responder) remove(mac net.HardwareAddr) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	r.drop(mac)
+	r.lock.Unlock()
+}
+
+func (r *responder) drop(mac net.HardwareAddr) {
+	if ip, ok := r.addrs[mac.String()]; ok {
+		delete(r.addrs, mac.String())
+		delete(r.owners, ip.String())
+	}
+}
+
+// owner returns the mac of the client the ip is leased to
+func (r *responder) owner(ip net.IP) net.HardwareAddr {
+	if r == nil {
+		return nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.owners[ip.String()]
+}
+
+// respondLoop answers the arp who-has and the icmp echo requests for the
+// leased addresses
+func (dc *DhcpClient) respondLoop() {
+	defer dc.wg.Done()
+	for {
+		select {
+		case <-dc.stop:
+			return
+		default:
+			recvBuf := make([]byte, MAXUDPReceivedPacketSize)
+			dc.respond.SetReadDeadline(time.Now().Add(DefaultReadTimeout))
+			n, _, err := dc.respond.ReadFrom(recvBuf)
+			if err != nil {
+				if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+					dc.addMessage(err)
+				}
+				continue
+			}
+			frame, err := dc.answer(recvBuf[:n])
+			if err != nil {
+				dc.addMessage(err)
+				continue
+			}
+			if frame == nil {
+				continue
+			}
+			if err := dc.sendFrame(frame); err != nil {
+				dc.addMessage(err)
+			}
+		}
+	}
+}
+
+// answer returns the reply to the frame, or nil when the frame doesn't ask
+// for a leased address
+func (dc *DhcpClient) answer(data []byte) ([]byte, error) {
+	packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+	if arpLayer := packet.Layer(layers.LayerTypeARP); arpLayer != nil {
+		arp := arpLayer.(*layers.ARP)
+		if arp.Operation != layers.ARPRequest || len(arp.DstProtAddress) != 4 {
+			return nil, nil
+		}
+		mac := dc.responder.owner(net.IP(arp.DstProtAddress))
+		if mac == nil || bytes.Equal(mac, arp.SourceHwAddress) || bytes.Equal(dc.Iface.HardwareAddr, arp.SourceHwAddress) {
+			return nil, nil
+		}
+		if dc.ifLog {
+			dc.addMessage(fmt.Sprintf("%s answers arp who-has %s from %s", mac, net.IP(arp.DstProtAddress), net.IP(arp.SourceProtAddress)))
+		}
+		return newARPReply(mac, arp)
+	}
+
+	ipLayer, icmpLayer := packet.Layer(layers.LayerTypeIPv4), packet.Layer(layers.LayerTypeICMPv4)
+	if ipLayer == nil || icmpLayer == nil {
+		return nil, nil
+	}
+	ip, icmp := ipLayer.(*layers.IPv4), icmpLayer.(*layers.ICMPv4)
+	if icmp.TypeCode.Type() != layers.ICMPv4TypeEchoRequest {
+		return nil, nil
+	}
+	mac := dc.responder.owner(ip.DstIP)
+	if mac == nil {
+		return nil, nil
+	}
+	eth := packet.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	if dc.ifLog {
+		dc.addMessage(fmt.Sprintf("%s answers icmp echo to %s from %s", mac, ip.DstIP, ip.SrcIP))
+	}
+	return newEchoReply(mac, eth.SrcMAC, ip, icmp)
+}
+
+// newARPReply builds the reply of the mac to an arp request
+func newARPReply(mac net.HardwareAddr, request *layers.ARP) ([]byte, error) {
+	eth := layers.Ethernet{
+		EthernetType: layers.EthernetTypeARP,
+		SrcMAC:       mac,
+		DstMAC:       net.HardwareAddr(request.SourceHwAddress),
+	}
+	arp := layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         layers.ARPReply,
+		SourceHwAddress:   mac,
+		SourceProtAddress: request.DstProtAddress,
+		DstHwAddress:      request.SourceHwAddress,
+		DstProtAddress:    request.SourceProtAddress,
+	}
+	return serialize(&eth, &arp)
+}
+
+// newEchoReply builds the reply of the mac to an icmp echo request, the
+// identifier, the sequence number and the payload are echoed back
+func newEchoReply(mac net.HardwareAddr, dstMac net.HardwareAddr, request *layers.IPv4, echo *layers.ICMPv4) ([]byte, error) {
+	eth := layers.Ethernet{
+		EthernetType: layers.EthernetTypeIPv4,
+		SrcMAC:       mac,
+		DstMAC:       dstMac,
+	}
+	ip := layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolICMPv4,
+		SrcIP:    request.DstIP,
+		DstIP:    request.SrcIP,
+	}
+	icmp := layers.ICMPv4{
+		TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoReply, 0),
+		Id:       echo.Id,
+		Seq:      echo.Seq,
+	}
+	return serialize(&eth, &ip, &icmp, gopacket.Payload(echo.Payload))
+}
+
+// serialize builds a frame of the layers with the lengths and checksums filled in
+func serialize(frame ...gopacket.SerializableLayer) ([]byte, error) {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{
+		FixLengths:       true,
+		ComputeChecksums: true,
+	}
+	if err := gopacket.SerializeLayers(buf, opts, frame...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
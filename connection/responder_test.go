@@ -0,0 +1,129 @@
+package connection
+
+import (
+	"bytes"
+	"dhcptest/layers"
+	"github.com/google/gopacket"
+	"net"
+	"testing"
+)
+
+func TestResponderAnswer(t *testing.T) {
+	ifaceMac := net.HardwareAddr{0x02, 0, 0, 0, 0, 0xaa}
+	clientMac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	serverMac := net.HardwareAddr{0x02, 0, 0, 0, 0, 0xfe}
+	leased, unknown, server := net.IPv4(10, 0, 0, 5).To4(), net.IPv4(10, 0, 0, 6).To4(), net.IPv4(10, 0, 0, 1).To4()
+	dc := &DhcpClient{Iface: &net.Interface{HardwareAddr: ifaceMac}, responder: newResponder()}
+	ack := replyFrom(layers.DHCPMsgTypeAck, server, leased)
+	ack.ClientHWAddr = clientMac
+	dc.responder.add(ack)
+
+	whoHas := func(ip net.IP, from net.HardwareAddr) []byte {
+		eth := layers.Ethernet{EthernetType: layers.EthernetTypeARP, SrcMAC: from, DstMAC: layers.EthernetBroadcast}
+		arp := layers.ARP{
+			AddrType:          layers.LinkTypeEthernet,
+			Protocol:          layers.EthernetTypeIPv4,
+			HwAddressSize:     6,
+			ProtAddressSize:   4,
+			Operation:         layers.ARPRequest,
+			SourceHwAddress:   from,
+			SourceProtAddress: server,
+			DstHwAddress:      make([]byte, 6),
+			DstProtAddress:    ip,
+		}
+		frame, err := serialize(&eth, &arp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return frame
+	}
+
+	frame, err := dc.answer(whoHas(leased, serverMac))
+	if err != nil || frame == nil {
+		t.Fatalf("who-has %s: %x, %v", leased, frame, err)
+	}
+	packet := gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.Default)
+	eth := packet.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	arp, ok := packet.Layer(layers.LayerTypeARP).(*layers.ARP)
+	if !ok || arp.Operation != layers.ARPReply {
+		t.Fatalf("the answer is no arp reply: %v", packet)
+	}
+	if eth.SrcMAC.String() != clientMac.String() || eth.DstMAC.String() != serverMac.String() {
+		t.Errorf("the reply goes from %s to %s", eth.SrcMAC, eth.DstMAC)
+	}
+	if !bytes.Equal(arp.SourceHwAddress, clientMac) || !net.IP(arp.SourceProtAddress).Equal(leased) ||
+		!bytes.Equal(arp.DstHwAddress, serverMac) || !net.IP(arp.DstProtAddress).Equal(server) {
+		t.Errorf("%s is at %s, told to %s at %s", net.IP(arp.SourceProtAddress), net.HardwareAddr(arp.SourceHwAddress),
+			net.IP(arp.DstProtAddress), net.HardwareAddr(arp.DstHwAddress))
+	}
+
+	for _, c := range []struct {
+		name  string
+		frame []byte
+	}{
+		{"who-has an unknown address", whoHas(unknown, serverMac)},
+		{"who-has from the owner", whoHas(leased, clientMac)},
+		{"who-has from the interface", whoHas(leased, ifaceMac)},
+	} {
+		if frame, err := dc.answer(c.frame); frame != nil || err != nil {
+			t.Errorf("%s is answered: %x, %v", c.name, frame, err)
+		}
+	}
+
+	ping := func(ip net.IP, typeCode layers.ICMPv4TypeCode) []byte {
+		eth := layers.Ethernet{EthernetType: layers.EthernetTypeIPv4, SrcMAC: serverMac, DstMAC: clientMac}
+		ipv4 := layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolICMPv4, SrcIP: server, DstIP: ip}
+		icmp := layers.ICMPv4{TypeCode: typeCode, Id: 0x1234, Seq: 7}
+		frame, err := serialize(&eth, &ipv4, &icmp, gopacket.Payload("ping-check"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return frame
+	}
+	echoRequest := layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0)
+
+	frame, err = dc.answer(ping(leased, echoRequest))
+	if err != nil || frame == nil {
+		t.Fatalf("echo to %s: %x, %v", leased, frame, err)
+	}
+	packet = gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.Default)
+	eth = packet.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	ip := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	icmp, ok := packet.Layer(layers.LayerTypeICMPv4).(*layers.ICMPv4)
+	if !ok || icmp.TypeCode.Type() != layers.ICMPv4TypeEchoReply {
+		t.Fatalf("the answer is no echo reply: %v", packet)
+	}
+	if eth.SrcMAC.String() != clientMac.String() || eth.DstMAC.String() != serverMac.String() ||
+		!ip.SrcIP.Equal(leased) || !ip.DstIP.Equal(server) {
+		t.Errorf("the echo reply goes from %s %s to %s %s", eth.SrcMAC, ip.SrcIP, eth.DstMAC, ip.DstIP)
+	}
+	if icmp.Id != 0x1234 || icmp.Seq != 7 || string(icmp.Payload) != "ping-check" {
+		t.Errorf("id %x, seq %d and payload %q are not echoed", icmp.Id, icmp.Seq, icmp.Payload)
+	}
+
+	for _, c := range []struct {
+		name  string
+		frame []byte
+	}{
+		{"echo to an unknown address", ping(unknown, echoRequest)},
+		{"echo reply", ping(leased, layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoReply, 0))},
+	} {
+		if frame, err := dc.answer(c.frame); frame != nil || err != nil {
+			t.Errorf("%s is answered: %x, %v", c.name, frame, err)
+		}
+	}
+
+	//the address of the client moves with its next ack
+	ack.YourClientIP = unknown
+	dc.responder.add(ack)
+	if frame, _ := dc.answer(whoHas(leased, serverMac)); frame != nil {
+		t.Error("the former address is still answered")
+	}
+	if frame, _ := dc.answer(whoHas(unknown, serverMac)); frame == nil {
+		t.Error("the new address is not answered")
+	}
+	dc.responder.remove(clientMac)
+	if frame, _ := dc.answer(whoHas(unknown, serverMac)); frame != nil {
+		t.Error("the address of a removed client is answered")
+	}
+}
@@ -0,0 +1,142 @@
+package connection
+
+import (
+	"dhcptest/layers"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SeenServer is a dhcp server which sent offers, told apart by its server id
+// and the mac its frames come from
+type SeenServer struct {
+	ServerID net.IP
+	MAC      net.HardwareAddr
+	// Subnet is the subnet of the offered addresses, nil when the offers
+	// carry no subnet mask
+	Subnet    *net.IPNet
+	Offers    int
+	FirstSeen time.Time
+	LastSeen  time.Time
+	// Allowed tells whether the server id or the mac is on the allowlist
+	Allowed bool
+}
+
+func (s SeenServer) String() string {
+	subnet := "unknown subnet"
+	if s.Subnet != nil {
+		subnet = s.Subnet.String()
+	}
+	verdict := "ROGUE"
+	if s.Allowed {
+		verdict = "allowed"
+	}
+	return fmt.Sprintf("%s server %s from %s offering %s, %d offers, first seen %s",
+		verdict, s.ServerID, s.MAC, subnet, s.Offers, s.FirstSeen.Format(time.RFC3339))
+}
+
+// ServerWatch records every server the client receives offers from, the
+// offers to the other clients included, and checks them against an allowlist
+type ServerWatch struct {
+	lock    sync.Mutex
+	ids     []net.IP
+	macs    []net.HardwareAddr
+	servers map[string]*SeenServer
+	// OnNew is called out of the lock with every server seen for the first time
+	OnNew func(SeenServer)
+}
+
+// NewServerWatch returns a watch allowing the server ids and the macs of the
+// allowlist, an empty allowlist flags every server
+func NewServerWatch(allowlist []string) (*ServerWatch, error) {
+	w := &ServerWatch{servers: make(map[string]*SeenServer)}
+	for _, entry := range allowlist {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		if ip := net.ParseIP(entry).To4(); ip != nil {
+			w.ids = append(w.ids, ip)
+		} else if mac, err := net.ParseMAC(entry); err == nil {
+			w.macs = append(w.macs, mac)
+		} else {
+			return nil, fmt.Errorf("invalid allowed server %q, want an ipv4 server id or a mac", entry)
+		}
+	}
+	return w, nil
+}
+
+// allowed tells whether the server id or the mac is on the allowlist
+func (w *ServerWatch) allowed(id net.IP, mac net.HardwareAddr) bool {
+	for _, allowed := range w.ids {
+		if allowed.Equal(id) {
+			return true
+		}
+	}
+	for _, allowed := range w.macs {
+		if allowed.String() == mac.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// observe records the server of an offer
+func (w *ServerWatch) observe(packet *layers.DHCPv4, serverMac net.HardwareAddr) {
+	if w == nil || packet.Operation != layers.DHCPOpReply || packet.MessageType() != layers.DHCPMsgTypeOffer {
+		return
+	}
+	_, lease := NewLease(packet)
+	now := time.Now()
+	key := fmt.Sprintf("%s/%s", lease.ServerID, serverMac)
+	w.lock.Lock()
+	s, ok := w.servers[key]
+	if !ok {
+		s = &SeenServer{ServerID: lease.ServerID, MAC: serverMac, FirstSeen: now, Allowed: w.allowed(lease.ServerID, serverMac)}
+		w.servers[key] = s
+	}
+	if s.Subnet == nil && lease.FixedAddress != nil && lease.Netmask != nil {
+		s.Subnet = &net.IPNet{IP: lease.FixedAddress.Mask(lease.Netmask), Mask: lease.Netmask}
+	}
+	s.Offers++
+	s.LastSeen = now
+	seen := *s
+	w.lock.Unlock()
+	if !ok && w.OnNew != nil {
+		w.OnNew(seen)
+	}
+}
+
+// Servers returns the servers seen so far, the rogue ones first
+func (w *ServerWatch) Servers() []SeenServer {
+	if w == nil {
+		return nil
+	}
+	w.lock.Lock()
+	servers := make([]SeenServer, 0, len(w.servers))
+	for _, s := range w.servers {
+		servers = append(servers, *s)
+	}
+	w.lock.Unlock()
+	sort.Slice(servers, func(i, j int) bool {
+		if servers[i].Allowed != servers[j].Allowed {
+			return !servers[i].Allowed
+		}
+		return servers[i].FirstSeen.Before(servers[j].FirstSeen)
+	})
+	return servers
+}
+
+// Rogues returns the number of servers seen which are not on the allowlist
+func (w *ServerWatch) Rogues() int {
+	rogues := 0
+	for _, s := range w.Servers() {
+		if !s.Allowed {
+			rogues++
+		}
+	}
+	return rogues
+}
@@ -0,0 +1,67 @@
+package connection
+
+import (
+	"dhcptest/layers"
+	"net"
+	"testing"
+)
+
+// replyFrom builds a reply of the server id offering yiaddr
+func replyFrom(msgType layers.DHCPMsgType, serverID net.IP, yiaddr net.IP) *layers.DHCPv4 {
+	discover := NewDiscover(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	reply := NewPacket(
+		layers.NewDHCPOption(layers.DHCPOptServerID, serverID.To4()),
+		layers.NewDHCPOption(layers.DHCPOptSubnetMask, []byte{255, 255, 255, 0}),
+	)
+	WithReply(discover)(reply)
+	reply.YourClientIP = yiaddr
+	WithMessageType(msgType)(reply)
+	return reply
+}
+
+func TestServerWatch(t *testing.T) {
+	if _, err := NewServerWatch([]string{"10.0.0.1", "not a server"}); err == nil {
+		t.Error("an invalid allowlist entry is accepted")
+	}
+	var none *ServerWatch
+	none.observe(replyFrom(layers.DHCPMsgTypeOffer, net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 100)), nil)
+	if none.Servers() != nil || none.Rogues() != 0 {
+		t.Error("a nil watch records servers")
+	}
+
+	w, err := NewServerWatch([]string{" 10.0.0.1", "02:00:00:00:00:fe", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var news []SeenServer
+	w.OnNew = func(s SeenServer) { news = append(news, s) }
+	legit := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x10}
+	byMac := net.HardwareAddr{0x02, 0, 0, 0, 0, 0xfe}
+	rogue := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x66}
+	w.observe(replyFrom(layers.DHCPMsgTypeOffer, net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 100)), legit)
+	w.observe(replyFrom(layers.DHCPMsgTypeOffer, net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 101)), legit)
+	w.observe(replyFrom(layers.DHCPMsgTypeOffer, net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 102)), byMac)
+	w.observe(replyFrom(layers.DHCPMsgTypeOffer, net.IPv4(192, 168, 1, 1), net.IPv4(192, 168, 1, 50)), rogue)
+
+	w.observe(replyFrom(layers.DHCPMsgTypeAck, net.IPv4(172, 16, 0, 1), net.IPv4(172, 16, 0, 9)), rogue)
+
+	if len(news) != 3 {
+		t.Fatalf("%d new servers, want 3", len(news))
+	}
+	servers := w.Servers()
+	if len(servers) != 3 || w.Rogues() != 1 {
+		t.Fatalf("%d servers and %d rogues, want 3 and 1: %v", len(servers), w.Rogues(), servers)
+	}
+	if s := servers[0]; s.Allowed || !s.ServerID.Equal(net.IPv4(192, 168, 1, 1)) ||
+		s.MAC.String() != rogue.String() || s.Subnet.String() != "192.168.1.0/24" {
+		t.Errorf("the rogue is %s", s)
+	}
+	for _, s := range servers[1:] {
+		if !s.Allowed {
+			t.Errorf("%s is not allowed", s)
+		}
+		if s.ServerID.Equal(net.IPv4(10, 0, 0, 1)) && s.Offers != 2 {
+			t.Errorf("%d offers from %s, want 2", s.Offers, s.ServerID)
+		}
+	}
+}
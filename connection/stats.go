@@ -0,0 +1,224 @@
+package connection
+
+import (
+	"dhcptest/utility"
+	"fmt"
+	"github.com/pinterest/bender/hist"
+	"sync"
+	"time"
+)
+
+const (
+	// histogramScale is the resolution of the latency histograms
+	histogramScale = 100 * time.Microsecond
+)
+
+// Latency holds the percentiles of one latency histogram
+type Latency struct {
+	P50  time.Duration
+	P90  time.Duration
+	P99  time.Duration
+	P999 time.Duration
+	Max  time.Duration
+}
+
+func (l Latency) String() string {
+	return fmt.Sprintf("p50=%s p90=%s p99=%s p999=%s max=%s", l.P50, l.P90, l.P99, l.P999, l.Max)
+}
+
+// Summary is a snapshot of the statistics of a time window
+type Summary struct {
+	Elapsed       time.Duration
+	Discovers     int
+	Offers        int
+	OfferTimeouts int
+	Requests      int
+	Acks          int
+	Naks          int
+	AckTimeouts   int
+	Errors        int
+	Conflicts     int //acked addresses found in use by the arp probes
+	Malformed     int //received packets which failed to decode
+	Offer         Latency //discover -> offer
+	Ack           Latency //request -> ack
+}
+
+// Sent returns the number of discover and request packets sent
+func (s Summary) Sent() int {
+	return s.Discovers + s.Requests
+}
+
+// ErrorPercent returns the percentage of sent packets which got a send error
+func (s Summary) ErrorPercent() float64 {
+	return percent(s.Errors, s.Sent())
+}
+
+// TimeoutPercent returns the percentage of sent packets which got no reply in time
+func (s Summary) TimeoutPercent() float64 {
+	return percent(s.OfferTimeouts+s.AckTimeouts, s.Sent())
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("during: %.2fs, discover: %d, offer: %d, request: %d, ack: %d, nak: %d, conflicts: %d, malformed: %d, errors: %.2f%%, timeouts: %.2f%%\n"+
+		"  discover->offer %s\n"+
+		"  request->ack    %s",
+		s.Elapsed.Seconds(), s.Discovers, s.Offers, s.Requests, s.Acks, s.Naks, s.Conflicts, s.Malformed, s.ErrorPercent(), s.TimeoutPercent(),
+		s.Offer, s.Ack)
+}
+
+func percent(part int, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return float64(part) / float64(whole) * 100.0
+}
+
+// window collects the counters and latencies from its start on
+type window struct {
+	start   time.Time
+	summary Summary
+	offer   *hist.Histogram
+	ack     *hist.Histogram
+}
+
+func newWindow() *window {
+	buckets := int(utility.Timeout / histogramScale)
+	return &window{
+		start: time.Now(),
+		offer: hist.NewHistogram(buckets, int(histogramScale)),
+		ack:   hist.NewHistogram(buckets, int(histogramScale)),
+	}
+}
+
+func (w *window) snapshot() Summary {
+	summary := w.summary
+	summary.Elapsed = time.Since(w.start)
+	summary.Offer = latency(w.offer)
+	summary.Ack = latency(w.ack)
+	return summary
+}
+
+func latency(h *hist.Histogram) Latency {
+	ps := h.Percentiles(0.5, 0.9, 0.99, 0.999, 1.0)
+	return Latency{
+		P50:  time.Duration(ps[0]) * histogramScale,
+		P90:  time.Duration(ps[1]) * histogramScale,
+		P99:  time.Duration(ps[2]) * histogramScale,
+		P999: time.Duration(ps[3]) * histogramScale,
+		Max:  time.Duration(ps[4]) * histogramScale,
+	}
+}
+
+// Statistics records the result of every transaction of a DhcpClient, both
+// for the whole run and for the current report interval, the results are
+// forwarded to the metrics when they are given
+type Statistics struct {
+	lock     sync.Mutex
+	total    *window
+	interval *window
+	metrics  *Metrics
+}
+
+func NewStatistics(metrics *Metrics) *Statistics {
+	return &Statistics{total: newWindow(), interval: newWindow(), metrics: metrics}
+}
+
+// observer returns the metrics the results are forwarded to
+func (s *Statistics) observer() *Metrics {
+	if s == nil {
+		return nil
+	}
+	return s.metrics
+}
+
+func (s *Statistics) record(f func(w *window)) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	f(s.total)
+	f(s.interval)
+	s.lock.Unlock()
+}
+
+func (s *Statistics) discoverSent() {
+	s.record(func(w *window) { w.summary.Discovers++ })
+}
+
+func (s *Statistics) requestSent() {
+	s.record(func(w *window) { w.summary.Requests++ })
+}
+
+func (s *Statistics) sendError() {
+	s.record(func(w *window) { w.summary.Errors++ })
+	s.observer().sendError()
+}
+
+// BuildFailed counts a packet which couldn't be built, such as one whose
+// --option templates don't expand for its device, as a send error
+func (s *Statistics) BuildFailed() {
+	s.sendError()
+}
+
+func (s *Statistics) offerReceived(latency time.Duration) {
+	s.record(func(w *window) {
+		w.summary.Offers++
+		w.offer.Add(int(latency))
+	})
+	s.observer().offerReceived(latency)
+}
+
+func (s *Statistics) ackReceived(latency time.Duration) {
+	s.record(func(w *window) {
+		w.summary.Acks++
+		w.ack.Add(int(latency))
+	})
+	s.observer().ackReceived(latency)
+}
+
+func (s *Statistics) nakReceived() {
+	s.record(func(w *window) { w.summary.Naks++ })
+	s.observer().nakReceived()
+}
+
+func (s *Statistics) offerTimeout() {
+	s.record(func(w *window) { w.summary.OfferTimeouts++ })
+	s.observer().offerTimeout()
+}
+
+func (s *Statistics) ackTimeout() {
+	s.record(func(w *window) { w.summary.AckTimeouts++ })
+	s.observer().ackTimeout()
+}
+
+func (s *Statistics) conflict() {
+	s.record(func(w *window) { w.summary.Conflicts++ })
+	s.observer().conflict()
+}
+
+func (s *Statistics) malformedReceived() {
+	s.record(func(w *window) { w.summary.Malformed++ })
+	s.observer().malformedReceived()
+}
+
+// Interval returns the summary since the last call and starts a new interval
+func (s *Statistics) Interval() Summary {
+	if s == nil {
+		return Summary{}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	summary := s.interval.snapshot()
+	s.interval = newWindow()
+	return summary
+}
+
+// Total returns the summary of the whole run
+func (s *Statistics) Total() Summary {
+	if s == nil {
+		return Summary{}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.total.snapshot()
+}
@@ -0,0 +1,211 @@
+package connection
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LeaseExpired is the state of a stored lease past its expiry
+const LeaseExpired = "expired"
+
+// StoredLease is the lease of one simulated client as it is saved in the lease file
+type StoredLease struct {
+	MAC       string    `json:"mac"`
+	Address   net.IP    `json:"address"`
+	ServerID  net.IP    `json:"server_id"`
+	ServerMAC string    `json:"server_mac,omitempty"`
+	Bound     time.Time `json:"bound"`
+	Renew     time.Time `json:"renew"`
+	Rebind    time.Time `json:"rebind"`
+	// Expire is zero for an infinite lease
+	Expire    time.Time `json:"expire"`
+}
+
+func newStoredLease(mac net.HardwareAddr, lease Lease, serverMac net.HardwareAddr) StoredLease {
+	stored := StoredLease{
+		MAC:      mac.String(),
+		Address:  lease.FixedAddress.To4(),
+		ServerID: lease.ServerID,
+		Bound:    lease.Bound,
+		Renew:    lease.Renew,
+		Rebind:   lease.Rebind,
+		Expire:   lease.Expire,
+	}
+	if serverMac != nil {
+		stored.ServerMAC = serverMac.String()
+	}
+	return stored
+}
+
+// State tells where the lease is at the given time: bound, renewing, rebinding or expired
+func (l StoredLease) State(now time.Time) string {
+	switch {
+	case l.Expire.IsZero() || now.Before(l.Renew):
+		return bound.String()
+	case now.Before(l.Rebind):
+		return renewing.String()
+	case now.Before(l.Expire):
+		return rebinding.String()
+	default:
+		return LeaseExpired
+	}
+}
+
+// Lease returns the lease the packets of the client are built from
+func (l StoredLease) Lease() Lease {
+	return Lease{
+		ServerID:     l.ServerID,
+		FixedAddress: l.Address,
+		Bound:        l.Bound,
+		Renew:        l.Renew,
+		Rebind:       l.Rebind,
+		Expire:       l.Expire,
+	}
+}
+
+// HardwareAddrs returns the mac of the client and the mac of the server
+// which acked the lease, nil when it is unknown
+func (l StoredLease) HardwareAddrs() (mac net.HardwareAddr, serverMac net.HardwareAddr) {
+	mac, _ = net.ParseMAC(l.MAC)
+	serverMac, _ = net.ParseMAC(l.ServerMAC)
+	return mac, serverMac
+}
+
+// LeaseStore holds the last lease acked to every simulated client, keyed by
+// mac, so that the clients can reboot or renew with the same identities in a
+// later run. The leases are saved to the file of the store, when it has one
+type LeaseStore struct {
+	lock   sync.Mutex
+	path   string
+	leases map[string]StoredLease
+	dirty  bool
+}
+
+// NewLeaseStore returns a store keeping the leases in memory only
+func NewLeaseStore() *LeaseStore {
+	return &LeaseStore{leases: make(map[string]StoredLease)}
+}
+
+// OpenLeaseStore returns a store saved to the json file at path, the leases
+// of the file are loaded when it exists
+func OpenLeaseStore(path string) (*LeaseStore, error) {
+	store := NewLeaseStore()
+	store.path = path
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var leases []StoredLease
+	if err := json.Unmarshal(data, &leases); err != nil {
+		return nil, err
+	}
+	for _, lease := range leases {
+		store.leases[lease.MAC] = lease
+	}
+	return store, nil
+}
+
+// put records the lease of an ack, the acks of informs carry none
+func (s *LeaseStore) put(lease Lease, mac net.HardwareAddr, serverMac net.HardwareAddr) {
+	ip := lease.FixedAddress.To4()
+	if s == nil || ip == nil || ip.Equal(net.IPv4zero) {
+		return
+	}
+	fillTimers(&lease)
+	s.lock.Lock()
+	s.leases[mac.String()] = newStoredLease(mac, lease, serverMac)
+	s.dirty = true
+	s.lock.Unlock()
+}
+
+// remove drops the lease of a client which got a nak, declined or released it
+func (s *LeaseStore) remove(mac net.HardwareAddr) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	if _, ok := s.leases[mac.String()]; ok {
+		delete(s.leases, mac.String())
+		s.dirty = true
+	}
+	s.lock.Unlock()
+}
+
+// List returns the leases sorted by mac
+func (s *LeaseStore) List() []StoredLease {
+	if s == nil {
+		return nil
+	}
+	s.lock.Lock()
+	leases := make([]StoredLease, 0, len(s.leases))
+	for _, lease := range s.leases {
+		leases = append(leases, lease)
+	}
+	s.lock.Unlock()
+	sort.Slice(leases, func(i, j int) bool { return leases[i].MAC < leases[j].MAC })
+	return leases
+}
+
+// Save writes the leases to the file of the store if they changed since the
+// last save, the file is replaced at once so that it is never left half written
+func (s *LeaseStore) Save() error {
+	if s == nil || len(s.path) == 0 {
+		return nil
+	}
+	s.lock.Lock()
+	dirty := s.dirty
+	s.dirty = false
+	s.lock.Unlock()
+	if !dirty {
+		return nil
+	}
+	if err := s.write(); err != nil {
+		s.lock.Lock()
+		s.dirty = true
+		s.lock.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (s *LeaseStore) write() error {
+	data, err := json.MarshalIndent(s.List(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// Addresses returns the address of every unexpired lease of the store, keyed by mac
+func (dc *DhcpClient) Addresses() map[string]net.IP {
+	addrs := make(map[string]net.IP)
+	now := time.Now()
+	for _, lease := range dc.Leases.List() {
+		if lease.State(now) != LeaseExpired {
+			addrs[lease.MAC] = lease.Address
+		}
+	}
+	return addrs
+}
@@ -0,0 +1,67 @@
+package connection
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLeaseStoreSaveAndLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "leases.json")
+
+	store, err := OpenLeaseStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+	other, _ := net.ParseMAC("02:00:00:00:00:02")
+	serverMac, _ := net.ParseMAC("02:00:00:00:00:fe")
+	now := time.Now().Truncate(time.Second)
+	store.put(Lease{
+		FixedAddress: net.IPv4(10, 0, 0, 5),
+		ServerID:     net.IPv4(10, 0, 0, 1).To4(),
+		Bound:        now,
+		Expire:       now.Add(time.Hour),
+	}, mac, serverMac)
+	store.put(Lease{FixedAddress: net.IPv4(10, 0, 0, 6), Bound: now}, other, nil)
+	store.put(Lease{FixedAddress: net.IPv4zero}, serverMac, nil)
+	store.remove(other)
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := OpenLeaseStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leases := loaded.List()
+	if len(leases) != 1 {
+		t.Fatalf("got %d leases, want 1: %+v", len(leases), leases)
+	}
+	lease := leases[0]
+	if lease.MAC != mac.String() || !lease.Address.Equal(net.IPv4(10, 0, 0, 5)) || lease.ServerMAC != serverMac.String() {
+		t.Errorf("unexpected lease %+v", lease)
+	}
+	//the timers are filled in with their defaults
+	if !lease.Renew.Equal(now.Add(30*time.Minute)) || !lease.Rebind.Equal(now.Add(52*time.Minute+30*time.Second)) {
+		t.Errorf("renew %s, rebind %s", lease.Renew, lease.Rebind)
+	}
+	for at, want := range map[time.Duration]string{
+		0:                "bound",
+		40 * time.Minute: "renewing",
+		55 * time.Minute: "rebinding",
+		2 * time.Hour:    LeaseExpired,
+	} {
+		if state := lease.State(now.Add(at)); state != want {
+			t.Errorf("state after %s is %s, want %s", at, state, want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package connection
+
+import (
+	"dhcptest/layers"
+	"dhcptest/utility"
+	"sync"
+)
+
+// deviceOptions remembers the templated options each simulated client sent
+// when its transaction started. The packets the client builds later without
+// its index, the request of an offer and the renewals, carry the same ones
+type deviceOptions struct {
+	lock    sync.Mutex
+	options map[string]layers.DHCPOptions
+}
+
+func newDeviceOptions() *deviceOptions {
+	return &deviceOptions{options: make(map[string]layers.DHCPOptions)}
+}
+
+// apply records the templated options of the packet, or adds the recorded
+// ones when it carries none. Declines and releases carry no --option, they
+// are left alone
+func (d *deviceOptions) apply(packet *layers.DHCPv4) {
+	if len(utility.OptionTemplates) == 0 {
+		return
+	}
+	switch packet.MessageType() {
+	case layers.DHCPMsgTypeDiscover, layers.DHCPMsgTypeRequest, layers.DHCPMsgTypeInform:
+	default:
+		return
+	}
+	var own layers.DHCPOptions
+	for _, option := range packet.Options {
+		for _, template := range utility.OptionTemplates {
+			if option.Type == template.Code {
+				own = append(own, option)
+				break
+			}
+		}
+	}
+	key := packet.ClientHWAddr.String()
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if len(own) > 0 {
+		d.options[key] = own
+		return
+	}
+	insertOptions(packet, d.options[key])
+}
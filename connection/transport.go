@@ -8,8 +8,10 @@ import (
 	"github.com/libp2p/go-reuseport"
 	"github.com/mdlayher/raw"
 	"github.com/pinterest/bender"
+	"golang.org/x/net/bpf"
 	"math/rand"
 	"net"
+	"time"
 )
 
 type Dialer func(*net.UDPAddr, *net.UDPAddr) (net.Conn, error)
@@ -50,6 +52,117 @@ func UDPListener() Listener {
 	}
 }
 
+// dhcpFilter passes the udp datagrams to the dhcp ports, 68 for the replies
+// to the clients and 67 for the replies to the relay agent. Only the first
+// fragment carries the udp header
+var dhcpFilter = []bpf.Instruction{
+	bpf.LoadAbsolute{Off: 23, Size: 1},
+	bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(layers.IPProtocolUDP), SkipFalse: 7},
+	bpf.LoadAbsolute{Off: 20, Size: 2},
+	bpf.JumpIf{Cond: bpf.JumpBitsSet, Val: 0x1fff, SkipTrue: 5},
+	bpf.LoadMemShift{Off: 14},
+	bpf.LoadIndirect{Off: 16, Size: 2},
+	bpf.JumpIf{Cond: bpf.JumpEqual, Val: 68, SkipTrue: 1},
+	bpf.JumpIf{Cond: bpf.JumpEqual, Val: 67, SkipFalse: 1},
+	bpf.RetConstant{Val: MAXUDPReceivedPacketSize},
+	bpf.RetConstant{Val: 0},
+}
+
+// listenClients opens the socket the replies to the simulated clients are
+// received on. The replies to the renews and the informs are unicast to the
+// macs of the clients rather than to the interface, RFC 2131 4.1, so the
+// interface is put in promiscuous mode behind a filter on the dhcp ports
+func listenClients(iface *net.Interface) (net.PacketConn, error) {
+	conn, err := raw.ListenPacket(iface, uint16(layers.EthernetTypeIPv4), nil)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := bpf.Assemble(dhcpFilter)
+	if err == nil {
+		err = conn.SetBPF(filter)
+	}
+	if err == nil {
+		err = conn.SetPromiscuous(true)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// listenARP opens the socket the arp probes are sent and received on
+func listenARP(iface *net.Interface) (net.PacketConn, error) {
+	return raw.ListenPacket(iface, uint16(layers.EthernetTypeARP), nil)
+}
+
+// sendProbe broadcasts an arp probe for the ip from the mac of the interface
+func (dc *DhcpClient) sendProbe(ip net.IP) error {
+	frame, err := newProbe(dc.Iface.HardwareAddr, ip)
+	if err != nil {
+		return err
+	}
+	dc.arp.SetWriteDeadline(time.Now().Add(DefaultWriteTimeout))
+	_, err = dc.arp.WriteTo(frame, &raw.Addr{HardwareAddr: layers.EthernetBroadcast})
+	return err
+}
+
+// ethernetTypeAll receives the frames of every ethernet type, ETH_P_ALL
+const ethernetTypeAll = 0x0003
+
+// respondFilter passes the arp packets and the ipv4 icmp packets
+var respondFilter = []bpf.Instruction{
+	bpf.LoadAbsolute{Off: 12, Size: 2},
+	bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(layers.EthernetTypeARP), SkipTrue: 3},
+	bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(layers.EthernetTypeIPv4), SkipFalse: 3},
+	bpf.LoadAbsolute{Off: 23, Size: 1},
+	bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(layers.IPProtocolICMPv4), SkipFalse: 1},
+	bpf.RetConstant{Val: MAXUDPReceivedPacketSize},
+	bpf.RetConstant{Val: 0},
+}
+
+// listenResponder opens the socket the arp and the icmp echo requests are
+// answered on. The echo requests are sent to the macs of the simulated
+// clients, so the interface is put in promiscuous mode
+func listenResponder(iface *net.Interface) (net.PacketConn, error) {
+	conn, err := raw.ListenPacket(iface, ethernetTypeAll, nil)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := bpf.Assemble(respondFilter)
+	if err == nil {
+		err = conn.SetBPF(filter)
+	}
+	if err == nil {
+		err = conn.SetPromiscuous(true)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// sendFrame writes a frame built by the responder
+func (dc *DhcpClient) sendFrame(frame []byte) error {
+	dc.respond.SetWriteDeadline(time.Now().Add(DefaultWriteTimeout))
+	_, err := dc.respond.WriteTo(frame, &raw.Addr{HardwareAddr: net.HardwareAddr(frame[:6])})
+	return err
+}
+
+// rawFrames tells whether whole frames are sent, so that the mutations of the
+// ip and udp headers can be sent
+const rawFrames = true
+
+// SendMutant broadcasts the frame of the mutant
+func (dc *DhcpClient) SendMutant(m *Mutant) error {
+	dc.connection.SetWriteDeadline(time.Now().Add(DefaultWriteTimeout))
+	_, err := dc.connection.WriteTo(m.Frame, &raw.Addr{HardwareAddr: layers.EthernetBroadcast})
+	if err == nil {
+		dc.Capture.sent(m.Frame)
+	}
+	return err
+}
 
 // CreateExecutor creates a new DHCPv4 RequestExecutor.
 func CreateExecutor(client *DhcpClient) bender.RequestExecutor {
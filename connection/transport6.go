@@ -0,0 +1,62 @@
+// +build !windows
+
+package connection
+
+import (
+	"dhcptest/layers"
+	"github.com/google/gopacket"
+	"github.com/mdlayher/raw"
+	"net"
+	"time"
+)
+
+var decoder6 gopacket.Decoder = layers.LayerTypeEthernet
+
+func listen6(iface *net.Interface) (net.PacketConn, error) {
+	return raw.ListenPacket(iface, uint16(layers.EthernetTypeIPv6), nil)
+}
+
+// captureReceived captures a received frame
+func (dc *DhcpV6Client) captureReceived(frame []byte, _ net.Addr) {
+	dc.Capture.received(frame)
+}
+
+// send multicasts the packet to ff02::1:2 from the link-local address of the interface
+func (dc *DhcpV6Client) send(packet *layers.DHCPv6) error {
+	eth := layers.Ethernet{
+		EthernetType: layers.EthernetTypeIPv6,
+		SrcMAC:       dc.Iface.HardwareAddr,
+		DstMAC:       AllDHCPMac,
+	}
+
+	ip := layers.IPv6{
+		Version:    6,
+		HopLimit:   1,
+		SrcIP:      dc.linkLocal,
+		DstIP:      AllDHCPRelayAgentsAndServers,
+		NextHeader: layers.IPProtocolUDP,
+	}
+
+	udp := layers.UDP{
+		SrcPort: 546,
+		DstPort: 547,
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{
+		ComputeChecksums: true,
+		FixLengths:       true,
+	}
+	udp.SetNetworkLayerForChecksum(&ip)
+
+	if err := gopacket.SerializeLayers(buf, opts, &eth, &ip, &udp, packet); err != nil {
+		return err
+	}
+
+	dc.connection.SetWriteDeadline(time.Now().Add(DefaultWriteTimeout))
+	_, err := dc.connection.WriteTo(buf.Bytes(), &raw.Addr{HardwareAddr: eth.DstMAC})
+	if err == nil {
+		dc.Capture.sent(buf.Bytes())
+	}
+	return err
+}
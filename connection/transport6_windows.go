@@ -0,0 +1,41 @@
+package connection
+
+import (
+	"dhcptest/layers"
+	"github.com/google/gopacket"
+	"github.com/libp2p/go-reuseport"
+	"net"
+	"time"
+)
+
+var decoder6 gopacket.Decoder = layers.LayerTypeDHCPv6
+
+func listen6(iface *net.Interface) (net.PacketConn, error) {
+	return reuseport.ListenPacket("udp6", (&net.UDPAddr{IP: net.IPv6unspecified, Port: 546, Zone: iface.Name}).String())
+}
+
+// captureReceived captures a received payload in a frame built around it
+func (dc *DhcpV6Client) captureReceived(payload []byte, from net.Addr) {
+	if remote, ok := from.(*net.UDPAddr); ok {
+		dc.Capture.udp(false, dc.Iface.HardwareAddr, &net.UDPAddr{IP: dc.linkLocal, Port: 546}, remote, payload)
+	}
+}
+
+// send multicasts the packet to ff02::1:2 on the interface
+func (dc *DhcpV6Client) send(packet *layers.DHCPv6) error {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{
+		FixLengths: true,
+	}
+	if err := packet.SerializeTo(buf, opts); err != nil {
+		return err
+	}
+
+	dc.connection.SetWriteDeadline(time.Now().Add(DefaultWriteTimeout))
+	remote := &net.UDPAddr{IP: AllDHCPRelayAgentsAndServers, Port: 547, Zone: dc.Iface.Name}
+	_, err := dc.connection.WriteTo(buf.Bytes(), remote)
+	if err == nil {
+		dc.Capture.udp(true, dc.Iface.HardwareAddr, &net.UDPAddr{IP: dc.linkLocal, Port: 546}, remote, buf.Bytes())
+	}
+	return err
+}
@@ -2,11 +2,13 @@ package connection
 
 import (
 	"dhcptest/layers"
+	"errors"
 	"fmt"
 	"github.com/libp2p/go-reuseport"
 	"github.com/pinterest/bender"
 	"math/rand"
 	"net"
+	"time"
 )
 
 type Dialer func(*net.UDPAddr, *net.UDPAddr) (net.Conn, error)
@@ -47,6 +49,41 @@ func UDPListener() Listener {
 	}
 }
 
+// listenARP fails on windows, there is no raw socket to send the arp probes on
+func listenARP(iface *net.Interface) (net.PacketConn, error) {
+	return nil, errors.New("arp probe is not supported on windows")
+}
+
+func (dc *DhcpClient) sendProbe(ip net.IP) error {
+	return errors.New("arp probe is not supported on windows")
+}
+
+// listenResponder fails on windows, there is no raw socket to answer on
+func listenResponder(iface *net.Interface) (net.PacketConn, error) {
+	return nil, errors.New("responding to arp and icmp is not supported on windows")
+}
+
+func (dc *DhcpClient) sendFrame(frame []byte) error {
+	return errors.New("responding to arp and icmp is not supported on windows")
+}
+
+// rawFrames tells whether whole frames are sent, there is no raw socket on
+// windows and only the mutations of the payload are sent
+const rawFrames = false
+
+// SendMutant broadcasts the payload of the mutant to the server port
+func (dc *DhcpClient) SendMutant(m *Mutant) error {
+	if m.Mutation.header() {
+		return fmt.Errorf("mutation %s is not supported on windows", m.Mutation)
+	}
+	dc.connection.SetWriteDeadline(time.Now().Add(DefaultWriteTimeout))
+	remote := &net.UDPAddr{IP: net.IPv4bcast, Port: 67}
+	_, err := dc.connection.WriteTo(m.Payload, remote)
+	if err == nil {
+		dc.Capture.udp(true, dc.Iface.HardwareAddr, &net.UDPAddr{IP: net.IPv4zero, Port: 68}, remote, m.Payload)
+	}
+	return err
+}
 
 // CreateExecutor creates a new DHCPv4 RequestExecutor.
 func CreateExecutor(client *DhcpClient) bender.RequestExecutor {
@@ -0,0 +1,292 @@
+package connection
+
+import (
+	"bytes"
+	"dhcptest/layers"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Rule is a check the validator makes on the offers and the acks
+type Rule string
+
+// The rules of RFC 2131 and of the expectations, in the order of the report
+const (
+	// RuleEcho wants a BOOTREPLY echoing the xid, htype, hlen and chaddr of the request
+	RuleEcho Rule = "echo"
+	// RuleYiaddr wants a non-zero yiaddr, the ack of an inform is left out
+	RuleYiaddr Rule = "yiaddr"
+	// RuleServerID wants the server identifier option
+	RuleServerID Rule = "server-id"
+	// RuleLeaseTimes wants a lease time, and T1 <= T2 <= lease time when they are given
+	RuleLeaseTimes Rule = "lease-times"
+	// RuleGiaddr wants the giaddr of the request back
+	RuleGiaddr Rule = "giaddr"
+	// RuleOption82 wants the relay agent information of the request back unchanged
+	RuleOption82 Rule = "option-82"
+	// RuleSubnet wants the yiaddr within the expected subnet
+	RuleSubnet Rule = "subnet"
+	// RuleRouter wants the routers of the expectations
+	RuleRouter Rule = "router"
+	// RuleDNS wants the dns servers of the expectations
+	RuleDNS Rule = "dns"
+	// RuleOptions wants the options of the expectations present
+	RuleOptions Rule = "required-options"
+)
+
+var rules = []Rule{RuleEcho, RuleYiaddr, RuleServerID, RuleLeaseTimes, RuleGiaddr, RuleOption82, RuleSubnet, RuleRouter, RuleDNS, RuleOptions}
+
+// Expectations are what the user expects of the replies on top of RFC 2131,
+// the zero value expects nothing
+type Expectations struct {
+	// Subnet holds the offered addresses
+	Subnet *net.IPNet
+	// Router and DNS are the values of the options, in order
+	Router []net.IP
+	DNS    []net.IP
+	// Required are the options every reply carries
+	Required []layers.DHCPOpt
+}
+
+// RuleResult counts the replies a rule was checked on and the ones breaking it
+type RuleResult struct {
+	Rule     Rule
+	Checked  uint64
+	Violated uint64
+}
+
+// Passed tells whether no reply broke the rule
+func (r RuleResult) Passed() bool {
+	return r.Violated == 0
+}
+
+// ConformanceReport is the result of every rule checked on at least one reply
+type ConformanceReport struct {
+	Replies uint64
+	Results []RuleResult
+}
+
+// Passed tells whether every reply kept every rule
+func (c ConformanceReport) Passed() bool {
+	for _, result := range c.Results {
+		if !result.Passed() {
+			return false
+		}
+	}
+	return true
+}
+
+func (c ConformanceReport) String() string {
+	verdict := "PASS"
+	if !c.Passed() {
+		verdict = "FAIL"
+	}
+	lines := []string{fmt.Sprintf("conformance: %s, %d replies checked", verdict, c.Replies)}
+	for _, result := range c.Results {
+		verdict = "PASS"
+		if !result.Passed() {
+			verdict = "FAIL"
+		}
+		lines = append(lines, fmt.Sprintf("  %-16s %s  %d of %d replies broke it", result.Rule, verdict, result.Violated, result.Checked))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Validator checks the offers and the acks against RFC 2131 and the
+// expectations, and counts the replies breaking each rule
+type Validator struct {
+	expect   Expectations
+	lock     sync.Mutex
+	replies  uint64
+	checked  map[Rule]uint64
+	violated map[Rule]uint64
+}
+
+func NewValidator(expect Expectations) *Validator {
+	return &Validator{
+		expect:   expect,
+		checked:  make(map[Rule]uint64),
+		violated: make(map[Rule]uint64),
+	}
+}
+
+// check checks a reply against the request it answers, it returns the rules
+// the reply breaks. A packet which is not a BOOTREPLY is only checked for the echo
+func (v *Validator) check(request *layers.DHCPv4, reply *layers.DHCPv4) []Rule {
+	if v == nil || request == nil || reply == nil {
+		return nil
+	}
+	results := make(map[Rule]bool)
+	results[RuleEcho] = reply.Operation == layers.DHCPOpReply && echoes(request, reply)
+	if reply.Operation == layers.DHCPOpReply {
+		v.checkReply(request, reply, results)
+	}
+
+	var broken []Rule
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.replies++
+	for _, rule := range rules {
+		ok, checked := results[rule]
+		if !checked {
+			continue
+		}
+		v.checked[rule]++
+		if !ok {
+			v.violated[rule]++
+			broken = append(broken, rule)
+		}
+	}
+	return broken
+}
+
+// checkReply fills the results of the rules on the fields and the options of a reply
+func (v *Validator) checkReply(request *layers.DHCPv4, reply *layers.DHCPv4, results map[Rule]bool) {
+	inform := request.MessageType() == layers.DHCPMsgTypeInform
+	options := make(map[layers.DHCPOpt][]byte)
+	for _, option := range reply.Options {
+		if _, ok := options[option.Type]; !ok {
+			options[option.Type] = option.Data
+		}
+	}
+
+	if !inform {
+		results[RuleYiaddr] = reply.YourClientIP != nil && !reply.YourClientIP.Equal(net.IPv4zero)
+		lease, t1, t2 := seconds(options[layers.DHCPOptLeaseTime]), seconds(options[layers.DHCPOptT1]), seconds(options[layers.DHCPOptT2])
+		ok := lease >= 0
+		if t2 >= 0 {
+			ok = ok && t2 <= lease
+		}
+		if t1 >= 0 {
+			ok = ok && (t2 < 0 || t1 <= t2) && t1 <= lease
+		}
+		results[RuleLeaseTimes] = ok
+	}
+	results[RuleServerID] = len(options[layers.DHCPOptServerID]) == 4
+	results[RuleGiaddr] = sameIP(reply.RelayAgentIP, request.RelayAgentIP)
+	for _, option := range request.Options {
+		if option.Type == layers.DHCPOptRelayAgent {
+			echoed, ok := options[layers.DHCPOptRelayAgent]
+			results[RuleOption82] = ok && bytes.Equal(echoed, option.Data)
+			break
+		}
+	}
+
+	if v.expect.Subnet != nil && !inform {
+		results[RuleSubnet] = v.expect.Subnet.Contains(reply.YourClientIP)
+	}
+	if len(v.expect.Router) > 0 {
+		results[RuleRouter] = sameIPs(options[layers.DHCPOptRouter], v.expect.Router)
+	}
+	if len(v.expect.DNS) > 0 {
+		results[RuleDNS] = sameIPs(options[layers.DHCPOptDNS], v.expect.DNS)
+	}
+	if len(v.expect.Required) > 0 {
+		ok := true
+		for _, opt := range v.expect.Required {
+			if _, found := options[opt]; !found {
+				ok = false
+			}
+		}
+		results[RuleOptions] = ok
+	}
+}
+
+// Report returns the counts of the rules checked so far
+func (v *Validator) Report() ConformanceReport {
+	if v == nil {
+		return ConformanceReport{}
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	report := ConformanceReport{Replies: v.replies}
+	for _, rule := range rules {
+		if checked := v.checked[rule]; checked > 0 {
+			report.Results = append(report.Results, RuleResult{Rule: rule, Checked: checked, Violated: v.violated[rule]})
+		}
+	}
+	return report
+}
+
+// echoes tells whether the reply echoes the xid, the htype and the chaddr of
+// the request, the client drops the replies which don't
+func echoes(request *layers.DHCPv4, reply *layers.DHCPv4) bool {
+	//chaddr is decoded to the length of hlen
+	return request != nil && reply.Xid == request.Xid && reply.HardwareType == request.HardwareType &&
+		bytes.Equal(reply.ClientHWAddr, request.ClientHWAddr)
+}
+
+// seconds returns the value of a time option, -1 when it is missing or malformed
+func seconds(data []byte) int64 {
+	if len(data) != 4 {
+		return -1
+	}
+	return int64(binary.BigEndian.Uint32(data))
+}
+
+// sameIP compares two addresses, nil standing for 0.0.0.0
+func sameIP(a net.IP, b net.IP) bool {
+	if a == nil {
+		a = net.IPv4zero
+	}
+	if b == nil {
+		b = net.IPv4zero
+	}
+	return a.Equal(b)
+}
+
+// sameIPs tells whether the addresses of an option are the expected ones, in order
+func sameIPs(data []byte, expected []net.IP) bool {
+	if len(data) != 4*len(expected) {
+		return false
+	}
+	for i, ip := range expected {
+		if !net.IP(data[4*i : 4*i+4]).Equal(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// requestOf returns the packet of the transaction a reply answers, the
+// discover for an offer and the request or the inform for the others
+func (pr *PacketResponse) requestOf(reply *layers.DHCPv4) *layers.DHCPv4 {
+	if reply.MessageType() != layers.DHCPMsgTypeOffer {
+		if request := pr.Packet(layers.DHCPMsgTypeRequest); request != nil {
+			return request
+		}
+		if inform := pr.Packet(layers.DHCPMsgTypeInform); inform != nil {
+			return inform
+		}
+	}
+	return pr.Packet(layers.DHCPMsgTypeDiscover)
+}
+
+// violated adds the rules a reply broke to the record of the transaction
+func (pr *PacketResponse) violated(broken []Rule) {
+	if len(broken) == 0 {
+		return
+	}
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	for _, rule := range broken {
+		pr.stats.observer().ruleViolated(rule)
+		found := false
+		for _, recorded := range pr.record.Violations {
+			found = found || recorded == rule
+		}
+		if !found {
+			pr.record.Violations = append(pr.record.Violations, rule)
+		}
+	}
+}
+
+// isReply tells whether the packet is an offer or an ack, the replies the
+// validator checks
+func isReply(packet *layers.DHCPv4) bool {
+	msgType := packet.MessageType()
+	return msgType == layers.DHCPMsgTypeOffer || msgType == layers.DHCPMsgTypeAck
+}
@@ -0,0 +1,83 @@
+package connection
+
+import (
+	"dhcptest/layers"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestValidator(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	discover := NewDiscover(mac)
+	WithTransactionID(7)(discover)
+	WithOption(layers.DHCPOptRelayAgent, []byte{1, 2, 'a', 'b'})(discover)
+	_, subnet, _ := net.ParseCIDR("10.0.0.0/24")
+	v := NewValidator(Expectations{
+		Subnet:   subnet,
+		Router:   []net.IP{net.IPv4(10, 0, 0, 1)},
+		Required: []layers.DHCPOpt{layers.DHCPOptSubnetMask},
+	})
+
+	offer := func(yiaddr net.IP, options ...layers.DHCPOption) *layers.DHCPv4 {
+		reply := NewPacket(append([]layers.DHCPOption{
+			layers.NewDHCPOption(layers.DHCPOptServerID, []byte{10, 0, 0, 1}),
+			layers.NewDHCPOption(layers.DHCPOptSubnetMask, []byte{255, 255, 255, 0}),
+			layers.NewDHCPOption(layers.DHCPOptRouter, []byte{10, 0, 0, 1}),
+			layers.NewDHCPOption(layers.DHCPOptRelayAgent, []byte{1, 2, 'a', 'b'}),
+		}, options...)...)
+		WithReply(discover)(reply)
+		reply.YourClientIP = yiaddr
+		WithMessageType(layers.DHCPMsgTypeOffer)(reply)
+		return reply
+	}
+	good := offer(net.IPv4(10, 0, 0, 5), layers.NewDHCPOption(layers.DHCPOptLeaseTime, []byte{0, 0, 0x0e, 0x10}))
+	if broken := v.check(discover, good); len(broken) != 0 {
+		t.Errorf("a good offer breaks %v", broken)
+	}
+	if report := v.Report(); !report.Passed() || len(report.Results) != 9 {
+		t.Errorf("report after a good offer:\n%s", report)
+	}
+
+	//T1 past the lease time, out of the subnet and no option 82 back
+	bad := offer(net.IPv4(192, 168, 0, 5),
+		layers.NewDHCPOption(layers.DHCPOptLeaseTime, []byte{0, 0, 0, 60}),
+		layers.NewDHCPOption(layers.DHCPOptT1, []byte{0, 0, 0, 120}))
+	bad.Options = append(bad.Options[:3], bad.Options[4:]...)
+	broken := v.check(discover, bad)
+	if strings.Join(rulesOf(broken), " ") != "lease-times option-82 subnet" {
+		t.Errorf("a bad offer breaks %v", broken)
+	}
+
+	request := offer(net.IPv4(10, 0, 0, 5))
+	request.Operation = layers.DHCPOpRequest
+	if broken := v.check(discover, request); len(broken) != 1 || broken[0] != RuleEcho {
+		t.Errorf("an offer sent as a request breaks %v", broken)
+	}
+
+	report := v.Report()
+	if report.Passed() || report.Replies != 3 {
+		t.Errorf("report %+v", report)
+	}
+	for _, result := range report.Results {
+		if result.Rule == RuleEcho && (result.Checked != 3 || result.Violated != 1) {
+			t.Errorf("echo %+v", result)
+		}
+	}
+	if !strings.Contains(report.String(), "FAIL") {
+		t.Errorf("report:\n%s", report)
+	}
+
+	var none *Validator
+	if none.check(discover, bad) != nil || !none.Report().Passed() {
+		t.Error("a nil validator checks")
+	}
+}
+
+func rulesOf(broken []Rule) []string {
+	var names []string
+	for _, rule := range broken {
+		names = append(names, string(rule))
+	}
+	return names
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"dhcptest/connection"
+	"dhcptest/utility"
+	"fmt"
+	"io"
+	"os"
+)
+
+// newExporter opens the --output-file for the --output records, it returns nil
+// when no record is asked for
+func newExporter() (*connection.Exporter, error) {
+	if len(utility.Output) == 0 {
+		return nil, nil
+	}
+	if utility.Output != "json" && utility.Output != "csv" {
+		return nil, fmt.Errorf("invalid output format: %s, want json or csv", utility.Output)
+	}
+	//the standard output is not closed with the exporter
+	var w io.Writer = struct{ io.Writer }{os.Stdout}
+	if len(utility.OutputFile) > 0 {
+		file, err := os.Create(utility.OutputFile)
+		if err != nil {
+			return nil, err
+		}
+		w = file
+	}
+	return connection.NewExporter(utility.Output, w)
+}
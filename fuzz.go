@@ -0,0 +1,198 @@
+package main
+
+import (
+	"dhcptest/connection"
+	"dhcptest/layers"
+	"dhcptest/utility"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	exitServerStopped = 1
+	exitBadFuzz       = 2
+	// mutantSpacing is the time between two broken packets of a batch
+	mutantSpacing = 10 * time.Millisecond
+	// probeAttempts is the number of liveness probes before the server is
+	// taken for stopped
+	probeAttempts = 3
+	// recoverWait is how long the bisection of a batch waits for the stopped
+	// server to answer again, as after a restart by its supervisor
+	recoverWait = 2 * time.Minute
+)
+
+// runFuzz sends batches of broken packets drawn from --seed and runs a normal
+// DORA after each batch, it stops at the first batch after which the server no
+// longer answers and bisects the batch down to the seed which stops it. The
+// seeds given as args are sent again as a single batch
+func runFuzz(dc client, args []string) int {
+	v4, ok := dc.(v4Client)
+	if !ok {
+		log.Println("the fuzz command speaks dhcpv4 only")
+		return exitBadFuzz
+	}
+	mutations, err := connection.ParseMutations(utility.Mutations)
+	if err != nil {
+		log.Println(err)
+		return exitBadFuzz
+	}
+	var replay []int64
+	for _, arg := range args {
+		seed, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			log.Printf("invalid mutant seed %q", arg)
+			return exitBadFuzz
+		}
+		replay = append(replay, seed)
+	}
+	if len(replay) == 0 && (utility.BatchSize <= 0 || utility.Batches <= 0) {
+		log.Println("--batch-size and --batches should be positive")
+		return exitBadFuzz
+	}
+	mac, err := macGenerator.Next()
+	if err != nil {
+		log.Println(err)
+		return exitBadFuzz
+	}
+
+	v4.Start(utility.BatchSize*3+16, true, true)
+	defer v4.Stop()
+	if !probe(v4, mac) {
+		log.Println("the server does not answer before fuzzing")
+		return exitServerStopped
+	}
+
+	seed := utility.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	source := rand.New(rand.NewSource(seed))
+	batches := utility.Batches
+	if len(replay) > 0 {
+		batches = 1
+	} else {
+		log.Printf("fuzzing with --seed %d, %d batches of %d packets", seed, batches, utility.BatchSize)
+	}
+	sent := make(map[connection.Mutation]int)
+	for batch := 1; batch <= batches; batch++ {
+		seeds := replay
+		if len(seeds) == 0 {
+			seeds = make([]int64, utility.BatchSize)
+			for i := range seeds {
+				seeds[i] = source.Int63()
+			}
+		}
+		var mutants []*connection.Mutant
+		for _, seed := range seeds {
+			mutant, err := connection.NewMutant(seed, mutations, v4.Iface.HardwareAddr)
+			if err != nil {
+				log.Println(err)
+				return exitBadFuzz
+			}
+			mutants = append(mutants, mutant)
+		}
+		mutants = sendMutants(v4, mutants)
+		for _, mutant := range mutants {
+			sent[mutant.Mutation]++
+		}
+		if !probe(v4, mac) {
+			log.Printf("the server stopped answering after batch %d, bisecting its %d packets", batch, len(mutants))
+			culprits := bisect(mutants, func(half []*connection.Mutant) bool {
+				sendMutants(v4, half)
+				return !probe(v4, mac)
+			}, func() bool {
+				return awaitServer(v4, mac)
+			})
+			if len(culprits) == 1 {
+				log.Printf("this packet stops the server: %s", culprits[0])
+			} else {
+				log.Printf("the bisection stopped at %d packets, one of them or all together stop the server:", len(culprits))
+				for _, mutant := range culprits {
+					log.Printf("  %s", mutant)
+				}
+			}
+			log.Printf("send it again with: fuzz SEED, keeping --mutations")
+			return exitServerStopped
+		}
+		log.Printf("batch %d/%d: %d packets sent, the server answers", batch, batches, len(mutants))
+	}
+	log.Printf("the server survived %s", formatMutationCounts(sent))
+	return exitOK
+}
+
+// sendMutants sends the broken packets spaced by mutantSpacing, it returns
+// the ones which were sent
+func sendMutants(v4 v4Client, mutants []*connection.Mutant) []*connection.Mutant {
+	var sent []*connection.Mutant
+	for _, mutant := range mutants {
+		if err := v4.SendMutant(mutant); err != nil {
+			log.Printf("%s: %s", mutant, err)
+			continue
+		}
+		sent = append(sent, mutant)
+		time.Sleep(mutantSpacing)
+	}
+	return sent
+}
+
+// bisect narrows the packets of a batch which stopped the server down to the
+// one which stops it. Once the server is back, as told by recovered, half of
+// the packets are sent again and stops tells whether the server stopped, the
+// half stopping it is kept. The packets left are returned when the server
+// doesn't come back or when no half stops it on its own
+func bisect(mutants []*connection.Mutant, stops func([]*connection.Mutant) bool, recovered func() bool) []*connection.Mutant {
+	for len(mutants) > 1 {
+		half := len(mutants) / 2
+		if !recovered() {
+			log.Printf("the server didn't answer again within %s", recoverWait)
+			return mutants
+		}
+		if stops(mutants[:half]) {
+			mutants = mutants[:half]
+			continue
+		}
+		if stops(mutants[half:]) {
+			mutants = mutants[half:]
+			continue
+		}
+		return mutants
+	}
+	return mutants
+}
+
+// awaitServer probes the stopped server until it answers again, for at most
+// recoverWait
+func awaitServer(v4 v4Client, mac net.HardwareAddr) bool {
+	for deadline := time.Now().Add(recoverWait); time.Now().Before(deadline); {
+		if probe(v4, mac) {
+			return true
+		}
+	}
+	return false
+}
+
+// probe runs a normal DORA for the mac, it tells whether an ack came
+func probe(v4 v4Client, mac net.HardwareAddr) bool {
+	for attempt := 0; attempt < probeAttempts; attempt++ {
+		pr := v4.Send(v4.newRequest(0, mac).(*layers.DHCPv4), connection.WithTransactionID(rand.Uint32()))
+		<-pr.Done()
+		if pr.Packet(layers.DHCPMsgTypeAck) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func formatMutationCounts(sent map[connection.Mutation]int) string {
+	var counts []string
+	for _, mutation := range connection.Mutations {
+		if sent[mutation] > 0 {
+			counts = append(counts, fmt.Sprintf("%s: %d", mutation, sent[mutation]))
+		}
+	}
+	return fmt.Sprint(counts)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"dhcptest/connection"
+	"dhcptest/utility"
+	"testing"
+)
+
+func TestRunFuzzArgs(t *testing.T) {
+	defer func(mutations string, size, batches int) {
+		utility.Mutations, utility.BatchSize, utility.Batches = mutations, size, batches
+	}(utility.Mutations, utility.BatchSize, utility.Batches)
+	utility.Mutations, utility.BatchSize, utility.Batches = "all", 10, 1
+
+	if code := runFuzz(&fakeClient{}, nil); code != exitBadFuzz {
+		t.Errorf("a dhcpv6 client exits with %d", code)
+	}
+	v4 := v4Client{&connection.DhcpClient{}}
+	if code := runFuzz(v4, []string{"12", "seed"}); code != exitBadFuzz {
+		t.Errorf("a bad seed exits with %d", code)
+	}
+	utility.BatchSize = 0
+	if code := runFuzz(v4, nil); code != exitBadFuzz {
+		t.Errorf("--batch-size 0 exits with %d", code)
+	}
+	utility.BatchSize, utility.Mutations = 10, "all,nonsense"
+	if code := runFuzz(v4, nil); code != exitBadFuzz {
+		t.Errorf("an unknown mutation exits with %d", code)
+	}
+}
+
+func TestFormatMutationCounts(t *testing.T) {
+	sent := map[connection.Mutation]int{connection.Mutations[1]: 2, connection.Mutations[0]: 5}
+	want := "[" + string(connection.Mutations[0]) + ": 5 " + string(connection.Mutations[1]) + ": 2]"
+	if got := formatMutationCounts(sent); got != want {
+		t.Errorf("%q, want %q", got, want)
+	}
+	if got := formatMutationCounts(nil); got != "[]" {
+		t.Errorf("no mutation gives %q", got)
+	}
+}
+
+func TestBisect(t *testing.T) {
+	var mutants []*connection.Mutant
+	for seed := int64(0); seed < 13; seed++ {
+		mutants = append(mutants, &connection.Mutant{Seed: seed})
+	}
+	// stopsWith builds a server which stops when all the given seeds come in one go
+	stopsWith := func(seeds ...int64) func([]*connection.Mutant) bool {
+		return func(sent []*connection.Mutant) bool {
+			found := 0
+			for _, mutant := range sent {
+				for _, seed := range seeds {
+					if mutant.Seed == seed {
+						found++
+					}
+				}
+			}
+			return found == len(seeds)
+		}
+	}
+	always := func() bool { return true }
+
+	for seed := int64(0); seed < 13; seed++ {
+		if culprits := bisect(mutants, stopsWith(seed), always); len(culprits) != 1 || culprits[0].Seed != seed {
+			t.Errorf("seed %d: bisected to %v", seed, culprits)
+		}
+	}
+	//two packets of different halves stop it together only
+	if culprits := bisect(mutants, stopsWith(2, 9), always); len(culprits) != 13 {
+		t.Errorf("the pair bisected to %d packets", len(culprits))
+	}
+	if culprits := bisect(mutants, stopsWith(2, 4), always); len(culprits) != 6 || culprits[0].Seed != 0 {
+		t.Errorf("the pair of the first half bisected to %v", culprits)
+	}
+
+	recovered := 0
+	once := func() bool {
+		recovered++
+		return recovered == 1
+	}
+	if culprits := bisect(mutants, stopsWith(12), once); len(culprits) != 7 {
+		t.Errorf("a server which stays stopped after the first half bisected to %d packets", len(culprits))
+	}
+}
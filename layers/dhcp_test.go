@@ -8,6 +8,7 @@ package layers
 
 import (
 	"bytes"
+	"fmt"
 	"net"
 	"testing"
 
@@ -119,6 +120,30 @@ func TestDHCPv4DecodeOption(t *testing.T) {
 	}
 }
 
+func TestDHCPRelayAgentInfo(t *testing.T) {
+	info := &DHCPRelayAgentInfo{CircuitID: []byte("eth0/1"), RemoteID: []byte("olt-7")}
+	data := info.Encode()
+	expected := []byte{1, 6, 'e', 't', 'h', '0', '/', '1', 2, 5, 'o', 'l', 't', '-', '7'}
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("expected %v, got %v", expected, data)
+	}
+
+	decoded := &DHCPRelayAgentInfo{}
+	if err := decoded.DecodeFromBytes(append(data, 9, 1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.CircuitID, info.CircuitID) || !bytes.Equal(decoded.RemoteID, info.RemoteID) {
+		t.Errorf("expected %s, got %s", info, decoded)
+	}
+
+	if len((&DHCPRelayAgentInfo{RemoteID: []byte("r")}).Encode()) != 3 {
+		t.Error("empty circuit id should be left out")
+	}
+	if err := decoded.DecodeFromBytes([]byte{1, 6, 'e'}); err != DecOptionMalformed {
+		t.Errorf("expected %v, got %v", DecOptionMalformed, err)
+	}
+}
+
 func testDHCPEqual(t *testing.T, d1, d2 *DHCPv4) {
 	if d1.Operation != d2.Operation {
 		t.Errorf("expected Operation=%s, got %s", d1.Operation, d2.Operation)
@@ -179,3 +204,269 @@ func testDHCPOptionEqual(t *testing.T, idx int, d1, d2 DHCPOption) {
 		t.Errorf("expection Options[%d].Data to be = %v, got %v", idx, d1.Data, d2.Data)
 	}
 }
+
+func TestDHCPStructuredOptions(t *testing.T) {
+	_, local, _ := net.ParseCIDR("10.0.0.0/8")
+	_, any, _ := net.ParseCIDR("0.0.0.0/0")
+	routes := DHCPClasslessRoutes{
+		{Destination: *local, Router: net.IPv4(10, 0, 0, 1)},
+		{Destination: *any, Router: net.IPv4(192, 168, 1, 1)},
+	}
+	search := DHCPDomainSearch{"eng.apple.com.", "marketing.apple.com."}
+	fqdn := &DHCPClientFQDN{Flags: DHCPFQDNServerUpdate | DHCPFQDNEncoded, Name: "host.example.com."}
+	vendor := DHCPVendorSpecific{{Enterprise: 3561, Options: DHCPSubOptions{{Code: 1, Data: []byte("abc")}}}}
+
+	for _, test := range []struct {
+		option  DHCPOpt
+		encoded func() ([]byte, error)
+		data    []byte
+		printed string
+	}{
+		{DHCPOptClasslessStaticRoute, routes.Encode,
+			[]byte{8, 10, 10, 0, 0, 1, 0, 192, 168, 1, 1},
+			"10.0.0.0/8 via 10.0.0.1, 0.0.0.0/0 via 192.168.1.1"},
+		//the example of RFC 3397 section 3
+		{DHCPOptDomainSearch, search.Encode,
+			append(append([]byte{3, 'e', 'n', 'g', 5, 'a', 'p', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 9},
+				"marketing"...), 0xc0, 4),
+			"eng.apple.com, marketing.apple.com"},
+		{DHCPOptFQDN, fqdn.Encode,
+			append(append([]byte{5, 0, 0, 4}, "host"...), 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0),
+			"flags=SE rcode=0/0 host.example.com."},
+		{DHCPOptUserClassInformation, DHCPUserClass{[]byte("a"), []byte("bc")}.Encode,
+			[]byte{1, 'a', 2, 'b', 'c'},
+			`"a","bc"`},
+		{DHCPOptVendorIdentifySpec, vendor.Encode,
+			[]byte{0, 0, 0x0d, 0xe9, 5, 1, 3, 'a', 'b', 'c'},
+			`3561:[1:"abc"]`},
+		{DHCPOptRelayAgent, DHCPSubOptions{{Code: 1, Data: []byte("eth0")}, {Code: 5, Data: []byte{10, 0, 0, 1}}}.Encode,
+			[]byte{1, 4, 'e', 't', 'h', '0', 5, 4, 10, 0, 0, 1},
+			`Circuit-ID:"eth0" Link-Selection:0x0a000001`},
+	} {
+		data, err := test.encoded()
+		if err != nil {
+			t.Fatalf("%s: %s", test.option, err)
+		}
+		if !bytes.Equal(data, test.data) {
+			t.Errorf("%s: expected %v, got %v", test.option, test.data, data)
+		}
+		want := fmt.Sprintf("%d (%s): %s", byte(test.option), test.option, test.printed)
+		if got := NewDHCPOption(test.option, data).String(); got != want {
+			t.Errorf("expected %s, got %s", want, got)
+		}
+	}
+
+	//a pointer forward would loop
+	var decoded DHCPDomainSearch
+	if err := decoded.DecodeFromBytes([]byte{1, 'a', 0xc0, 0}); err != DecOptionMalformed {
+		t.Errorf("expected %v, got %v", DecOptionMalformed, err)
+	}
+	//data which doesn't decode is printed in hex
+	if got := NewDHCPOption(DHCPOptVendorIdentifySpec, []byte{0, 0}).String(); got != "125 (Vendor-Identifying Vendor-Specific): INVALID 0x0000" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestDHCPOptionValue(t *testing.T) {
+	for _, test := range []struct {
+		option DHCPOption
+		value  string
+	}{
+		{NewDHCPOption(DHCPOptDNS, []byte{8, 8, 8, 8, 1, 1, 1, 1}), "8.8.8.8,1.1.1.1"},
+		{NewDHCPOption(DHCPOptDNS, []byte{8, 8, 8}), "INVALID 0x080808"},
+		{NewDHCPOption(DHCPOptTimeOffset, []byte{0xff, 0xff, 0xf1, 0xf0}), "-3600"},
+		{NewDHCPOption(DHCPOptLeaseTime, []byte{0, 0, 0x0e, 0x10}), "3600 (1h0m0s)"},
+		{NewDHCPOption(DHCPOptLeaseTime, []byte{0xff, 0xff, 0xff, 0xff}), "infinite"},
+		{NewDHCPOption(DHCPOptInterfaceMTU, []byte{0x05, 0xdc}), "1500"},
+		{NewDHCPOption(DHCPOptIPForwarding, []byte{1}), "true"},
+		{NewDHCPOption(DHCPOptStaticRoute, []byte{10, 0, 0, 0, 192, 168, 0, 1}), "10.0.0.0 via 192.168.0.1"},
+		{NewDHCPOption(DHCPOptPathPlateuTableOption, []byte{0, 68, 1, 40}), "68,296"},
+		{NewDHCPOption(DHCPOptMessageType, []byte{byte(DHCPMsgTypeAck)}), "Ack"},
+		{NewDHCPOption(DHCPOptParamsRequest, []byte{1, 3}), "SubnetMask,Router"},
+		{NewDHCPOption(DHCPOptClientID, []byte{1, 2, 0, 0, 0, 0, 1}), "Ethernet 02:00:00:00:00:01"},
+		{NewDHCPOption(DHCPOptClientID, []byte{0, 'i', 'd'}), `"id"`},
+		{NewDHCPOption(DHCPOptVendorOption, []byte{0xff}), "0xff"},
+		{NewDHCPOption(DHCPOptSIPServers, []byte{1, 10, 0, 0, 1}), "10.0.0.1"},
+		{NewDHCPOption(DHCPOpt(254), []byte{1, 2}), "0x0102"},
+	} {
+		if value := test.option.Value(); value != test.value {
+			t.Errorf("%s: expected %q, got %q", test.option.Type, test.value, value)
+		}
+	}
+
+	if value, err := FormatDHCPOptionData([]byte{10, 0, 0, 1}, "hex"); err != nil || value != "0x0a000001" {
+		t.Errorf("got %q, %v", value, err)
+	}
+	if _, err := FormatDHCPOptionData(nil, "nope"); err == nil {
+		t.Error("an unknown format is accepted")
+	}
+}
+
+func TestDHCPv4LongOptions(t *testing.T) {
+	vendor := make([]byte, 600)
+	for i := range vendor {
+		vendor[i] = byte(i)
+	}
+	routes := make([]byte, 0, 400)
+	for i := 0; len(routes)+8 <= cap(routes); i++ {
+		routes = append(routes, 24, 10, byte(i>>8), byte(i), 192, 168, 0, 1)
+	}
+	dhcp := &DHCPv4{Operation: DHCPOpReply, HardwareType: LinkTypeEthernet, Xid: 0x12345678,
+		ClientIP: net.IP{0, 0, 0, 0}, YourClientIP: net.IP{192, 168, 0, 123}, NextServerIP: net.IP{0, 0, 0, 0}, RelayAgentIP: net.IP{0, 0, 0, 0},
+		ClientHWAddr: net.HardwareAddr{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc},
+		ServerName:   make([]byte, 64), File: make([]byte, 128)}
+	dhcp.Options = append(dhcp.Options, NewDHCPOption(DHCPOptMessageType, []byte{byte(DHCPMsgTypeAck)}))
+	dhcp.Options = append(dhcp.Options, NewDHCPOption(DHCPOptVendorOption, vendor))
+	dhcp.Options = append(dhcp.Options, NewDHCPOption(DHCPOptClasslessStaticRoute, routes))
+	dhcp.Options = append(dhcp.Options, NewDHCPOption(DHCPOptServerID, []byte{192, 168, 0, 1}))
+
+	// 600 bytes take 3 instances, 400 bytes 2
+	if want := 240 + 3 + 3*2 + 600 + 2*2 + 400 + 6 + 1; int(dhcp.Len()) != want {
+		t.Errorf("expected length %d, got %d", want, dhcp.Len())
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, dhcp); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if data[243] != byte(DHCPOptVendorOption) || data[244] != 255 || data[244+256] != byte(DHCPOptVendorOption) {
+		t.Errorf("expected option 43 split in instances of 255 bytes, got % x", data[240:250])
+	}
+
+	p2 := gopacket.NewPacket(data, LayerTypeDHCPv4, testDecodeOptions)
+	dhcp2 := p2.Layer(LayerTypeDHCPv4).(*DHCPv4)
+	testDHCPEqual(t, dhcp, dhcp2)
+}
+
+func TestDHCPv4OptionOverload(t *testing.T) {
+	data := make([]byte, 240)
+	data[0], data[1], data[2] = byte(DHCPOpReply), byte(LinkTypeEthernet), 6
+	copy(data[28:], []byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc})
+	// the file field carries the second half of option 43 and the domain name,
+	// the sname field the rest of option 43 behind a pad
+	copy(data[108:], []byte{byte(DHCPOptVendorOption), 2, 3, 4, byte(DHCPOptDomainName), 3, 'l', 'a', 'n', byte(DHCPOptEnd)})
+	copy(data[44:], []byte{byte(DHCPOptPad), byte(DHCPOptVendorOption), 1, 5, byte(DHCPOptEnd)})
+	data[236], data[237], data[238], data[239] = 0x63, 0x82, 0x53, 0x63
+	data = append(data,
+		byte(DHCPOptMessageType), 1, byte(DHCPMsgTypeAck),
+		byte(DHCPOptExtOptions), 1, DHCPOverloadFile|DHCPOverloadServerName,
+		byte(DHCPOptVendorOption), 2, 1, 2,
+		byte(DHCPOptEnd))
+
+	p := gopacket.NewPacket(data, LayerTypeDHCPv4, testDecodeOptions)
+	if p.ErrorLayer() != nil {
+		t.Fatal("failed to decode packet:", p.ErrorLayer().Error())
+	}
+	dhcp := p.Layer(LayerTypeDHCPv4).(*DHCPv4)
+	expected := DHCPOptions{
+		NewDHCPOption(DHCPOptMessageType, []byte{byte(DHCPMsgTypeAck)}),
+		NewDHCPOption(DHCPOptExtOptions, []byte{DHCPOverloadFile | DHCPOverloadServerName}),
+		NewDHCPOption(DHCPOptVendorOption, []byte{1, 2, 3, 4, 5}),
+		NewDHCPOption(DHCPOptDomainName, []byte("lan")),
+	}
+	if len(dhcp.Options) != len(expected) {
+		t.Fatalf("expected options %s, got %s", expected, dhcp.Options)
+	}
+	for i, o := range expected {
+		testDHCPOptionEqual(t, i, o, dhcp.Options[i])
+	}
+	if dhcp.ServerName != nil || dhcp.File != nil {
+		t.Errorf("expected the overloaded sname and file to be cleared, got %q %q", dhcp.ServerName, dhcp.File)
+	}
+}
+
+func TestDHCPv4DecodeMalformed(t *testing.T) {
+	header := func(hlen byte, options ...byte) []byte {
+		data := make([]byte, 240)
+		data[0], data[1], data[2] = byte(DHCPOpReply), byte(LinkTypeEthernet), hlen
+		data[236], data[237], data[238], data[239] = 0x63, 0x82, 0x53, 0x63
+		return append(data, options...)
+	}
+	var tests = []struct {
+		msg  string
+		data []byte
+		err  error
+	}{
+		{"empty packet", nil, DecPacketTooShort},
+		{"truncated header", header(6)[:239], DecPacketTooShort},
+		{"hardware address over chaddr", header(17), DecHardwareLenInvalid},
+		{"magic cookie cut off", header(6)[:236], DecPacketTooShort},
+		{"missing magic cookie", append(header(6)[:236], 1, 2, 3, 4), InvalidMagicCookie},
+		{"option overrun", header(6, byte(DHCPOptHostname), 10, 'a'), DecOptionMalformed},
+		{"truncated option", header(6, byte(DHCPOptHostname)), DecOptionNotEnoughData},
+		{"overloaded file overrun", func() []byte {
+			data := header(6, byte(DHCPOptExtOptions), 1, DHCPOverloadFile, byte(DHCPOptEnd))
+			data[108], data[109] = byte(DHCPOptHostname), 200
+			return data
+		}(), DecOptionMalformed},
+		{"no option", header(16), nil},
+	}
+	for _, test := range tests {
+		d := &DHCPv4{}
+		if err := d.DecodeFromBytes(test.data, gopacket.NilDecodeFeedback); err != test.err {
+			t.Errorf("%s: expected error %v, got %v", test.msg, test.err, err)
+		}
+	}
+}
+
+// fuzzDHCPv4Seeds returns well formed packets the fuzz targets start from
+func fuzzDHCPv4Seeds(f *testing.F) [][]byte {
+	dhcp := &DHCPv4{Operation: DHCPOpReply, HardwareType: LinkTypeEthernet, Xid: 0x12345678,
+		ClientIP: net.IP{0, 0, 0, 0}, YourClientIP: net.IP{192, 168, 0, 123}, NextServerIP: net.IP{0, 0, 0, 0}, RelayAgentIP: net.IP{0, 0, 0, 0},
+		ClientHWAddr: net.HardwareAddr{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc},
+		ServerName:   make([]byte, 64), File: make([]byte, 128)}
+	dhcp.Options = append(dhcp.Options, NewDHCPOption(DHCPOptMessageType, []byte{byte(DHCPMsgTypeAck)}))
+	dhcp.Options = append(dhcp.Options, NewDHCPOption(DHCPOptPad, nil))
+	dhcp.Options = append(dhcp.Options, NewDHCPOption(DHCPOptExtOptions, []byte{DHCPOverloadFile | DHCPOverloadServerName}))
+	dhcp.Options = append(dhcp.Options, NewDHCPOption(DHCPOptClasslessStaticRoute, []byte{8, 10, 10, 0, 0, 1}))
+	dhcp.Options = append(dhcp.Options, NewDHCPOption(DHCPOptVendorOption, make([]byte, 300)))
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, dhcp); err != nil {
+		f.Fatal(err)
+	}
+	return [][]byte{buf.Bytes(), buf.Bytes()[:240]}
+}
+
+func FuzzDHCPv4(f *testing.F) {
+	for _, seed := range fuzzDHCPv4Seeds(f) {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := &DHCPv4{}
+		if err := d.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+			if _, ok := err.(DHCPv4Error); !ok {
+				t.Fatalf("expected a DHCPv4Error, got %T %v", err, err)
+			}
+			return
+		}
+		_ = d.Options.String()
+		buf := gopacket.NewSerializeBuffer()
+		if err := d.SerializeTo(buf, gopacket.SerializeOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		d2 := &DHCPv4{}
+		if err := d2.DecodeFromBytes(buf.Bytes(), gopacket.NilDecodeFeedback); err != nil {
+			t.Fatalf("failed to decode the encoded packet % x: %s", buf.Bytes(), err)
+		}
+		if len(d.Options) != len(d2.Options) {
+			t.Fatalf("expected options %s, got %s", d.Options, d2.Options)
+		}
+		for i, o := range d.Options {
+			testDHCPOptionEqual(t, i, o, d2.Options[i])
+		}
+	})
+}
+
+func FuzzDHCPOptionValue(f *testing.F) {
+	f.Add(byte(DHCPOptRelayAgent), []byte{1, 4, 'e', 't', 'h', '0'})
+	f.Add(byte(DHCPOptDomainSearch), []byte{3, 'l', 'a', 'n', 0, 0xc0, 0})
+	f.Add(byte(DHCPOptClasslessStaticRoute), []byte{24, 10, 0, 0, 10, 0, 0, 1})
+	f.Add(byte(DHCPOptFQDN), []byte{5, 0, 0, 4, 'h', 'o', 's', 't', 0})
+	f.Add(byte(125), []byte{0, 0, 0x0d, 0xe9, 3, 1, 1, 'a'})
+	f.Fuzz(func(t *testing.T, code byte, data []byte) {
+		_ = NewDHCPOption(DHCPOpt(code), data).String()
+		for _, format := range DHCPOptionFormats() {
+			FormatDHCPOptionData(data, format)
+		}
+	})
+}
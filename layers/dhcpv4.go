@@ -9,7 +9,6 @@ package layers
 import (
 	"bytes"
 	"encoding/binary"
-	"encoding/hex"
 	"fmt"
 	"github.com/google/gopacket"
 	"net"
@@ -145,6 +144,12 @@ func (d *DHCPv4) LayerType() gopacket.LayerType { return LayerTypeDHCPv4 }
 // DecodeFromBytes decodes the given bytes into this layer.
 func (d *DHCPv4) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
 	d.Options = d.Options[:0]
+	if len(data) < 240 {
+		return DecPacketTooShort
+	}
+	if data[2] > 16 {
+		return DecHardwareLenInvalid
+	}
 	d.Operation = DHCPOp(data[0])
 	d.HardwareType = LinkType(data[1])
 	d.HardwareLen = data[2]
@@ -168,39 +173,95 @@ func (d *DHCPv4) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error
 		return nil
 	}
 
-	options := data[240:]
+	var err error
+	if d.Options, err = decodeOptionArea(data[240:], d.Options, true); err != nil {
+		return err
+	}
+	// RFC 2131 4.1, option 52 moves options into the file and sname fields,
+	// which are read after the options field in that order, RFC 3396 5
+	if overload := d.overload(); overload != 0 {
+		if overload&DHCPOverloadFile != 0 {
+			if d.Options, err = decodeOptionArea(d.File, d.Options, false); err != nil {
+				return err
+			}
+			d.File = nil
+		}
+		if overload&DHCPOverloadServerName != 0 {
+			if d.Options, err = decodeOptionArea(d.ServerName, d.Options, false); err != nil {
+				return err
+			}
+			d.ServerName = nil
+		}
+	}
+	d.Options = joinOptions(d.Options)
+	return nil
+}
 
-	stop := len(options)
+// decodeOptionArea appends the options of an option area up to its End option,
+// the pad options are kept when keepPad is set
+func decodeOptionArea(area []byte, options DHCPOptions, keepPad bool) (DHCPOptions, error) {
 	start := 0
-	for start < stop {
+	for start < len(area) {
 		o := DHCPOption{}
-		if err := o.decode(options[start:]); err != nil {
-			return err
+		if err := o.decode(area[start:]); err != nil {
+			return options, err
 		}
 		if o.Type == DHCPOptEnd {
 			break
 		}
-		d.Options = append(d.Options, o)
 		// Check if the option is a single byte pad
 		if o.Type == DHCPOptPad {
 			start++
+			if !keepPad {
+				continue
+			}
 		} else {
 			start += int(o.Length) + 2
 		}
+		options = append(options, o)
 	}
-	return nil
+	return options, nil
+}
+
+// joinOptions concatenates the instances of an option split over several,
+// RFC 3396, into the first of them. The joined data is a copy, the
+// other options keep pointing into the packet
+func joinOptions(options DHCPOptions) DHCPOptions {
+	first := make(map[DHCPOpt]int)
+	joined := options[:0]
+	for _, o := range options {
+		if o.Type == DHCPOptPad {
+			joined = append(joined, o)
+			continue
+		}
+		i, ok := first[o.Type]
+		if !ok {
+			first[o.Type] = len(joined)
+			joined = append(joined, o)
+			continue
+		}
+		data := make([]byte, 0, len(joined[i].Data)+len(o.Data))
+		joined[i].Data = append(append(data, joined[i].Data...), o.Data...)
+		joined[i].Length = uint8(len(joined[i].Data))
+	}
+	return joined
+}
+
+// overload returns the value of option 52, 0 when the packet has none
+func (d *DHCPv4) overload() byte {
+	for _, o := range d.Options {
+		if o.Type == DHCPOptExtOptions && len(o.Data) == 1 {
+			return o.Data[0] & (DHCPOverloadFile | DHCPOverloadServerName)
+		}
+	}
+	return 0
 }
 
 // Len returns the length of a DHCPv4 packet.
 func (d *DHCPv4) Len() uint16 {
 	n := uint16(240)
 	for _, o := range d.Options {
-		if o.Type == DHCPOptPad {
-			n++
-		} else {
-			n += uint16(o.Length) + 2
-		}
-
+		n += uint16(o.encodedLen())
 	}
 	n++ // for opt end
 	return n
@@ -237,25 +298,15 @@ func (d *DHCPv4) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.Serialize
 	binary.BigEndian.PutUint32(data[236:240], DHCPMagic)
 
 
-	if len(d.Options) > 0 {
-		offset := 240
-		for _, o := range d.Options {
-			if err := o.encode(data[offset:]); err != nil {
-				return err
-			}
-			// A pad option is only a single byte
-			if o.Type == DHCPOptPad {
-				offset++
-			} else {
-				offset += 2 + len(o.Data)
-			}
-		}
-		optend := NewDHCPOption(DHCPOptEnd, nil)
-		if err := optend.encode(data[offset:]); err != nil {
+	offset := 240
+	for _, o := range d.Options {
+		if err := o.encode(data[offset:]); err != nil {
 			return err
 		}
+		offset += o.encodedLen()
 	}
-	return nil
+	optend := NewDHCPOption(DHCPOptEnd, nil)
+	return optend.encode(data[offset:])
 }
 
 // CanDecode returns the set of layer types that this DecodingLayer can decode.
@@ -747,76 +798,28 @@ func (o DHCPOpt) String() string {
 	}
 }
 
-// DHCPOption rerpresents a DHCP option.
+const (
+	// DHCPOptionMaxLength is the most data one instance of an option holds
+	DHCPOptionMaxLength = 255
+	// DHCPOverloadFile is the bit of option 52 moving options into the file field
+	DHCPOverloadFile = 1
+	// DHCPOverloadServerName is the bit of option 52 moving options into the sname field
+	DHCPOverloadServerName = 2
+)
+
+// DHCPOption rerpresents a DHCP option. Data may be longer than 255 bytes, it
+// is then split over several instances of the option on the wire, RFC 3396,
+// and Length only holds its lower byte.
 type DHCPOption struct {
 	Type   DHCPOpt
 	Length uint8
 	Data   []byte
 }
 
-// String returns a string version of a DHCP Option.
+// String returns a string version of a DHCP Option, the data is rendered
+// in the format of the option, see DHCPOptionFormat.
 func (o DHCPOption) String() string {
-	switch o.Type {
-
-	case DHCPOptHostname, DHCPOptMeritDumpFile, DHCPOptDomainName, DHCPOptRootPath,
-		DHCPOptExtensionsPath, DHCPOptNISDomain, DHCPOptNetBIOSTCPScope, DHCPOptXFontServer,
-		DHCPOptXDisplayManager, DHCPOptMessage, DHCPOptDomainSearch, DHCPOptClassID: // string
-		return fmt.Sprintf("%d (%s): %s", byte(o.Type), o.Type, string(o.Data))
-
-	case DHCPOptMessageType:
-		if len(o.Data) != 1 {
-			return fmt.Sprintf("%d (%s) :INVALID)", byte(o.Type), o.Type)
-		}
-		return fmt.Sprintf("%d (%s): %s", byte(o.Type), o.Type, DHCPMsgType(o.Data[0]))
-
-	case DHCPOptSubnetMask, DHCPOptServerID, DHCPOptBroadcastAddr,
-		DHCPOptSolicitAddr, DHCPOptRequestIP, DHCPOptRouter: // net.IP
-		if len(o.Data) < 4 {
-			return fmt.Sprintf("%d (%s): INVALID)", byte(o.Type), o.Type)
-		}
-		return fmt.Sprintf("%d (%s): %s", byte(o.Type), o.Type, net.IP(o.Data))
-
-	case DHCPOptT1, DHCPOptT2, DHCPOptLeaseTime, DHCPOptPathMTUAgingTimeout,
-		DHCPOptARPTimeout, DHCPOptTCPKeepAliveInt: // uint32
-		if len(o.Data) != 4 {
-			return fmt.Sprintf("%d (%s): INVALID)", byte(o.Type), o.Type)
-		}
-		return fmt.Sprintf("%d (%s): %d", byte(o.Type), o.Type,
-			uint32(o.Data[0])<<24|uint32(o.Data[1])<<16|uint32(o.Data[2])<<8|uint32(o.Data[3]))
-
-	case DHCPOptParamsRequest:
-		buf := &bytes.Buffer{}
-		buf.WriteString(fmt.Sprintf("%d (%s): ", byte(o.Type), o.Type))
-		for i, v := range o.Data {
-			buf.WriteString(DHCPOpt(v).String())
-			if i+1 != len(o.Data) {
-				buf.WriteByte(',')
-			}
-		}
-		return buf.String()
-	case DHCPOptClientID:
-		if len(o.Data) < 2 {
-			return fmt.Sprintf("%d (%s): INVALID", byte(o.Type), o.Type)
-		}
-		return fmt.Sprintf("%d (%s): %v", byte(o.Type), o.Type, o.Data)
-	case DHCPOptDNS:
-		if len(o.Data) % 4 != 0 {
-			return fmt.Sprint("%d (%s): INVALID", byte(o.Type), o.Type)
-		}
-		buf := &bytes.Buffer{}
-		buf.WriteString(fmt.Sprintf("%d (%s): ", byte(o.Type), o.Type))
-		for i := 0; i<len(o.Data); i = i+4 {
-			dns := o.Data[i:i+4]
-			buf.WriteString(net.IP(dns).String())
-			if i+4 != len(o.Data) {
-				buf.WriteByte(',')
-			}
-		}
-		return buf.String()
-
-	default:
-		return fmt.Sprintf("%d (%s): 0x%s", byte(o.Type), o.Type, hex.EncodeToString(o.Data))
-	}
+	return fmt.Sprintf("%d (%s): %s", byte(o.Type), o.Type, o.Value())
 }
 
 // NewDHCPOption constructs a new DHCPOption with a given type and data.
@@ -829,14 +832,41 @@ func NewDHCPOption(t DHCPOpt, data []byte) DHCPOption {
 	return o
 }
 
+// encodedLen returns the length of the option on the wire, the option is split
+// into instances of at most DHCPOptionMaxLength bytes of data
+func (o *DHCPOption) encodedLen() int {
+	switch o.Type {
+	case DHCPOptPad, DHCPOptEnd:
+		return 1
+	}
+	instances := (len(o.Data) + DHCPOptionMaxLength - 1) / DHCPOptionMaxLength
+	if instances == 0 {
+		instances = 1
+	}
+	return 2*instances + len(o.Data)
+}
+
 func (o *DHCPOption) encode(b []byte) error {
 	switch o.Type {
 	case DHCPOptPad, DHCPOptEnd:
 		b[0] = byte(o.Type)
 	default:
-		b[0] = byte(o.Type)
-		b[1] = o.Length
-		copy(b[2:], o.Data)
+		if len(o.Data) <= DHCPOptionMaxLength {
+			b[0] = byte(o.Type)
+			b[1] = uint8(len(o.Data))
+			copy(b[2:], o.Data)
+			return nil
+		}
+		for data := o.Data; len(data) > 0; {
+			n := len(data)
+			if n > DHCPOptionMaxLength {
+				n = DHCPOptionMaxLength
+			}
+			b[0] = byte(o.Type)
+			b[1] = uint8(n)
+			copy(b[2:], data[:n])
+			b, data = b[2+n:], data[n:]
+		}
 	}
 	return nil
 }
@@ -883,5 +913,9 @@ const (
 	DecOptionMalformed = DHCPv4Error("Option is malformed")
 	// InvalidMagicCookie is returned when Magic cookie is missing into BOOTP header
 	InvalidMagicCookie = DHCPv4Error("Bad DHCP header")
+	// DecPacketTooShort is returned when the packet is shorter than its fixed header
+	DecPacketTooShort = DHCPv4Error("Packet too short")
+	// DecHardwareLenInvalid is returned when the hardware address is longer than the chaddr field
+	DecHardwareLenInvalid = DHCPv4Error("Hardware address length over 16")
 )
 
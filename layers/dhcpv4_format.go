@@ -0,0 +1,360 @@
+package layers
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dhcpFormatter renders the data of an option, it fails when the data
+// doesn't fit the format
+type dhcpFormatter func(data []byte) (string, error)
+
+// dhcpFormatters are the formats options are rendered in, keyed by the names
+// --option and --print-only know them by
+var dhcpFormatters = map[string]dhcpFormatter{
+	"ip":          formatIPs,
+	"ipmask":      formatIPPairs("/"),
+	"staticroute": formatIPPairs(" via "),
+	"string":      func(data []byte) (string, error) { return string(data), nil },
+	"hex":         func(data []byte) (string, error) { return "0x" + hex.EncodeToString(data), nil },
+	"bool":        formatBool,
+	"uint8":       formatFixed(1),
+	"uint16":      formatFixed(2),
+	"uint32":      formatFixed(4),
+	"int32":       formatInt32,
+	"uint16s":     formatUint16s,
+	"time":        formatTime,
+	"message":     formatMessageType,
+	"option":      formatParams,
+	"mac":         formatMacs,
+	"clientid":    formatClientID,
+	"empty":       formatEmpty,
+	"sip":         formatSIPServers,
+	"agent":       decodeWith(func() optionDecoder { return &agentInfo{} }),
+	"tlv":         formatTLV,
+	"routes":      decodeWith(func() optionDecoder { return &DHCPClasslessRoutes{} }),
+	"domains":     decodeWith(func() optionDecoder { return &DHCPDomainSearch{} }),
+	"fqdn":        decodeWith(func() optionDecoder { return &DHCPClientFQDN{} }),
+	"userclass":   decodeWith(func() optionDecoder { return &DHCPUserClass{} }),
+	"vivc":        decodeWith(func() optionDecoder { return &DHCPVendorClasses{} }),
+	"vivso":       decodeWith(func() optionDecoder { return &DHCPVendorSpecific{} }),
+}
+
+// dhcpOptionFormats is the format of every option, as the comments of the
+// DHCPOpt constants declare them. The others are rendered in hex
+var dhcpOptionFormats = map[DHCPOpt]string{
+	DHCPOptSubnetMask:            "ip",
+	DHCPOptTimeOffset:            "int32",
+	DHCPOptRouter:                "ip",
+	DHCPOptTimeServer:            "ip",
+	DHCPOptNameServer:            "ip",
+	DHCPOptDNS:                   "ip",
+	DHCPOptLogServer:             "ip",
+	DHCPOptCookieServer:          "ip",
+	DHCPOptLPRServer:             "ip",
+	DHCPOptImpressServer:         "ip",
+	DHCPOptResLocServer:          "ip",
+	DHCPOptHostname:              "string",
+	DHCPOptBootfileSize:          "uint16",
+	DHCPOptMeritDumpFile:         "string",
+	DHCPOptDomainName:            "string",
+	DHCPOptSwapServer:            "ip",
+	DHCPOptRootPath:              "string",
+	DHCPOptExtensionsPath:        "string",
+	DHCPOptIPForwarding:          "bool",
+	DHCPOptSourceRouting:         "bool",
+	DHCPOptPolicyFilter:          "ipmask",
+	DHCPOptDatagramMTU:           "uint16",
+	DHCPOptDefaultTTL:            "uint8",
+	DHCPOptPathMTUAgingTimeout:   "time",
+	DHCPOptPathPlateuTableOption: "uint16s",
+	DHCPOptInterfaceMTU:          "uint16",
+	DHCPOptAllSubsLocal:          "bool",
+	DHCPOptBroadcastAddr:         "ip",
+	DHCPOptMaskDiscovery:         "bool",
+	DHCPOptMaskSupplier:          "bool",
+	DHCPOptRouterDiscovery:       "bool",
+	DHCPOptSolicitAddr:           "ip",
+	DHCPOptStaticRoute:           "staticroute",
+	DHCPOptARPTrailers:           "bool",
+	DHCPOptARPTimeout:            "time",
+	DHCPOptEthernetEncap:         "bool",
+	DHCPOptTCPTTL:                "uint8",
+	DHCPOptTCPKeepAliveInt:       "time",
+	DHCPOptTCPKeepAliveGarbage:   "bool",
+	DHCPOptNISDomain:             "string",
+	DHCPOptNISServers:            "ip",
+	DHCPOptNTPServers:            "ip",
+	DHCPOptVendorOption:          "tlv",
+	DHCPOptNetBIOSTCPNS:          "ip",
+	DHCPOptNetBIOSTCPDDS:         "ip",
+	DHCPOptNETBIOSTCPNodeType:    "uint8",
+	DHCPOptNetBIOSTCPScope:       "string",
+	DHCPOptXFontServer:           "ip",
+	DHCPOptXDisplayManager:       "ip",
+	DHCPOptRequestIP:             "ip",
+	DHCPOptLeaseTime:             "time",
+	DHCPOptExtOptions:            "uint8",
+	DHCPOptMessageType:           "message",
+	DHCPOptServerID:              "ip",
+	DHCPOptParamsRequest:         "option",
+	DHCPOptMessage:               "string",
+	DHCPOptMaxMessageSize:        "uint16",
+	DHCPOptT1:                    "time",
+	DHCPOptT2:                    "time",
+	DHCPOptClassID:               "string",
+	DHCPOptClientID:              "clientid",
+	DHCPOptIPDomainName:          "string",
+	DHCPOptServiceDomain:         "string",
+	DHCPOptServiceServers:        "ip",
+	DHCPOptTFTPServerName:        "string",
+	DHCPOptBootfileName:          "string",
+	DHCPOptMobileIPHomeAgent:     "ip",
+	DHCPOptSMTPServerOption:      "ip",
+	DHCPOptPOP3ServerOption:      "ip",
+	DHCPOptNNTPServerOption:      "ip",
+	DHCPOptWWWServerOption:       "ip",
+	DHCPOptFingerServerOption:    "ip",
+	DHCPOptIRCServerOption:       "ip",
+	DHCPOptStreeTalkServerOption: "ip",
+	DHCPOptSTDAServerOption:      "ip",
+	DHCPOptUserClassInformation:  "userclass",
+	DHCPOptRapidCommit:           "empty",
+	DHCPOptFQDN:                  "fqdn",
+	DHCPOptRelayAgent:            "agent",
+	DHCPOptBCMCSDomainName:       "domains",
+	DHCPOptBCMCSIPv4Adress:       "ip",
+	DHCPOptLastTransactionTime:   "uint32",
+	DHCPOptAssociatedIP:          "ip",
+	DHCPOptIEEE1003_1TZString:    "string",
+	DHCPOptRefToTZDatabase:       "string",
+	DHCPOptURL:                   "string",
+	DHCPOptAutoConfigure:         "uint8",
+	DHCPOptSubnetSelection:       "ip",
+	DHCPOptDomainSearch:          "domains",
+	DHCPOptSIPServers:            "sip",
+	DHCPOptClasslessStaticRoute:  "routes",
+	DHCPOptVendorIdentifyVClass:  "vivc",
+	DHCPOptVendorIdentifySpec:    "vivso",
+	DHCPOptTFTPServerAddress:     "ip",
+}
+
+// DHCPOptionFormat returns the format the option is rendered in by default
+func DHCPOptionFormat(opt DHCPOpt) string {
+	if format, ok := dhcpOptionFormats[opt]; ok {
+		return format
+	}
+	return "hex"
+}
+
+// DHCPOptionFormats returns the names of all the formats
+func DHCPOptionFormats() []string {
+	names := make([]string, 0, len(dhcpFormatters))
+	for name := range dhcpFormatters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FormatDHCPOptionData renders the data of an option in the named format
+func FormatDHCPOptionData(data []byte, format string) (string, error) {
+	formatter, ok := dhcpFormatters[strings.ToLower(format)]
+	if !ok {
+		return "", fmt.Errorf("unknown format %s", format)
+	}
+	return formatter(data)
+}
+
+// Value renders the data of the option in its format, the data which doesn't
+// fit the format is marked INVALID and rendered in hex
+func (o DHCPOption) Value() string {
+	value, err := FormatDHCPOptionData(o.Data, DHCPOptionFormat(o.Type))
+	if err != nil {
+		return "INVALID 0x" + hex.EncodeToString(o.Data)
+	}
+	return value
+}
+
+func invalidLength(data []byte) error {
+	return fmt.Errorf("invalid length %d", len(data))
+}
+
+func formatIPs(data []byte) (string, error) {
+	if len(data) == 0 || len(data)%4 != 0 {
+		return "", invalidLength(data)
+	}
+	ips := make([]string, 0, len(data)/4)
+	for i := 0; i < len(data); i += 4 {
+		ips = append(ips, net.IP(data[i:i+4]).String())
+	}
+	return strings.Join(ips, ","), nil
+}
+
+func formatIPPairs(separator string) dhcpFormatter {
+	return func(data []byte) (string, error) {
+		if len(data) == 0 || len(data)%8 != 0 {
+			return "", invalidLength(data)
+		}
+		pairs := make([]string, 0, len(data)/8)
+		for i := 0; i < len(data); i += 8 {
+			pairs = append(pairs, net.IP(data[i:i+4]).String()+separator+net.IP(data[i+4:i+8]).String())
+		}
+		return strings.Join(pairs, ", "), nil
+	}
+}
+
+func formatBool(data []byte) (string, error) {
+	if len(data) != 1 || data[0] > 1 {
+		return "", invalidLength(data)
+	}
+	return fmt.Sprint(data[0] == 1), nil
+}
+
+// formatFixed renders an unsigned integer of the given size
+func formatFixed(size int) dhcpFormatter {
+	return func(data []byte) (string, error) {
+		if len(data) != size {
+			return "", invalidLength(data)
+		}
+		var value uint64
+		for _, b := range data {
+			value = value<<8 | uint64(b)
+		}
+		return fmt.Sprint(value), nil
+	}
+}
+
+func formatInt32(data []byte) (string, error) {
+	if len(data) != 4 {
+		return "", invalidLength(data)
+	}
+	return fmt.Sprint(int32(binary.BigEndian.Uint32(data))), nil
+}
+
+func formatUint16s(data []byte) (string, error) {
+	if len(data) == 0 || len(data)%2 != 0 {
+		return "", invalidLength(data)
+	}
+	values := make([]string, 0, len(data)/2)
+	for i := 0; i < len(data); i += 2 {
+		values = append(values, fmt.Sprint(binary.BigEndian.Uint16(data[i:])))
+	}
+	return strings.Join(values, ","), nil
+}
+
+// formatTime renders a number of seconds, 0xffffffff is infinite
+func formatTime(data []byte) (string, error) {
+	if len(data) != 4 {
+		return "", invalidLength(data)
+	}
+	seconds := binary.BigEndian.Uint32(data)
+	if seconds == 0xffffffff {
+		return "infinite", nil
+	}
+	return fmt.Sprintf("%d (%s)", seconds, time.Duration(seconds)*time.Second), nil
+}
+
+func formatMessageType(data []byte) (string, error) {
+	if len(data) != 1 {
+		return "", invalidLength(data)
+	}
+	return DHCPMsgType(data[0]).String(), nil
+}
+
+func formatParams(data []byte) (string, error) {
+	params := make([]string, 0, len(data))
+	for _, code := range data {
+		params = append(params, DHCPOpt(code).String())
+	}
+	return strings.Join(params, ","), nil
+}
+
+func formatMacs(data []byte) (string, error) {
+	if len(data) == 0 || len(data)%6 != 0 {
+		return "", invalidLength(data)
+	}
+	macs := make([]string, 0, len(data)/6)
+	for i := 0; i < len(data); i += 6 {
+		macs = append(macs, net.HardwareAddr(data[i:i+6]).String())
+	}
+	return strings.Join(macs, ","), nil
+}
+
+// formatClientID renders the hardware type and the address of a client id,
+// type 0 carries an opaque identifier
+func formatClientID(data []byte) (string, error) {
+	if len(data) < 2 {
+		return "", invalidLength(data)
+	}
+	if data[0] == 0 {
+		return formatBytes(data[1:]), nil
+	}
+	return fmt.Sprintf("%s %s", LinkType(data[0]), net.HardwareAddr(data[1:])), nil
+}
+
+func formatEmpty(data []byte) (string, error) {
+	if len(data) != 0 {
+		return "", invalidLength(data)
+	}
+	return "", nil
+}
+
+// formatSIPServers renders the domain names or the addresses of option 120 - RFC 3361
+func formatSIPServers(data []byte) (string, error) {
+	if len(data) < 2 {
+		return "", invalidLength(data)
+	}
+	switch data[0] {
+	case 0:
+		return decodeWith(func() optionDecoder { return &DHCPDomainSearch{} })(data[1:])
+	case 1:
+		return formatIPs(data[1:])
+	}
+	return "", fmt.Errorf("unknown encoding %d", data[0])
+}
+
+// formatTLV renders option 43 as sub-options when it holds some, the vendors
+// may fill it in any way so it is rendered in hex otherwise
+func formatTLV(data []byte) (string, error) {
+	var s DHCPSubOptions
+	if s.DecodeFromBytes(data) != nil {
+		return "0x" + hex.EncodeToString(data), nil
+	}
+	return s.String(), nil
+}
+
+// agentInfo renders the sub-options of option 82 with their names
+type agentInfo struct {
+	DHCPSubOptions
+}
+
+func (a *agentInfo) DecodeFromBytes(data []byte) error {
+	return a.DHCPSubOptions.DecodeFromBytes(data)
+}
+
+func (a *agentInfo) String() string {
+	return formatAgentInfo(a.DHCPSubOptions)
+}
+
+// optionDecoder decodes the data of a compound option
+type optionDecoder interface {
+	DecodeFromBytes(data []byte) error
+	String() string
+}
+
+// decodeWith renders the data decoded by a new decoder
+func decodeWith(decoder func() optionDecoder) dhcpFormatter {
+	return func(data []byte) (string, error) {
+		d := decoder()
+		if err := d.DecodeFromBytes(data); err != nil {
+			return "", err
+		}
+		return d.String(), nil
+	}
+}
@@ -0,0 +1,582 @@
+package layers
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"unicode"
+)
+
+// DHCPAgentSubOpt is a sub-option of the Relay Agent Information option - RFC 3046
+type DHCPAgentSubOpt byte
+
+// Constants for the DHCPAgentSubOpt type.
+const (
+	DHCPAgentSubOptCircuitID        DHCPAgentSubOpt = 1
+	DHCPAgentSubOptRemoteID         DHCPAgentSubOpt = 2
+	DHCPAgentSubOptLinkSelection    DHCPAgentSubOpt = 5  // RFC 3527
+	DHCPAgentSubOptSubscriberID     DHCPAgentSubOpt = 6  // RFC 3993
+	DHCPAgentSubOptVendorSpecific   DHCPAgentSubOpt = 9  // RFC 4243
+	DHCPAgentSubOptServerIDOverride DHCPAgentSubOpt = 11 // RFC 5107
+)
+
+// String returns a string version of a DHCPAgentSubOpt.
+func (o DHCPAgentSubOpt) String() string {
+	switch o {
+	case DHCPAgentSubOptCircuitID:
+		return "Circuit-ID"
+	case DHCPAgentSubOptRemoteID:
+		return "Remote-ID"
+	case DHCPAgentSubOptLinkSelection:
+		return "Link-Selection"
+	case DHCPAgentSubOptSubscriberID:
+		return "Subscriber-ID"
+	case DHCPAgentSubOptVendorSpecific:
+		return "Vendor-Specific"
+	case DHCPAgentSubOptServerIDOverride:
+		return "Server-ID-Override"
+	default:
+		return fmt.Sprintf("Unknown(%d)", byte(o))
+	}
+}
+
+// DHCPRelayAgentInfo is the content of the Relay Agent Information option (82),
+// empty sub-options are left out of the encoded option
+type DHCPRelayAgentInfo struct {
+	CircuitID []byte
+	RemoteID  []byte
+}
+
+// Encode encodes the sub-options into the data of option 82
+func (r *DHCPRelayAgentInfo) Encode() []byte {
+	var data []byte
+	for _, sub := range []struct {
+		code  DHCPAgentSubOpt
+		value []byte
+	}{
+		{DHCPAgentSubOptCircuitID, r.CircuitID},
+		{DHCPAgentSubOptRemoteID, r.RemoteID},
+	} {
+		if len(sub.value) == 0 {
+			continue
+		}
+		data = append(data, byte(sub.code), byte(len(sub.value)))
+		data = append(data, sub.value...)
+	}
+	return data
+}
+
+// DecodeFromBytes decodes the data of option 82, unknown sub-options are skipped
+func (r *DHCPRelayAgentInfo) DecodeFromBytes(data []byte) error {
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return DecOptionNotEnoughData
+		}
+		length := int(data[1])
+		if len(data) < 2+length {
+			return DecOptionMalformed
+		}
+		switch DHCPAgentSubOpt(data[0]) {
+		case DHCPAgentSubOptCircuitID:
+			r.CircuitID = data[2 : 2+length]
+		case DHCPAgentSubOptRemoteID:
+			r.RemoteID = data[2 : 2+length]
+		}
+		data = data[2+length:]
+	}
+	return nil
+}
+
+// String returns a string version of the relay agent information.
+func (r *DHCPRelayAgentInfo) String() string {
+	return fmt.Sprintf("%s:%q %s:%q", DHCPAgentSubOptCircuitID, r.CircuitID, DHCPAgentSubOptRemoteID, r.RemoteID)
+}
+
+// DHCPSubOption is a code, length, value sub-option as options 43, 82 and 125 carry them
+type DHCPSubOption struct {
+	Code byte
+	Data []byte
+}
+
+// DHCPSubOptions is the content of an option made of sub-options
+type DHCPSubOptions []DHCPSubOption
+
+// Encode encodes the sub-options in turn
+func (s DHCPSubOptions) Encode() ([]byte, error) {
+	var data []byte
+	for _, sub := range s {
+		if len(sub.Data) > 255 {
+			return nil, fmt.Errorf("sub-option %d is %d bytes long, 255 at most", sub.Code, len(sub.Data))
+		}
+		data = append(data, sub.Code, byte(len(sub.Data)))
+		data = append(data, sub.Data...)
+	}
+	return data, nil
+}
+
+// DecodeFromBytes decodes the sub-options of the data
+func (s *DHCPSubOptions) DecodeFromBytes(data []byte) error {
+	*s = nil
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return DecOptionNotEnoughData
+		}
+		length := int(data[1])
+		if len(data) < 2+length {
+			return DecOptionMalformed
+		}
+		*s = append(*s, DHCPSubOption{Code: data[0], Data: data[2 : 2+length]})
+		data = data[2+length:]
+	}
+	return nil
+}
+
+// String returns a string version of the sub-options.
+func (s DHCPSubOptions) String() string {
+	return s.format(func(code byte) string { return fmt.Sprint(code) })
+}
+
+// format prints the sub-options, the codes named by name
+func (s DHCPSubOptions) format(name func(byte) string) string {
+	items := make([]string, 0, len(s))
+	for _, sub := range s {
+		items = append(items, name(sub.Code)+":"+formatBytes(sub.Data))
+	}
+	return strings.Join(items, " ")
+}
+
+// formatAgentInfo prints the sub-options of option 82 with their names
+func formatAgentInfo(s DHCPSubOptions) string {
+	return s.format(func(code byte) string { return DHCPAgentSubOpt(code).String() })
+}
+
+// formatBytes quotes printable data and prints the rest in hex
+func formatBytes(data []byte) string {
+	for _, r := range string(data) {
+		if r == unicode.ReplacementChar || !unicode.IsPrint(r) {
+			return "0x" + hex.EncodeToString(data)
+		}
+	}
+	return fmt.Sprintf("%q", data)
+}
+
+// DHCPClasslessRoute is a route of the Classless Static Route option - RFC 3442
+type DHCPClasslessRoute struct {
+	Destination net.IPNet
+	Router      net.IP
+}
+
+// DHCPClasslessRoutes is the content of the Classless Static Route option (121)
+type DHCPClasslessRoutes []DHCPClasslessRoute
+
+// Encode encodes every route as the width of its mask, the significant octets
+// of its destination and its router
+func (r DHCPClasslessRoutes) Encode() ([]byte, error) {
+	var data []byte
+	for _, route := range r {
+		ones, bits := route.Destination.Mask.Size()
+		destination, router := route.Destination.IP.To4(), route.Router.To4()
+		if bits != 32 || destination == nil || router == nil {
+			return nil, fmt.Errorf("%s via %s is not an ipv4 route", &route.Destination, route.Router)
+		}
+		data = append(data, byte(ones))
+		data = append(data, destination[:(ones+7)/8]...)
+		data = append(data, router...)
+	}
+	return data, nil
+}
+
+// DecodeFromBytes decodes the routes of option 121
+func (r *DHCPClasslessRoutes) DecodeFromBytes(data []byte) error {
+	*r = nil
+	for len(data) > 0 {
+		ones := int(data[0])
+		if ones > 32 {
+			return DecOptionMalformed
+		}
+		significant := (ones + 7) / 8
+		if len(data) < 1+significant+4 {
+			return DecOptionNotEnoughData
+		}
+		destination := make(net.IP, net.IPv4len)
+		copy(destination, data[1:1+significant])
+		*r = append(*r, DHCPClasslessRoute{
+			Destination: net.IPNet{IP: destination, Mask: net.CIDRMask(ones, 32)},
+			Router:      net.IP(data[1+significant : 1+significant+4]),
+		})
+		data = data[1+significant+4:]
+	}
+	return nil
+}
+
+// String returns a string version of the routes.
+func (r DHCPClasslessRoutes) String() string {
+	items := make([]string, 0, len(r))
+	for _, route := range r {
+		items = append(items, fmt.Sprintf("%s via %s", &route.Destination, route.Router))
+	}
+	return strings.Join(items, ", ")
+}
+
+// DHCPDomainSearch is the content of the Domain Search option (119), the
+// names are encoded as in DNS messages with compression - RFC 3397
+type DHCPDomainSearch []string
+
+// Encode encodes the names, a name ending as one encoded before points to it
+func (d DHCPDomainSearch) Encode() ([]byte, error) {
+	var data []byte
+	offsets := make(map[string]int)
+	for _, name := range d {
+		labels := splitLabels(name)
+		pointed := false
+		for i := range labels {
+			suffix := strings.ToLower(strings.Join(labels[i:], "."))
+			if offset, ok := offsets[suffix]; ok {
+				data = append(data, 0xc0|byte(offset>>8), byte(offset))
+				pointed = true
+				break
+			}
+			if len(data) < 0x3fff {
+				offsets[suffix] = len(data)
+			}
+			if len(labels[i]) == 0 || len(labels[i]) > 63 {
+				return nil, fmt.Errorf("invalid label %q in %s", labels[i], name)
+			}
+			data = append(data, byte(len(labels[i])))
+			data = append(data, labels[i]...)
+		}
+		if !pointed {
+			data = append(data, 0)
+		}
+	}
+	return data, nil
+}
+
+// DecodeFromBytes decodes the names of option 119, following the pointers
+func (d *DHCPDomainSearch) DecodeFromBytes(data []byte) error {
+	*d = nil
+	for offset := 0; offset < len(data); {
+		name, next, err := readName(data, offset)
+		if err != nil {
+			return err
+		}
+		*d = append(*d, name)
+		offset = next
+	}
+	return nil
+}
+
+// String returns a string version of the search list.
+func (d DHCPDomainSearch) String() string {
+	return strings.Join(d, ", ")
+}
+
+// readName reads the name at offset and returns the offset following it. The
+// pointers may only point backwards, so that they can't loop
+func readName(data []byte, offset int) (string, int, error) {
+	var labels []string
+	next := -1
+	for limit := offset; ; {
+		if offset >= len(data) {
+			return "", 0, DecOptionNotEnoughData
+		}
+		length := int(data[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case length&0xc0 == 0xc0:
+			if offset+1 >= len(data) {
+				return "", 0, DecOptionNotEnoughData
+			}
+			pointer := (length&0x3f)<<8 | int(data[offset+1])
+			if pointer >= limit {
+				return "", 0, DecOptionMalformed
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset, limit = pointer, pointer
+		case length > 63:
+			return "", 0, DecOptionMalformed
+		default:
+			if offset+1+length > len(data) {
+				return "", 0, DecOptionNotEnoughData
+			}
+			labels = append(labels, string(data[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
+
+// splitLabels splits a name into its labels, the trailing dot of a fully
+// qualified name is dropped
+func splitLabels(name string) []string {
+	return strings.Split(strings.TrimSuffix(name, "."), ".")
+}
+
+// Flags of the Client FQDN option - RFC 4702 2.1
+const (
+	DHCPFQDNServerUpdate byte = 1 << 0 // S
+	DHCPFQDNOverride     byte = 1 << 1 // O
+	DHCPFQDNEncoded      byte = 1 << 2 // E
+	DHCPFQDNNoUpdate     byte = 1 << 3 // N
+)
+
+// fqdnFlags are the letters of the flags from the lowest bit
+const fqdnFlags = "SOEN"
+
+// DHCPClientFQDN is the content of the Client FQDN option (81) - RFC 4702
+type DHCPClientFQDN struct {
+	Flags  byte
+	RCode1 byte
+	RCode2 byte
+	// Name is fully qualified when it ends with a dot, a partial name otherwise
+	Name string
+}
+
+// ParseDHCPFQDNFlags parses the letters of the flags, e.g. "SE"
+func ParseDHCPFQDNFlags(letters string) (byte, error) {
+	var flags byte
+	for _, letter := range strings.ToUpper(letters) {
+		bit := strings.IndexRune(fqdnFlags, letter)
+		if bit < 0 {
+			return 0, fmt.Errorf("unknown fqdn flag %c, want one of %s", letter, fqdnFlags)
+		}
+		flags |= 1 << uint(bit)
+	}
+	return flags, nil
+}
+
+// Encode encodes the name in the canonical wire format when the E flag is
+// set and in ascii otherwise
+func (f *DHCPClientFQDN) Encode() ([]byte, error) {
+	data := []byte{f.Flags, f.RCode1, f.RCode2}
+	if f.Flags&DHCPFQDNEncoded == 0 {
+		return append(data, f.Name...), nil
+	}
+	if len(f.Name) == 0 {
+		return data, nil
+	}
+	for _, label := range splitLabels(f.Name) {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("invalid label %q in %s", label, f.Name)
+		}
+		data = append(data, byte(len(label)))
+		data = append(data, label...)
+	}
+	if strings.HasSuffix(f.Name, ".") {
+		data = append(data, 0)
+	}
+	return data, nil
+}
+
+// DecodeFromBytes decodes the data of option 81
+func (f *DHCPClientFQDN) DecodeFromBytes(data []byte) error {
+	if len(data) < 3 {
+		return DecOptionNotEnoughData
+	}
+	f.Flags, f.RCode1, f.RCode2 = data[0], data[1], data[2]
+	if f.Flags&DHCPFQDNEncoded == 0 {
+		f.Name = string(data[3:])
+		return nil
+	}
+	var labels []string
+	for name := data[3:]; len(name) > 0; {
+		length := int(name[0])
+		if length == 0 {
+			labels = append(labels, "")
+			break
+		}
+		if length > 63 || len(name) < 1+length {
+			return DecOptionMalformed
+		}
+		labels = append(labels, string(name[1:1+length]))
+		name = name[1+length:]
+	}
+	f.Name = strings.Join(labels, ".")
+	return nil
+}
+
+// String returns a string version of the client fqdn.
+func (f *DHCPClientFQDN) String() string {
+	var letters []byte
+	for bit := range fqdnFlags {
+		if f.Flags&(1<<uint(bit)) != 0 {
+			letters = append(letters, fqdnFlags[bit])
+		}
+	}
+	return fmt.Sprintf("flags=%s rcode=%d/%d %s", letters, f.RCode1, f.RCode2, f.Name)
+}
+
+// DHCPUserClass is the content of the User Class option (77), a list of
+// opaque classes - RFC 3004
+type DHCPUserClass [][]byte
+
+// Encode encodes every class with its length
+func (u DHCPUserClass) Encode() ([]byte, error) {
+	var data []byte
+	for _, class := range u {
+		if len(class) == 0 || len(class) > 255 {
+			return nil, fmt.Errorf("user class %q is %d bytes long, want 1 to 255", class, len(class))
+		}
+		data = append(data, byte(len(class)))
+		data = append(data, class...)
+	}
+	return data, nil
+}
+
+// DecodeFromBytes decodes the classes of option 77
+func (u *DHCPUserClass) DecodeFromBytes(data []byte) error {
+	classes, err := decodeOpaque(data)
+	*u = classes
+	return err
+}
+
+// String returns a string version of the user classes.
+func (u DHCPUserClass) String() string {
+	return formatOpaque(u)
+}
+
+// DHCPVendorClass is the vendor class data of one enterprise in the
+// V-I Vendor Class option (124) - RFC 3925
+type DHCPVendorClass struct {
+	Enterprise uint32
+	Data       [][]byte
+}
+
+// DHCPVendorClasses is the content of option 124
+type DHCPVendorClasses []DHCPVendorClass
+
+// Encode encodes every enterprise number followed by its class data
+func (v DHCPVendorClasses) Encode() ([]byte, error) {
+	var data []byte
+	for _, class := range v {
+		classData, err := DHCPUserClass(class.Data).Encode()
+		if err != nil {
+			return nil, err
+		}
+		if data, err = appendEnterprise(data, class.Enterprise, classData); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// DecodeFromBytes decodes the data of option 124
+func (v *DHCPVendorClasses) DecodeFromBytes(data []byte) error {
+	*v = nil
+	return readEnterprises(data, func(enterprise uint32, block []byte) error {
+		classes, err := decodeOpaque(block)
+		*v = append(*v, DHCPVendorClass{Enterprise: enterprise, Data: classes})
+		return err
+	})
+}
+
+// String returns a string version of the vendor classes.
+func (v DHCPVendorClasses) String() string {
+	items := make([]string, 0, len(v))
+	for _, class := range v {
+		items = append(items, fmt.Sprintf("%d:%s", class.Enterprise, formatOpaque(class.Data)))
+	}
+	return strings.Join(items, " ")
+}
+
+// DHCPVendorOptions is the vendor options of one enterprise in the V-I
+// Vendor-Specific Information option (125) - RFC 3925
+type DHCPVendorOptions struct {
+	Enterprise uint32
+	Options    DHCPSubOptions
+}
+
+// DHCPVendorSpecific is the content of option 125
+type DHCPVendorSpecific []DHCPVendorOptions
+
+// Encode encodes every enterprise number followed by its sub-options
+func (v DHCPVendorSpecific) Encode() ([]byte, error) {
+	var data []byte
+	for _, vendor := range v {
+		options, err := vendor.Options.Encode()
+		if err != nil {
+			return nil, err
+		}
+		if data, err = appendEnterprise(data, vendor.Enterprise, options); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// DecodeFromBytes decodes the data of option 125
+func (v *DHCPVendorSpecific) DecodeFromBytes(data []byte) error {
+	*v = nil
+	return readEnterprises(data, func(enterprise uint32, block []byte) error {
+		var options DHCPSubOptions
+		err := options.DecodeFromBytes(block)
+		*v = append(*v, DHCPVendorOptions{Enterprise: enterprise, Options: options})
+		return err
+	})
+}
+
+// String returns a string version of the vendor options.
+func (v DHCPVendorSpecific) String() string {
+	items := make([]string, 0, len(v))
+	for _, vendor := range v {
+		items = append(items, fmt.Sprintf("%d:[%s]", vendor.Enterprise, vendor.Options))
+	}
+	return strings.Join(items, " ")
+}
+
+// appendEnterprise appends an enterprise number and the block of its data
+func appendEnterprise(data []byte, enterprise uint32, block []byte) ([]byte, error) {
+	if len(block) > 255 {
+		return nil, fmt.Errorf("the data of enterprise %d is %d bytes long, 255 at most", enterprise, len(block))
+	}
+	data = append(data, 0, 0, 0, 0, byte(len(block)))
+	binary.BigEndian.PutUint32(data[len(data)-5:], enterprise)
+	return append(data, block...), nil
+}
+
+// readEnterprises hands the block of every enterprise of options 124 and 125 to read
+func readEnterprises(data []byte, read func(uint32, []byte) error) error {
+	for len(data) > 0 {
+		if len(data) < 5 {
+			return DecOptionNotEnoughData
+		}
+		length := int(data[4])
+		if len(data) < 5+length {
+			return DecOptionMalformed
+		}
+		if err := read(binary.BigEndian.Uint32(data), data[5:5+length]); err != nil {
+			return err
+		}
+		data = data[5+length:]
+	}
+	return nil
+}
+
+// decodeOpaque decodes a list of length prefixed data
+func decodeOpaque(data []byte) ([][]byte, error) {
+	var items [][]byte
+	for len(data) > 0 {
+		length := int(data[0])
+		if length == 0 || len(data) < 1+length {
+			return items, DecOptionMalformed
+		}
+		items = append(items, data[1:1+length])
+		data = data[1+length:]
+	}
+	return items, nil
+}
+
+func formatOpaque(items [][]byte) string {
+	formatted := make([]string, 0, len(items))
+	for _, item := range items {
+		formatted = append(formatted, formatBytes(item))
+	}
+	return strings.Join(formatted, ",")
+}
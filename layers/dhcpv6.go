@@ -8,7 +8,6 @@ package layers
 
 import (
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"net"
 
@@ -90,10 +89,16 @@ func (d *DHCPv6) LayerType() gopacket.LayerType { return LayerTypeDHCPv6 }
 func (d *DHCPv6) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
 	d.BaseLayer = BaseLayer{Contents: data}
 	d.Options = d.Options[:0]
+	if len(data) < 4 {
+		return DecPacketTooShort
+	}
 	d.MsgType = DHCPv6MsgType(data[0])
 
 	offset := 0
 	if d.MsgType == DHCPv6MsgTypeRelayForward || d.MsgType == DHCPv6MsgTypeRelayReply {
+		if len(data) < 34 {
+			return DecPacketTooShort
+		}
 		d.HopCount = data[1]
 		d.LinkAddr = net.IP(data[2:18])
 		d.PeerAddr = net.IP(data[18:34])
@@ -261,10 +266,19 @@ type DHCPv6DUID struct {
 // DecodeFromBytes decodes the given bytes into a DHCPv6DUID
 func (d *DHCPv6DUID) DecodeFromBytes(data []byte) error {
 	if len(data) < 2 {
-		return errors.New("Not enough bytes to decode: " + string(len(data)))
+		return fmt.Errorf("Not enough bytes to decode: %d", len(data))
 	}
 
 	d.Type = DHCPv6DUIDType(binary.BigEndian.Uint16(data[:2]))
+	minLength := 4 // LL
+	if d.Type == DHCPv6DUIDTypeLLT {
+		minLength = 8
+	} else if d.Type == DHCPv6DUIDTypeEN {
+		minLength = 6
+	}
+	if len(data) < minLength {
+		return fmt.Errorf("Not enough bytes to decode %s DUID: %d", d.Type, len(data))
+	}
 	if d.Type == DHCPv6DUIDTypeLLT || d.Type == DHCPv6DUIDTypeLL {
 		d.HardwareType = data[2:4]
 	}
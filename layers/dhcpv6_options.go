@@ -9,7 +9,6 @@ package layers
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"github.com/google/gopacket"
 )
@@ -571,7 +570,7 @@ func (o DHCPv6Option) String() string {
 		return fmt.Sprintf("Option(%s:[%s])", o.Code, duid.String())
 	case DHCPv6OptOro:
 		options := ""
-		for i := 0; i < int(o.Length); i += 2 {
+		for i := 0; i+1 < len(o.Data); i += 2 {
 			if options != "" {
 				options += ","
 			}
@@ -608,14 +607,14 @@ func (o *DHCPv6Option) encode(b []byte, opts gopacket.SerializeOptions) error {
 }
 
 func (o *DHCPv6Option) decode(data []byte) error {
-	if len(data) < 2 {
-		return errors.New("not enough data to decode")
+	if len(data) < 4 {
+		return DecOptionNotEnoughData
 	}
 	o.Code = DHCPv6Opt(binary.BigEndian.Uint16(data[0:2]))
-	if len(data) < 3 {
-		return errors.New("not enough data to decode")
-	}
 	o.Length = binary.BigEndian.Uint16(data[2:4])
-	o.Data = data[4 : 4+o.Length]
+	if int(o.Length) > len(data[4:]) {
+		return DecOptionMalformed
+	}
+	o.Data = data[4 : 4+int(o.Length)]
 	return nil
 }
@@ -8,6 +8,7 @@ package layers
 
 import (
 	"bytes"
+	"net"
 	"testing"
 
 	"github.com/google/gopacket"
@@ -91,3 +92,30 @@ func testDHCPv6OptionEqual(t *testing.T, idx int, d1, d2 DHCPv6Option) {
 		t.Errorf("expection Options[%d].Data to be = %v, got %v", idx, d1.Data, d2.Data)
 	}
 }
+
+func FuzzDHCPv6(f *testing.F) {
+	client := &DHCPv6DUID{Type: DHCPv6DUIDTypeLL, HardwareType: []byte{0, 1}, LinkLayerAddress: []byte{8, 0, 39, 254, 143, 149}}
+	for _, dhcpv6 := range []*DHCPv6{
+		{MsgType: DHCPv6MsgTypeReply, TransactionID: []byte{87, 25, 88}, Options: DHCPv6Options{
+			NewDHCPv6Option(DHCPv6OptClientID, client.Encode()),
+			NewDHCPv6Option(DHCPv6OptOro, []byte{0, 23, 0, 24}),
+		}},
+		{MsgType: DHCPv6MsgTypeRelayForward, LinkAddr: net.IPv6loopback, PeerAddr: net.IPv6loopback},
+	} {
+		buf := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, dhcpv6); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := &DHCPv6{}
+		if err := d.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+			if _, ok := err.(DHCPv4Error); !ok {
+				t.Fatalf("expected a typed error, got %T %v", err, err)
+			}
+			return
+		}
+		_ = d.Options.String()
+	})
+}
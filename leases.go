@@ -0,0 +1,188 @@
+package main
+
+import (
+	"dhcptest/connection"
+	"dhcptest/utility"
+	"fmt"
+	"github.com/pinterest/bender"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// newLeaseStore opens the --lease-file, the leases are kept in memory only
+// when it is not set
+func newLeaseStore() (*connection.LeaseStore, error) {
+	if len(utility.LeaseFile) == 0 {
+		return connection.NewLeaseStore(), nil
+	}
+	store, err := connection.OpenLeaseStore(utility.LeaseFile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", utility.LeaseFile, err)
+	}
+	return store, nil
+}
+
+// renewClient starts the transactions of the devices with the renew of their
+// stored lease, unicast to the server of the lease
+type renewClient struct {
+	v4Client
+	leases map[string]connection.StoredLease
+}
+
+// renewRequest is the stored lease a device renews and the options of the device
+type renewRequest struct {
+	lease  connection.StoredLease
+	device connection.Modifier
+}
+
+func (c renewClient) newRequest(index int, mac net.HardwareAddr) interface{} {
+	device, err := c.device(index, mac)
+	if err != nil {
+		return err
+	}
+	return renewRequest{lease: c.leases[mac.String()], device: device}
+}
+
+func (c renewClient) executor() bender.RequestExecutor {
+	return func(_ int64, request interface{}) (interface{}, error) {
+		if err, ok := request.(error); ok {
+			c.failed(err)
+			return nil, err
+		}
+		renew, ok := request.(renewRequest)
+		if !ok {
+			return nil, fmt.Errorf("invalid request type %T, want: renewRequest", request)
+		}
+		return c.RenewLease(renew.lease, renew.device), nil
+	}
+}
+
+// leaseStates are the states a lease filter can name
+var leaseStates = []string{"bound", "renewing", "rebinding", connection.LeaseExpired}
+
+// matchLeases returns the leases matching the filter as a whole: a mac, an
+// address equal to the address or the server id of the lease, a cidr holding
+// one of them, or a state. All of them match an empty filter
+func matchLeases(leases []connection.StoredLease, filter string, now time.Time) ([]connection.StoredLease, error) {
+	var match func(lease connection.StoredLease) bool
+	filter = strings.TrimSpace(filter)
+	if mac, err := net.ParseMAC(filter); err == nil {
+		match = func(lease connection.StoredLease) bool {
+			leaseMac, _ := lease.HardwareAddrs()
+			return leaseMac.String() == mac.String()
+		}
+	} else if ip := net.ParseIP(filter); ip != nil {
+		match = func(lease connection.StoredLease) bool {
+			return lease.Address.Equal(ip) || lease.ServerID.Equal(ip)
+		}
+	} else if _, subnet, err := net.ParseCIDR(filter); err == nil {
+		match = func(lease connection.StoredLease) bool {
+			return subnet.Contains(lease.Address) || subnet.Contains(lease.ServerID)
+		}
+	} else if len(filter) == 0 {
+		match = func(connection.StoredLease) bool { return true }
+	} else {
+		for _, state := range leaseStates {
+			if strings.EqualFold(filter, state) {
+				match = func(lease connection.StoredLease) bool { return lease.State(now) == state }
+				break
+			}
+		}
+		if match == nil {
+			return nil, fmt.Errorf("invalid lease filter %q, want a mac, an address, a cidr or one of %s", filter, strings.Join(leaseStates, ", "))
+		}
+	}
+
+	var matched []connection.StoredLease
+	for _, lease := range leases {
+		if match(lease) {
+			matched = append(matched, lease)
+		}
+	}
+	return matched, nil
+}
+
+func printLeases(leases []connection.StoredLease, now time.Time) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MAC\tADDRESS\tSERVER\tSTATE\tEXPIRE")
+	for _, lease := range leases {
+		expire := "never"
+		if !lease.Expire.IsZero() {
+			expire = lease.Expire.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", lease.MAC, lease.Address, lease.ServerID, lease.State(now), expire)
+	}
+	w.Flush()
+	fmt.Printf("%d leases\n", len(leases))
+}
+
+// leasesCommand lists, renews or releases the stored leases matching the
+// filter: "leases [list|renew|release] [FILTER]"
+func leasesCommand(params []string, dc client) error {
+	v4, ok := dc.(v4Client)
+	if !ok {
+		return fmt.Errorf("leases are only kept with dhcpv4")
+	}
+	action, filter := "list", ""
+	if len(params) >= 2 {
+		action = params[1]
+	}
+	if len(params) >= 3 {
+		filter = params[2]
+	}
+	if len(params) > 3 {
+		return fmt.Errorf("usage: leases [list|renew|release] [FILTER]")
+	}
+	now := time.Now()
+	leases, err := matchLeases(v4.Leases.List(), filter, now)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "list":
+		printLeases(leases, now)
+		return nil
+	case "renew", "release":
+	default:
+		return fmt.Errorf("unknown leases action %q, want list, renew or release", action)
+	}
+
+	//expired leases are given up already
+	var held []connection.StoredLease
+	for _, lease := range leases {
+		if lease.State(now) != connection.LeaseExpired {
+			held = append(held, lease)
+		}
+	}
+	if len(held) == 0 {
+		return fmt.Errorf("no unexpired lease matches %q", filter)
+	}
+
+	if action == "release" {
+		released := 0
+		for _, lease := range held {
+			if err := v4.ReleaseLease(lease); err != nil {
+				log.Printf("release %s of %s: %s", lease.Address, lease.MAC, err)
+				continue
+			}
+			released++
+		}
+		fmt.Printf("%d leases released\n", released)
+		return v4.Leases.Save()
+	}
+
+	byMac := make(map[string]connection.StoredLease)
+	var macList []net.HardwareAddr
+	for _, lease := range held {
+		mac, _ := lease.HardwareAddrs()
+		byMac[lease.MAC] = lease
+		macList = append(macList, mac)
+	}
+	fmt.Printf("%d terminals renew\n", len(macList))
+	return startDHCP(renewClient{v4, byMac}, macList, "", true)
+}
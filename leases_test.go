@@ -0,0 +1,111 @@
+package main
+
+import (
+	"dhcptest/connection"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchLeases(t *testing.T) {
+	now := time.Unix(10000, 0)
+	lease := func(mac string, address string, expire time.Duration) connection.StoredLease {
+		return connection.StoredLease{
+			MAC:      mac,
+			Address:  net.ParseIP(address),
+			ServerID: net.ParseIP("10.0.0.254"),
+			Bound:    now.Add(-time.Hour),
+			Renew:    now.Add(expire / 2),
+			Rebind:   now.Add(expire * 7 / 8),
+			Expire:   now.Add(expire),
+		}
+	}
+	leases := []connection.StoredLease{
+		lease("02:00:00:00:00:01", "10.0.0.1", time.Hour),
+		lease("02:00:00:00:00:10", "10.0.0.10", time.Hour),
+		lease("02:00:00:00:00:11", "10.0.0.11", -time.Hour),
+		lease("02:00:00:00:01:00", "110.0.0.1", time.Hour),
+	}
+	macs := func(matched []connection.StoredLease) string {
+		var list []string
+		for _, l := range matched {
+			list = append(list, l.MAC[len(l.MAC)-5:])
+		}
+		return strings.Join(list, " ")
+	}
+
+	for _, c := range []struct {
+		filter string
+		want   string
+	}{
+		{"", "00:01 00:10 00:11 01:00"},
+		{"10.0.0.1", "00:01"},
+		{"02:00:00:00:00:01", "00:01"},
+		{"02-00-00-00-00-01", "00:01"},
+		{"10.0.0.0/28", "00:01 00:10 00:11"},
+		{"10.0.0.254", "00:01 00:10 00:11 01:00"},
+		{"expired", "00:11"},
+		{"Bound", "00:01 00:10 01:00"},
+		{"110.0.0.0/8", "01:00"},
+	} {
+		matched, err := matchLeases(leases, c.filter, now)
+		if err != nil || macs(matched) != c.want {
+			t.Errorf("%q matches %q: %v, want %q", c.filter, macs(matched), err, c.want)
+		}
+	}
+	for _, filter := range []string{"10.0.0.", "02:00:00", "bou", "10.0.0.0/33"} {
+		if matched, err := matchLeases(leases, filter, now); err == nil {
+			t.Errorf("%q accepted, matches %q", filter, macs(matched))
+		}
+	}
+}
+
+func TestQuitStopsTheRun(t *testing.T) {
+	macs := []net.HardwareAddr{{0x02, 0, 0, 0, 0, 1}, {0x02, 0, 0, 0, 0, 2}}
+	stops := func(dc *fakeClient) int {
+		dc.lock.Lock()
+		defer dc.lock.Unlock()
+		return dc.stops
+	}
+
+	//the client saves the lease store and writes the total summary when it
+	//stops, q and ctrl-c stop the run of the prompt without an s
+	for _, rate := range []string{"", "50"} {
+		dc := &fakeClient{started: make(map[int]int)}
+		if err := startDHCP(dc, macs, rate, true); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		runs.stop()
+		if n := stops(dc); n != 1 {
+			t.Errorf("rate %q: the client stopped %d times on quit, want once", rate, n)
+		}
+	}
+
+	dc := &fakeClient{started: make(map[int]int)}
+	clientMacs = macs
+	defer func() {
+		clientMacs = nil
+	}()
+	if err := concurrentDHCP([]string{"c", "2"}, dc); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	runs.stop()
+	if n := stops(dc); n != 1 {
+		t.Errorf("the concurrent run stopped %d times on quit, want once", n)
+	}
+
+	//no run, nothing to stop
+	done := make(chan struct{})
+	go func() {
+		runs.stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("quitting without a run blocks")
+	}
+}
@@ -8,11 +8,14 @@ import (
 	"fmt"
 	"github.com/pinterest/bender"
 	"log"
-	"math/rand"
+	"math"
 	"net"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
@@ -20,8 +23,41 @@ var (
 	intervalC chan int
 	loggerC chan int
 	clientMacs []net.HardwareAddr
+	macGenerator *utility.MacGenerator
+	exporter *connection.Exporter
+	runs session
 )
 
+// session tracks the run started from the prompt. The client of a run saves
+// the leases, releases them with --release and writes the total summary when
+// it stops, so q and ctrl-c stop the run as s does
+type session struct {
+	lock sync.Mutex
+	done chan struct{}
+}
+
+// begin records a run, the run closes the channel once its client has stopped
+func (s *session) begin() chan struct{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.done = make(chan struct{})
+	return s.done
+}
+
+// stop stops the run going on, if any, and waits for its client to stop
+func (s *session) stop() {
+	s.lock.Lock()
+	done := s.done
+	s.done = nil
+	s.lock.Unlock()
+	if done == nil {
+		return
+	}
+	intervalC <- 1
+	loggerC <- 1
+	<-done
+}
+
 func init() {
 	intervalC = make(chan int)
 	loggerC = make(chan int)
@@ -30,6 +66,7 @@ func init() {
 }
 
 func main() {
+	utility.ParseCommandLine()
 
 	//bind ip
 	iface, err :=utility.GetInterfaceByName(utility.BindIface, utility.ValidIface)
@@ -38,6 +75,13 @@ func main() {
 		return
 	}
 
+	if len(utility.Args) > 0 && utility.Args[0] == "serve" {
+		if err := serve(iface); err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
 	//mac
 	for _, mac := range utility.BindMac {
 		clientMac, err := net.ParseMAC(mac)
@@ -47,11 +91,17 @@ func main() {
 		}
 		clientMacs = append(clientMacs, clientMac)
 	}
+	macGenerator, err = utility.NewMacGenerator()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	macGenerator.Reserve(clientMacs...)
 
 	//option
 	parser := &utility.Parser{}
 	parser.Init()
-	utility.DhcpOptions, err =  parser.Parse(utility.Option)
+	utility.DhcpOptions, utility.OptionTemplates, err =  parser.Parse(utility.Option)
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -61,6 +111,13 @@ func main() {
 	//dhcpOptions = append(dhcpOptions, layers.NewDHCPOption(layers.DHCPOptClientID, clientID))
 
 	fmt.Printf("dhcpOptions: %+v\n", utility.DhcpOptions)
+	if len(utility.PrintOnly) > 0 {
+		utility.PrintOption, utility.PrintFormat, err = utility.ParsePrintOnly(utility.PrintOnly)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
 	/*
 	hostname, err := os.Hostname()
 	if err != nil {
@@ -69,16 +126,121 @@ func main() {
 	}
 	*/
 
-	dc := &connection.DhcpClient{
-		//ClientMac: clientMac,
-		Iface:     iface,
+	if err := checkSpacing(); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	exporter, err = newExporter()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer exporter.Close()
+	metrics, err := newMetrics()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	capture, err := newCapture(iface)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer closeCapture(capture)
+	validator, err := newValidator()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	var dc client
+	if utility.V6 {
+		var duidType layers.DHCPv6DUIDType
+		duidType, err = connection.ParseDUIDType(utility.DUID)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if validator != nil {
+			log.Println("the validation rules are for dhcpv4, the dhcpv6 replies are not checked")
+			validator = nil
+		}
+		v6 := &connection.DhcpV6Client{
+			Iface:    iface,
+			DUIDType: duidType,
+			Exporter: exporter,
+			Metrics:  metrics,
+			Capture:  capture,
+		}
+		err = v6.Open()
+		dc = v6Client{v6}
+	} else {
+		var relay *connection.Relay
+		relay, err = newRelay()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		var leases *connection.LeaseStore
+		leases, err = newLeaseStore()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		v4 := &connection.DhcpClient{
+			//ClientMac: clientMac,
+			Iface:     iface,
+			Relay:     relay,
+			Identity:  newIdentity(),
+			Exporter:  exporter,
+			Metrics:   metrics,
+			Leases:    leases,
+			Capture:   capture,
+			Validator: validator,
+		}
+		err = v4.Open()
+		dc = v4Client{v4}
 	}
-	err = dc.Open()
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 	defer dc.Close()
+	//exit tears the client down as the deferred calls do, os.Exit skips them
+	exit := func(code int) {
+		runs.stop()
+		dc.Close()
+		code = reportConformance(validator, code)
+		closeCapture(capture)
+		exporter.Close()
+		os.Exit(code)
+	}
+
+	if len(utility.Args) > 0 && utility.Args[0] == "replay" {
+		exit(runReplay(dc, utility.Args[1:]))
+	}
+
+	if len(utility.Args) > 0 && utility.Args[0] == "audit" {
+		exit(runAudit(dc, utility.Args[1:]))
+	}
+
+	if len(utility.Args) > 0 && utility.Args[0] == "fuzz" {
+		exit(runFuzz(dc, utility.Args[1:]))
+	}
+
+	if len(utility.Scenario) > 0 {
+		exit(runScenario(dc, utility.Scenario))
+	}
+
+	//ctrl-c ends the interactive and the load runs as q does, so the frames
+	//queued for the capture and the export are written out
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		exit(exitOK)
+	}()
 
 	inputReader := bufio.NewReader(os.Stdin)
 	fmt.Println("Type \"d\" to broadcast a DHCP discover packet, or \"help\" for details")
@@ -96,6 +258,8 @@ func main() {
 		command := params[0]
 		switch command {
 		case "q", "quit":
+			runs.stop()
+			reportConformance(validator, exitOK)
 			return
 		case "h", "help":
 			fmt.Println("Commands:")
@@ -105,11 +269,29 @@ func main() {
 				"\t\t to use for the throughput testing, e.g.\n" +
 				"\t\t \"d 5 100\" will pretend 5 terminals and request\n" +
 				"\t\t 100 times per second.\n" +
+				"\t\t The rate can also be a load profile, e.g. \"d 5 ramp:10,500,5m\",\n" +
+				"\t\t step:FROM,STEP,PERIOD[,MAX], ramp:FROM,TO,DURATION and sine:BASE,AMPLITUDE,PERIOD\n" +
+				"\t\t are supported, --spacing tells how the requests of a second are spaced.\n" +
 				"\t\t You can also only specify the device num to use for one-time request\n" +
 				"\t\t dhcp packet message will be printed.The default value is 1 when the device num is omitted\n")
 			fmt.Printf("\t r / request\n" +
 				"\t\t Broadcast a DHCP discover.Then broadcast a DHCP request packet when you gen an offer packet.\n" +
 				"\t\t You can also specify parameters as d command does.\n")
+			fmt.Printf("\t b / reboot\n" +
+				"\t\t The terminals which got an address send an INIT-REBOOT request for it,\n" +
+				"\t\t e.g. \"b\" reboots all of them once, \"b 50 200\" reboots 50 of them 200 times per second.\n")
+			fmt.Printf("\t i / inform\n" +
+				"\t\t Send a DHCP inform from the address of --ciaddr, the following terminals inform\n" +
+				"\t\t from the following addresses. You can also specify parameters as d command does.\n")
+			fmt.Printf("\t l / leases [list|renew|release] [FILTER]\n" +
+				"\t\t List, renew or release the leases of the terminals, the FILTER is a whole mac,\n" +
+				"\t\t an address matching the address or the server id of a lease, a cidr or a state\n" +
+				"\t\t (bound, renewing, rebinding or expired), e.g. \"leases list expired\",\n" +
+				"\t\t \"leases release 10.0.0.0/24\".\n")
+			fmt.Printf("\t c / concurrent\n" +
+				"\t\t Keep a DORA transaction in flight for each of the given number of terminals,\n" +
+				"\t\t e.g. \"c 100\": every terminal starts its next exchange as soon as the previous\n" +
+				"\t\t one completes or times out, until the s command.\n")
 			fmt.Printf("\t s / stop used for stop the dhcp client\n")
 			fmt.Printf("\t h / help\n" +
 				"\t\t Print this message.\n")
@@ -125,16 +307,35 @@ func main() {
 			if err != nil {
 				log.Println(err)
 			}
+		case "b", "reboot":
+			err = rebootDHCP(params, dc)
+			if err != nil {
+				log.Println(err)
+			}
+		case "i", "inform":
+			err = informDHCP(params, dc)
+			if err != nil {
+				log.Println(err)
+			}
+		case "l", "leases":
+			err = leasesCommand(params, dc)
+			if err != nil {
+				log.Println(err)
+			}
+		case "c", "concurrent":
+			err = concurrentDHCP(params, dc)
+			if err != nil {
+				log.Println(err)
+			}
 		case "s", "stop":
-			intervalC <- 1
-			loggerC <- 1
+			runs.stop()
 		default:
 			fmt.Println("Enter a supported command, Type \"help\" for details")
 		}
 	}
 }
 
-func sendDHCP(params []string, dc *connection.DhcpClient, ifRequest bool) error {
+func sendDHCP(params []string, dc client, ifRequest bool) error {
 	//init deviceNum
 	deviceNum := 1
 	var err error
@@ -144,33 +345,41 @@ func sendDHCP(params []string, dc *connection.DhcpClient, ifRequest bool) error
 			return err
 		}
 	}
+	macList, err := newMacList(deviceNum, clientMacs)
+	if err != nil {
+		return err
+	}
 	fmt.Printf("the %d device mac is: ", deviceNum)
-	var macList []net.HardwareAddr
-	for i:=0; i< deviceNum; i++ {
-		var mac net.HardwareAddr
-		if i < len(clientMacs) {
-			mac = clientMacs[i]
-		} else {
-			mac, err = net.ParseMAC(utility.RandomMac())
-			time.Sleep(time.Millisecond) //only ensure time seed different
-			if err != nil {
-				return err
-			}
-		}
-		macList = append(macList, mac)
+	for _, mac := range macList {
 		fmt.Printf("%s ", mac)
 	}
 	fmt.Println()
+	return startDHCP(dc, macList, rate(params), ifRequest)
+}
 
+// rate returns the rate of the params of a command, or "" for a one-time request
+func rate(params []string) string {
+	if len(params) == 3 {
+		return params[2]
+	}
+	return ""
+}
 
+// startDHCP starts a transaction for each of the macs, once with the dhcp packet messages
+// printed when the rate is "", at the rate or load profile otherwise
+func startDHCP(dc client, macList []net.HardwareAddr, rate string, ifRequest bool) error {
+	deviceNum := len(macList)
 	//init rate
-	if len(params) == 3 {
-		rate, err := strconv.Atoi(params[2])
+	if len(rate) > 0 {
+		profile, err := parseProfile(rate)
 		if err != nil {
 			return err
 		}
+		size := int(math.Ceil(profile.Max()))
+		done := runs.begin()
 		//send func
 		go func() {
+			defer close(done)
 			/* cpu  or memory test
 			fc, err := os.OpenFile("./cpu.prof", os.O_RDWR | os.O_CREATE, 0644)
 
@@ -192,77 +401,21 @@ func sendDHCP(params []string, dc *connection.DhcpClient, ifRequest bool) error
 			pprof.WriteHeapProfile(fm)
 			*/
 
-			dc.Start(rate * 3, ifRequest, false)
+			log.Printf("load profile: %s", profile)
+			dc.Start(size * 3, ifRequest, false)
 			defer dc.Stop()
-			requests := make(chan interface{}, rate * 3)
-			ticker := time.NewTicker(time.Second)
-			//countTicker测试用
-			//countTicker := time.NewTicker(time.Second)
-			//windows下的ticker精度是ms级(本次测试2ms) 所以在1s的ticker内循环rate次
-			go func() {
-				defer close(requests)
-				index := 0
-				//request := 0
-				for {
-					select {
-					case <-ticker.C:
-						for i:=0; i < rate; i++ {
-							mac := macList[index]
-							packet := connection.NewPacket(utility.DhcpOptions...)
-							connection.WithHWType(layers.LinkTypeEthernet)(packet)
-							connection.WithHwAddr(mac)(packet)
-							connection.WithMessageType(layers.DHCPMsgTypeDiscover)(packet)
-							requests <- packet
-							//request = request + 1
-							if index = index +1 ; index == deviceNum {
-								index = 0
-							}
-						}
-					case <-intervalC:
-						log.Println("ticker stop")
-						return
-					}
-					/*
-					select {
-					case <-countTicker.C:
-						log.Printf("produce request count : %d\n", request)
-					default:
-					}
-					*/
-				}
-			}()
-			intervals := bender.ExponentialIntervalGenerator(float64(rate))
-			exec := connection.CreateExecutor(dc)
-			bender.LoadTestThroughput(intervals, requests, exec)
+			loadTest(dc, macList, profile, intervalC)
 		}()
 		//低延迟读取 不要使用共享数据来通信；使用通信来共享数据
-		go func() {
-			ticker := time.NewTicker(time.Second * 5)
-			current_time := time.Now()
-			for {
-				select {
-				case <-ticker.C:
-					request, response := dc.GetRequestAndResponse()
-					now_time := time.Now()
-					during := now_time.Sub(current_time).Seconds()
-					log.Printf("request: %d, response: %d, during: %d, qSpeed: %.2f, pSpeed: %.2f", request, response, int(during), float64(request) / during, float64(response)/during)
-				case <-loggerC:
-					log.Println("logger stop")
-					return
-				}
-			}
-		}()
+		go report(dc, loggerC, true)
 	} else {
+		done := runs.begin()
 		go func() {
+			defer close(done)
 			dc.Start(deviceNum, ifRequest, true)
 			defer dc.Stop()
 			for i:=0; i < deviceNum; i++ {
-				mac := macList[i]
-				packet := connection.NewPacket(utility.DhcpOptions...)
-				connection.WithHWType(layers.LinkTypeEthernet)(packet)
-				connection.WithHwAddr(mac)(packet)
-				connection.WithMessageType(layers.DHCPMsgTypeDiscover)(packet)
-				dc.Send(packet, connection.WithTransactionID(rand.Uint32()))
+				dc.executor()(0, dc.newRequest(i, macList[i]))
 			}
 			select {
 			case <- intervalC:
@@ -274,3 +427,212 @@ func sendDHCP(params []string, dc *connection.DhcpClient, ifRequest bool) error
 	return nil
 }
 
+// concurrentDHCP runs the concurrency test with the terminal num of the params
+func concurrentDHCP(params []string, dc client) error {
+	if len(params) != 2 {
+		return fmt.Errorf("usage: c N, N is the terminal num")
+	}
+	deviceNum, err := strconv.Atoi(params[1])
+	if err != nil {
+		return err
+	}
+	if deviceNum <= 0 {
+		return fmt.Errorf("the terminal num should be greater than 0")
+	}
+	macList, err := newMacList(deviceNum, clientMacs)
+	if err != nil {
+		return err
+	}
+	done := runs.begin()
+	go func() {
+		defer close(done)
+		dc.Start(deviceNum * 3, true, false)
+		defer dc.Stop()
+		concurrencyTest(dc, macList, intervalC)
+		summary := dc.Stats().Total()
+		log.Printf("%d concurrent terminals: %.2f transactions/s", deviceNum, float64(succeeded(summary, true)) / summary.Elapsed.Seconds())
+	}()
+	go report(dc, loggerC, true)
+	return nil
+}
+
+// newMacList returns deviceNum macs, the given macs are used first and the
+// rest are taken from the generator, so that no two terminals of a run share a mac
+func newMacList(deviceNum int, macs []net.HardwareAddr) ([]net.HardwareAddr, error) {
+	var macList []net.HardwareAddr
+	for i:=0; i< deviceNum; i++ {
+		var mac net.HardwareAddr
+		var err error
+		if i < len(macs) {
+			mac = macs[i]
+		} else {
+			mac, err = macGenerator.Next()
+			if err != nil {
+				return nil, err
+			}
+		}
+		macList = append(macList, mac)
+	}
+	return macList, nil
+}
+
+// newIdentity returns the identity of --client-id and --hostname, nil when
+// neither is set
+func newIdentity() *connection.Identity {
+	if len(utility.ClientID) == 0 && len(utility.Hostname) == 0 {
+		return nil
+	}
+	return &connection.Identity{ClientID: utility.ClientID, Hostname: utility.Hostname}
+}
+
+// client is what the commands need from a DhcpClient or a DhcpV6Client
+type client interface {
+	Close() error
+	Start(size int, ifRequest bool, ifLog bool)
+	Stop()
+	GetRequestAndResponse() (int, int)
+	Stats() *connection.Statistics
+	// newRequest returns the packet which starts a transaction of the device
+	newRequest(index int, mac net.HardwareAddr) interface{}
+	executor() bender.RequestExecutor
+}
+
+type v4Client struct {
+	*connection.DhcpClient
+}
+
+func (c v4Client) newRequest(index int, mac net.HardwareAddr) interface{} {
+	return c.request(connection.NewDiscover(mac), index, mac)
+}
+
+// request applies the device to the packet, it returns the error of the
+// device instead and the executor counts it as a send error
+func (c v4Client) request(packet *layers.DHCPv4, index int, mac net.HardwareAddr) interface{} {
+	device, err := c.device(index, mac)
+	if err != nil {
+		return err
+	}
+	device(packet)
+	return packet
+}
+
+// device adds the --option templates expanded for the device with the given
+// index and mac, then relays the packet when --relay is set. It fails when a
+// template doesn't expand to a valid value for the device
+func (c v4Client) device(index int, mac net.HardwareAddr) (connection.Modifier, error) {
+	var options []layers.DHCPOption
+	for _, template := range utility.OptionTemplates {
+		option, err := template.Expand(index, mac)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, option)
+	}
+	return func(packet *layers.DHCPv4) {
+		for _, option := range options {
+			packet.AddOption(option.Type, option.Data)
+		}
+		if c.Relay != nil {
+			c.Relay.Modifier(index, mac)(packet)
+		}
+	}, nil
+}
+
+func (c v4Client) executor() bender.RequestExecutor {
+	execute := connection.CreateExecutor(c.DhcpClient)
+	return func(now int64, request interface{}) (interface{}, error) {
+		if err, ok := request.(error); ok {
+			c.failed(err)
+			return nil, err
+		}
+		return execute(now, request)
+	}
+}
+
+// failed counts a request which couldn't be built as a send error
+func (c v4Client) failed(err error) {
+	log.Println(err)
+	c.Stats().BuildFailed()
+}
+
+type v6Client struct {
+	*connection.DhcpV6Client
+}
+
+func (c v6Client) newRequest(index int, mac net.HardwareAddr) interface{} {
+	return c.NewSolicit(mac)
+}
+
+func (c v6Client) executor() bender.RequestExecutor {
+	return connection.CreateExecutor6(c.DhcpV6Client)
+}
+
+// loadTest sends discovers, or solicits, for the macs in turn at the rate of the profile until
+// stop is signalled. The client must have been started for throughput testing
+func loadTest(dc client, macList []net.HardwareAddr, profile Profile, stop chan int) {
+	requests := make(chan interface{}, int(math.Ceil(profile.Max())) * 3 + 1)
+	start := time.Now()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	//countTicker测试用
+	//countTicker := time.NewTicker(time.Second)
+	//windows下的ticker精度是ms级(本次测试2ms) 所以在1s的ticker内循环rate次
+	go func() {
+		defer close(requests)
+		index := 0
+		//request := 0
+		//the fractions of the rate are carried over to the next second
+		due := 0.0
+		for {
+			select {
+			case <-ticker.C:
+				due = due + profile.Rate(time.Since(start))
+				rate := int(due)
+				due = due - float64(rate)
+				for i:=0; i < rate; i++ {
+					requests <- dc.newRequest(index, macList[index])
+					//request = request + 1
+					if index = index +1 ; index == len(macList) {
+						index = 0
+					}
+				}
+			case <-stop:
+				log.Println("ticker stop")
+				return
+			}
+			/*
+			select {
+			case <-countTicker.C:
+				log.Printf("produce request count : %d\n", request)
+			default:
+			}
+			*/
+		}
+	}()
+	bender.LoadTestThroughput(intervalGenerator(profile, start), requests, dc.executor())
+}
+
+// report logs the counters every 5 seconds until stop is signalled, along with the latency
+// percentiles of the interval when intervals is set. The interval summaries are exported as well
+func report(dc client, stop chan int, intervals bool) {
+	ticker := time.NewTicker(time.Second * 5)
+	defer ticker.Stop()
+	current_time := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+			request, response := dc.GetRequestAndResponse()
+			now_time := time.Now()
+			during := now_time.Sub(current_time).Seconds()
+			log.Printf("request: %d, response: %d, during: %d, qSpeed: %.2f, pSpeed: %.2f", request, response, int(during), float64(request) / during, float64(response)/during)
+			if intervals {
+				interval := dc.Stats().Interval()
+				log.Printf("interval %s", interval)
+				exporter.Summary("interval", interval)
+			}
+		case <-stop:
+			log.Println("logger stop")
+			return
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"dhcptest/connection"
+	"dhcptest/utility"
+	"log"
+	"net"
+	"net/http"
+)
+
+// newMetrics serves the metrics of the clients on /metrics of the
+// --metrics-listen address, it returns nil when no address is given
+func newMetrics() (*connection.Metrics, error) {
+	if len(utility.MetricsListen) == 0 {
+		return nil, nil
+	}
+	listener, err := net.Listen("tcp", utility.MetricsListen)
+	if err != nil {
+		return nil, err
+	}
+	metrics := connection.NewMetrics()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Println(err)
+		}
+	}()
+	log.Printf("serving metrics on http://%s/metrics", listener.Addr())
+	return metrics, nil
+}
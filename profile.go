@@ -0,0 +1,202 @@
+package main
+
+import (
+	"dhcptest/utility"
+	"fmt"
+	"github.com/pinterest/bender"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minIntervalRate is the rate the requests are spaced at while a profile
+// gives no rate at all, the requests come from the producer anyway
+const minIntervalRate = 100
+
+// Profile gives the request rate, per second, at the elapsed time of a load test
+type Profile interface {
+	Rate(elapsed time.Duration) float64
+	// Max is the highest rate of the profile, the queues are sized after it
+	Max() float64
+	String() string
+}
+
+// constantProfile keeps the same rate
+type constantProfile float64
+
+func (p constantProfile) Rate(time.Duration) float64 { return float64(p) }
+func (p constantProfile) Max() float64               { return float64(p) }
+func (p constantProfile) String() string             { return fmt.Sprintf("%g/s", float64(p)) }
+
+// stepProfile raises the rate by step every period, up to max when it is set
+type stepProfile struct {
+	from   float64
+	step   float64
+	period time.Duration
+	max    float64
+}
+
+func (p stepProfile) Rate(elapsed time.Duration) float64 {
+	rate := p.from + p.step*float64(elapsed/p.period)
+	if p.max > 0 && rate > p.max {
+		rate = p.max
+	}
+	return rate
+}
+
+func (p stepProfile) Max() float64 {
+	if p.max > 0 {
+		return p.max
+	}
+	//without a cap the rate is sized for ten steps
+	return p.from + 10*p.step
+}
+
+func (p stepProfile) String() string {
+	return fmt.Sprintf("step from %g/s by %g/s every %s", p.from, p.step, p.period)
+}
+
+// rampProfile goes linearly from one rate to another over the duration, then
+// keeps the last rate
+type rampProfile struct {
+	from     float64
+	to       float64
+	duration time.Duration
+}
+
+func (p rampProfile) Rate(elapsed time.Duration) float64 {
+	if elapsed >= p.duration {
+		return p.to
+	}
+	return p.from + (p.to-p.from)*float64(elapsed)/float64(p.duration)
+}
+
+func (p rampProfile) Max() float64 { return math.Max(p.from, p.to) }
+
+func (p rampProfile) String() string {
+	return fmt.Sprintf("ramp from %g/s to %g/s in %s", p.from, p.to, p.duration)
+}
+
+// sineProfile swings around the base rate by the amplitude
+type sineProfile struct {
+	base      float64
+	amplitude float64
+	period    time.Duration
+}
+
+func (p sineProfile) Rate(elapsed time.Duration) float64 {
+	rate := p.base + p.amplitude*math.Sin(2*math.Pi*float64(elapsed)/float64(p.period))
+	return math.Max(rate, 0)
+}
+
+func (p sineProfile) Max() float64 { return p.base + math.Abs(p.amplitude) }
+
+func (p sineProfile) String() string {
+	return fmt.Sprintf("sine around %g/s by %g/s every %s", p.base, p.amplitude, p.period)
+}
+
+// parseProfile parses a rate such as "100" or a profile:
+//   step:FROM,STEP,PERIOD[,MAX]  e.g. step:10,10,30s
+//   ramp:FROM,TO,DURATION        e.g. ramp:10,500,5m
+//   sine:BASE,AMPLITUDE,PERIOD   e.g. sine:100,50,10m
+func parseProfile(value string) (Profile, error) {
+	kind, args := "", value
+	if i := strings.Index(value, ":"); i >= 0 {
+		kind, args = value[:i], value[i+1:]
+	}
+	params := strings.Split(args, ",")
+	rates := func(params []string) ([]float64, error) {
+		var rates []float64
+		for _, param := range params {
+			rate, err := strconv.ParseFloat(strings.TrimSpace(param), 64)
+			if err != nil || rate < 0 {
+				return nil, fmt.Errorf("invalid rate %q in profile %q", param, value)
+			}
+			rates = append(rates, rate)
+		}
+		return rates, nil
+	}
+	duration := func(i int) (time.Duration, error) {
+		d, err := time.ParseDuration(strings.TrimSpace(params[i]))
+		if err != nil || d <= 0 {
+			return 0, fmt.Errorf("invalid duration %q in profile %q", params[i], value)
+		}
+		return d, nil
+	}
+
+	switch {
+	case kind == "" && len(params) == 1:
+		r, err := rates(params)
+		if err != nil {
+			return nil, err
+		}
+		if r[0] == 0 {
+			return nil, fmt.Errorf("rate should be greater than 0")
+		}
+		return constantProfile(r[0]), nil
+	case kind == "step" && (len(params) == 3 || len(params) == 4):
+		r, err := rates(params[:2])
+		if err != nil {
+			return nil, err
+		}
+		period, err := duration(2)
+		if err != nil {
+			return nil, err
+		}
+		p := stepProfile{from: r[0], step: r[1], period: period}
+		if len(params) == 4 {
+			max, err := rates(params[3:])
+			if err != nil {
+				return nil, err
+			}
+			p.max = max[0]
+		}
+		return p, nil
+	case kind == "ramp" && len(params) == 3:
+		r, err := rates(params[:2])
+		if err != nil {
+			return nil, err
+		}
+		d, err := duration(2)
+		if err != nil {
+			return nil, err
+		}
+		return rampProfile{from: r[0], to: r[1], duration: d}, nil
+	case kind == "sine" && len(params) == 3:
+		r, err := rates(params[:2])
+		if err != nil {
+			return nil, err
+		}
+		period, err := duration(2)
+		if err != nil {
+			return nil, err
+		}
+		return sineProfile{base: r[0], amplitude: r[1], period: period}, nil
+	}
+	return nil, fmt.Errorf("invalid profile %q, want RATE, step:FROM,STEP,PERIOD[,MAX], ramp:FROM,TO,DURATION or sine:BASE,AMPLITUDE,PERIOD", value)
+}
+
+// checkSpacing validates --spacing
+func checkSpacing() error {
+	switch utility.Spacing {
+	case "exponential", "uniform":
+		return nil
+	}
+	return fmt.Errorf("invalid spacing: %s, want exponential or uniform", utility.Spacing)
+}
+
+// intervalGenerator spaces the requests after the rate the profile gives at
+// the time of each request, exponentially or uniformly as --spacing tells
+func intervalGenerator(profile Profile, start time.Time) bender.IntervalGenerator {
+	return func(now int64) int64 {
+		rate := profile.Rate(time.Duration(now - start.UnixNano()))
+		if rate <= 0 {
+			rate = minIntervalRate
+		}
+		if utility.Spacing == "uniform" {
+			return bender.UniformIntervalGenerator(rate)(now)
+		}
+		return bender.ExponentialIntervalGenerator(rate)(now)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseProfile(t *testing.T) {
+	for _, c := range []struct {
+		value string
+		at    time.Duration
+		rate  float64
+		max   float64
+	}{
+		{"100", time.Hour, 100, 100},
+		{"step:10,10,30s", 65 * time.Second, 30, 110},
+		{"step:10,10,30s,25", 65 * time.Second, 25, 25},
+		{"ramp:10,500,5m", 150 * time.Second, 255, 500},
+		{"ramp:10,500,5m", time.Hour, 500, 500},
+		{"ramp:500,10,5m", 0, 500, 500},
+		{"sine:100,50,10m", 150 * time.Second, 150, 150},
+		{"sine:10,50,10m", 450 * time.Second, 0, 60},
+	} {
+		profile, err := parseProfile(c.value)
+		if err != nil {
+			t.Errorf("%q: %s", c.value, err)
+			continue
+		}
+		if rate := profile.Rate(c.at); math.Abs(rate-c.rate) > 1e-9 {
+			t.Errorf("%q at %s: rate %g, want %g", c.value, c.at, rate, c.rate)
+		}
+		if max := profile.Max(); max != c.max {
+			t.Errorf("%q: max %g, want %g", c.value, max, c.max)
+		}
+	}
+	for _, value := range []string{"", "0", "-5", "fast", "step:10,10", "step:10,10,0s", "ramp:10,x,5m", "sine:100,50", "wave:1,2,3s"} {
+		if _, err := parseProfile(value); err == nil {
+			t.Errorf("%q is taken", value)
+		}
+	}
+}
+
+func TestInFlightClient(t *testing.T) {
+	dc := &fakeClient{latency: 30 * time.Millisecond, started: make(map[int]int)}
+	counted := inFlightClient{client: dc, count: new(int64)}
+	execute := counted.executor()
+	for i := 0; i < 5; i++ {
+		execute(0, i)
+	}
+	if n := counted.inFlight(); n != 5 {
+		t.Errorf("%d transactions in flight, want 5", n)
+	}
+	start := time.Now()
+	if !counted.wait(time.Second) || counted.inFlight() != 0 {
+		t.Errorf("%d transactions are still in flight", counted.inFlight())
+	}
+	if waited := time.Since(start); waited < 20*time.Millisecond || waited > 500*time.Millisecond {
+		t.Errorf("waited %s for transactions of 30ms", waited)
+	}
+
+	dc.latency = time.Hour
+	execute(0, 0)
+	if counted.wait(20 * time.Millisecond) {
+		t.Error("a transaction of an hour is over")
+	}
+}
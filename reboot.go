@@ -0,0 +1,140 @@
+package main
+
+import (
+	"dhcptest/connection"
+	"dhcptest/utility"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+)
+
+// rebootClient starts the transactions of the devices with the INIT-REBOOT
+// request of the address they were acked last
+type rebootClient struct {
+	v4Client
+	addresses map[string]net.IP
+}
+
+func (c rebootClient) newRequest(index int, mac net.HardwareAddr) interface{} {
+	return c.request(connection.NewRebootRequest(mac, c.addresses[mac.String()]), index, mac)
+}
+
+// informClient starts the transactions of the devices with an inform, the
+// device of the index informs from the index-th address after ciaddr
+type informClient struct {
+	v4Client
+	ciaddr net.IP
+}
+
+func (c informClient) newRequest(index int, mac net.HardwareAddr) interface{} {
+	return c.request(connection.NewInform(mac, nextIP(c.ciaddr, index)), index, mac)
+}
+
+// nextIP returns the address count addresses after ip
+func nextIP(ip net.IP, count int) net.IP {
+	next := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(next, binary.BigEndian.Uint32(ip.To4())+uint32(count))
+	return next
+}
+
+// parseCIAddr parses the address the informs start from
+func parseCIAddr(value string) (net.IP, error) {
+	if len(value) == 0 {
+		return nil, fmt.Errorf("the address to inform from is not set, use --ciaddr")
+	}
+	ip := net.ParseIP(value).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("%s is not a valid ipv4 address", value)
+	}
+	return ip, nil
+}
+
+// rebootDevices returns the macs of up to deviceNum devices which got an
+// address, all of them when deviceNum is 0. When macs are given only those
+// devices reboot
+func rebootDevices(addresses map[string]net.IP, deviceNum int, macs []net.HardwareAddr) ([]net.HardwareAddr, error) {
+	var macList []net.HardwareAddr
+	if len(macs) > 0 {
+		for _, mac := range macs {
+			if _, ok := addresses[mac.String()]; !ok {
+				return nil, fmt.Errorf("%s has got no address to reboot with", mac)
+			}
+			macList = append(macList, mac)
+		}
+	} else {
+		keys := make([]string, 0, len(addresses))
+		for key := range addresses {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			mac, err := net.ParseMAC(key)
+			if err != nil {
+				return nil, err
+			}
+			macList = append(macList, mac)
+		}
+	}
+	if len(macList) == 0 {
+		return nil, fmt.Errorf("no terminal has got an address yet, run r first")
+	}
+	if deviceNum > 0 && deviceNum < len(macList) {
+		macList = macList[:deviceNum]
+	}
+	return macList, nil
+}
+
+// rebootDHCP makes the terminals which got an address reboot and ask for it
+// again, params are the terminal num, all of them by default, and the rate
+func rebootDHCP(params []string, dc client) error {
+	v4, ok := dc.(v4Client)
+	if !ok {
+		return fmt.Errorf("reboot is only supported with dhcpv4")
+	}
+	deviceNum := 0
+	var err error
+	if len(params) >= 2 {
+		deviceNum, err = strconv.Atoi(params[1])
+		if err != nil {
+			return err
+		}
+	}
+	addresses := v4.Addresses()
+	macList, err := rebootDevices(addresses, deviceNum, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d terminals reboot\n", len(macList))
+	return startDHCP(rebootClient{v4, addresses}, macList, rate(params), true)
+}
+
+// informDHCP makes the terminals inform from the addresses following --ciaddr,
+// params are the terminal num and the rate as for the d command
+func informDHCP(params []string, dc client) error {
+	v4, ok := dc.(v4Client)
+	if !ok {
+		return fmt.Errorf("inform is only supported with dhcpv4")
+	}
+	ciaddr, err := parseCIAddr(utility.CIAddr)
+	if err != nil {
+		return err
+	}
+	if err := v4.OpenResponder(); err != nil {
+		return err
+	}
+	deviceNum := 1
+	if len(params) >= 2 {
+		deviceNum, err = strconv.Atoi(params[1])
+		if err != nil {
+			return err
+		}
+	}
+	macList, err := newMacList(deviceNum, clientMacs)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d terminals inform from %s\n", len(macList), ciaddr)
+	return startDHCP(informClient{v4, ciaddr}, macList, rate(params), true)
+}
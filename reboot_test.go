@@ -0,0 +1,126 @@
+package main
+
+import (
+	"dhcptest/connection"
+	"dhcptest/layers"
+	"dhcptest/utility"
+	"net"
+	"testing"
+)
+
+func TestNextIP(t *testing.T) {
+	for _, c := range []struct {
+		ip    string
+		count int
+		want  string
+	}{
+		{"10.0.0.1", 0, "10.0.0.1"},
+		{"10.0.0.1", 9, "10.0.0.10"},
+		{"10.0.0.250", 10, "10.0.1.4"},
+	} {
+		if got := nextIP(net.ParseIP(c.ip), c.count); got.String() != c.want {
+			t.Errorf("%s + %d = %s, want %s", c.ip, c.count, got, c.want)
+		}
+	}
+}
+
+func TestParseCIAddr(t *testing.T) {
+	if ip, err := parseCIAddr("192.168.1.10"); err != nil || !ip.Equal(net.IPv4(192, 168, 1, 10)) {
+		t.Errorf("parsed %s: %v", ip, err)
+	}
+	for _, value := range []string{"", "fe80::1", "not an ip"} {
+		if _, err := parseCIAddr(value); err == nil {
+			t.Errorf("%q accepted", value)
+		}
+	}
+}
+
+func TestRebootDevices(t *testing.T) {
+	first, _ := net.ParseMAC("02:00:00:00:00:01")
+	second, _ := net.ParseMAC("02:00:00:00:00:02")
+	other, _ := net.ParseMAC("02:00:00:00:00:09")
+	addresses := map[string]net.IP{
+		second.String(): net.IPv4(10, 0, 0, 2),
+		first.String():  net.IPv4(10, 0, 0, 1),
+	}
+
+	macs, err := rebootDevices(addresses, 0, nil)
+	if err != nil || len(macs) != 2 || macs[0].String() != first.String() || macs[1].String() != second.String() {
+		t.Errorf("all the devices: %v %v", macs, err)
+	}
+	if macs, err := rebootDevices(addresses, 1, nil); err != nil || len(macs) != 1 || macs[0].String() != first.String() {
+		t.Errorf("one device: %v %v", macs, err)
+	}
+	if macs, err := rebootDevices(addresses, 5, []net.HardwareAddr{second}); err != nil || len(macs) != 1 || macs[0].String() != second.String() {
+		t.Errorf("the given device: %v %v", macs, err)
+	}
+	if _, err := rebootDevices(addresses, 0, []net.HardwareAddr{other}); err == nil {
+		t.Error("a device without an address reboots")
+	}
+	if _, err := rebootDevices(map[string]net.IP{}, 0, nil); err == nil {
+		t.Error("no device reboots without an error")
+	}
+}
+
+func TestRebootAndInformRequests(t *testing.T) {
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+	v4 := v4Client{&connection.DhcpClient{}}
+
+	reboot := rebootClient{v4, map[string]net.IP{mac.String(): net.IPv4(10, 0, 0, 7)}}
+	packet := reboot.newRequest(0, mac).(*layers.DHCPv4)
+	requested := false
+	for _, option := range packet.Options {
+		if option.Type == layers.DHCPOptRequestIP {
+			requested = net.IP(option.Data).Equal(net.IPv4(10, 0, 0, 7))
+		}
+		if option.Type == layers.DHCPOptServerID {
+			t.Error("an INIT-REBOOT request carries a server id")
+		}
+	}
+	if packet.MessageType() != layers.DHCPMsgTypeRequest || !requested || packet.ClientHWAddr.String() != mac.String() {
+		t.Errorf("reboot request %v", packet)
+	}
+
+	inform := informClient{v4, net.IPv4(10, 0, 1, 1)}
+	packet = inform.newRequest(3, mac).(*layers.DHCPv4)
+	if packet.MessageType() != layers.DHCPMsgTypeInform || !packet.ClientIP.Equal(net.IPv4(10, 0, 1, 4)) {
+		t.Errorf("the fourth device informs %s from %s", packet.MessageType(), packet.ClientIP)
+	}
+}
+
+func TestRequestTemplateError(t *testing.T) {
+	parser := &utility.Parser{}
+	parser.Init()
+	_, templates, err := parser.Parse(utility.RequestParams{"3[ip]=10.0.0.{index}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	utility.OptionTemplates = templates
+	defer func() {
+		utility.OptionTemplates = nil
+	}()
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+	v4 := v4Client{&connection.DhcpClient{}}
+
+	packet, ok := v4.newRequest(7, mac).(*layers.DHCPv4)
+	if !ok || len(packet.Options) == 0 {
+		t.Fatalf("request of the device 7: %v", packet)
+	}
+	for _, option := range packet.Options {
+		if option.Type == layers.DHCPOptRouter && !net.IP(option.Data).Equal(net.IPv4(10, 0, 0, 7)) {
+			t.Errorf("router %v", net.IP(option.Data))
+		}
+	}
+
+	//10.0.0.300 is not an address, the device isn't sent
+	inform := informClient{v4, net.IPv4(10, 0, 1, 1)}
+	for _, c := range []client{v4, inform} {
+		request := c.newRequest(300, mac)
+		if _, ok := request.(error); !ok {
+			t.Fatalf("%T builds %v for the device 300", c, request)
+		}
+		if response, err := c.executor()(0, request); err == nil || response != nil {
+			t.Errorf("%T executes the request of the device 300: %v, %v", c, response, err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"dhcptest/connection"
+	"dhcptest/utility"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// newRelay builds the relay agent settings from the command-line, it returns
+// nil when the client doesn't act as a relay
+func newRelay() (*connection.Relay, error) {
+	if len(utility.Relay) == 0 {
+		return nil, nil
+	}
+	relay := &connection.Relay{
+		IP:        net.ParseIP(utility.Relay).To4(),
+		Server:    net.ParseIP(utility.RelayServer).To4(),
+		CircuitID: utility.CircuitID,
+		RemoteID:  utility.RemoteID,
+	}
+	if relay.IP == nil {
+		return nil, fmt.Errorf("invalid relay ip: %s", utility.Relay)
+	}
+	if relay.Server == nil {
+		return nil, fmt.Errorf("invalid relay server: %q, --relay-server is required with --relay", utility.RelayServer)
+	}
+	if len(utility.RelayServerMac) > 0 {
+		mac, err := net.ParseMAC(utility.RelayServerMac)
+		if err != nil {
+			return nil, err
+		}
+		relay.ServerMAC = mac
+	}
+	if len(utility.Giaddr) > 0 {
+		for _, value := range strings.Split(utility.Giaddr, ",") {
+			giaddr := net.ParseIP(strings.TrimSpace(value)).To4()
+			if giaddr == nil {
+				return nil, fmt.Errorf("invalid giaddr: %s", value)
+			}
+			relay.Giaddrs = append(relay.Giaddrs, giaddr)
+		}
+	}
+	return relay, nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"dhcptest/connection"
+	"dhcptest/layers"
+	"dhcptest/utility"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	exitBadReplay = 2
+	// replayBuffer caps the queues of the client during a replay
+	replayBuffer = 1 << 16
+)
+
+// replayStep sends a message of the capture, the macs of the loop map the
+// clients of the capture to their macs. done is nil for the messages which
+// are sent without a transaction
+type replayStep struct {
+	at   time.Duration
+	send func(macs map[string]net.HardwareAddr) (done <-chan struct{}, err error)
+}
+
+// parseSpeed parses --speed, such as 1x or 10x, max gives 0
+func parseSpeed(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if value == "max" {
+		return 0, nil
+	}
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+	if err != nil || speed <= 0 {
+		return 0, fmt.Errorf("invalid --speed %q, want such as 1x, 10x or max", value)
+	}
+	return speed, nil
+}
+
+// replayMac returns the mac the client of the capture is moved to in the
+// loop, nil keeps the mac of the capture
+func replayMac(client string, macs map[string]net.HardwareAddr) (net.HardwareAddr, error) {
+	if !utility.RewriteMac {
+		return nil, nil
+	}
+	if mac, ok := macs[client]; ok {
+		return mac, nil
+	}
+	mac, err := macGenerator.Next()
+	if err != nil {
+		return nil, err
+	}
+	macs[client] = mac
+	return mac, nil
+}
+
+// replaySteps returns the steps sending the client messages of the capture
+// of the family of the client
+func replaySteps(dc client, frames []connection.CapturedFrame) ([]replayStep, error) {
+	v4, v6 := connection.ClientMessages(frames)
+	log.Printf("%d frames read, %d dhcpv4 and %d dhcpv6 client messages", len(frames), len(v4), len(v6))
+	var steps []replayStep
+	switch c := dc.(type) {
+	case v4Client:
+		//the clients of the capture are the devices of the relay, in the order they show up
+		devices := make(map[string]int)
+		informs := false
+		for _, message := range v4 {
+			message := message
+			client := message.Packet.ClientHWAddr.String()
+			if _, ok := devices[client]; !ok {
+				devices[client] = len(devices)
+			}
+			device := devices[client]
+			informs = informs || message.Packet.MessageType() == layers.DHCPMsgTypeInform
+			steps = append(steps, replayStep{at: message.At, send: func(macs map[string]net.HardwareAddr) (<-chan struct{}, error) {
+				var modifiers []connection.Modifier
+				if utility.FreshXid {
+					modifiers = append(modifiers, connection.WithTransactionID(rand.Uint32()))
+				}
+				mac, err := replayMac(client, macs)
+				if err != nil {
+					return nil, err
+				}
+				if mac != nil {
+					modifiers = append(modifiers, connection.WithClientMAC(mac))
+				} else {
+					mac = message.Packet.ClientHWAddr
+				}
+				if c.Relay != nil {
+					modifiers = append(modifiers, c.Relay.Modifier(device, mac))
+				}
+				pr, err := c.Replay(message, modifiers...)
+				if pr == nil {
+					return nil, err
+				}
+				return pr.Done(), err
+			}})
+		}
+		if len(v6) > 0 {
+			log.Printf("the dhcpv6 messages are left out, replay them with --v6")
+		}
+		if informs {
+			//the servers arp for the ciaddr of an inform before they answer
+			if err := c.OpenResponder(); err != nil {
+				return nil, err
+			}
+		}
+	case v6Client:
+		for _, message := range v6 {
+			message := message
+			client := ""
+			for _, option := range message.Packet.Options {
+				if option.Code == layers.DHCPv6OptClientID {
+					client = fmt.Sprintf("%x", option.Data)
+				}
+			}
+			steps = append(steps, replayStep{at: message.At, send: func(macs map[string]net.HardwareAddr) (<-chan struct{}, error) {
+				var modifiers []connection.Modifier6
+				if utility.FreshXid {
+					modifiers = append(modifiers, connection.WithTransactionID6(rand.Uint32()))
+				}
+				mac, err := replayMac(client, macs)
+				if err != nil {
+					return nil, err
+				}
+				if mac != nil {
+					modifiers = append(modifiers, connection.WithClientMAC6(mac))
+				}
+				pr, err := c.Replay(message, modifiers...)
+				if pr == nil {
+					return nil, err
+				}
+				return pr.Done(), err
+			}})
+		}
+		if len(v4) > 0 {
+			log.Printf("the dhcpv4 messages are left out, replay them without --v6")
+		}
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("no client message to replay")
+	}
+	return steps, nil
+}
+
+// runReplay sends the messages the clients of the capture sent again, at
+// their times in the capture sped up by --speed, --loops times. The replies
+// are matched and counted as in a load test
+func runReplay(dc client, args []string) int {
+	if len(args) != 1 {
+		log.Println("usage: replay CAPTURE")
+		return exitBadReplay
+	}
+	speed, err := parseSpeed(utility.Speed)
+	if err != nil {
+		log.Println(err)
+		return exitBadReplay
+	}
+	if utility.Loops < 0 {
+		log.Println("--loops should be 0 or more")
+		return exitBadReplay
+	}
+	if utility.Loops != 1 && !utility.FreshXid {
+		//a transaction of a loop would take the place of the same one of the loop before
+		log.Println("the xids of the capture are rewritten with --loops other than 1, as with --fresh-xid")
+		utility.FreshXid = true
+	}
+	frames, err := connection.ReadCapture(args[0])
+	if err != nil {
+		log.Println(err)
+		return exitBadReplay
+	}
+	steps, err := replaySteps(dc, frames)
+	if err != nil {
+		log.Println(err)
+		return exitBadReplay
+	}
+
+	size := len(steps)*3 + 16
+	if size > replayBuffer {
+		size = replayBuffer
+	}
+	dc.Start(size, false, false)
+	loggerStop := make(chan int)
+	go report(dc, loggerStop, true)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	var (
+		inflight sync.WaitGroup
+		sent     int
+		failed   int
+	)
+	log.Printf("replaying %d messages at %s, %d loops", len(steps), utility.Speed, utility.Loops)
+loop:
+	for pass := 0; utility.Loops == 0 || pass < utility.Loops; pass++ {
+		start := time.Now()
+		macs := make(map[string]net.HardwareAddr)
+		for _, step := range steps {
+			if speed > 0 {
+				wait := time.NewTimer(time.Until(start.Add(time.Duration(float64(step.at) / speed))))
+				select {
+				case <-wait.C:
+				case <-signals:
+					wait.Stop()
+					break loop
+				}
+			} else {
+				select {
+				case <-signals:
+					break loop
+				default:
+				}
+			}
+			done, err := step.send(macs)
+			if err != nil {
+				failed++
+				log.Println(err)
+				continue
+			}
+			sent++
+			if done != nil {
+				inflight.Add(1)
+				go func() {
+					<-done
+					inflight.Done()
+				}()
+			}
+		}
+	}
+	inflight.Wait()
+	loggerStop <- 1
+	dc.Stop()
+	log.Printf("replay: %d messages sent, %d failed", sent, failed)
+	return exitOK
+}
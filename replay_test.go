@@ -0,0 +1,73 @@
+package main
+
+import (
+	"dhcptest/utility"
+	"net"
+	"testing"
+)
+
+func TestParseSpeed(t *testing.T) {
+	for _, c := range []struct {
+		value string
+		want  float64
+	}{
+		{"1x", 1},
+		{" 10x ", 10},
+		{"0.5", 0.5},
+		{"max", 0},
+	} {
+		if speed, err := parseSpeed(c.value); err != nil || speed != c.want {
+			t.Errorf("%q: %g, %v", c.value, speed, err)
+		}
+	}
+	for _, value := range []string{"", "0x", "-2x", "fast"} {
+		if _, err := parseSpeed(value); err == nil {
+			t.Errorf("%q is taken", value)
+		}
+	}
+}
+
+func TestReplayMac(t *testing.T) {
+	defer func(rewrite bool, generator *utility.MacGenerator) {
+		utility.RewriteMac, macGenerator = rewrite, generator
+	}(utility.RewriteMac, macGenerator)
+	first, last, _ := utility.ParseMacRange("02:00:00:00:00:01-02:00:00:00:00:ff")
+	macGenerator, _ = utility.NewSequentialMacGenerator(first, last)
+
+	utility.RewriteMac = false
+	macs := make(map[string]net.HardwareAddr)
+	if mac, err := replayMac("00:11:22:33:44:55", macs); mac != nil || err != nil {
+		t.Errorf("the mac is rewritten to %s without --rewrite-mac: %v", mac, err)
+	}
+
+	utility.RewriteMac = true
+	a, _ := replayMac("00:11:22:33:44:55", macs)
+	b, _ := replayMac("00:11:22:33:44:66", macs)
+	again, _ := replayMac("00:11:22:33:44:55", macs)
+	if a == nil || a.String() == b.String() || a.String() != again.String() {
+		t.Errorf("the clients are moved to %s, %s and %s", a, b, again)
+	}
+}
+
+func TestRunReplayArgs(t *testing.T) {
+	defer func(speed string, loops int) {
+		utility.Speed, utility.Loops = speed, loops
+	}(utility.Speed, utility.Loops)
+	utility.Speed, utility.Loops = "1x", 1
+
+	dc := &fakeClient{}
+	if code := runReplay(dc, nil); code != exitBadReplay {
+		t.Errorf("no capture exits with %d", code)
+	}
+	if code := runReplay(dc, []string{"/nonexistent.pcapng"}); code != exitBadReplay {
+		t.Errorf("a missing capture exits with %d", code)
+	}
+	utility.Loops = -1
+	if code := runReplay(dc, []string{"capture.pcapng"}); code != exitBadReplay {
+		t.Errorf("--loops -1 exits with %d", code)
+	}
+	utility.Loops, utility.Speed = 1, "fast"
+	if code := runReplay(dc, []string{"capture.pcapng"}); code != exitBadReplay {
+		t.Errorf("--speed fast exits with %d", code)
+	}
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"dhcptest/connection"
+	"dhcptest/layers"
+	"dhcptest/utility"
+	"encoding/json"
+	"fmt"
+	"github.com/pinterest/bender"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// exit codes of the scenario mode
+const (
+	exitOK          = 0
+	exitSLOViolated = 1
+	exitBadScenario = 2
+)
+
+// Duration is a time.Duration read from a json or yaml string such as "30s"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration should be a string like \"30s\": %s", err)
+	}
+	return d.parse(value)
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return fmt.Errorf("duration should be a string like \"30s\": %s", err)
+	}
+	return d.parse(value)
+}
+
+func (d *Duration) parse(value string) error {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// SLO is the service level a phase must reach, zero fields are not checked
+type SLO struct {
+	SuccessRatio float64  `json:"success_ratio" yaml:"success_ratio"`
+	P99          Duration `json:"p99" yaml:"p99"`
+}
+
+// Search raises the rate of a phase step by step until the phase SLO is
+// missed, the last rate meeting it is the knee point
+type Search struct {
+	// Step is added to the rate after every step, the default is the start rate
+	Step         int      `json:"step" yaml:"step"`
+	StepDuration Duration `json:"step_duration" yaml:"step_duration"`
+	MaxRate      int      `json:"max_rate" yaml:"max_rate"`
+}
+
+// Phase is one step of a scenario
+type Phase struct {
+	Name    string `json:"name" yaml:"name"`
+	Devices int    `json:"devices" yaml:"devices"`
+	Rate    int    `json:"rate" yaml:"rate"`
+	// Profile replaces the constant rate with a load profile, e.g. ramp:10,500,5m
+	Profile string `json:"profile" yaml:"profile"`
+	// Search makes the phase look for the highest rate meeting its SLO,
+	// starting from the rate
+	Search *Search `json:"search" yaml:"search"`
+	// Concurrency replaces the rate with as many devices keeping a transaction
+	// in flight each, a device starts its next exchange when the previous one is over
+	Concurrency int      `json:"concurrency" yaml:"concurrency"`
+	Duration    Duration `json:"duration" yaml:"duration"`
+	// Hold is how long the client keeps running after the last discover,
+	// the default is the reply timeout
+	Hold Duration `json:"hold" yaml:"hold"`
+	// Flow is discover, dora, reboot or inform, optionally followed by +renew
+	// and +release
+	Flow string `json:"flow" yaml:"flow"`
+	// CIAddr is the address the inform flow starts from, the default is --ciaddr
+	CIAddr  string   `json:"ciaddr" yaml:"ciaddr"`
+	Options []string `json:"options" yaml:"options"`
+	Macs    []string `json:"macs" yaml:"macs"`
+	SLO     *SLO     `json:"slo" yaml:"slo"`
+}
+
+// Scenario describes a non-interactive run
+type Scenario struct {
+	Phases []Phase `json:"phases" yaml:"phases"`
+	SLO    *SLO    `json:"slo" yaml:"slo"`
+}
+
+// flow is the parsed Phase.Flow
+type flow struct {
+	request bool
+	// reboot starts with the INIT-REBOOT request of the devices which got an
+	// address in an earlier phase, inform with an inform
+	reboot  bool
+	inform  bool
+	renew   bool
+	release bool
+}
+
+// requestOnly tells whether the transactions start with a request, or an
+// inform, instead of a discover
+func (f flow) requestOnly() bool {
+	return f.reboot || f.inform
+}
+
+func parseFlow(value string) (flow, error) {
+	var f flow
+	if len(value) == 0 {
+		value = "dora"
+	}
+	for i, step := range strings.Split(value, "+") {
+		step = strings.ToLower(strings.TrimSpace(step))
+		switch {
+		case i == 0 && step == "discover":
+		case i == 0 && step == "dora":
+			f.request = true
+		case i == 0 && step == "reboot":
+			f.request, f.reboot = true, true
+		case i == 0 && step == "inform":
+			f.request, f.inform = true, true
+		case i > 0 && f.inform:
+			return f, fmt.Errorf("an inform holds no lease, it can't be followed by %q", step)
+		case i > 0 && step == "renew":
+			f.request, f.renew = true, true
+		case i > 0 && step == "release":
+			f.request, f.release = true, true
+		default:
+			return f, fmt.Errorf("unsupport flow step %q in %q", step, value)
+		}
+	}
+	return f, nil
+}
+
+// loadScenario reads a yaml scenario from a .yaml or .yml file and a json one
+// from any other file
+func loadScenario(path string) (*Scenario, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	scenario := &Scenario{}
+	unmarshal := json.Unmarshal
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		unmarshal = yaml.UnmarshalStrict
+	}
+	if err := unmarshal(data, scenario); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if len(scenario.Phases) == 0 {
+		return nil, fmt.Errorf("%s: no phase", path)
+	}
+	for i := range scenario.Phases {
+		phase := &scenario.Phases[i]
+		if len(phase.Name) == 0 {
+			phase.Name = fmt.Sprintf("phase-%d", i+1)
+		}
+		f, err := parseFlow(phase.Flow)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", phase.Name, err)
+		}
+		if phase.Concurrency > 0 {
+			phase.Devices = phase.Concurrency
+		}
+		//a reboot phase reboots all the devices with an address by default
+		if phase.Devices <= 0 && !f.reboot {
+			phase.Devices = 1
+		}
+		if f.inform {
+			if len(phase.CIAddr) == 0 {
+				phase.CIAddr = utility.CIAddr
+			}
+			if _, err := parseCIAddr(phase.CIAddr); err != nil {
+				return nil, fmt.Errorf("%s: %s", phase.Name, err)
+			}
+		}
+		if len(phase.Profile) > 0 {
+			if _, err := parseProfile(phase.Profile); err != nil {
+				return nil, fmt.Errorf("%s: %s", phase.Name, err)
+			}
+		} else if phase.Rate <= 0 && phase.Concurrency <= 0 {
+			return nil, fmt.Errorf("%s: rate should be greater than 0", phase.Name)
+		}
+		if phase.Search == nil && phase.Duration <= 0 {
+			return nil, fmt.Errorf("%s: duration should be greater than 0", phase.Name)
+		}
+		if phase.Hold <= 0 {
+			phase.Hold = Duration(utility.Timeout)
+		}
+		if phase.SLO == nil {
+			phase.SLO = scenario.SLO
+		}
+		if phase.Search != nil && phase.Concurrency > 0 {
+			return nil, fmt.Errorf("%s: search and concurrency can't be used together", phase.Name)
+		}
+		if search := phase.Search; search != nil {
+			if phase.SLO == nil {
+				return nil, fmt.Errorf("%s: the search needs an SLO", phase.Name)
+			}
+			if phase.Rate <= 0 {
+				return nil, fmt.Errorf("%s: the search starts from the rate, it should be greater than 0", phase.Name)
+			}
+			if search.Step <= 0 {
+				search.Step = phase.Rate
+			}
+			if search.StepDuration <= 0 {
+				search.StepDuration = Duration(10 * time.Second)
+			}
+			if search.MaxRate <= 0 {
+				search.MaxRate = phase.Rate * 100
+			}
+		}
+	}
+	return scenario, nil
+}
+
+// runScenario runs the phases of the scenario file in order and returns the exit code
+func runScenario(dc client, path string) int {
+	scenario, err := loadScenario(path)
+	if err != nil {
+		log.Println(err)
+		return exitBadScenario
+	}
+
+	parser := &utility.Parser{}
+	parser.Init()
+	code := exitOK
+	for _, phase := range scenario.Phases {
+		if phase.Search != nil {
+			knee, err := runSearch(dc, parser, phase)
+			if err != nil {
+				log.Printf("phase %s: %s", phase.Name, err)
+				return exitBadScenario
+			}
+			if knee == 0 {
+				log.Printf("phase %s: no rate meets the SLO", phase.Name)
+				code = exitSLOViolated
+			} else {
+				log.Printf("phase %s: knee point at %d/s", phase.Name, knee)
+			}
+			continue
+		}
+		summary, err := runPhase(dc, parser, phase)
+		if err != nil {
+			log.Printf("phase %s: %s", phase.Name, err)
+			return exitBadScenario
+		}
+		log.Printf("phase %s %s", phase.Name, summary)
+		if phase.Concurrency > 0 {
+			f, _ := parseFlow(phase.Flow)
+			log.Printf("phase %s: %d concurrent devices, %.2f transactions/s",
+				phase.Name, phase.Concurrency, float64(succeeded(summary, f.request))/summary.Elapsed.Seconds())
+		}
+		for _, violation := range checkSLO(phase, summary) {
+			log.Printf("phase %s: SLO violated, %s", phase.Name, violation)
+			code = exitSLOViolated
+		}
+	}
+	return code
+}
+
+// preparePhase returns the client and the macs of the phase and sets the global settings the
+// packets are built from, restore puts the settings back when the phase is over
+func preparePhase(dc client, parser *utility.Parser, phase Phase) (phaseClient client, macList []net.HardwareAddr, restore func(), err error) {
+	f, _ := parseFlow(phase.Flow)
+
+	var macs []net.HardwareAddr
+	for _, value := range phase.Macs {
+		mac, err := net.ParseMAC(value)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		macs = append(macs, mac)
+	}
+	phaseClient = dc
+	if f.requestOnly() {
+		v4, ok := dc.(v4Client)
+		if !ok {
+			return nil, nil, nil, fmt.Errorf("flow %s is only supported with dhcpv4", phase.Flow)
+		}
+		if f.reboot {
+			addresses := v4.Addresses()
+			phaseClient = rebootClient{v4, addresses}
+			macList, err = rebootDevices(addresses, phase.Devices, macs)
+		} else {
+			ciaddr, _ := parseCIAddr(phase.CIAddr)
+			if err := v4.OpenResponder(); err != nil {
+				return nil, nil, nil, err
+			}
+			phaseClient = informClient{v4, ciaddr}
+		}
+	}
+	if len(macs) == 0 {
+		macs = clientMacs
+	}
+	if !f.reboot {
+		macList, err = newMacList(phase.Devices, macs)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	defaultOptions, defaultTemplates := utility.DhcpOptions, utility.OptionTemplates
+	defaultRenew, defaultRelease := utility.Renew, utility.Release
+	restore = func() {
+		utility.DhcpOptions, utility.OptionTemplates = defaultOptions, defaultTemplates
+		utility.Renew, utility.Release = defaultRenew, defaultRelease
+	}
+	if len(phase.Options) > 0 {
+		options, templates, err := parser.Parse(utility.RequestParams(phase.Options))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		utility.DhcpOptions, utility.OptionTemplates = options, templates
+	}
+	utility.Renew = utility.Renew || f.renew
+	utility.Release = utility.Release || f.release
+	return phaseClient, macList, restore, nil
+}
+
+func runPhase(dc client, parser *utility.Parser, phase Phase) (connection.Summary, error) {
+	f, _ := parseFlow(phase.Flow)
+	dc, macList, restore, err := preparePhase(dc, parser, phase)
+	if err != nil {
+		return connection.Summary{}, err
+	}
+	defer restore()
+
+	var profile Profile = constantProfile(phase.Rate)
+	if len(phase.Profile) > 0 {
+		profile, _ = parseProfile(phase.Profile)
+	}
+	size := int(math.Ceil(profile.Max()))
+	if phase.Concurrency > 0 {
+		log.Printf("phase %s start: %d concurrent devices, duration %s, flow %s",
+			phase.Name, len(macList), time.Duration(phase.Duration), phase.Flow)
+		size = phase.Concurrency
+	} else {
+		log.Printf("phase %s start: %d devices, rate %s, duration %s, flow %s",
+			phase.Name, len(macList), profile, time.Duration(phase.Duration), phase.Flow)
+	}
+	dc.Start(size*3, f.request, false)
+	loggerStop := make(chan int)
+	go report(dc, loggerStop, true)
+
+	stop := make(chan int)
+	timer := time.AfterFunc(time.Duration(phase.Duration), func() {
+		stop <- 1
+	})
+	defer timer.Stop()
+	if phase.Concurrency > 0 {
+		concurrencyTest(dc, macList, stop)
+	} else {
+		loadTest(dc, macList, profile, stop)
+	}
+
+	//wait for the replies of the last packets, or keep the leases for a while
+	time.Sleep(time.Duration(phase.Hold))
+	summary := dc.Stats().Total()
+	loggerStop <- 1
+	dc.Stop()
+	return summary, nil
+}
+
+// runSearch raises the rate of the phase by a step every step duration as long
+// as the step meets the phase SLO, it returns the last rate which met it. The
+// transactions of a step are over, or --timeout has passed, before the step
+// is checked and the next one starts
+func runSearch(dc client, parser *utility.Parser, phase Phase) (int, error) {
+	f, _ := parseFlow(phase.Flow)
+	dc, macList, restore, err := preparePhase(dc, parser, phase)
+	if err != nil {
+		return 0, err
+	}
+	defer restore()
+
+	search := phase.Search
+	log.Printf("phase %s start: %d devices, search from %d/s by %d/s every %s up to %d/s, flow %s",
+		phase.Name, len(macList), phase.Rate, search.Step, time.Duration(search.StepDuration), search.MaxRate, phase.Flow)
+	dc.Start(search.MaxRate*3, f.request, false)
+	//the interval summaries are the steps of the search, the logger leaves them alone
+	loggerStop := make(chan int)
+	go report(dc, loggerStop, false)
+
+	counted := inFlightClient{client: dc, count: new(int64)}
+	knee := 0
+	dc.Stats().Interval()
+	for rate := phase.Rate; rate <= search.MaxRate; rate += search.Step {
+		stop := make(chan int)
+		timer := time.AfterFunc(time.Duration(search.StepDuration), func() {
+			stop <- 1
+		})
+		loadTest(counted, macList, constantProfile(rate), stop)
+		timer.Stop()
+		if !counted.wait(utility.Timeout) {
+			log.Printf("phase %s step %d/s: %d transactions still in flight after %s", phase.Name, rate, counted.inFlight(), utility.Timeout)
+		}
+		summary := dc.Stats().Interval()
+		log.Printf("phase %s step %d/s %s", phase.Name, rate, summary)
+		exporter.Summary(fmt.Sprintf("step %d", rate), summary)
+		violations := checkSLO(phase, summary)
+		for _, violation := range violations {
+			log.Printf("phase %s step %d/s: SLO violated, %s", phase.Name, rate, violation)
+		}
+		if len(violations) > 0 {
+			break
+		}
+		knee = rate
+	}
+
+	time.Sleep(time.Duration(phase.Hold))
+	loggerStop <- 1
+	dc.Stop()
+	return knee, nil
+}
+
+// inFlightClient counts the transactions started by its executor which are
+// not over yet
+type inFlightClient struct {
+	client
+	count *int64
+}
+
+func (c inFlightClient) executor() bender.RequestExecutor {
+	execute := c.client.executor()
+	return func(now int64, request interface{}) (interface{}, error) {
+		response, err := execute(now, request)
+		if t, ok := response.(transaction); ok && err == nil {
+			atomic.AddInt64(c.count, 1)
+			go func() {
+				<-t.Done()
+				atomic.AddInt64(c.count, -1)
+			}()
+		}
+		return response, err
+	}
+}
+
+func (c inFlightClient) inFlight() int64 {
+	return atomic.LoadInt64(c.count)
+}
+
+// wait waits for the transactions to be over, for at most timeout. It tells
+// whether they are
+func (c inFlightClient) wait(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for c.inFlight() > 0 {
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// checkSLO returns the violations of the phase SLO
+func checkSLO(phase Phase, summary connection.Summary) []string {
+	var violations []string
+	slo := phase.SLO
+	if slo == nil {
+		return violations
+	}
+	f, _ := parseFlow(phase.Flow)
+
+	if slo.SuccessRatio > 0 {
+		sent := summary.Discovers
+		if f.requestOnly() {
+			sent = summary.Requests
+		}
+		ratio := 0.0
+		if sent > 0 {
+			ratio = float64(succeeded(summary, f.request)) / float64(sent)
+		}
+		if ratio < slo.SuccessRatio {
+			violations = append(violations, fmt.Sprintf("success ratio %.4f < %.4f", ratio, slo.SuccessRatio))
+		}
+	}
+
+	if slo.P99 > 0 {
+		check := func(msgType layers.DHCPMsgType, latency connection.Latency) {
+			if latency.P99 > time.Duration(slo.P99) {
+				violations = append(violations, fmt.Sprintf("%s p99 %s > %s", msgType, latency.P99, time.Duration(slo.P99)))
+			}
+		}
+		if !f.requestOnly() {
+			check(layers.DHCPMsgTypeOffer, summary.Offer)
+		}
+		if f.request {
+			check(layers.DHCPMsgTypeAck, summary.Ack)
+		}
+	}
+	return violations
+}
+
+// succeeded returns the number of transactions which got an offer, or an ack
+// when the flow requests the offers
+func succeeded(summary connection.Summary, request bool) int {
+	if request {
+		return summary.Acks
+	}
+	return summary.Offers
+}
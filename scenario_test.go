@@ -0,0 +1,180 @@
+package main
+
+import (
+	"dhcptest/connection"
+	"dhcptest/utility"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseFlow(t *testing.T) {
+	for _, c := range []struct {
+		value string
+		want  flow
+	}{
+		{"", flow{request: true}},
+		{"discover", flow{}},
+		{"dora+renew+release", flow{request: true, renew: true, release: true}},
+		{"reboot", flow{request: true, reboot: true}},
+		{"reboot+release", flow{request: true, reboot: true, release: true}},
+		{"Inform", flow{request: true, inform: true}},
+	} {
+		f, err := parseFlow(c.value)
+		if err != nil || f != c.want {
+			t.Errorf("%q parses to %+v: %v, want %+v", c.value, f, err, c.want)
+		}
+	}
+	if f, _ := parseFlow("reboot"); !f.requestOnly() {
+		t.Error("a reboot starts with a discover")
+	}
+	if f, _ := parseFlow("inform"); !f.requestOnly() {
+		t.Error("an inform starts with a discover")
+	}
+	for _, value := range []string{"inform+renew", "inform+release", "renew", "dora+reboot", "dora+"} {
+		if _, err := parseFlow(value); err == nil {
+			t.Errorf("%q accepted", value)
+		}
+	}
+}
+
+func TestCheckSLO(t *testing.T) {
+	phase := Phase{Flow: "dora", SLO: &SLO{SuccessRatio: 0.9, P99: Duration(100 * time.Millisecond)}}
+	summary := connection.Summary{Discovers: 100, Offers: 100, Acks: 95}
+	summary.Offer.P99, summary.Ack.P99 = 10*time.Millisecond, 50*time.Millisecond
+	if violations := checkSLO(phase, summary); len(violations) != 0 {
+		t.Errorf("a good step violates %v", violations)
+	}
+
+	summary.Acks = 80
+	summary.Ack.P99 = 200 * time.Millisecond
+	if violations := checkSLO(phase, summary); len(violations) != 2 {
+		t.Errorf("a bad step violates %v", violations)
+	}
+
+	//a discover only flow takes the offers
+	phase.Flow = "discover"
+	if violations := checkSLO(phase, summary); len(violations) != 0 {
+		t.Errorf("the discover flow violates %v", violations)
+	}
+	if violations := checkSLO(phase, connection.Summary{}); len(violations) != 1 {
+		t.Errorf("a step without a discover violates %v", violations)
+	}
+	phase.SLO = nil
+	if violations := checkSLO(phase, summary); len(violations) != 0 {
+		t.Errorf("no SLO violates %v", violations)
+	}
+}
+
+func TestLoadScenario(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scenario")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	fromJSON, err := loadScenario(write("scenario.json", `{
+  "slo": {"success_ratio": 0.99, "p99": "200ms"},
+  "phases": [
+    {"name": "warmup", "devices": 100, "rate": 50, "duration": "30s", "flow": "discover"},
+    {"rate": 200, "duration": "2m", "flow": "dora+renew", "hold": "1m",
+     "options": ["60=Initech Groupware"], "macs": ["02:00:00:00:00:01"]},
+    {"rate": 50, "flow": "dora", "search": {"step": 50, "step_duration": "30s", "max_rate": 2000}}
+  ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromYAML, err := loadScenario(write("scenario.yml", `
+slo: {success_ratio: 0.99, p99: 200ms}
+phases:
+  - {name: warmup, devices: 100, rate: 50, duration: 30s, flow: discover}
+  - rate: 200
+    duration: 2m
+    flow: dora+renew
+    hold: 1m
+    options: ["60=Initech Groupware"]
+    macs: ["02:00:00:00:00:01"]
+  - rate: 50
+    flow: dora
+    search: {step: 50, step_duration: 30s, max_rate: 2000}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromJSON, fromYAML) {
+		t.Errorf("the yaml scenario %+v differs from the json one %+v", fromYAML, fromJSON)
+	}
+	phase := fromYAML.Phases[1]
+	if phase.Name != "phase-2" || phase.Devices != 1 || phase.Hold != Duration(time.Minute) || phase.SLO != fromYAML.SLO {
+		t.Errorf("phase %+v", phase)
+	}
+	if search := fromYAML.Phases[2].Search; search.StepDuration != Duration(30*time.Second) || fromYAML.SLO.P99 != Duration(200*time.Millisecond) {
+		t.Errorf("search %+v, slo %+v", search, fromYAML.SLO)
+	}
+
+	for name, content := range map[string]string{
+		"typo.yaml":     "phases:\n  - {rate: 10, duration: 1s, flwo: dora}\n",
+		"duration.yaml": "phases:\n  - {rate: 10, duration: 10}\n",
+		"flow.json":     `{"phases": [{"rate": 10, "duration": "1s", "flow": "dance"}]}`,
+		"empty.yml":     "slo: {success_ratio: 0.99}\n",
+	} {
+		if _, err := loadScenario(write(name, content)); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
+
+func TestPreparePhaseRestores(t *testing.T) {
+	parser := &utility.Parser{}
+	parser.Init()
+	defaultOptions, _, err := parser.Parse(utility.RequestParams{"60=default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	utility.DhcpOptions, utility.OptionTemplates = defaultOptions, nil
+	utility.Renew, utility.Release = false, false
+	defer func() {
+		utility.DhcpOptions, utility.OptionTemplates = nil, nil
+	}()
+
+	phase := Phase{Devices: 1, Flow: "dora+renew+release", Macs: []string{"02:00:00:00:00:01"},
+		Options: []string{"60=phase", "12=host-{index}"}}
+	_, macList, restore, err := preparePhase(&fakeClient{}, parser, phase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(macList) != 1 || macList[0].String() != phase.Macs[0] {
+		t.Errorf("macs %v", macList)
+	}
+	if string(utility.DhcpOptions[0].Data) != "phase" || len(utility.OptionTemplates) != 1 || !utility.Renew || !utility.Release {
+		t.Errorf("the phase settings aren't set: options %v, templates %v, renew %v, release %v",
+			utility.DhcpOptions, utility.OptionTemplates, utility.Renew, utility.Release)
+	}
+
+	restore()
+	if !reflect.DeepEqual(utility.DhcpOptions, defaultOptions) || utility.OptionTemplates != nil || utility.Renew || utility.Release {
+		t.Errorf("the settings of the command line aren't restored: options %v, templates %v, renew %v, release %v",
+			utility.DhcpOptions, utility.OptionTemplates, utility.Renew, utility.Release)
+	}
+
+	//a phase without options keeps the ones of the command line
+	_, _, restore, err = preparePhase(&fakeClient{}, parser, Phase{Devices: 1, Flow: "discover", Macs: phase.Macs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(utility.DhcpOptions, defaultOptions) {
+		t.Errorf("options %v", utility.DhcpOptions)
+	}
+	restore()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"dhcptest/server"
+	"dhcptest/utility"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// newServerConfig builds the emulated server settings from the command-line
+func newServerConfig(iface *net.Interface) (server.Config, error) {
+	config := server.Config{
+		LeaseTime: utility.LeaseTime,
+		Latency:   utility.Latency,
+		DropRate:  utility.DropRate,
+		NakRatio:  utility.NakRatio,
+		Broadcast: utility.BroadcastReplies,
+	}
+
+	if len(utility.ServerIP) > 0 {
+		config.ServerIP = net.ParseIP(utility.ServerIP).To4()
+		if config.ServerIP == nil {
+			return config, fmt.Errorf("invalid server ip: %s", utility.ServerIP)
+		}
+	} else {
+		ips, err := utility.GetUnicastIPofInterface(iface)
+		if err != nil {
+			return config, err
+		}
+		if len(ips) == 0 {
+			return config, fmt.Errorf("no ipv4 address on %s, use --server-ip", iface.Name)
+		}
+		config.ServerIP = ips[0]
+	}
+
+	pool := strings.Split(utility.ServerPool, "-")
+	if len(pool) != 2 {
+		return config, fmt.Errorf("invalid pool: %q, e.g. --pool 192.168.0.100-192.168.0.200", utility.ServerPool)
+	}
+	config.PoolStart, config.PoolEnd = net.ParseIP(strings.TrimSpace(pool[0])), net.ParseIP(strings.TrimSpace(pool[1]))
+
+	netmask := net.ParseIP(utility.Netmask).To4()
+	if netmask == nil {
+		return config, fmt.Errorf("invalid netmask: %s", utility.Netmask)
+	}
+	config.Netmask = net.IPMask(netmask)
+
+	if len(utility.Router) > 0 {
+		config.Router = net.ParseIP(utility.Router).To4()
+		if config.Router == nil {
+			return config, fmt.Errorf("invalid router: %s", utility.Router)
+		}
+	}
+
+	if len(utility.DNS) > 0 {
+		for _, value := range strings.Split(utility.DNS, ",") {
+			dns := net.ParseIP(strings.TrimSpace(value)).To4()
+			if dns == nil {
+				return config, fmt.Errorf("invalid dns: %s", value)
+			}
+			config.DNS = append(config.DNS, dns)
+		}
+	}
+
+	if config.DropRate < 0 || config.DropRate > 1 || config.NakRatio < 0 || config.NakRatio > 1 {
+		return config, fmt.Errorf("drop rate and nak ratio should be between 0 and 1")
+	}
+	return config, nil
+}
+
+// serve runs the emulated dhcp server on the interface until it is interrupted
+func serve(iface *net.Interface) error {
+	config, err := newServerConfig(iface)
+	if err != nil {
+		return err
+	}
+	s := &server.Server{
+		Iface:  iface,
+		Config: config,
+	}
+	if err := s.Open(); err != nil {
+		return err
+	}
+	defer s.Close()
+	s.Start()
+	defer s.Stop()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(time.Second * 5)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			log.Printf("leased: %d/%d, %s", s.Pool().Leased(), s.Pool().Size(), s.Counters())
+		case <-signals:
+			return nil
+		}
+	}
+}
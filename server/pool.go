@@ -0,0 +1,194 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ErrPoolExhausted = errors.New("no free address in the pool")
+	ErrNotInPool     = errors.New("address is not in the pool")
+	ErrAddressInUse  = errors.New("address is leased to another client")
+)
+
+// offerHold is how long an offered address stays reserved for the client
+const offerHold = time.Minute
+
+type poolLease struct {
+	mac    string
+	expire time.Time
+}
+
+// Pool allocates the addresses of a range to client macs
+type Pool struct {
+	lock     sync.Mutex
+	start    uint32
+	size     uint32
+	next     uint32
+	leases   map[uint32]*poolLease
+	macs     map[string]uint32
+	declined map[uint32]time.Time
+}
+
+// NewPool creates a pool of the addresses from start to end, both included
+func NewPool(start net.IP, end net.IP) (*Pool, error) {
+	if start.To4() == nil || end.To4() == nil {
+		return nil, fmt.Errorf("pool %s-%s is not an ipv4 range", start, end)
+	}
+	first, last := ipToUint(start), ipToUint(end)
+	if last < first {
+		return nil, fmt.Errorf("pool %s-%s is empty", start, end)
+	}
+	return &Pool{
+		start:    first,
+		size:     last - first + 1,
+		leases:   make(map[uint32]*poolLease),
+		macs:     make(map[string]uint32),
+		declined: make(map[uint32]time.Time),
+	}, nil
+}
+
+// Size returns the number of addresses in the pool
+func (p *Pool) Size() int {
+	return int(p.size)
+}
+
+// Leased returns the number of addresses offered or leased right now
+func (p *Pool) Leased() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	now := time.Now()
+	leased := 0
+	for _, lease := range p.leases {
+		if now.Before(lease.expire) {
+			leased++
+		}
+	}
+	return leased
+}
+
+// Offer picks an address for the mac: the one it already holds, else the
+// requested one when it is free, else the next free one. The address is
+// reserved for a short time until the client requests it
+func (p *Pool) Offer(mac net.HardwareAddr, requested net.IP) (net.IP, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	now := time.Now()
+
+	if offset, ok := p.macs[mac.String()]; ok {
+		if _, declined := p.declined[offset]; !declined {
+			p.hold(offset, mac, now.Add(offerHold))
+			return p.ip(offset), nil
+		}
+	}
+	if offset, ok := p.offset(requested); ok && p.free(offset, now) {
+		p.hold(offset, mac, now.Add(offerHold))
+		return p.ip(offset), nil
+	}
+	for i := uint32(0); i < p.size; i++ {
+		offset := (p.next + i) % p.size
+		if p.free(offset, now) {
+			p.next = offset + 1
+			p.hold(offset, mac, now.Add(offerHold))
+			return p.ip(offset), nil
+		}
+	}
+	return nil, ErrPoolExhausted
+}
+
+// Lease binds the address to the mac for the given time, it fails when the
+// address is out of the pool or held by another client
+func (p *Pool) Lease(mac net.HardwareAddr, ip net.IP, leaseTime time.Duration) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	now := time.Now()
+	offset, ok := p.offset(ip)
+	if !ok {
+		return ErrNotInPool
+	}
+	if lease, ok := p.leases[offset]; ok && lease.mac != mac.String() && now.Before(lease.expire) {
+		return ErrAddressInUse
+	}
+	if until, ok := p.declined[offset]; ok && now.Before(until) {
+		return ErrAddressInUse
+	}
+	p.hold(offset, mac, now.Add(leaseTime))
+	return nil
+}
+
+// Release gives the address of the mac back to the pool
+func (p *Pool) Release(mac net.HardwareAddr, ip net.IP) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	offset, ok := p.offset(ip)
+	if !ok {
+		return
+	}
+	if lease, ok := p.leases[offset]; ok && lease.mac == mac.String() {
+		delete(p.leases, offset)
+		delete(p.macs, lease.mac)
+	}
+}
+
+// Decline takes the address out of the pool for the given time as a client
+// found it in use
+func (p *Pool) Decline(mac net.HardwareAddr, ip net.IP, hold time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	offset, ok := p.offset(ip)
+	if !ok {
+		return
+	}
+	if lease, ok := p.leases[offset]; ok && lease.mac == mac.String() {
+		delete(p.leases, offset)
+		delete(p.macs, lease.mac)
+	}
+	p.declined[offset] = time.Now().Add(hold)
+}
+
+func (p *Pool) hold(offset uint32, mac net.HardwareAddr, expire time.Time) {
+	if old, ok := p.macs[mac.String()]; ok && old != offset {
+		delete(p.leases, old)
+	}
+	if lease, ok := p.leases[offset]; ok && lease.mac != mac.String() {
+		delete(p.macs, lease.mac)
+	}
+	p.leases[offset] = &poolLease{mac: mac.String(), expire: expire}
+	p.macs[mac.String()] = offset
+}
+
+func (p *Pool) free(offset uint32, now time.Time) bool {
+	if until, ok := p.declined[offset]; ok {
+		if now.Before(until) {
+			return false
+		}
+		delete(p.declined, offset)
+	}
+	lease, ok := p.leases[offset]
+	return !ok || !now.Before(lease.expire)
+}
+
+func (p *Pool) offset(ip net.IP) (uint32, bool) {
+	if ip == nil || ip.To4() == nil {
+		return 0, false
+	}
+	value := ipToUint(ip)
+	if value < p.start || value-p.start >= p.size {
+		return 0, false
+	}
+	return value - p.start, true
+}
+
+func (p *Pool) ip(offset uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, p.start+offset)
+	return ip
+}
+
+func ipToUint(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
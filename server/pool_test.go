@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func testMac(i byte) net.HardwareAddr {
+	return net.HardwareAddr{0x02, 0, 0, 0, 0, i}
+}
+
+func TestPoolOfferAndLease(t *testing.T) {
+	pool, err := NewPool(net.IPv4(10, 0, 0, 10), net.IPv4(10, 0, 0, 11))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pool.Size() != 2 {
+		t.Fatalf("pool size %d, want 2", pool.Size())
+	}
+
+	first, err := pool.Offer(testMac(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := pool.Offer(testMac(1), nil)
+	if err != nil || !again.Equal(first) {
+		t.Fatalf("second offer to the same mac got %s %v, want %s", again, err, first)
+	}
+	if err := pool.Lease(testMac(2), first, time.Hour); err != ErrAddressInUse {
+		t.Fatalf("lease of an address offered to another mac got %v, want %v", err, ErrAddressInUse)
+	}
+	if err := pool.Lease(testMac(1), first, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := pool.Offer(testMac(2), nil)
+	if err != nil || second.Equal(first) {
+		t.Fatalf("offer to another mac got %s %v", second, err)
+	}
+	if _, err := pool.Offer(testMac(3), nil); err != ErrPoolExhausted {
+		t.Fatalf("offer from a full pool got %v, want %v", err, ErrPoolExhausted)
+	}
+	if pool.Leased() != 2 {
+		t.Fatalf("leased %d, want 2", pool.Leased())
+	}
+
+	pool.Release(testMac(1), first)
+	if ip, err := pool.Offer(testMac(3), first); err != nil || !ip.Equal(first) {
+		t.Fatalf("offer of the released address got %s %v, want %s", ip, err, first)
+	}
+}
+
+func TestPoolDecline(t *testing.T) {
+	pool, err := NewPool(net.IPv4(10, 0, 0, 10), net.IPv4(10, 0, 0, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, err := pool.Offer(testMac(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Decline(testMac(1), ip, time.Hour)
+	if _, err := pool.Offer(testMac(1), nil); err != ErrPoolExhausted {
+		t.Fatalf("offer of a declined address got %v, want %v", err, ErrPoolExhausted)
+	}
+	if err := pool.Lease(testMac(2), ip, time.Hour); err != ErrAddressInUse {
+		t.Fatalf("lease of a declined address got %v, want %v", err, ErrAddressInUse)
+	}
+	if err := pool.Lease(testMac(2), net.IPv4(10, 0, 0, 20), time.Hour); err != ErrNotInPool {
+		t.Fatalf("lease out of the pool got %v, want %v", err, ErrNotInPool)
+	}
+}
+
+func TestNewPoolInvalid(t *testing.T) {
+	if _, err := NewPool(net.IPv4(10, 0, 0, 11), net.IPv4(10, 0, 0, 10)); err == nil {
+		t.Fatal("reversed range should fail")
+	}
+	if _, err := NewPool(net.ParseIP("fe80::1"), net.IPv4(10, 0, 0, 10)); err == nil {
+		t.Fatal("ipv6 range should fail")
+	}
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"dhcptest/connection"
+	"dhcptest/layers"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// declineHold is how long a declined address stays out of the pool
+const declineHold = 10 * time.Minute
+
+// Config is the behaviour of the emulated server
+type Config struct {
+	ServerIP  net.IP
+	PoolStart net.IP
+	PoolEnd   net.IP
+	Netmask   net.IPMask
+	Router    net.IP
+	DNS       []net.IP
+	LeaseTime time.Duration
+	// Latency delays every reply
+	Latency time.Duration
+	// DropRate is the ratio of requests which get no reply at all
+	DropRate float64
+	// NakRatio is the ratio of requests which get a nak instead of an ack
+	NakRatio float64
+	// Broadcast broadcasts every reply instead of addressing it as RFC 2131 4.1 does
+	Broadcast bool
+}
+
+// Counters are the packets handled by the server since it started
+type Counters struct {
+	Received  uint64
+	Dropped   uint64
+	Offers    uint64
+	Acks      uint64
+	Naks      uint64
+	Releases  uint64
+	Declines  uint64
+	Exhausted uint64
+}
+
+func (c Counters) String() string {
+	return fmt.Sprintf("received: %d, dropped: %d, offer: %d, ack: %d, nak: %d, release: %d, decline: %d, pool exhausted: %d",
+		c.Received, c.Dropped, c.Offers, c.Acks, c.Naks, c.Releases, c.Declines, c.Exhausted)
+}
+
+// Server is a small DHCPv4 server used to test the client without a real server
+type Server struct {
+	Iface      *net.Interface
+	Config     Config
+	pool       *Pool
+	connection net.PacketConn
+	counters   Counters
+	stop       chan int
+	wg         *sync.WaitGroup
+}
+
+func (s *Server) Open() error {
+	if s.Config.ServerIP.To4() == nil {
+		return fmt.Errorf("server ip %s is not an ipv4 address", s.Config.ServerIP)
+	}
+	if s.Config.Netmask == nil {
+		s.Config.Netmask = net.IPv4Mask(255, 255, 255, 0)
+	}
+	if s.Config.LeaseTime <= 0 {
+		s.Config.LeaseTime = time.Hour
+	}
+	var err error
+	s.pool, err = NewPool(s.Config.PoolStart, s.Config.PoolEnd)
+	if err != nil {
+		return err
+	}
+	s.wg = new(sync.WaitGroup)
+	s.connection, err = listen(s.Iface)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (s *Server) Close() error {
+	return s.connection.Close()
+}
+
+func (s *Server) Start() {
+	s.stop = make(chan int)
+	s.wg.Add(1)
+	go s.serveLoop()
+	log.Printf("[%s] dhcp server %s serving %s-%s", s.Iface.Name, s.Config.ServerIP, s.Config.PoolStart, s.Config.PoolEnd)
+}
+
+func (s *Server) Stop() {
+	s.stop <- 1
+	s.wg.Wait()
+	log.Printf("[%s] dhcp server stopped, %s", s.Iface.Name, s.Counters())
+}
+
+// Pool returns the address pool of the server
+func (s *Server) Pool() *Pool {
+	return s.pool
+}
+
+// Counters returns a snapshot of the server counters
+func (s *Server) Counters() Counters {
+	return Counters{
+		Received:  atomic.LoadUint64(&s.counters.Received),
+		Dropped:   atomic.LoadUint64(&s.counters.Dropped),
+		Offers:    atomic.LoadUint64(&s.counters.Offers),
+		Acks:      atomic.LoadUint64(&s.counters.Acks),
+		Naks:      atomic.LoadUint64(&s.counters.Naks),
+		Releases:  atomic.LoadUint64(&s.counters.Releases),
+		Declines:  atomic.LoadUint64(&s.counters.Declines),
+		Exhausted: atomic.LoadUint64(&s.counters.Exhausted),
+	}
+}
+
+func (s *Server) serveLoop() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.stop:
+			return
+		default:
+			recvBuf := make([]byte, connection.MAXUDPReceivedPacketSize)
+			s.connection.SetReadDeadline(time.Now().Add(connection.DefaultReadTimeout))
+			n, from, err := s.connection.ReadFrom(recvBuf)
+			if err != nil {
+				continue
+			}
+			request := parseRequest(recvBuf[:n])
+			if request == nil || request.Operation != layers.DHCPOpRequest {
+				continue
+			}
+			atomic.AddUint64(&s.counters.Received, 1)
+			if s.Config.DropRate > 0 && rand.Float64() < s.Config.DropRate {
+				atomic.AddUint64(&s.counters.Dropped, 1)
+				continue
+			}
+			reply := s.handle(request)
+			if reply == nil {
+				continue
+			}
+			if s.Config.Latency > 0 {
+				time.AfterFunc(s.Config.Latency, func() {
+					s.reply(request, reply, from)
+				})
+			} else {
+				s.reply(request, reply, from)
+			}
+		}
+	}
+}
+
+// handle returns the reply to a client request, or nil when there is none
+func (s *Server) handle(request *layers.DHCPv4) *layers.DHCPv4 {
+	mac := request.ClientHWAddr
+	switch request.MessageType() {
+	case layers.DHCPMsgTypeDiscover:
+		ip, err := s.pool.Offer(mac, requestedIP(request))
+		if err != nil {
+			atomic.AddUint64(&s.counters.Exhausted, 1)
+			return nil
+		}
+		atomic.AddUint64(&s.counters.Offers, 1)
+		return s.newLeaseReply(request, layers.DHCPMsgTypeOffer, ip)
+
+	case layers.DHCPMsgTypeRequest:
+		if serverID := optionIP(request, layers.DHCPOptServerID); serverID != nil && !serverID.Equal(s.Config.ServerIP) {
+			//the client took the offer of another server
+			s.pool.Release(mac, requestedIP(request))
+			return nil
+		}
+		ip := requestedIP(request)
+		if ip == nil {
+			ip = request.ClientIP
+		}
+		if s.Config.NakRatio > 0 && rand.Float64() < s.Config.NakRatio {
+			atomic.AddUint64(&s.counters.Naks, 1)
+			return s.newNak(request)
+		}
+		if err := s.pool.Lease(mac, ip, s.Config.LeaseTime); err != nil {
+			atomic.AddUint64(&s.counters.Naks, 1)
+			return s.newNak(request)
+		}
+		atomic.AddUint64(&s.counters.Acks, 1)
+		return s.newLeaseReply(request, layers.DHCPMsgTypeAck, ip)
+
+	case layers.DHCPMsgTypeRelease:
+		atomic.AddUint64(&s.counters.Releases, 1)
+		s.pool.Release(mac, request.ClientIP)
+		return nil
+
+	case layers.DHCPMsgTypeDecline:
+		atomic.AddUint64(&s.counters.Declines, 1)
+		s.pool.Decline(mac, requestedIP(request), declineHold)
+		return nil
+
+	case layers.DHCPMsgTypeInform:
+		atomic.AddUint64(&s.counters.Acks, 1)
+		reply := s.newReply(request, layers.DHCPMsgTypeAck)
+		connection.WithClientIP(request.ClientIP)(reply)
+		s.addConfig(reply)
+		return reply
+
+	default:
+		return nil
+	}
+}
+
+func (s *Server) newReply(request *layers.DHCPv4, msgType layers.DHCPMsgType) *layers.DHCPv4 {
+	reply := connection.NewPacket()
+	connection.WithReply(request)(reply)
+	connection.WithMessageType(msgType)(reply)
+	connection.WithOption(layers.DHCPOptServerID, []byte(s.Config.ServerIP.To4()))(reply)
+	for _, option := range request.Options {
+		if option.Type == layers.DHCPOptRelayAgent {
+			//echo the relay agent information, RFC 3046 2.2
+			connection.WithOption(option.Type, option.Data)(reply)
+		}
+	}
+	return reply
+}
+
+func (s *Server) newLeaseReply(request *layers.DHCPv4, msgType layers.DHCPMsgType, ip net.IP) *layers.DHCPv4 {
+	reply := s.newReply(request, msgType)
+	connection.WithYourIP(ip)(reply)
+	connection.WithServerIP(s.Config.ServerIP)(reply)
+	seconds := uint32(s.Config.LeaseTime / time.Second)
+	connection.WithLeaseTime(seconds)(reply)
+	connection.WithOption(layers.DHCPOptT1, uint32Bytes(seconds/2))(reply)
+	connection.WithOption(layers.DHCPOptT2, uint32Bytes(seconds/8*7))(reply)
+	s.addConfig(reply)
+	return reply
+}
+
+func (s *Server) newNak(request *layers.DHCPv4) *layers.DHCPv4 {
+	reply := s.newReply(request, layers.DHCPMsgTypeNak)
+	connection.WithBroadcast(true)(reply)
+	return reply
+}
+
+func (s *Server) addConfig(reply *layers.DHCPv4) {
+	connection.WithNetmask(s.Config.Netmask)(reply)
+	if s.Config.Router != nil {
+		connection.WithOption(layers.DHCPOptRouter, []byte(s.Config.Router.To4()))(reply)
+	}
+	if len(s.Config.DNS) > 0 {
+		var data []byte
+		for _, dns := range s.Config.DNS {
+			data = append(data, dns.To4()...)
+		}
+		connection.WithOption(layers.DHCPOptDNS, data)(reply)
+	}
+}
+
+// destination returns where the reply goes, RFC 2131 4.1: to the relay agent,
+// else to the address the client holds, else broadcast when the client asks
+// for it or the reply is a nak, else to the offered address
+func (s *Server) destination(request *layers.DHCPv4, reply *layers.DHCPv4) *net.UDPAddr {
+	switch {
+	case !isZero(request.RelayAgentIP):
+		return &net.UDPAddr{IP: request.RelayAgentIP, Port: 67}
+	case s.Config.Broadcast || reply.MessageType() == layers.DHCPMsgTypeNak:
+	case !isZero(request.ClientIP):
+		return &net.UDPAddr{IP: request.ClientIP, Port: 68}
+	case request.Flags&uint16(layers.BroadcastFlag) == 0 && !isZero(reply.YourClientIP):
+		return &net.UDPAddr{IP: reply.YourClientIP, Port: 68}
+	}
+	return &net.UDPAddr{IP: net.IPv4bcast, Port: 68}
+}
+
+func requestedIP(request *layers.DHCPv4) net.IP {
+	return optionIP(request, layers.DHCPOptRequestIP)
+}
+
+func optionIP(packet *layers.DHCPv4, opt layers.DHCPOpt) net.IP {
+	for _, option := range packet.Options {
+		if option.Type == opt && len(option.Data) == 4 {
+			return net.IP(option.Data)
+		}
+	}
+	return nil
+}
+
+func isZero(ip net.IP) bool {
+	return ip == nil || ip.Equal(net.IPv4zero)
+}
+
+func uint32Bytes(value uint32) []byte {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, value)
+	return data
+}
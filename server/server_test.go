@@ -0,0 +1,133 @@
+// +build linux
+
+package server
+
+import (
+	"dhcptest/connection"
+	"dhcptest/layers"
+	"dhcptest/utility"
+	"math/rand"
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// vethPair creates a veth pair for the end-to-end tests, they are skipped
+// when it can't be done (not root, no veth support)
+func vethPair(t *testing.T, name string, peer string) (*net.Interface, *net.Interface) {
+	if os.Geteuid() != 0 {
+		t.Skip("end-to-end tests need root")
+	}
+	if out, err := exec.Command("ip", "link", "add", name, "type", "veth", "peer", "name", peer).CombinedOutput(); err != nil {
+		t.Skipf("can't create veth pair: %s %s", err, out)
+	}
+	for _, link := range []string{name, peer} {
+		if out, err := exec.Command("ip", "link", "set", link, "up").CombinedOutput(); err != nil {
+			exec.Command("ip", "link", "del", name).Run()
+			t.Fatalf("can't bring %s up: %s %s", link, err, out)
+		}
+	}
+	serverIface, err := net.InterfaceByName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientIface, err := net.InterfaceByName(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serverIface, clientIface
+}
+
+func TestDestination(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	yiaddr, ciaddr, giaddr := net.IPv4(10, 0, 0, 5).To4(), net.IPv4(10, 0, 0, 6).To4(), net.IPv4(10, 1, 0, 1).To4()
+	s := &Server{Config: Config{ServerIP: net.IPv4(10, 0, 0, 1)}}
+
+	for _, c := range []struct {
+		name      string
+		request   func(*layers.DHCPv4)
+		msgType   layers.DHCPMsgType
+		broadcast bool
+		want      string
+	}{
+		{"broadcast flag", func(*layers.DHCPv4) {}, layers.DHCPMsgTypeOffer, false, "255.255.255.255:68"},
+		{"unicast flag", connection.WithBroadcast(false), layers.DHCPMsgTypeOffer, false, "10.0.0.5:68"},
+		{"ciaddr", connection.WithClientIP(ciaddr), layers.DHCPMsgTypeAck, false, "10.0.0.6:68"},
+		{"giaddr", connection.WithRelay(giaddr), layers.DHCPMsgTypeOffer, false, "10.1.0.1:67"},
+		{"nak", connection.WithClientIP(ciaddr), layers.DHCPMsgTypeNak, false, "255.255.255.255:68"},
+		{"nak relayed", connection.WithRelay(giaddr), layers.DHCPMsgTypeNak, false, "10.1.0.1:67"},
+		{"broadcast replies", connection.WithClientIP(ciaddr), layers.DHCPMsgTypeAck, true, "255.255.255.255:68"},
+	} {
+		request := connection.NewDiscover(mac)
+		c.request(request)
+		reply := s.newReply(request, c.msgType)
+		if c.msgType != layers.DHCPMsgTypeNak {
+			connection.WithYourIP(yiaddr)(reply)
+		}
+		s.Config.Broadcast = c.broadcast
+		if got := s.destination(request, reply).String(); got != c.want {
+			t.Errorf("%s: reply sent to %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestServerDORA(t *testing.T) {
+	serverIface, clientIface := vethPair(t, "dtsrv0", "dtcli0")
+	defer exec.Command("ip", "link", "del", serverIface.Name).Run()
+	//wait for the links to come up
+	time.Sleep(500 * time.Millisecond)
+
+	utility.Timeout = 2 * time.Second
+	s := &Server{
+		Iface: serverIface,
+		Config: Config{
+			ServerIP:  net.IPv4(10, 99, 0, 1),
+			PoolStart: net.IPv4(10, 99, 0, 100),
+			PoolEnd:   net.IPv4(10, 99, 0, 199),
+			Latency:   time.Millisecond,
+		},
+	}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Start()
+	defer s.Stop()
+
+	dc := &connection.DhcpClient{Iface: clientIface}
+	if err := dc.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer dc.Close()
+
+	const devices = 20
+	dc.Start(devices, true, true)
+	for i := 0; i < devices; i++ {
+		packet := connection.NewPacket()
+		connection.WithHWType(layers.LinkTypeEthernet)(packet)
+		connection.WithHwAddr(net.HardwareAddr{0x02, 0, 0, 0, 1, byte(i)})(packet)
+		connection.WithMessageType(layers.DHCPMsgTypeDiscover)(packet)
+		//half of the clients take the replies unicast to their chaddr
+		connection.WithBroadcast(i%2 == 0)(packet)
+		dc.Send(packet, connection.WithTransactionID(rand.Uint32()))
+	}
+
+	deadline := time.Now().Add(utility.Timeout * 2)
+	for dc.Stats().Total().Acks < devices && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	summary := dc.Stats().Total()
+	dc.Stop()
+
+	if summary.Offers != devices || summary.Acks != devices {
+		t.Fatalf("client got %d offers and %d acks, want %d", summary.Offers, summary.Acks, devices)
+	}
+	if leased := s.Pool().Leased(); leased != devices {
+		t.Fatalf("server leased %d addresses, want %d", leased, devices)
+	}
+	if counters := s.Counters(); counters.Acks != devices {
+		t.Fatalf("server sent %d acks, want %d", counters.Acks, devices)
+	}
+}
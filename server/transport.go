@@ -0,0 +1,69 @@
+// +build !windows
+
+package server
+
+import (
+	"dhcptest/connection"
+	"dhcptest/layers"
+	"github.com/google/gopacket"
+	"github.com/mdlayher/raw"
+	"net"
+	"time"
+)
+
+func listen(iface *net.Interface) (net.PacketConn, error) {
+	return connection.UDPListener()(iface)
+}
+
+func parseRequest(data []byte) *layers.DHCPv4 {
+	return connection.ParsePacket(data, layers.LayerTypeEthernet)
+}
+
+// reply writes the frame to the link address of the destination: the sender
+// of the request for a relay agent, else the chaddr, as the server can't arp
+// for an address the client doesn't hold yet
+func (s *Server) reply(request *layers.DHCPv4, reply *layers.DHCPv4, from net.Addr) {
+	dst := s.destination(request, reply)
+
+	eth := layers.Ethernet{
+		EthernetType: layers.EthernetTypeIPv4,
+		SrcMAC:       s.Iface.HardwareAddr,
+		DstMAC:       layers.EthernetBroadcast,
+	}
+	switch {
+	case dst.IP.Equal(net.IPv4bcast):
+	case dst.Port == 67:
+		if addr, ok := from.(*raw.Addr); ok {
+			eth.DstMAC = addr.HardwareAddr
+		}
+	default:
+		eth.DstMAC = request.ClientHWAddr
+	}
+
+	ip := layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		SrcIP:    s.Config.ServerIP,
+		DstIP:    dst.IP,
+		Protocol: layers.IPProtocolUDP,
+	}
+
+	udp := layers.UDP{
+		SrcPort: 67,
+		DstPort: layers.UDPPort(dst.Port),
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{
+		ComputeChecksums: true,
+		FixLengths:       true,
+	}
+	udp.SetNetworkLayerForChecksum(&ip)
+
+	if err := gopacket.SerializeLayers(buf, opts, &eth, &ip, &udp, reply); err != nil {
+		return
+	}
+
+	s.connection.SetWriteDeadline(time.Now().Add(connection.DefaultWriteTimeout))
+	s.connection.WriteTo(buf.Bytes(), &raw.Addr{HardwareAddr: eth.DstMAC})
+}
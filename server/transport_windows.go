@@ -0,0 +1,37 @@
+package server
+
+import (
+	"dhcptest/connection"
+	"dhcptest/layers"
+	"github.com/google/gopacket"
+	"net"
+	"time"
+)
+
+func listen(iface *net.Interface) (net.PacketConn, error) {
+	return connection.UDPListener()(&net.UDPAddr{IP: net.IPv4zero, Port: 67})
+}
+
+func parseRequest(data []byte) *layers.DHCPv4 {
+	return connection.ParsePacket(data, layers.LayerTypeDHCPv4)
+}
+
+func (s *Server) reply(request *layers.DHCPv4, reply *layers.DHCPv4, from net.Addr) {
+	dst := s.destination(request, reply)
+	if dst.Port == 68 && isZero(request.ClientIP) && dst.IP.Equal(reply.YourClientIP) {
+		//the socket would arp for the offered address, RFC 2131 4.1 allows a broadcast then
+		dst = &net.UDPAddr{IP: net.IPv4bcast, Port: 68}
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{
+		ComputeChecksums: true,
+		FixLengths:       true,
+	}
+
+	if err := gopacket.SerializeLayers(buf, opts, reply); err != nil {
+		return
+	}
+
+	s.connection.SetWriteDeadline(time.Now().Add(connection.DefaultWriteTimeout))
+	s.connection.WriteTo(buf.Bytes(), dst)
+}
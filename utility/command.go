@@ -15,17 +15,79 @@ var (
 	BindMac      RequestParams
 	Option       RequestParams
 	Timeout      time.Duration
+	Renew        bool
+	Release      bool
+	ARPProbe     bool
+	ProbeWait    time.Duration
+	Respond      bool
+	CIAddr       string
+	LeaseFile    string
+	PrintOnly    string
+	// PrintOption and PrintFormat are parsed from --print-only by ParsePrintOnly
+	PrintOption  layers.DHCPOpt
+	PrintFormat  string
+	MacRange     string
+	OUI          string
+	Seed         int64
+	ClientID     string
+	Hostname     string
+	Mutations    string
+	BatchSize    int
+	Batches      int
+	StarveRate   int
+	StarveStreak int
+	StarveMax    int
+	AllowServers string
+	Watch        time.Duration
+	Pcap         string
+	PcapRotate   int
+	FreshXid     bool
+	RewriteMac   bool
+	Speed        string
+	Loops        int
+	Validate     bool
+	ExpectSubnet string
+	ExpectRouter string
+	ExpectDNS    string
+	ExpectOptions string
+	Scenario     string
+	Output       string
+	OutputFile   string
+	MetricsListen string
+	Spacing      string
+	Relay        string
+	RelayServer  string
+	RelayServerMac string
+	Giaddr       string
+	CircuitID    string
+	RemoteID     string
+	V6           bool
+	DUID         string
+	IAPD         bool
+	RapidCommit  bool
+	ServerIP     string
+	ServerPool   string
+	Netmask      string
+	Router       string
+	DNS          string
+	LeaseTime    time.Duration
+	Latency      time.Duration
+	DropRate     float64
+	NakRatio     float64
+	BroadcastReplies bool
+	Args         []string
 	/*
 	Secs         time.Duration
 	Quiet        bool
 	Query        bool
 	Wait         bool
 	Request      string
-	PrintOnly    string
 	Try          int
 	RequestIP    string
 	*/
 	DhcpOptions  layers.DHCPOptions
+	// OptionTemplates are the --option values with placeholders, expanded for every device
+	OptionTemplates []OptionTemplate
 )
 
 type CommandFlag struct {
@@ -63,15 +125,71 @@ var (
 	CommandIfaceList      = CommandFlag{Name: "iface-list",   usage: "  --iface-list    get a list of avaliable ip(only v4)"}
 	CommandBindIface      = CommandFlag{Name: "bind",         usage: "  --bind Iface    Listen on the interface with the specified IP.\r\n\t\t  Required parameters."}
 	CommandMac            = CommandFlag{Name: "mac",          usage: "  --mac MAC       Specify a MAC address to use for the client hardware\r\n\t\t  address field (chaddr), in the format NN:NN:NN:NN:NN:NN"}
-	CommandOption         = CommandFlag{Name: "option",       usage: "  --option OPTION Add an option to the request packet. The option must be\r\n\t\t  specified using the syntax CODE=VALUE or CODE[FORMAT]=VALUE,\r\n\t\t  where CODE is the numeric option number, FORMAT is how the\r\n\t\t  value is to be interpreted and decoded, and VALUE is the\r\n\t\t  option Value. FORMAT may be omitted for known option CODEs\r\n\t\t  E.g. to specify a Vendor Class Identifier:\r\n\t\t  --option \"60=Initech Groupware\"\r\n\t\t  You can specify hexadecimal or IPv4-formatted options using\r\n\t\t  --option \"N[hex]=...\" or --option \"N[IP]=...\"\r\n\t\t  Supported FORMAT types:\r\n\t\t  string, ip, hex, bool, time, message, option, mac"}
+	CommandOption         = CommandFlag{Name: "option",       usage: "  --option OPTION Add an option to the request packet. The option must be\r\n\t\t  specified using the syntax CODE=VALUE or CODE[FORMAT]=VALUE,\r\n\t\t  where CODE is the numeric option number, FORMAT is how the\r\n\t\t  value is to be interpreted and decoded, and VALUE is the\r\n\t\t  option Value. FORMAT may be omitted for known option CODEs\r\n\t\t  E.g. to specify a Vendor Class Identifier:\r\n\t\t  --option \"60=Initech Groupware\"\r\n\t\t  You can specify hexadecimal or IPv4-formatted options using\r\n\t\t  --option \"N[hex]=...\" or --option \"N[IP]=...\"\r\n\t\t  Supported FORMAT types:\r\n\t\t  string, ip, hex, bool, time, message, option, mac\r\n\t\t  and for the compound options:\r\n\t\t  agent, tlv       CODE:VALUE,...           (82, 43)\r\n\t\t  routes           NET/WIDTH:ROUTER,...     (121)\r\n\t\t  domains          NAME,...                 (119)\r\n\t\t  fqdn             [FLAGS:]NAME, FLAGS of SOEN (81)\r\n\t\t  userclass        CLASS,...                (77)\r\n\t\t  vivc             ENTERPRISE:CLASS,...     (124)\r\n\t\t  vivso            ENTERPRISE:CODE:VALUE,... (125)\r\n\t\t  a VALUE or CLASS starting with 0x is hex\r\n\t\t  The VALUE may hold per-terminal placeholders: {index}, {mac}, {mac_hex}\r\n\t\t  and {rand:a,b,c}, a choice kept by each terminal, e.g.\r\n\t\t  --option \"12=host-{index}\" --option \"61[hex]=01{mac}\""}
 	CommandTimeOut        = CommandFlag{Name: "timeout",      usage: "  --timeout N     Wait N seconds for replies, after which reject response packets for this request.\r\n\t\t  Default is 10 seconds. Can be a fractional number.\r\n\t\t  A Value of 0 is not admitted."}
+	CommandRenew          = CommandFlag{Name: "renew",        usage: "  --renew         Keep the leases got by the \"r\" command: unicast a RENEW request at T1,\r\n\t\t  broadcast a REBIND request at T2 and drop the lease when it expires.\r\n\t\t  The arp requests for the leased addresses are answered as with --respond."}
+	CommandARPProbe       = CommandFlag{Name: "arp-probe",    usage: "  --arp-probe     Probe the address of every ack with arp before binding it(RFC 5227),\r\n\t\t  decline the address and discover again when another host answers."}
+	CommandProbeWait      = CommandFlag{Name: "probe-wait",   usage: "  --probe-wait N  The time between two arp probes and after the last one. Default is 1s"}
+	CommandRespond        = CommandFlag{Name: "respond",      usage: "  --respond       Answer the arp who-has and the icmp echo requests for the leased addresses\r\n\t\t  so that the ping-checks of the servers see the clients."}
+	CommandCIAddr         = CommandFlag{Name: "ciaddr",       usage: "  --ciaddr IP     The address the i command informs from, the following terminals take\r\n\t\t  the following addresses."}
+	CommandLeaseFile      = CommandFlag{Name: "lease-file",   usage: "  --lease-file FILE\r\n\t\t  The json file the leases of the terminals are loaded from and saved to,\r\n\t\t  a later run can reboot or renew the same terminals."}
+	CommandMacRange       = CommandFlag{Name: "mac-range",    usage: "  --mac-range FIRST-LAST\r\n\t\t  Give the terminals the macs of the range in order, e.g.\r\n\t\t  02:00:00:00:00:00-02:00:00:0f:ff:ff, a mac is never given twice."}
+	CommandOUI            = CommandFlag{Name: "oui",          usage: "  --oui OUI       The prefix of the random macs, 02:00:00 by default, unused with --mac-range."}
+	CommandSeed           = CommandFlag{Name: "seed",         usage: "  --seed N        The seed of the random macs, the same seed gives the same macs,\r\n\t\t  and of the broken packets of the fuzz command. 0 seeds from the clock."}
+	CommandClientID       = CommandFlag{Name: "client-id",    usage: "  --client-id TEMPLATE\r\n\t\t  Send the client identifier (option 61) expanded from the mac of every\r\n\t\t  terminal with {mac} and {mac_hex}, \"mac\" sends the hardware type and the mac."}
+	CommandHostname       = CommandFlag{Name: "hostname",     usage: "  --hostname TEMPLATE\r\n\t\t  Send the host name (option 12) expanded from the mac of every terminal,\r\n\t\t  e.g. host-{mac_hex}."}
+	CommandPrint          = CommandFlag{Name: "print-only",   usage: "  --print-only N  Print only the specified DHCP option of the received packets.\r\n\t\t  You can specify a desired format using the syntax N[FORMAT]\r\n\t\t  See above for a list of FORMATs, the option is printed in its own\r\n\t\t  format by default. For example:\r\n\t\t  --print-only \"N[hex]\" or --print-only \"N[ip]\""}
+	CommandMutations      = CommandFlag{Name: "mutations",    usage: "  --mutations M,M [fuzz] The mutations the fuzz command draws from: option-length, no-end, hlen,\r\n\t\t  magic, duplicate-type, overlong-fields, random-options, truncate-udp and\r\n\t\t  truncate-ip. Default is all"}
+	CommandBatchSize      = CommandFlag{Name: "batch-size",   usage: "  --batch-size N  [fuzz] The broken packets sent between two liveness probes. Default is 50"}
+	CommandBatches        = CommandFlag{Name: "batches",      usage: "  --batches N     [fuzz] The batches sent before the fuzz command stops. Default is 20"}
+	CommandStarveRate     = CommandFlag{Name: "starve-rate",  usage: "  --starve-rate N [audit] The DORA started per second by \"audit starve\", each from a new mac. Default is 10"}
+	CommandStarveStreak   = CommandFlag{Name: "starve-streak", usage: "  --starve-streak N\r\n\t\t  [audit] The naks and missing offers in a row taken for an exhausted pool. Default is 20"}
+	CommandStarveMax      = CommandFlag{Name: "starve-max",   usage: "  --starve-max N  [audit] Stop \"audit starve\" after N leases, 0 leases until the pool is exhausted."}
+	CommandAllowServers   = CommandFlag{Name: "allow-servers", usage: "  --allow-servers ID,MAC\r\n\t\t  [audit] The server ids and the macs of the legitimate servers, the others are\r\n\t\t  reported as rogue."}
+	CommandWatch          = CommandFlag{Name: "watch",        usage: "  --watch D       [audit] How long \"audit rogue\" watches the offers, 0 watches until interrupted.\r\n\t\t  Default is 1m"}
+	CommandPcap           = CommandFlag{Name: "pcap",         usage: "  --pcap FILE     Write every dhcp frame sent and received to FILE, in pcap when it ends in\r\n\t\t  .pcap and in pcapng otherwise."}
+	CommandPcapRotate     = CommandFlag{Name: "pcap-rotate",  usage: "  --pcap-rotate MB\r\n\t\t  Go on in FILE-1, FILE-2 and so on once the --pcap file holds MB megabytes,\r\n\t\t  0 never rotates."}
+	CommandFreshXid       = CommandFlag{Name: "fresh-xid",    usage: "  --fresh-xid     [replay] Give every replayed transaction a new xid."}
+	CommandRewriteMac     = CommandFlag{Name: "rewrite-mac",  usage: "  --rewrite-mac   [replay] Move every client of the capture to a mac of --mac-range or --oui,\r\n\t\t  a new one on every loop."}
+	CommandSpeed          = CommandFlag{Name: "speed",        usage: "  --speed S       [replay] The speed of the replay against the capture, such as 1x or 10x,\r\n\t\t  max sends as fast as possible. Default is 1x"}
+	CommandLoops          = CommandFlag{Name: "loops",        usage: "  --loops N       [replay] The times the capture is replayed, 0 replays until interrupted.\r\n\t\t  Default is 1, any other value implies --fresh-xid"}
+	CommandValidate       = CommandFlag{Name: "validate",     usage: "  --validate      Check the offers and the acks against RFC 2131 and log a conformance report\r\n\t\t  at the end, the exit code is 3 when a reply broke a rule."}
+	CommandExpectSubnet   = CommandFlag{Name: "expect-subnet", usage: "  --expect-subnet CIDR\r\n\t\t  Check that the offered addresses are within CIDR, implies --validate."}
+	CommandExpectRouter   = CommandFlag{Name: "expect-router", usage: "  --expect-router IP,IP\r\n\t\t  Check that the router option holds the addresses, implies --validate."}
+	CommandExpectDNS      = CommandFlag{Name: "expect-dns",   usage: "  --expect-dns IP,IP\r\n\t\t  Check that the dns option holds the addresses, implies --validate."}
+	CommandExpectOptions  = CommandFlag{Name: "expect-options", usage: "  --expect-options N,N\r\n\t\t  Check that the replies carry the options of the codes, implies --validate."}
+	CommandScenario       = CommandFlag{Name: "scenario",     usage: "  --scenario FILE Run the phases described in the json or yaml (.yaml, .yml) FILE without the interactive prompt,\r\n\t\t  the exit code is 1 when a phase misses its SLO and 2 when the file is invalid."}
+	CommandOutput         = CommandFlag{Name: "output",       usage: "  --output FORMAT Write a record per transaction and the periodic summaries as json lines\r\n\t\t  or as csv rows, FORMAT is json or csv."}
+	CommandOutputFile     = CommandFlag{Name: "output-file",  usage: "  --output-file FILE\r\n\t\t  The file the --output records are written to. Default is the standard output"}
+	CommandMetricsListen  = CommandFlag{Name: "metrics-listen", usage: "  --metrics-listen ADDR\r\n\t\t  Serve the counters, the in-flight transactions and the latency histograms\r\n\t\t  in the prometheus format on http://ADDR/metrics, e.g. :9100"}
+	CommandSpacing        = CommandFlag{Name: "spacing",      usage: "  --spacing S     How the requests of the load tests are spaced, exponential(a poisson process)\r\n\t\t  or uniform. Default is exponential"}
+	CommandRelay          = CommandFlag{Name: "relay",        usage: "  --relay IP      Act as a relay agent with the address IP: unicast the packets from IP:67\r\n\t\t  to the --relay-server on port 67 and match the replies sent back to IP:67."}
+	CommandRelayServer    = CommandFlag{Name: "relay-server", usage: "  --relay-server IP\r\n\t\t  [relay] The dhcp server the packets are relayed to. Required with --relay"}
+	CommandRelayServerMac = CommandFlag{Name: "relay-server-mac", usage: "  --relay-server-mac MAC\r\n\t\t  [relay] The mac of the next hop to the server, the frames are broadcast when omitted."}
+	CommandGiaddr         = CommandFlag{Name: "giaddr",       usage: "  --giaddr IP,IP  [relay] The giaddr of every simulated subnet, the devices are spread over them in turn.\r\n\t\t  Default is the --relay address"}
+	CommandCircuitID      = CommandFlag{Name: "circuit-id",   usage: "  --circuit-id T  [relay] Add the Circuit-ID sub-option of option 82 built from the template T,\r\n\t\t  {index} and {mac} are replaced by the number and the mac of the device, e.g. \"eth0/{index}\""}
+	CommandRemoteID       = CommandFlag{Name: "remote-id",    usage: "  --remote-id T   [relay] Add the Remote-ID sub-option of option 82 built from the template T"}
+	CommandV6             = CommandFlag{Name: "v6",           usage: "  --v6            Speak DHCPv6 instead of DHCPv4: the d command sends solicits and the r command\r\n\t\t  requests the advertised addresses."}
+	CommandDUID           = CommandFlag{Name: "duid",         usage: "  --duid TYPE     [v6] The DUID built from the mac of every simulated client, ll or llt. Default is ll"}
+	CommandIAPD           = CommandFlag{Name: "ia-pd",        usage: "  --ia-pd         [v6] Ask for a delegated prefix (IA_PD) besides the address (IA_NA)."}
+	CommandRapidCommit    = CommandFlag{Name: "rapid-commit", usage: "  --rapid-commit  [v6] Add the Rapid Commit option to the solicits for the two message exchange."}
+	CommandServerIP       = CommandFlag{Name: "server-ip",    usage: "  --server-ip IP  [serve] The server identifier of the emulated server.\r\n\t\t  Default is the first ipv4 address of the bound interface."}
+	CommandServerPool     = CommandFlag{Name: "pool",         usage: "  --pool IP-IP    [serve] The range of addresses leased by the emulated server,\r\n\t\t  e.g. --pool 192.168.0.100-192.168.0.200"}
+	CommandNetmask        = CommandFlag{Name: "netmask",      usage: "  --netmask MASK  [serve] The subnet mask sent by the emulated server. Default is 255.255.255.0"}
+	CommandRouter         = CommandFlag{Name: "router",       usage: "  --router IP     [serve] The router sent by the emulated server."}
+	CommandDNS            = CommandFlag{Name: "dns",          usage: "  --dns IP,IP     [serve] The dns servers sent by the emulated server."}
+	CommandLeaseTime      = CommandFlag{Name: "lease-time",   usage: "  --lease-time N  [serve] The lease time of the emulated server, e.g. 1h. Default is 1 hour"}
+	CommandLatency        = CommandFlag{Name: "latency",      usage: "  --latency N     [serve] Delay every reply of the emulated server, e.g. 5ms"}
+	CommandDropRate       = CommandFlag{Name: "drop-rate",    usage: "  --drop-rate R   [serve] The ratio(0-1) of requests the emulated server doesn't answer"}
+	CommandNakRatio       = CommandFlag{Name: "nak-ratio",    usage: "  --nak-ratio R   [serve] The ratio(0-1) of requests the emulated server answers with a nak"}
+	CommandBroadcastReplies = CommandFlag{Name: "broadcast-replies", usage: "  --broadcast-replies\r\n\t\t  [serve] Broadcast every reply of the emulated server instead of unicasting it\r\n\t\t  to the relay agent, the ciaddr or the offered address as RFC 2131 4.1 does."}
+	CommandRelease        = CommandFlag{Name: "release",      usage: "  --release       Send a DHCP release for every bound lease when the client stops."}
 	/*
 	CommandSecs           = CommandFlag{Name: "secs",         usage: "  --secs          Specify the \"Secs\" request field (number of seconds elapsed\r\n\t\t  since a client began an attempt to acquire or renew a lease)"}
 	CommandQuiet          = CommandFlag{Name: "quiet",        usage: "  --quiet         Suppress program output except for received data\r\n\t\t  and error messages"}
 	CommandQuery          = CommandFlag{Name: "query",        usage: "  --query         Instead of starting an interactive prompt, immediately send\r\n\t\t  a discover packet, wait for a result, print it and exit."}
 	CommandWait           = CommandFlag{Name: "wait",         usage: "  --wait          Wait until timeout elapsed before exiting from --query, all\r\n\t\t  offers returned will be reported."}
 	CommandRequest        = CommandFlag{Name: "request",      usage: "  --request N     Uses DHCP option 55 (\"Parameter Request List\") to\r\n\t\t  explicitly request the specified option from the server.\r\n\t\t  Can be repeated several times to request multiple options."}
-	CommandPrint          = CommandFlag{Name: "print-only",   usage: "  --print-only N  Print only the specified DHCP option.\r\n\t\t  You can specify a desired format using the syntax N[FORMAT]\r\n\t\t  See above for a list of FORMATs. For example:\r\n\t\t  --print-only \"N[hex]\" or --print-only \"N[IP]\""}
 	CommandTry            = CommandFlag{Name: "tries",        usage: "  --tries N       Send N DHCP discover packets after each timeout interval.\r\n\t\t  Specify N=0 to retry indefinitely."}
 	CommandRequestIP      = CommandFlag{Name: "requestip",    usage: "  --requestip IP  Specify the IP Address you want to get for the client mac"}
 	*/
@@ -85,13 +203,69 @@ var (
 	Command{CommandFlag: &CommandMac, Value: &clientmacs},
 	Command{CommandFlag: &CommandOption, Value: &optionRequest},
 	Command{CommandFlag: &CommandTimeOut, Value: flag.Duration(CommandTimeOut.Name, 10*time.Second, CommandTimeOut.usage)},
+	Command{CommandFlag: &CommandRenew, Value: flag.Bool(CommandRenew.Name, false, CommandRenew.usage)},
+	Command{CommandFlag: &CommandRelease, Value: flag.Bool(CommandRelease.Name, false, CommandRelease.usage)},
+	Command{CommandFlag: &CommandARPProbe, Value: flag.Bool(CommandARPProbe.Name, false, CommandARPProbe.usage)},
+	Command{CommandFlag: &CommandProbeWait, Value: flag.Duration(CommandProbeWait.Name, time.Second, CommandProbeWait.usage)},
+	Command{CommandFlag: &CommandRespond, Value: flag.Bool(CommandRespond.Name, false, CommandRespond.usage)},
+	Command{CommandFlag: &CommandCIAddr, Value: flag.String(CommandCIAddr.Name, "", CommandCIAddr.usage)},
+	Command{CommandFlag: &CommandLeaseFile, Value: flag.String(CommandLeaseFile.Name, "", CommandLeaseFile.usage)},
+	Command{CommandFlag: &CommandMacRange, Value: flag.String(CommandMacRange.Name, "", CommandMacRange.usage)},
+	Command{CommandFlag: &CommandOUI, Value: flag.String(CommandOUI.Name, DefaultOUI, CommandOUI.usage)},
+	Command{CommandFlag: &CommandSeed, Value: flag.Int64(CommandSeed.Name, 0, CommandSeed.usage)},
+	Command{CommandFlag: &CommandClientID, Value: flag.String(CommandClientID.Name, "", CommandClientID.usage)},
+	Command{CommandFlag: &CommandHostname, Value: flag.String(CommandHostname.Name, "", CommandHostname.usage)},
+	Command{CommandFlag: &CommandPrint, Value: flag.String(CommandPrint.Name, "", CommandPrint.usage)},
+	Command{CommandFlag: &CommandMutations, Value: flag.String(CommandMutations.Name, "all", CommandMutations.usage)},
+	Command{CommandFlag: &CommandBatchSize, Value: flag.Int(CommandBatchSize.Name, 50, CommandBatchSize.usage)},
+	Command{CommandFlag: &CommandBatches, Value: flag.Int(CommandBatches.Name, 20, CommandBatches.usage)},
+	Command{CommandFlag: &CommandStarveRate, Value: flag.Int(CommandStarveRate.Name, 10, CommandStarveRate.usage)},
+	Command{CommandFlag: &CommandStarveStreak, Value: flag.Int(CommandStarveStreak.Name, 20, CommandStarveStreak.usage)},
+	Command{CommandFlag: &CommandStarveMax, Value: flag.Int(CommandStarveMax.Name, 0, CommandStarveMax.usage)},
+	Command{CommandFlag: &CommandAllowServers, Value: flag.String(CommandAllowServers.Name, "", CommandAllowServers.usage)},
+	Command{CommandFlag: &CommandWatch, Value: flag.Duration(CommandWatch.Name, time.Minute, CommandWatch.usage)},
+	Command{CommandFlag: &CommandPcap, Value: flag.String(CommandPcap.Name, "", CommandPcap.usage)},
+	Command{CommandFlag: &CommandPcapRotate, Value: flag.Int(CommandPcapRotate.Name, 0, CommandPcapRotate.usage)},
+	Command{CommandFlag: &CommandFreshXid, Value: flag.Bool(CommandFreshXid.Name, false, CommandFreshXid.usage)},
+	Command{CommandFlag: &CommandRewriteMac, Value: flag.Bool(CommandRewriteMac.Name, false, CommandRewriteMac.usage)},
+	Command{CommandFlag: &CommandSpeed, Value: flag.String(CommandSpeed.Name, "1x", CommandSpeed.usage)},
+	Command{CommandFlag: &CommandLoops, Value: flag.Int(CommandLoops.Name, 1, CommandLoops.usage)},
+	Command{CommandFlag: &CommandValidate, Value: flag.Bool(CommandValidate.Name, false, CommandValidate.usage)},
+	Command{CommandFlag: &CommandExpectSubnet, Value: flag.String(CommandExpectSubnet.Name, "", CommandExpectSubnet.usage)},
+	Command{CommandFlag: &CommandExpectRouter, Value: flag.String(CommandExpectRouter.Name, "", CommandExpectRouter.usage)},
+	Command{CommandFlag: &CommandExpectDNS, Value: flag.String(CommandExpectDNS.Name, "", CommandExpectDNS.usage)},
+	Command{CommandFlag: &CommandExpectOptions, Value: flag.String(CommandExpectOptions.Name, "", CommandExpectOptions.usage)},
+	Command{CommandFlag: &CommandScenario, Value: flag.String(CommandScenario.Name, "", CommandScenario.usage)},
+	Command{CommandFlag: &CommandOutput, Value: flag.String(CommandOutput.Name, "", CommandOutput.usage)},
+	Command{CommandFlag: &CommandOutputFile, Value: flag.String(CommandOutputFile.Name, "", CommandOutputFile.usage)},
+	Command{CommandFlag: &CommandMetricsListen, Value: flag.String(CommandMetricsListen.Name, "", CommandMetricsListen.usage)},
+	Command{CommandFlag: &CommandSpacing, Value: flag.String(CommandSpacing.Name, "exponential", CommandSpacing.usage)},
+	Command{CommandFlag: &CommandRelay, Value: flag.String(CommandRelay.Name, "", CommandRelay.usage)},
+	Command{CommandFlag: &CommandRelayServer, Value: flag.String(CommandRelayServer.Name, "", CommandRelayServer.usage)},
+	Command{CommandFlag: &CommandRelayServerMac, Value: flag.String(CommandRelayServerMac.Name, "", CommandRelayServerMac.usage)},
+	Command{CommandFlag: &CommandGiaddr, Value: flag.String(CommandGiaddr.Name, "", CommandGiaddr.usage)},
+	Command{CommandFlag: &CommandCircuitID, Value: flag.String(CommandCircuitID.Name, "", CommandCircuitID.usage)},
+	Command{CommandFlag: &CommandRemoteID, Value: flag.String(CommandRemoteID.Name, "", CommandRemoteID.usage)},
+	Command{CommandFlag: &CommandV6, Value: flag.Bool(CommandV6.Name, false, CommandV6.usage)},
+	Command{CommandFlag: &CommandDUID, Value: flag.String(CommandDUID.Name, "ll", CommandDUID.usage)},
+	Command{CommandFlag: &CommandIAPD, Value: flag.Bool(CommandIAPD.Name, false, CommandIAPD.usage)},
+	Command{CommandFlag: &CommandRapidCommit, Value: flag.Bool(CommandRapidCommit.Name, false, CommandRapidCommit.usage)},
+	Command{CommandFlag: &CommandServerIP, Value: flag.String(CommandServerIP.Name, "", CommandServerIP.usage)},
+	Command{CommandFlag: &CommandServerPool, Value: flag.String(CommandServerPool.Name, "", CommandServerPool.usage)},
+	Command{CommandFlag: &CommandNetmask, Value: flag.String(CommandNetmask.Name, "255.255.255.0", CommandNetmask.usage)},
+	Command{CommandFlag: &CommandRouter, Value: flag.String(CommandRouter.Name, "", CommandRouter.usage)},
+	Command{CommandFlag: &CommandDNS, Value: flag.String(CommandDNS.Name, "", CommandDNS.usage)},
+	Command{CommandFlag: &CommandLeaseTime, Value: flag.Duration(CommandLeaseTime.Name, time.Hour, CommandLeaseTime.usage)},
+	Command{CommandFlag: &CommandLatency, Value: flag.Duration(CommandLatency.Name, 0, CommandLatency.usage)},
+	Command{CommandFlag: &CommandDropRate, Value: flag.Float64(CommandDropRate.Name, 0, CommandDropRate.usage)},
+	Command{CommandFlag: &CommandNakRatio, Value: flag.Float64(CommandNakRatio.Name, 0, CommandNakRatio.usage)},
+	Command{CommandFlag: &CommandBroadcastReplies, Value: flag.Bool(CommandBroadcastReplies.Name, false, CommandBroadcastReplies.usage)},
 	/*
 	Command{CommandFlag: &CommandSecs, Value: flag.Duration(CommandSecs.Name, 10*time.Second, CommandSecs.usage)},
 	Command{CommandFlag: &CommandQuiet, Value: flag.Bool(CommandQuiet.Name, false, CommandQuiet.usage)},
 	Command{CommandFlag: &CommandQuery, Value: flag.Bool(CommandQuery.Name, false, CommandQuery.usage)},
 	Command{CommandFlag: &CommandWait, Value: flag.Bool(CommandWait.Name, false, CommandWait.usage)},
 	Command{CommandFlag: &CommandRequest, Value: flag.String(CommandRequest.Name, "", CommandRequest.usage)},
-	Command{CommandFlag: &CommandPrint, Value: flag.String(CommandPrint.Name, "", CommandPrint.usage)},
 	Command{CommandFlag: &CommandTry, Value: flag.Int(CommandTry.Name, 1, CommandTry.usage)},
 	Command{CommandFlag: &CommandRequestIP, Value: flag.String(CommandRequestIP.Name, "", CommandRequestIP.usage)},
 	*/
@@ -104,16 +278,18 @@ func (c Command) Print() {
 		for _, command := range CommandList {
 			fmt.Println(command.CommandFlag.usage)
 		}
+		fmt.Println("Subcommands:")
+		fmt.Println("  serve           Run an emulated dhcp server on the interface instead of the client,\r\n\t\t  the options marked with [serve] must precede it, e.g.\r\n\t\t  dhcptest --bind eth0 --pool 192.168.0.100-192.168.0.200 serve")
 
 	case &CommandOptionHelp:
 		fmt.Println("dhcpoption list")
-		fmt.Println("  code\tdescription")
+		fmt.Println("  code\tformat\t\tdescription")
 		for i := 0; i <= 255; i++ {
 			var opt = layers.DHCPOpt(byte(i))
 			if opt.String() == "Unknown" {
 				continue
 			}
-			fmt.Printf("  %d\t%s\n", byte(opt), opt)
+			fmt.Printf("  %d\t%-12s\t%s\n", byte(opt), layers.DHCPOptionFormat(opt), opt)
 		}
 
 	case &CommandIfaceList:
@@ -136,8 +312,14 @@ func init() {
 
 	flag.Var(&optionRequest, CommandOption.Name, CommandOption.usage)
 	flag.Var(&clientmacs, CommandMac.Name, CommandMac.usage)
+}
 
+// ParseCommandLine parses the command-line into the package variables,
+// it is called first thing in main so that tests importing the package
+// are not hit by the flags of the test binary
+func ParseCommandLine() {
 	flag.Parse()
+	Args = flag.Args()
 
 	getOpts()
 }
@@ -160,6 +342,120 @@ func getOpts() {
 			Option = *command.Value.(*RequestParams)
 		case &CommandTimeOut:
 			Timeout = *command.Value.(*time.Duration)
+		case &CommandRenew:
+			Renew = *command.Value.(*bool)
+		case &CommandRelease:
+			Release = *command.Value.(*bool)
+		case &CommandARPProbe:
+			ARPProbe = *command.Value.(*bool)
+		case &CommandProbeWait:
+			ProbeWait = *command.Value.(*time.Duration)
+		case &CommandRespond:
+			Respond = *command.Value.(*bool)
+		case &CommandCIAddr:
+			CIAddr = *command.Value.(*string)
+		case &CommandLeaseFile:
+			LeaseFile = *command.Value.(*string)
+		case &CommandMacRange:
+			MacRange = *command.Value.(*string)
+		case &CommandOUI:
+			OUI = *command.Value.(*string)
+		case &CommandSeed:
+			Seed = *command.Value.(*int64)
+		case &CommandClientID:
+			ClientID = *command.Value.(*string)
+		case &CommandHostname:
+			Hostname = *command.Value.(*string)
+		case &CommandPrint:
+			PrintOnly = *command.Value.(*string)
+		case &CommandMutations:
+			Mutations = *command.Value.(*string)
+		case &CommandBatchSize:
+			BatchSize = *command.Value.(*int)
+		case &CommandBatches:
+			Batches = *command.Value.(*int)
+		case &CommandStarveRate:
+			StarveRate = *command.Value.(*int)
+		case &CommandStarveStreak:
+			StarveStreak = *command.Value.(*int)
+		case &CommandStarveMax:
+			StarveMax = *command.Value.(*int)
+		case &CommandAllowServers:
+			AllowServers = *command.Value.(*string)
+		case &CommandWatch:
+			Watch = *command.Value.(*time.Duration)
+		case &CommandPcap:
+			Pcap = *command.Value.(*string)
+		case &CommandPcapRotate:
+			PcapRotate = *command.Value.(*int)
+		case &CommandFreshXid:
+			FreshXid = *command.Value.(*bool)
+		case &CommandRewriteMac:
+			RewriteMac = *command.Value.(*bool)
+		case &CommandSpeed:
+			Speed = *command.Value.(*string)
+		case &CommandLoops:
+			Loops = *command.Value.(*int)
+		case &CommandValidate:
+			Validate = *command.Value.(*bool)
+		case &CommandExpectSubnet:
+			ExpectSubnet = *command.Value.(*string)
+		case &CommandExpectRouter:
+			ExpectRouter = *command.Value.(*string)
+		case &CommandExpectDNS:
+			ExpectDNS = *command.Value.(*string)
+		case &CommandExpectOptions:
+			ExpectOptions = *command.Value.(*string)
+		case &CommandScenario:
+			Scenario = *command.Value.(*string)
+		case &CommandOutput:
+			Output = *command.Value.(*string)
+		case &CommandOutputFile:
+			OutputFile = *command.Value.(*string)
+		case &CommandMetricsListen:
+			MetricsListen = *command.Value.(*string)
+		case &CommandSpacing:
+			Spacing = *command.Value.(*string)
+		case &CommandRelay:
+			Relay = *command.Value.(*string)
+		case &CommandRelayServer:
+			RelayServer = *command.Value.(*string)
+		case &CommandRelayServerMac:
+			RelayServerMac = *command.Value.(*string)
+		case &CommandGiaddr:
+			Giaddr = *command.Value.(*string)
+		case &CommandCircuitID:
+			CircuitID = *command.Value.(*string)
+		case &CommandRemoteID:
+			RemoteID = *command.Value.(*string)
+		case &CommandV6:
+			V6 = *command.Value.(*bool)
+		case &CommandDUID:
+			DUID = *command.Value.(*string)
+		case &CommandIAPD:
+			IAPD = *command.Value.(*bool)
+		case &CommandRapidCommit:
+			RapidCommit = *command.Value.(*bool)
+		case &CommandServerIP:
+			ServerIP = *command.Value.(*string)
+		case &CommandServerPool:
+			ServerPool = *command.Value.(*string)
+		case &CommandNetmask:
+			Netmask = *command.Value.(*string)
+		case &CommandRouter:
+			Router = *command.Value.(*string)
+		case &CommandDNS:
+			DNS = *command.Value.(*string)
+		case &CommandLeaseTime:
+			LeaseTime = *command.Value.(*time.Duration)
+		case &CommandLatency:
+			Latency = *command.Value.(*time.Duration)
+		case &CommandDropRate:
+			DropRate = *command.Value.(*float64)
+		case &CommandNakRatio:
+			NakRatio = *command.Value.(*float64)
+		case &CommandBroadcastReplies:
+			BroadcastReplies = *command.Value.(*bool)
 			/*
 		case &CommandSecs:
 			Secs = *command.Value.(*time.Duration)
@@ -176,9 +472,6 @@ func getOpts() {
 		case &CommandRequest:
 			Request = *command.Value.(*string)
 			break
-		case &CommandPrint:
-			PrintOnly = *command.Value.(*string)
-			break
 		case &CommandTry:
 			Try = *command.Value.(*int)
 			break
@@ -2,35 +2,17 @@ package utility
 
 import (
 	"fmt"
-	"math/rand"
 	"net"
-	"time"
 )
 
 func GetInterfaceByName(ifaceName string, validIface map[string]net.Interface) (*net.Interface, error) {
 	iface, ok := validIface[ifaceName]
 	if !ok {
-		return nil, fmt.Errorf("invalid iface:%s", iface)
+		return nil, fmt.Errorf("invalid iface:%s", ifaceName)
 	}
 	return &iface, nil
 }
 
-func RandomMac() string {
-	m := []byte{2, 0, 0}
-
-	rand.Seed(time.Now().UnixNano())
-
-	for i := 3; i < 6; i++ {
-		macByte := rand.Intn(256)
-		m = append(m, byte(macByte))
-
-        rand.Seed(int64(macByte))
-	}
-
-	return fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x", m[0], m[1], m[2], m[3], m[4], m[5])
-
-}
-
 func ParseIPs(data []byte) []net.IP {
 	result := make([]net.IP, len(data)/4)
 	for i:=0; i+3 < len(data); i +=4 {
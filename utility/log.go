@@ -21,6 +21,10 @@ func DHCPLogger() Logger {
 		switch message.(type) {
 		case *layers.DHCPv4:
 			dhcpPacket := message.(*layers.DHCPv4)
+			if len(PrintOnly) > 0 {
+				printOnly(dhcpPacket)
+				return
+			}
 			//fmt.Printf("%+v\n", dhcpPacket)
 			fmt.Printf("  op=%s  chaddr=%s  hops=%d  xid=%x  secs=%d  flags=%s\n", dhcpPacket.Operation, dhcpPacket.ClientHWAddr, dhcpPacket.HardwareOpts, dhcpPacket.Xid, dhcpPacket.Secs, layers.BootpFlag(dhcpPacket.Flags))
 			fmt.Printf("  ciaddr=%s  yiaddr=%s  siaddr=%s  giaddr=%s  sname=%s file=%s\n", dhcpPacket.ClientIP, dhcpPacket.YourClientIP, dhcpPacket.NextServerIP, dhcpPacket.RelayAgentIP,
@@ -30,8 +34,33 @@ func DHCPLogger() Logger {
 			for _, option := range dhcpOptions {
 				fmt.Printf("     %s\n", option)
 			}
+		case *layers.DHCPv6:
+			dhcpPacket := message.(*layers.DHCPv6)
+			fmt.Printf("  msg-type=%s  xid=%x\n", dhcpPacket.MsgType, dhcpPacket.TransactionID)
+			fmt.Printf("  %d options:\n", len(dhcpPacket.Options))
+			for _, option := range dhcpPacket.Options {
+				fmt.Printf("     %s\n", option)
+			}
 		default:
 			log.Println(message)
 		}
 	}
 }
+
+// printOnly prints the option of --print-only of a received packet alone,
+// in the format of --print-only
+func printOnly(packet *layers.DHCPv4) {
+	if packet.Operation != layers.DHCPOpReply {
+		return
+	}
+	for _, option := range packet.Options {
+		if option.Type != PrintOption {
+			continue
+		}
+		value, err := layers.FormatDHCPOptionData(option.Data, PrintFormat)
+		if err != nil {
+			value = fmt.Sprintf("INVALID %s: %s", PrintFormat, err)
+		}
+		fmt.Println(value)
+	}
+}
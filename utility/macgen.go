@@ -0,0 +1,172 @@
+package utility
+
+import (
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultOUI is the locally administered prefix of the generated macs
+	DefaultOUI = "02:00:00"
+	// feistelRounds is the number of rounds of the permutation of the random macs
+	feistelRounds = 4
+)
+
+// MacGenerator hands out the macs of the simulated clients, each of them once.
+// A sequential generator walks its range in order, a random one walks it in the
+// order of a permutation keyed by the seed, so that the same seed gives the
+// same macs and no mac comes twice however many are drawn
+type MacGenerator struct {
+	lock     sync.Mutex
+	first    uint64
+	size     uint64
+	next     uint64
+	random   bool
+	half     uint
+	keys     []uint64
+	reserved map[uint64]bool
+}
+
+// NewSequentialMacGenerator returns a generator of the macs from first to last
+func NewSequentialMacGenerator(first net.HardwareAddr, last net.HardwareAddr) (*MacGenerator, error) {
+	from, to := macToUint64(first), macToUint64(last)
+	if len(first) != 6 || len(last) != 6 || from > to {
+		return nil, fmt.Errorf("invalid mac range %s-%s", first, last)
+	}
+	return &MacGenerator{first: from, size: to - from + 1, reserved: make(map[uint64]bool)}, nil
+}
+
+// NewRandomMacGenerator returns a generator of random macs under the oui,
+// drawn in the order the seed gives
+func NewRandomMacGenerator(oui net.HardwareAddr, seed int64) (*MacGenerator, error) {
+	if len(oui) != 3 {
+		return nil, fmt.Errorf("invalid oui %s, want 3 bytes such as 02:00:00", oui)
+	}
+	g := &MacGenerator{
+		first:    macToUint64(append(append(net.HardwareAddr{}, oui...), 0, 0, 0)),
+		size:     1 << 24,
+		random:   true,
+		reserved: make(map[uint64]bool),
+	}
+	//the permutation works on an even number of bits, the values past the range are walked over
+	g.half = uint(bits.Len64(g.size-1)+1) / 2
+	source := rand.New(rand.NewSource(seed))
+	for i := 0; i < feistelRounds; i++ {
+		g.keys = append(g.keys, source.Uint64())
+	}
+	return g, nil
+}
+
+// ParseMacRange parses a range such as 02:00:00:00:00:00-02:00:00:0f:ff:ff
+func ParseMacRange(value string) (net.HardwareAddr, net.HardwareAddr, error) {
+	bounds := strings.Split(value, "-")
+	if len(bounds) != 2 {
+		return nil, nil, fmt.Errorf("invalid mac range %q, want FIRST-LAST", value)
+	}
+	first, err := net.ParseMAC(strings.TrimSpace(bounds[0]))
+	if err != nil {
+		return nil, nil, err
+	}
+	last, err := net.ParseMAC(strings.TrimSpace(bounds[1]))
+	if err != nil {
+		return nil, nil, err
+	}
+	return first, last, nil
+}
+
+// NewMacGenerator returns the generator of --mac-range, or the random
+// generator of --oui seeded with --seed, from the clock when it is 0
+func NewMacGenerator() (*MacGenerator, error) {
+	if len(MacRange) > 0 {
+		first, last, err := ParseMacRange(MacRange)
+		if err != nil {
+			return nil, err
+		}
+		return NewSequentialMacGenerator(first, last)
+	}
+	oui, err := net.ParseMAC(OUI + ":00:00:00")
+	if err != nil {
+		return nil, fmt.Errorf("invalid oui %s: %s", OUI, err)
+	}
+	seed := Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return NewRandomMacGenerator(oui[:3], seed)
+}
+
+// Reserve keeps the given macs, set by --mac, from being generated
+func (g *MacGenerator) Reserve(macs ...net.HardwareAddr) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, mac := range macs {
+		g.reserved[macToUint64(mac)] = true
+	}
+}
+
+// Next returns the next mac, it fails once the range is exhausted
+func (g *MacGenerator) Next() (net.HardwareAddr, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for g.next < g.size {
+		offset := g.next
+		g.next++
+		if g.random {
+			offset = g.permute(offset)
+		}
+		if value := g.first + offset; !g.reserved[value] {
+			return uint64ToMac(value), nil
+		}
+	}
+	return nil, fmt.Errorf("all the %d macs of the range are used", g.size)
+}
+
+// permute maps the index to its offset in the range, one to one. The Feistel
+// network permutes 2*half bits, the results out of the range are walked over
+// until one falls in it
+func (g *MacGenerator) permute(index uint64) uint64 {
+	mask := uint64(1)<<g.half - 1
+	value := index
+	for {
+		left, right := value>>g.half, value&mask
+		for _, key := range g.keys {
+			left, right = right, left^(mix(right^key)&mask)
+		}
+		value = left<<g.half | right
+		if value < g.size {
+			return value
+		}
+	}
+}
+
+// mix is the finalizer of splitmix64
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func macToUint64(mac net.HardwareAddr) uint64 {
+	var value uint64
+	for _, b := range mac {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+func uint64ToMac(value uint64) net.HardwareAddr {
+	mac := make(net.HardwareAddr, 6)
+	for i := 5; i >= 0; i-- {
+		mac[i] = byte(value)
+		value >>= 8
+	}
+	return mac
+}
@@ -0,0 +1,69 @@
+package utility
+
+import (
+	"net"
+	"testing"
+)
+
+func TestRandomMacGeneratorIsUniqueAndSeeded(t *testing.T) {
+	oui := net.HardwareAddr{0x02, 0x12, 0x34}
+	g, err := NewRandomMacGenerator(oui, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := NewRandomMacGenerator(oui, 42)
+	reserved := net.HardwareAddr{0x02, 0x12, 0x34, 0, 0, 7}
+	g.Reserve(reserved)
+	other.Reserve(reserved)
+
+	seen := make(map[uint64]bool)
+	for i := 0; i < 1<<20; i++ {
+		mac, err := g.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mac[0] != 0x02 || mac[1] != 0x12 || mac[2] != 0x34 {
+			t.Fatalf("%s is out of the oui", mac)
+		}
+		value := macToUint64(mac)
+		if seen[value] || value == macToUint64(reserved) {
+			t.Fatalf("%s is given twice or reserved", mac)
+		}
+		seen[value] = true
+		if same, _ := other.Next(); same.String() != mac.String() {
+			t.Fatalf("the same seed gives %s and %s", mac, same)
+		}
+	}
+}
+
+func TestSequentialMacGeneratorIsExhausted(t *testing.T) {
+	first, last, err := ParseMacRange("02:00:00:00:00:fe-02:00:00:00:01:01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := NewSequentialMacGenerator(first, last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Reserve(net.HardwareAddr{0x02, 0, 0, 0, 0, 0xff})
+	var macs []string
+	for {
+		mac, err := g.Next()
+		if err != nil {
+			break
+		}
+		macs = append(macs, mac.String())
+	}
+	want := []string{"02:00:00:00:00:fe", "02:00:00:00:01:00", "02:00:00:00:01:01"}
+	if len(macs) != len(want) {
+		t.Fatalf("got %v, want %v", macs, want)
+	}
+	for i := range want {
+		if macs[i] != want[i] {
+			t.Fatalf("got %v, want %v", macs, want)
+		}
+	}
+	if _, err := NewSequentialMacGenerator(last, first); err == nil {
+		t.Error("a reversed range is accepted")
+	}
+}
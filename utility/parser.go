@@ -23,6 +23,14 @@ const (
 	 DHCPMessageFormat optionFormat = "message"
 	 MacFormat         optionFormat = "mac"
 	 DHCPTimeFormat    optionFormat = "time"
+	 AgentFormat       optionFormat = "agent"
+	 TLVFormat         optionFormat = "tlv"
+	 RoutesFormat      optionFormat = "routes"
+	 DomainsFormat     optionFormat = "domains"
+	 FQDNFormat        optionFormat = "fqdn"
+	 UserClassFormat   optionFormat = "userclass"
+	 VIVCFormat        optionFormat = "vivc"
+	 VIVSOFormat       optionFormat = "vivso"
 )
 
 type parse func(string) ([]byte, error)
@@ -41,11 +49,40 @@ func (parser *Parser) Init() {
 	parser.parser[string(DHCPMessageFormat)] = parseMessage
 	parser.parser[string(MacFormat)] = parseMac
 	parser.parser[string(DHCPTimeFormat)] = parseTime
+	parser.parser[string(AgentFormat)] = parseSubOptions
+	parser.parser[string(TLVFormat)] = parseSubOptions
+	parser.parser[string(RoutesFormat)] = parseRoutes
+	parser.parser[string(DomainsFormat)] = parseDomains
+	parser.parser[string(FQDNFormat)] = parseFQDN
+	parser.parser[string(UserClassFormat)] = parseUserClass
+	parser.parser[string(VIVCFormat)] = parseVendorClass
+	parser.parser[string(VIVSOFormat)] = parseVendorSpecific
 }
 
-func (parser *Parser) Parse(options RequestParams) (layers.DHCPOptions, error) {
+// OptionTemplate is an --option whose value holds per-device placeholders,
+// such as "12=host-{index}", it is parsed again for every device
+type OptionTemplate struct {
+	Code   layers.DHCPOpt
+	Format string
+	Value  string
+	parse  parse
+}
+
+// Expand returns the option of the device with the given index and mac
+func (t OptionTemplate) Expand(index int, mac net.HardwareAddr) (layers.DHCPOption, error) {
+	data, err := t.parse(ExpandTemplate(t.Value, index, mac))
+	if err != nil {
+		return layers.DHCPOption{}, fmt.Errorf("option %d of %s: %s", t.Code, mac, err)
+	}
+	return layers.NewDHCPOption(t.Code, data), nil
+}
+
+// Parse parses the --option values, the values with placeholders are returned
+// as templates, checked against the first device
+func (parser *Parser) Parse(options RequestParams) (layers.DHCPOptions, []OptionTemplate, error) {
 	//define variable
 	var dhcpOptions layers.DHCPOptions
+	var templates []OptionTemplate
 	buf := bytes.Buffer{}
 
 	//define parse function
@@ -57,6 +94,9 @@ func (parser *Parser) Parse(options RequestParams) (layers.DHCPOptions, error) {
 		if err != nil {
 			return fmt.Errorf("code parser error: %s", err)
 		}
+		if optionCode <= int(layers.DHCPOptPad) || optionCode >= int(layers.DHCPOptEnd) {
+			return fmt.Errorf("option code %d out of range 1-254", optionCode)
+		}
 		//format
 		if len(format) == 0 {
 			format = StringFormat
@@ -71,6 +111,15 @@ func (parser *Parser) Parse(options RequestParams) (layers.DHCPOptions, error) {
 			return fmt.Errorf("%s unsupport value format", format)
 		}
 
+		if IsTemplate(value) {
+			template := OptionTemplate{Code: layers.DHCPOpt(optionCode), Format: string(format), Value: value, parse: valueParser}
+			if _, err := template.Expand(0, make(net.HardwareAddr, 6)); err != nil {
+				return err
+			}
+			templates = append(templates, template)
+			return nil
+		}
+
 		//parse
 		data, err := valueParser(value)
 		if err != nil {
@@ -94,7 +143,7 @@ func (parser *Parser) Parse(options RequestParams) (layers.DHCPOptions, error) {
 			switch s {
 			case '=':
 				if hasLeftBracket != hasRightBracket {
-					return dhcpOptions, fmt.Errorf("左括号和右括号应成对出现")
+					return dhcpOptions, templates, fmt.Errorf("左括号和右括号应成对出现")
 				}
 				if !hasLeftBracket {
 					code = buf.String()
@@ -116,14 +165,41 @@ func (parser *Parser) Parse(options RequestParams) (layers.DHCPOptions, error) {
 		err := parseOption(code, value, format)
 		buf.Reset()
 		if err != nil {
-			return dhcpOptions, err
+			return dhcpOptions, templates, err
 		}
 	}
-	return dhcpOptions, nil
+	return dhcpOptions, templates, nil
+}
+
+// ParsePrintOnly parses the N[FORMAT] of --print-only, the format is the one
+// of the option when it is left out
+func ParsePrintOnly(value string) (layers.DHCPOpt, string, error) {
+	code, format := value, ""
+	if open := strings.Index(value, "["); open >= 0 {
+		if !strings.HasSuffix(value, "]") {
+			return 0, "", fmt.Errorf("invalid --print-only %s, want N or N[FORMAT]", value)
+		}
+		code, format = value[:open], strings.ToLower(value[open+1:len(value)-1])
+	}
+	optionCode, err := strconv.ParseUint(code, 10, 8)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid option code %s", code)
+	}
+	opt := layers.DHCPOpt(optionCode)
+	if len(format) == 0 {
+		format = layers.DHCPOptionFormat(opt)
+	}
+	for _, known := range layers.DHCPOptionFormats() {
+		if format == known {
+			return opt, format, nil
+		}
+	}
+	return 0, "", fmt.Errorf("unknown format %s, want one of %s", format, strings.Join(layers.DHCPOptionFormats(), ", "))
 }
 
 func parseHex(value string) ([]byte, error) {
-	data, err := hex.DecodeString(value)
+	//the bytes may be separated as in a mac, e.g. 01{mac}
+	data, err := hex.DecodeString(strings.Replace(value, ":", "", -1))
 	if err != nil {
 		return data, err
 	}
@@ -231,3 +307,189 @@ func parseTime(value string) ([]byte, error) {
 	binary.BigEndian.PutUint32(data, uint32(sec/time.Second))
 	return data, nil
 }
+
+// parseBytes parses a sub-option or a class, in hex when it starts with 0x
+func parseBytes(value string) ([]byte, error) {
+	if strings.HasPrefix(value, "0x") {
+		return parseHex(value[2:])
+	}
+	return []byte(value), nil
+}
+
+// parseCode parses the code of a sub-option
+func parseCode(value string) (byte, error) {
+	code, err := strconv.ParseUint(value, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid sub-option code %s", value)
+	}
+	return byte(code), nil
+}
+
+// parseSubOptions parses the sub-options of option 82 or 43: "CODE:VALUE,..."
+func parseSubOptions(value string) ([]byte, error) {
+	var subOptions layers.DHCPSubOptions
+	for _, item := range strings.Split(value, ",") {
+		fields := strings.SplitN(item, ":", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid sub-option %q, want CODE:VALUE", item)
+		}
+		code, err := parseCode(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		data, err := parseBytes(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		subOptions = append(subOptions, layers.DHCPSubOption{Code: code, Data: data})
+	}
+	return subOptions.Encode()
+}
+
+// parseRoutes parses the classless static routes of option 121: "DESTINATION/WIDTH:ROUTER,..."
+func parseRoutes(value string) ([]byte, error) {
+	var routes layers.DHCPClasslessRoutes
+	for _, item := range strings.Split(value, ",") {
+		fields := strings.SplitN(item, ":", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid route %q, want DESTINATION/WIDTH:ROUTER", item)
+		}
+		_, destination, err := net.ParseCIDR(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		router := net.ParseIP(fields[1])
+		if router == nil {
+			return nil, fmt.Errorf("%s is not a valid ip address", fields[1])
+		}
+		routes = append(routes, layers.DHCPClasslessRoute{Destination: *destination, Router: router})
+	}
+	return routes.Encode()
+}
+
+// parseDomains parses the domain search list of option 119: "NAME,..."
+func parseDomains(value string) ([]byte, error) {
+	return layers.DHCPDomainSearch(strings.Split(value, ",")).Encode()
+}
+
+// parseFQDN parses the client fqdn of option 81: "[FLAGS:]NAME", the flags
+// are upper case letters among S, O, E and N, E alone by default. The value is
+// split at the first colon only when the letters before it are flags, so a
+// name holding colons, such as one expanded from {mac}, is kept whole
+func parseFQDN(value string) ([]byte, error) {
+	fqdn := layers.DHCPClientFQDN{Flags: layers.DHCPFQDNEncoded, Name: value}
+	if fields := strings.SplitN(value, ":", 2); len(fields) == 2 && isFQDNFlags(fields[0]) {
+		flags, err := layers.ParseDHCPFQDNFlags(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		fqdn.Flags, fqdn.Name = flags, fields[1]
+	}
+	return fqdn.Encode()
+}
+
+// isFQDNFlags tells whether the letters are distinct upper case fqdn flags
+func isFQDNFlags(letters string) bool {
+	if len(letters) == 0 {
+		return false
+	}
+	for i, letter := range letters {
+		if !strings.ContainsRune("SOEN", letter) || strings.ContainsRune(letters[:i], letter) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseClasses parses a list of classes: "CLASS,..."
+func parseClasses(value string) ([][]byte, error) {
+	var classes [][]byte
+	for _, item := range strings.Split(value, ",") {
+		class, err := parseBytes(item)
+		if err != nil {
+			return nil, err
+		}
+		classes = append(classes, class)
+	}
+	return classes, nil
+}
+
+// parseUserClass parses the user classes of option 77: "CLASS,..."
+func parseUserClass(value string) ([]byte, error) {
+	classes, err := parseClasses(value)
+	if err != nil {
+		return nil, err
+	}
+	return layers.DHCPUserClass(classes).Encode()
+}
+
+// parseEnterprise splits ENTERPRISE:REST
+func parseEnterprise(item string) (uint32, string, error) {
+	fields := strings.SplitN(item, ":", 2)
+	if len(fields) != 2 {
+		return 0, "", fmt.Errorf("invalid item %q, want ENTERPRISE:...", item)
+	}
+	enterprise, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid enterprise number %s", fields[0])
+	}
+	return uint32(enterprise), fields[1], nil
+}
+
+// parseVendorClass parses the vendor classes of option 124:
+// "ENTERPRISE:CLASS,...", the classes of an enterprise are sent together
+func parseVendorClass(value string) ([]byte, error) {
+	var classes layers.DHCPVendorClasses
+	index := make(map[uint32]int)
+	for _, item := range strings.Split(value, ",") {
+		enterprise, rest, err := parseEnterprise(item)
+		if err != nil {
+			return nil, err
+		}
+		class, err := parseBytes(rest)
+		if err != nil {
+			return nil, err
+		}
+		i, ok := index[enterprise]
+		if !ok {
+			i = len(classes)
+			index[enterprise] = i
+			classes = append(classes, layers.DHCPVendorClass{Enterprise: enterprise})
+		}
+		classes[i].Data = append(classes[i].Data, class)
+	}
+	return classes.Encode()
+}
+
+// parseVendorSpecific parses the vendor options of option 125:
+// "ENTERPRISE:CODE:VALUE,...", the options of an enterprise are sent together
+func parseVendorSpecific(value string) ([]byte, error) {
+	var vendors layers.DHCPVendorSpecific
+	index := make(map[uint32]int)
+	for _, item := range strings.Split(value, ",") {
+		enterprise, rest, err := parseEnterprise(item)
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.SplitN(rest, ":", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid vendor option %q, want ENTERPRISE:CODE:VALUE", item)
+		}
+		code, err := parseCode(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		data, err := parseBytes(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		i, ok := index[enterprise]
+		if !ok {
+			i = len(vendors)
+			index[enterprise] = i
+			vendors = append(vendors, layers.DHCPVendorOptions{Enterprise: enterprise})
+		}
+		vendors[i].Options = append(vendors[i].Options, layers.DHCPSubOption{Code: code, Data: data})
+	}
+	return vendors.Encode()
+}
@@ -0,0 +1,160 @@
+package utility
+
+import (
+	"bytes"
+	"dhcptest/layers"
+	"github.com/google/gopacket"
+	"net"
+	"testing"
+)
+
+func TestParseOptionTemplates(t *testing.T) {
+	parser := &Parser{}
+	parser.Init()
+	options, templates, err := parser.Parse(RequestParams{
+		"12=host-{index}",
+		"61[hex]=01{mac}",
+		"60=vendor-{rand:a,b,c}",
+		"66=tftp",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(options) != 1 || options[0].Type != layers.DHCPOptTFTPServerName {
+		t.Fatalf("static options %v", options)
+	}
+	if len(templates) != 3 {
+		t.Fatalf("got %d templates, want 3", len(templates))
+	}
+
+	mac, _ := net.ParseMAC("02:00:00:00:00:2a")
+	hostname, _ := templates[0].Expand(7, mac)
+	if string(hostname.Data) != "host-7" {
+		t.Errorf("host name %q", hostname.Data)
+	}
+	clientID, _ := templates[1].Expand(7, mac)
+	if want := append([]byte{1}, mac...); !bytes.Equal(clientID.Data, want) {
+		t.Errorf("client id %x, want %x", clientID.Data, want)
+	}
+
+	//a device keeps its vendor class, the devices spread over all of them
+	seen := make(map[string]bool)
+	for i := 0; i < 64; i++ {
+		mac := net.HardwareAddr{2, 0, 0, 0, 0, byte(i)}
+		first, _ := templates[2].Expand(i, mac)
+		again, _ := templates[2].Expand(i+1, mac)
+		if string(first.Data) != string(again.Data) {
+			t.Fatalf("%s got %q then %q", mac, first.Data, again.Data)
+		}
+		seen[string(first.Data)] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("vendor classes %v", seen)
+	}
+
+	if _, _, err := parser.Parse(RequestParams{"3[ip]=10.0.0.{mac}"}); err == nil {
+		t.Error("an invalid template is accepted")
+	}
+}
+
+func TestParseCompoundOptions(t *testing.T) {
+	parser := &Parser{}
+	parser.Init()
+	options, _, err := parser.Parse(RequestParams{
+		"82[agent]=1:eth0/1,2:0x0a0b",
+		"121[routes]=10.0.0.0/8:10.0.0.1",
+		"81[fqdn]=SE:host.example.com.",
+		"124[vivc]=4491:docsis3.0,4491:cm",
+		"125[vivso]=3561:1:abc,3561:2:0x01",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]byte{
+		{1, 6, 'e', 't', 'h', '0', '/', '1', 2, 2, 0x0a, 0x0b},
+		{8, 10, 10, 0, 0, 1},
+		append(append([]byte{5, 0, 0, 4}, "host"...), 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0),
+		append(append([]byte{0, 0, 0x11, 0x8b, 13, 9}, "docsis3.0"...), 2, 'c', 'm'),
+		{0, 0, 0x0d, 0xe9, 8, 1, 3, 'a', 'b', 'c', 2, 1, 1},
+	}
+	for i, option := range options {
+		if !bytes.Equal(option.Data, want[i]) {
+			t.Errorf("option %d: expected %v, got %v", option.Type, want[i], option.Data)
+		}
+	}
+
+	for _, invalid := range []string{"82[agent]=1", "121[routes]=10.0.0.0:10.0.0.1", "81[fqdn]=SE:host..example.com", "124[vivc]=docsis"} {
+		if _, _, err := parser.Parse(RequestParams{invalid}); err == nil {
+			t.Errorf("%s is accepted", invalid)
+		}
+	}
+}
+
+func TestParseFQDN(t *testing.T) {
+	for _, c := range []struct {
+		value string
+		flags byte
+		name  string
+	}{
+		{"host.example.com.", layers.DHCPFQDNEncoded, "host.example.com."},
+		{"SE:host", 0x05, "host"},
+		{"N:host", 0x08, "host"},
+		//the colons of a mac are part of the name
+		{"02:00:00:00:00:01", layers.DHCPFQDNEncoded, "02:00:00:00:00:01"},
+		{"ee:00:00:00:00:01", layers.DHCPFQDNEncoded, "ee:00:00:00:00:01"},
+		{"S:ee:00:00:00:00:01", 0x01, "ee:00:00:00:00:01"},
+		{"EE:host", layers.DHCPFQDNEncoded, "EE:host"},
+	} {
+		data, err := parseFQDN(c.value)
+		if err != nil {
+			t.Errorf("%s: %s", c.value, err)
+			continue
+		}
+		want, _ := (&layers.DHCPClientFQDN{Flags: c.flags, Name: c.name}).Encode()
+		if !bytes.Equal(data, want) {
+			t.Errorf("%s: got %v, want flags %#x and name %s", c.value, data, c.flags, c.name)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"12=host-{index}",
+		"61[hex]=01{mac}",
+		"82[agent]=1:eth0/1,2:olt-7",
+		"121[routes]=10.0.0.0/8:10.0.0.1",
+		"119[domains]=example.com,lan",
+		"81[fqdn]=SE:host.example.com.",
+		"124[vivc]=3561:class",
+		"125[vivso]=3561:1:{mac_hex}",
+		"51[time]=3600",
+	} {
+		f.Add(seed)
+	}
+	parser := &Parser{}
+	parser.Init()
+	f.Fuzz(func(t *testing.T, value string) {
+		options, templates, err := parser.Parse(RequestParams{value})
+		if err != nil {
+			return
+		}
+		for _, template := range templates {
+			option, err := template.Expand(1, net.HardwareAddr{2, 0, 0, 0, 0, 1})
+			if err == nil {
+				options = append(options, option)
+			}
+		}
+		packet := &layers.DHCPv4{Operation: layers.DHCPOpRequest, HardwareType: layers.LinkTypeEthernet, Options: options}
+		buf := gopacket.NewSerializeBuffer()
+		if err := packet.SerializeTo(buf, gopacket.SerializeOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		decoded := &layers.DHCPv4{}
+		if err := decoded.DecodeFromBytes(buf.Bytes(), gopacket.NilDecodeFeedback); err != nil {
+			t.Fatalf("%q: %s", value, err)
+		}
+		if len(decoded.Options) != len(options) || len(options) > 0 && !bytes.Equal(decoded.Options[0].Data, options[0].Data) {
+			t.Fatalf("%q: encoded %s, decoded %s", value, options, decoded.Options)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package utility
+
+import (
+	"encoding/hex"
+	"hash/fnv"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// randPlaceholder starts the {rand:a,b,c} placeholder
+const randPlaceholder = "{rand:"
+
+// ExpandTemplate fills in the per-device placeholders of a template:
+// {index} is the number of the simulated device counted from 0 and
+// {mac} its mac address, {mac_hex} the mac without separators. See
+// ExpandMacTemplate for {rand:a,b,c}
+func ExpandTemplate(template string, index int, mac net.HardwareAddr) string {
+	if !strings.Contains(template, "{") {
+		return template
+	}
+	replacer := strings.NewReplacer(
+		"{index}", strconv.Itoa(index),
+		"{mac}", mac.String(),
+		"{mac_hex}", hex.EncodeToString(mac),
+	)
+	return replacer.Replace(expandRand(template, mac))
+}
+
+// ExpandMacTemplate fills in the placeholders of a template which depend on
+// the mac only, so that the same mac always expands the same: {mac}, {mac_hex}
+// and {rand:a,b,c}, one of the choices picked by the hash of the mac
+func ExpandMacTemplate(template string, mac net.HardwareAddr) string {
+	if !strings.Contains(template, "{") {
+		return template
+	}
+	replacer := strings.NewReplacer(
+		"{mac}", mac.String(),
+		"{mac_hex}", hex.EncodeToString(mac),
+	)
+	return replacer.Replace(expandRand(template, mac))
+}
+
+// IsTemplate tells whether the value holds a placeholder ExpandTemplate fills in
+func IsTemplate(value string) bool {
+	for _, placeholder := range []string{"{index}", "{mac}", "{mac_hex}", randPlaceholder} {
+		if strings.Contains(value, placeholder) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandRand replaces every {rand:a,b,c} with one of its choices. The choice
+// is a hash of the mac, the template and the place of the placeholder, a device
+// keeps it from one packet and one run to the next while the devices spread
+// over all of them
+func expandRand(template string, mac net.HardwareAddr) string {
+	var expanded strings.Builder
+	whole := template
+	for n := 0; ; n++ {
+		start := strings.Index(template, randPlaceholder)
+		if start < 0 {
+			break
+		}
+		end := strings.Index(template[start:], "}")
+		if end < 0 {
+			break
+		}
+		choices := strings.Split(template[start+len(randPlaceholder):start+end], ",")
+		h := fnv.New64a()
+		h.Write(mac)
+		h.Write([]byte(whole))
+		h.Write([]byte{byte(n)})
+		expanded.WriteString(template[:start])
+		expanded.WriteString(choices[mix(h.Sum64())%uint64(len(choices))])
+		template = template[start+end+1:]
+	}
+	expanded.WriteString(template)
+	return expanded.String()
+}
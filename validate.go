@@ -0,0 +1,80 @@
+package main
+
+import (
+	"dhcptest/connection"
+	"dhcptest/layers"
+	"dhcptest/utility"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// exitNonConformant is the exit code of a run which went well otherwise but
+// got replies breaking a validation rule
+const exitNonConformant = 3
+
+// newValidator returns the validator of --validate and the --expect flags, it
+// returns nil when none of them is set
+func newValidator() (*connection.Validator, error) {
+	if !utility.Validate && len(utility.ExpectSubnet) == 0 && len(utility.ExpectRouter) == 0 &&
+		len(utility.ExpectDNS) == 0 && len(utility.ExpectOptions) == 0 {
+		return nil, nil
+	}
+	var expect connection.Expectations
+	var err error
+	if len(utility.ExpectSubnet) > 0 {
+		if _, expect.Subnet, err = net.ParseCIDR(strings.TrimSpace(utility.ExpectSubnet)); err != nil || expect.Subnet.IP.To4() == nil {
+			return nil, fmt.Errorf("invalid --expect-subnet %q, want an ipv4 cidr such as 10.0.0.0/24", utility.ExpectSubnet)
+		}
+	}
+	if expect.Router, err = parseIPList("--expect-router", utility.ExpectRouter); err != nil {
+		return nil, err
+	}
+	if expect.DNS, err = parseIPList("--expect-dns", utility.ExpectDNS); err != nil {
+		return nil, err
+	}
+	for _, code := range strings.Split(utility.ExpectOptions, ",") {
+		if code = strings.TrimSpace(code); len(code) == 0 {
+			continue
+		}
+		n, err := strconv.Atoi(code)
+		if err != nil || n < 1 || n > 254 {
+			return nil, fmt.Errorf("invalid option code %q in --expect-options, want 1 to 254", code)
+		}
+		expect.Required = append(expect.Required, layers.DHCPOpt(n))
+	}
+	return connection.NewValidator(expect), nil
+}
+
+// parseIPList parses the comma separated ipv4 addresses of a flag
+func parseIPList(name string, value string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); len(entry) == 0 {
+			continue
+		}
+		ip := net.ParseIP(entry).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %q in %s, want ipv4 addresses", entry, name)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// reportConformance logs the conformance report of the validator, it turns
+// the exit code of a run which went well into exitNonConformant when a reply
+// broke a rule
+func reportConformance(validator *connection.Validator, code int) int {
+	if validator == nil {
+		return code
+	}
+	report := validator.Report()
+	log.Println(report)
+	if code == exitOK && !report.Passed() {
+		return exitNonConformant
+	}
+	return code
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"dhcptest/connection"
+	"dhcptest/utility"
+	"testing"
+)
+
+func TestNewValidator(t *testing.T) {
+	defer func(validate bool, subnet, router, options string) {
+		utility.Validate, utility.ExpectSubnet, utility.ExpectRouter, utility.ExpectOptions = validate, subnet, router, options
+	}(utility.Validate, utility.ExpectSubnet, utility.ExpectRouter, utility.ExpectOptions)
+
+	utility.Validate, utility.ExpectSubnet, utility.ExpectRouter, utility.ExpectOptions = false, "", "", ""
+	if v, err := newValidator(); v != nil || err != nil {
+		t.Errorf("a validator %v without the flags: %v", v, err)
+	}
+
+	utility.ExpectSubnet, utility.ExpectRouter, utility.ExpectOptions = "10.0.0.0/24", "10.0.0.1, 10.0.0.2", "1,3"
+	if v, err := newValidator(); v == nil || err != nil {
+		t.Errorf("no validator for the --expect flags: %v", err)
+	}
+
+	for _, c := range []struct {
+		subnet, router, options string
+	}{
+		{"10.0.0.1", "", ""},
+		{"fe80::/64", "", ""},
+		{"", "10.0.0.1,router", ""},
+		{"", "fe80::1", ""},
+		{"", "", "0"},
+		{"", "", "255"},
+		{"", "", "dns"},
+	} {
+		utility.ExpectSubnet, utility.ExpectRouter, utility.ExpectOptions = c.subnet, c.router, c.options
+		if _, err := newValidator(); err == nil {
+			t.Errorf("%+v is taken", c)
+		}
+	}
+}
+
+func TestReportConformance(t *testing.T) {
+	if code := reportConformance(nil, exitBadReplay); code != exitBadReplay {
+		t.Errorf("no validator turns the code into %d", code)
+	}
+	v := connection.NewValidator(connection.Expectations{})
+	if code := reportConformance(v, exitOK); code != exitOK {
+		t.Errorf("no reply turns the code into %d", code)
+	}
+}